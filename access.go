@@ -0,0 +1,120 @@
+package wesplot
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// The capabilities of a client, granted by the token it presents (see
+// SetAccessTokens).
+type AccessLevel int
+
+const (
+	AccessNone AccessLevel = iota
+
+	// Can watch the plots: the web UI, /ws, /sse, /metadata, /stats, and so on.
+	AccessView
+
+	// Can also change the plots: the requests other than GET (such as PUT
+	// /options, POST /annotations, POST /control/pause, and POST /ingest) and
+	// /ws-ingest.
+	AccessControl
+)
+
+// The cookie set when a valid token is passed in the URL, so the requests of
+// the web UI (assets, websocket, fetch) carry the token.
+const accessTokenCookie = "wesplot_token"
+
+// Requires a token to view or to control the plots, so shared viewers can
+// watch the plots while only the owner can change them. If controlToken is
+// empty, the clients that can view can also control. If viewToken is empty,
+// anyone can view. The token is passed as a bearer token in the Authorization
+// header, or in the token query parameter of the URL (e.g. /?token=...),
+// which also stores it in a cookie for the web UI. Must be called before Run.
+func (s *HttpServer) SetAccessTokens(viewToken string, controlToken string) {
+	s.viewToken = viewToken
+	s.controlToken = controlToken
+}
+
+func (s *HttpServer) accessLevel(token string) AccessLevel {
+	switch {
+	case s.controlToken != "" && tokenEqual(token, s.controlToken):
+		return AccessControl
+	case s.viewToken != "" && !tokenEqual(token, s.viewToken):
+		return AccessNone
+	case s.controlToken == "":
+		return AccessControl
+	default:
+		return AccessView
+	}
+}
+
+func tokenEqual(token string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+func requiredAccessLevel(req *http.Request) AccessLevel {
+	switch {
+	case req.Method == http.MethodOptions:
+		// CORS preflight requests never carry credentials.
+		return AccessNone
+	case req.Method != http.MethodGet && req.Method != http.MethodHead:
+		return AccessControl
+	case req.URL.Path == "/ws-ingest":
+		return AccessControl
+	default:
+		return AccessView
+	}
+}
+
+func requestToken(req *http.Request) (token string, fromQuery bool) {
+	if token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok {
+		return token, false
+	}
+
+	if req.URL.Query().Has("token") {
+		return req.URL.Query().Get("token"), true
+	}
+
+	cookie, err := req.Cookie(accessTokenCookie)
+	if err == nil {
+		return cookie.Value, false
+	}
+
+	return "", false
+}
+
+// Rejects the requests whose token does not grant the access they require.
+// The paths are relative to the base path.
+func (s *HttpServer) checkAccess(next http.Handler) http.Handler {
+	if s.viewToken == "" && s.controlToken == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, fromQuery := requestToken(req)
+		level := s.accessLevel(token)
+
+		if level < requiredAccessLevel(req) {
+			if level == AccessNone {
+				http.Error(w, "a valid token is required", http.StatusUnauthorized)
+			} else {
+				http.Error(w, "the token only allows viewing", http.StatusForbidden)
+			}
+			return
+		}
+
+		if fromQuery && level > AccessNone {
+			http.SetCookie(w, &http.Cookie{
+				Name:     accessTokenCookie,
+				Value:    token,
+				Path:     s.basePath,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,14 @@
+package wesplot
+
+import "net"
+
+// Advertises the plot served on the listener, with its title and the base path
+// of the server, such as via mDNS (see the mdns package). Returns a function
+// that stops the advertisement.
+type Advertiser func(listener net.Listener, title string, basePath string) (stop func(), err error)
+
+// Advertises the plot while the server is running, so it can be found on the
+// network. Must be called before Run.
+func (s *HttpServer) SetAdvertiser(advertiser Advertiser) {
+	s.advertiser = advertiser
+}
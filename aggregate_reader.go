@@ -0,0 +1,263 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A DataRowReader that buckets the rows from the input into fixed windows of
+// X (which is normally a timestamp in seconds) and emits one row per window
+// with the aggregates of each series, instead of the raw samples. This is
+// useful to plot metrics such as request latencies at high volume.
+//
+// For every input column and every aggregate function, an output column named
+// "<column> <function>" is emitted. The X value of the emitted row is the end
+// of the window. Since the reader is driven by the input, a window is only
+// emitted once a row from a later window (or EOF) is read.
+//
+// The ohlc and box functions aggregate every window into the columns of a
+// candlestick or a box plot, which are described by Candles so the clients
+// can draw them.
+type AggregateDataRowReader struct {
+	input     DataRowReader
+	window    float64
+	functions []string
+
+	columns []string
+	candles []Candle
+
+	hasBucket bool
+	bucket    float64
+	values    [][]float64 // In the order they are read
+	sorted    []float64
+
+	// Set after the last bucket is flushed at EOF.
+	ended bool
+}
+
+// Creates the reader from a spec such as p99:10s or p50,p99,max:1m. The
+// supported functions are min, max, mean, count, pNN where NN is the
+// percentile, open and close (the first and the last value of the window),
+// and high and low (same as max and min). ohlc is short for open, high, low,
+// and close, and box for min, p25, p50, p75, and max.
+func NewAggregateDataRowReader(input DataRowReader, spec string) (*AggregateDataRowReader, error) {
+	functionsSpec, windowSpec, found := strings.Cut(spec, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid aggregate %q, expected functions:window (e.g. p99:10s)", spec)
+	}
+
+	window, err := time.ParseDuration(windowSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aggregate window %q: %w", windowSpec, err)
+	}
+
+	if window <= 0 {
+		return nil, fmt.Errorf("aggregate window must be positive, got %q", windowSpec)
+	}
+
+	var functions []string
+	var candleKinds []string
+	for _, function := range strings.Split(functionsSpec, ",") {
+		if candleFunctions, ok := candleAggregates[function]; ok {
+			functions = append(functions, candleFunctions...)
+			candleKinds = append(candleKinds, function)
+			continue
+		}
+
+		_, err := aggregate(function, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		functions = append(functions, function)
+	}
+
+	r := &AggregateDataRowReader{
+		input:     input,
+		window:    window.Seconds(),
+		functions: functions,
+		values:    make([][]float64, len(input.ColumnNames())),
+	}
+
+	for _, column := range input.ColumnNames() {
+		for _, function := range functions {
+			r.columns = append(r.columns, column+" "+function)
+		}
+
+		for _, kind := range candleKinds {
+			candle := Candle{Kind: kind, Series: column}
+			for _, function := range candleAggregates[kind] {
+				candle.Columns = append(candle.Columns, column+" "+function)
+			}
+
+			r.candles = append(r.candles, candle)
+		}
+	}
+
+	return r, nil
+}
+
+// The functions of the aggregates drawn as candles, in the order of the
+// Columns of the Candle.
+var candleAggregates = map[string][]string{
+	CandleOHLC: {"open", "high", "low", "close"},
+	CandleBox:  {"min", "p25", "p50", "p75", "max"},
+}
+
+func (r *AggregateDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if r.ended {
+		return DataRow{}, io.EOF
+	}
+
+	for {
+		dataRow, err := r.input.Read(ctx)
+		if err == io.EOF {
+			r.ended = true
+			if r.hasBucket {
+				return r.flush(), nil
+			}
+
+			return DataRow{}, io.EOF
+		} else if err != nil {
+			return DataRow{}, err
+		}
+
+		// Annotations are not aggregated, so they are sent before the row of
+		// their bucket.
+		if dataRow.annotation != nil {
+			return dataRow, nil
+		}
+
+		bucket := math.Floor(dataRow.X / r.window)
+		if !r.hasBucket {
+			r.hasBucket = true
+			r.bucket = bucket
+		}
+
+		if bucket != r.bucket {
+			aggregatedRow := r.flush()
+			r.hasBucket = true
+			r.bucket = bucket
+			r.add(dataRow)
+			return aggregatedRow, nil
+		}
+
+		r.add(dataRow)
+	}
+}
+
+func (r *AggregateDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+// The candles of the ohlc and box functions, if any, to be sent to the
+// clients in the Metadata.
+func (r *AggregateDataRowReader) Candles() []Candle {
+	return r.candles
+}
+
+func (r *AggregateDataRowReader) add(dataRow DataRow) {
+	for i, y := range dataRow.Ys {
+		if i >= len(r.values) || math.IsNaN(y) {
+			continue
+		}
+
+		r.values[i] = append(r.values[i], y)
+	}
+}
+
+func (r *AggregateDataRowReader) flush() DataRow {
+	dataRow := DataRow{
+		X:  (r.bucket + 1) * r.window,
+		Ys: make([]float64, 0, len(r.columns)),
+	}
+
+	for i, values := range r.values {
+		r.sorted = append(r.sorted[:0], values...)
+		sort.Float64s(r.sorted)
+		for _, function := range r.functions {
+			// Functions are validated in the constructor.
+			value, _ := aggregate(function, values, r.sorted)
+			dataRow.Ys = append(dataRow.Ys, value)
+		}
+
+		r.values[i] = values[:0]
+	}
+
+	r.hasBucket = false
+	return dataRow
+}
+
+// Computes the aggregate function over the values, in the order they were
+// read and sorted. Returns NaN (which is plotted as a gap) if there are no
+// values.
+func aggregate(function string, values []float64, sorted []float64) (float64, error) {
+	switch function {
+	case "count":
+		return float64(len(sorted)), nil
+	case "min", "low":
+		if len(sorted) == 0 {
+			return math.NaN(), nil
+		}
+		return sorted[0], nil
+	case "max", "high":
+		if len(sorted) == 0 {
+			return math.NaN(), nil
+		}
+		return sorted[len(sorted)-1], nil
+	case "open":
+		if len(values) == 0 {
+			return math.NaN(), nil
+		}
+		return values[0], nil
+	case "close":
+		if len(values) == 0 {
+			return math.NaN(), nil
+		}
+		return values[len(values)-1], nil
+	case "mean":
+		if len(sorted) == 0 {
+			return math.NaN(), nil
+		}
+
+		sum := 0.0
+		for _, value := range sorted {
+			sum += value
+		}
+		return sum / float64(len(sorted)), nil
+	}
+
+	if !strings.HasPrefix(function, "p") {
+		return 0, errors.New("unknown aggregate function " + function)
+	}
+
+	// Only digits, so that NaN, Inf, and exponents are rejected.
+	digits := function[1:]
+	if digits == "" || strings.TrimLeft(digits, "0123456789.") != "" {
+		return 0, errors.New("invalid percentile " + function)
+	}
+
+	percentile, err := strconv.ParseFloat(digits, 64)
+	if err != nil || math.IsNaN(percentile) || percentile < 0 || percentile > 100 {
+		return 0, errors.New("invalid percentile " + function)
+	}
+
+	if len(sorted) == 0 {
+		return math.NaN(), nil
+	}
+
+	// Linear interpolation between the closest ranks.
+	rank := percentile / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	fraction := rank - float64(lower)
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*fraction, nil
+}
@@ -0,0 +1,425 @@
+package wesplot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type AlertState string
+
+const (
+	AlertOk      AlertState = "ok"
+	AlertPending AlertState = "pending" // The condition is true but not for long enough
+	AlertFiring  AlertState = "firing"
+)
+
+// A condition such as `y1 > 100 for 30s`, evaluated against every row.
+type AlertCondition struct {
+	Condition string
+	Column    string
+	Operator  string
+	Threshold float64
+	For       time.Duration
+
+	columnIndex int
+}
+
+// Parses a condition in the form of `<column> <operator> <threshold> [for
+// <duration>]`. The supported operators are >, >=, <, <=, ==, and !=.
+func ParseAlertCondition(condition string, columns []string) (AlertCondition, error) {
+	fields := strings.Fields(condition)
+	if len(fields) != 3 && !(len(fields) == 5 && fields[3] == "for") {
+		return AlertCondition{}, fmt.Errorf("invalid alert %q, expected <column> <operator> <threshold> [for <duration>]", condition)
+	}
+
+	alert := AlertCondition{
+		Condition:   condition,
+		Column:      fields[0],
+		Operator:    fields[1],
+		columnIndex: -1,
+	}
+
+	for i, column := range columns {
+		if column == alert.Column {
+			alert.columnIndex = i
+			break
+		}
+	}
+
+	if alert.columnIndex == -1 {
+		return AlertCondition{}, fmt.Errorf("invalid alert %q, %s is not one of the columns %v", condition, alert.Column, columns)
+	}
+
+	switch alert.Operator {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return AlertCondition{}, fmt.Errorf("invalid alert %q, unknown operator %s", condition, alert.Operator)
+	}
+
+	var err error
+	alert.Threshold, err = strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return AlertCondition{}, fmt.Errorf("invalid alert %q: %w", condition, err)
+	}
+
+	if len(fields) == 5 {
+		alert.For, err = time.ParseDuration(fields[4])
+		if err != nil {
+			return AlertCondition{}, fmt.Errorf("invalid alert %q: %w", condition, err)
+		}
+	}
+
+	return alert, nil
+}
+
+func (a AlertCondition) evaluate(ys []float64) (bool, float64) {
+	if a.columnIndex >= len(ys) || math.IsNaN(ys[a.columnIndex]) {
+		return false, math.NaN()
+	}
+
+	value := ys[a.columnIndex]
+	switch a.Operator {
+	case ">":
+		return value > a.Threshold, value
+	case ">=":
+		return value >= a.Threshold, value
+	case "<":
+		return value < a.Threshold, value
+	case "<=":
+		return value <= a.Threshold, value
+	case "==":
+		return value == a.Threshold, value
+	default:
+		return value != a.Threshold, value
+	}
+}
+
+// The current state of an alert, served via /alerts and sent to the webhook.
+type AlertStatus struct {
+	Condition string
+	State     AlertState
+	Since     time.Time // When the alert entered the current state
+	Value     float64   // The most recent value of the column
+}
+
+// The JSON encoding of AlertStatus, as NaN cannot be encoded by encoding/json.
+type alertStatusJSON struct {
+	Condition string
+	State     AlertState
+	Since     time.Time
+	Value     *float64
+}
+
+func (s AlertStatus) MarshalJSON() ([]byte, error) {
+	statusJSON := alertStatusJSON{
+		Condition: s.Condition,
+		State:     s.State,
+		Since:     s.Since,
+	}
+
+	if !math.IsNaN(s.Value) {
+		statusJSON.Value = &s.Value
+	}
+
+	return json.Marshal(statusJSON)
+}
+
+// A DataRowReader that passes the rows from the input through unmodified,
+// while evaluating the alert conditions against them. When an alert starts or
+// stops firing, the command is executed and/or the webhook is called. This
+// turns wesplot into a lightweight live monitor.
+//
+// The command is executed by the shell with the environment variables
+// WESPLOT_ALERT (the condition), WESPLOT_ALERT_STATE (firing or ok), and
+// WESPLOT_ALERT_VALUE. The webhook receives a POST with the AlertStatus as
+// JSON. The actions of an alert are run one at a time, in the order of the
+// state changes, by a goroutine of the alert, which stops when the input ends.
+//
+// This also implements http.Handler, which serves the status of all alerts.
+// The clients of the HttpServer can also receive the status whenever it
+// changes (see SetAlerts).
+type AlertDataRowReader struct {
+	input      DataRowReader
+	conditions []AlertCondition
+	command    string
+	webhook    string
+
+	mutex     sync.Mutex
+	statuses  []AlertStatus
+	listeners map[chan AlertStatus]struct{}
+	actions   []chan AlertStatus // The state changes queued for the worker of every alert, nil without actions
+	stopped   bool               // Set when the input ends and the workers are stopped
+
+	logger logrus.FieldLogger
+}
+
+// The number of status changes queued for a client before the oldest ones are
+// dropped.
+const alertListenerCapacity = 64
+
+// The number of state changes queued for the actions of an alert, such as while
+// a slow webhook is called. Further state changes are dropped so the pipeline
+// is never blocked.
+const alertActionQueueSize = 16
+
+func NewAlertDataRowReader(input DataRowReader, conditions []AlertCondition, command string, webhook string) *AlertDataRowReader {
+	r := &AlertDataRowReader{
+		input:      input,
+		conditions: conditions,
+		command:    command,
+		webhook:    webhook,
+		statuses:   make([]AlertStatus, len(conditions)),
+		listeners:  make(map[chan AlertStatus]struct{}),
+		logger:     logrus.WithField("tag", "Alert"),
+	}
+
+	now := time.Now()
+	for i, condition := range conditions {
+		r.statuses[i] = AlertStatus{
+			Condition: condition.Condition,
+			State:     AlertOk,
+			Since:     now,
+			Value:     math.NaN(),
+		}
+	}
+
+	if command != "" || webhook != "" {
+		r.actions = make([]chan AlertStatus, len(conditions))
+		for i := range conditions {
+			r.actions[i] = make(chan AlertStatus, alertActionQueueSize)
+			go r.runActions(r.actions[i])
+		}
+	}
+
+	return r
+}
+
+func (r *AlertDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if errors.Is(err, errIgnoreThisRow) {
+		return dataRow, err
+	} else if err != nil {
+		r.stop()
+		return dataRow, err
+	}
+
+	if dataRow.annotation != nil {
+		return dataRow, nil
+	}
+
+	r.evaluate(dataRow)
+	return dataRow, nil
+}
+
+func (r *AlertDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
+
+func (r *AlertDataRowReader) Statuses() []AlertStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	statuses := make([]AlertStatus, len(r.statuses))
+	copy(statuses, r.statuses)
+	return statuses
+}
+
+func (r *AlertDataRowReader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(w, r.Statuses())
+}
+
+// Returns a channel that receives the status of every alert that is not ok,
+// and then the status of an alert whenever its state changes. If the client
+// falls behind, the oldest statuses are dropped so the pipeline is never
+// blocked.
+func (r *AlertDataRowReader) addListener() chan AlertStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	listener := make(chan AlertStatus, alertListenerCapacity)
+	for _, status := range r.statuses {
+		if status.State != AlertOk {
+			notifyAlertListener(listener, status)
+		}
+	}
+
+	r.listeners[listener] = struct{}{}
+	return listener
+}
+
+func (r *AlertDataRowReader) removeListener(listener chan AlertStatus) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.listeners, listener)
+}
+
+func notifyAlertListener(listener chan AlertStatus, status AlertStatus) {
+	for {
+		select {
+		case listener <- status:
+			return
+		default:
+		}
+
+		select {
+		case <-listener:
+		default:
+		}
+	}
+}
+
+func (r *AlertDataRowReader) evaluate(dataRow DataRow) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for i, condition := range r.conditions {
+		status := &r.statuses[i]
+
+		active, value := condition.evaluate(dataRow.Ys)
+		status.Value = value
+		previousState := status.State
+
+		switch {
+		case !active && status.State != AlertOk:
+			status.State = AlertOk
+			status.Since = now
+
+			if previousState == AlertFiring {
+				r.fire(i, *status)
+			}
+		case active && status.State == AlertOk:
+			status.State = AlertPending
+			status.Since = now
+			fallthrough
+		case active && status.State == AlertPending:
+			if now.Sub(status.Since) >= condition.For {
+				status.State = AlertFiring
+				status.Since = now
+				r.fire(i, *status)
+			}
+		}
+
+		if status.State != previousState {
+			for listener := range r.listeners {
+				notifyAlertListener(listener, *status)
+			}
+		}
+	}
+}
+
+// Queues the actions of the alert of the given index, so the pipeline is not
+// blocked. Must be called with the mutex held.
+func (r *AlertDataRowReader) fire(index int, status AlertStatus) {
+	logger := r.logger.WithFields(logrus.Fields{
+		"alert": status.Condition,
+		"value": status.Value,
+	})
+
+	if status.State == AlertFiring {
+		logger.Warn("alert firing")
+	} else {
+		logger.Info("alert resolved")
+	}
+
+	if r.actions == nil || r.stopped {
+		return
+	}
+
+	select {
+	case r.actions[index] <- status:
+	default:
+		logger.Warn("too many alert actions pending, dropping the actions for this state change")
+	}
+}
+
+// Stops the workers once they ran the queued actions.
+func (r *AlertDataRowReader) stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return
+	}
+
+	r.stopped = true
+	for _, actions := range r.actions {
+		close(actions)
+	}
+}
+
+// Runs the actions of an alert for every state change, until the queue is
+// closed.
+func (r *AlertDataRowReader) runActions(actions chan AlertStatus) {
+	for status := range actions {
+		logger := r.logger.WithFields(logrus.Fields{
+			"alert": status.Condition,
+			"value": status.Value,
+		})
+
+		if r.command != "" {
+			r.runCommand(status, logger)
+		}
+
+		if r.webhook != "" {
+			r.callWebhook(status, logger)
+		}
+	}
+}
+
+func (r *AlertDataRowReader) runCommand(status AlertStatus, logger logrus.FieldLogger) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", r.command)
+	} else {
+		cmd = exec.Command("sh", "-c", r.command)
+	}
+
+	cmd.Env = append(os.Environ(),
+		"WESPLOT_ALERT="+status.Condition,
+		"WESPLOT_ALERT_STATE="+string(status.State),
+		"WESPLOT_ALERT_VALUE="+strconv.FormatFloat(status.Value, 'g', -1, 64),
+	)
+	cmd.Stdout = os.Stderr // Keep stdout clean for --tee
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		logger.WithError(err).Error("alert command failed")
+	}
+}
+
+func (r *AlertDataRowReader) callWebhook(status AlertStatus, logger logrus.FieldLogger) {
+	body, err := json.Marshal(status)
+	if err != nil {
+		logger.WithError(err).Error("cannot encode alert for webhook")
+		return
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(r.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.WithError(err).Error("alert webhook failed")
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		logger.WithField("status", resp.Status).Error("alert webhook failed")
+	}
+}
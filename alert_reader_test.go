@@ -0,0 +1,219 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseAlertCondition(t *testing.T) {
+	columns := []string{"y1", "y2"}
+
+	tests := []struct {
+		condition string
+		valid     bool
+		expected  AlertCondition
+	}{
+		{"y1 > 100", true, AlertCondition{Column: "y1", Operator: ">", Threshold: 100, columnIndex: 0}},
+		{"y2 <= -1.5 for 30s", true, AlertCondition{Column: "y2", Operator: "<=", Threshold: -1.5, For: 30 * time.Second, columnIndex: 1}},
+		{"y1 != 0", true, AlertCondition{Column: "y1", Operator: "!=", Threshold: 0, columnIndex: 0}},
+		{"y3 > 100", false, AlertCondition{}},
+		{"y1 => 100", false, AlertCondition{}},
+		{"y1 > abc", false, AlertCondition{}},
+		{"y1 > 100 for", false, AlertCondition{}},
+		{"y1 > 100 during 30s", false, AlertCondition{}},
+		{"y1 > 100 for 30", false, AlertCondition{}},
+		{"y1 >100", false, AlertCondition{}},
+	}
+
+	for _, test := range tests {
+		condition, err := ParseAlertCondition(test.condition, columns)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", test.condition, condition)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", test.condition, err)
+			continue
+		}
+
+		test.expected.Condition = test.condition
+		if condition != test.expected {
+			t.Errorf("%q: got %+v, expected %+v", test.condition, condition, test.expected)
+		}
+	}
+}
+
+func TestAlertConditionEvaluate(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []struct {
+		condition string
+		ys        []float64
+		active    bool
+	}{
+		{"y1 > 100", []float64{101, 0}, true},
+		{"y1 > 100", []float64{100, 0}, false},
+		{"y1 >= 100", []float64{100, 0}, true},
+		{"y2 < 0", []float64{0, -1}, true},
+		{"y2 <= 0", []float64{0, 1}, false},
+		{"y1 == 5", []float64{5, 0}, true},
+		{"y1 != 5", []float64{5, 0}, false},
+		// A missing value never triggers an alert.
+		{"y1 != 5", []float64{nan, 0}, false},
+		{"y2 > 0", []float64{1}, false},
+	}
+
+	for _, test := range tests {
+		condition, err := ParseAlertCondition(test.condition, []string{"y1", "y2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		active, _ := condition.evaluate(test.ys)
+		if active != test.active {
+			t.Errorf("%q with %v: got %v, expected %v", test.condition, test.ys, active, test.active)
+		}
+	}
+}
+
+func TestAlertDataRowReaderStates(t *testing.T) {
+	immediate, err := ParseAlertCondition("y1 > 10", []string{"y1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stays pending for the duration of the test.
+	delayed, err := ParseAlertCondition("y1 > 10 for 1h", []string{"y1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		y       float64
+		states  []AlertState
+		changes int // The number of status changes sent to the listener
+	}{
+		{5, []AlertState{AlertOk, AlertOk}, 0},
+		{11, []AlertState{AlertFiring, AlertPending}, 2},
+		{12, []AlertState{AlertFiring, AlertPending}, 0},
+		{math.NaN(), []AlertState{AlertOk, AlertOk}, 2},
+		{20, []AlertState{AlertFiring, AlertPending}, 2},
+	}
+
+	reader := NewAlertDataRowReader(nil, []AlertCondition{immediate, delayed}, "", "")
+	listener := reader.addListener()
+	defer reader.removeListener(listener)
+
+	for _, test := range tests {
+		reader.evaluate(DataRow{X: 1, Ys: []float64{test.y}})
+
+		statuses := reader.Statuses()
+		for i, status := range statuses {
+			if status.State != test.states[i] {
+				t.Errorf("y1 = %v: alert %q is %s, expected %s", test.y, status.Condition, status.State, test.states[i])
+			}
+		}
+
+		if len(listener) != test.changes {
+			t.Errorf("y1 = %v: %d status changes sent, expected %d", test.y, len(listener), test.changes)
+		}
+
+		for len(listener) > 0 {
+			<-listener
+		}
+	}
+}
+
+// Returns the rows in order, and then io.EOF.
+type sliceDataRowReader struct {
+	dataRows []DataRow
+}
+
+func (r *sliceDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if len(r.dataRows) == 0 {
+		return DataRow{}, io.EOF
+	}
+
+	dataRow := r.dataRows[0]
+	r.dataRows = r.dataRows[1:]
+	return dataRow, nil
+}
+
+func (r *sliceDataRowReader) ColumnNames() []string {
+	return []string{"y1"}
+}
+
+func TestAlertDataRowReaderWebhookOrder(t *testing.T) {
+	var mutex sync.Mutex
+	var states []AlertState
+	done := make(chan struct{})
+
+	// The first call is slow, so the later state changes are queued behind it.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var status alertStatusJSON
+		err := json.NewDecoder(req.Body).Decode(&status)
+		if err != nil {
+			t.Error(err)
+		}
+
+		mutex.Lock()
+		states = append(states, status.State)
+		count := len(states)
+		mutex.Unlock()
+
+		if count == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		if count == 4 {
+			close(done)
+		}
+	}))
+	defer server.Close()
+
+	condition, err := ParseAlertCondition("y1 > 10", []string{"y1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := &sliceDataRowReader{}
+	for _, y := range []float64{11, 5, 11, 5} {
+		input.dataRows = append(input.dataRows, DataRow{X: 1, Ys: []float64{y}})
+	}
+
+	reader := NewAlertDataRowReader(input, []AlertCondition{condition}, "", server.URL)
+	for {
+		_, err := reader.Read(context.Background())
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the webhook")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected := []AlertState{AlertFiring, AlertOk, AlertFiring, AlertOk}
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Fatalf("webhook called with %v, expected %v", states, expected)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package wesplot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// A labeled vertical marker at X, such as a deploy or the start of a load
+// test. Annotations are cached by the DataBroadcaster along with the rows, so
+// the clients that connect later see them as long as X is in the window.
+type Annotation struct {
+	X     float64
+	Label string
+}
+
+// Clients that pass the annotations query parameter (e.g. /ws?annotations)
+// receive the annotations in order with the rows. On /ws, they are sent as
+// {"Annotation": {...}}. On /sse, the annotation event is always sent.
+type AnnotationMessage struct {
+	Annotation Annotation
+}
+
+type annotationRequest struct {
+	X     *float64
+	Label string
+}
+
+// Serves POST /annotations, which adds an annotation to the plot. The body is
+// a JSON object such as {"Label": "deploy v2"}. If X is omitted, the current
+// timestamp is used, which requires the X values to be timestamps. The
+// response contains the annotation added. Must be enabled with SetControl.
+func (s *HttpServer) handleAnnotations(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkControl(w, req) {
+		return
+	}
+
+	var request annotationRequest
+	err := json.NewDecoder(req.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	annotation, err := s.newAnnotation(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.dataBroadcaster.Annotate(annotation)
+	s.logger.WithField("annotation", annotation).Info("added annotation")
+
+	writeJSON(w, annotation)
+}
+
+func (s *HttpServer) newAnnotation(request annotationRequest) (Annotation, error) {
+	if request.Label == "" {
+		return Annotation{}, errors.New("the Label of the annotation is required")
+	}
+
+	annotation := Annotation{Label: request.Label}
+	if request.X != nil {
+		annotation.X = *request.X
+	} else if s.currentMetadata().XIsTimestamp {
+		annotation.X = NowXGenerator(nil)
+	} else {
+		return Annotation{}, errors.New("the X of the annotation is required, as the X values are not timestamps")
+	}
+
+	return annotation, nil
+}
@@ -0,0 +1,101 @@
+package wesplot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleAnnotations(t *testing.T) {
+	tests := []struct {
+		name         string
+		control      bool
+		xIsTimestamp bool
+		origin       string
+		body         string
+		status       int
+		annotation   Annotation
+	}{
+		{
+			name:       "x and label",
+			control:    true,
+			body:       `{"X": 12, "Label": "deploy v2"}`,
+			status:     http.StatusOK,
+			annotation: Annotation{X: 12, Label: "deploy v2"},
+		},
+		{
+			name:         "current timestamp",
+			control:      true,
+			xIsTimestamp: true,
+			body:         `{"Label": "deploy v2"}`,
+			status:       http.StatusOK,
+			annotation:   Annotation{Label: "deploy v2"},
+		},
+		{
+			name:    "no x",
+			control: true,
+			body:    `{"Label": "deploy v2"}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "no label",
+			control: true,
+			body:    `{"X": 12}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:   "disabled",
+			body:   `{"X": 12, "Label": "deploy v2"}`,
+			status: http.StatusForbidden,
+		},
+		{
+			name:    "cross origin",
+			control: true,
+			origin:  "http://evil.example.org",
+			body:    `{"X": 12, "Label": "deploy v2"}`,
+			status:  http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		dataBroadcaster := NewDataBroadcaster(NewChannelDataRowReader([]string{"a"}, 10), 100, false)
+		metadata := Metadata{WindowSize: 100, XIsTimestamp: test.xIsTimestamp, WesplotOptions: WesplotOptions{Columns: []string{"a"}}}
+		s := NewHttpServer(dataBroadcaster, "127.0.0.1", 0, metadata, 250*time.Millisecond)
+		s.SetControl(test.control)
+
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/annotations", strings.NewReader(test.body))
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.mux.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: got status %d, expected %d: %s", test.name, recorder.Code, test.status, recorder.Body)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var annotation Annotation
+		err := json.NewDecoder(recorder.Body).Decode(&annotation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Without X, the current timestamp is used.
+		if test.xIsTimestamp && annotation.X > 1e9 {
+			annotation.X = 0
+		}
+
+		if annotation != test.annotation {
+			t.Errorf("%s: got %+v, expected %+v", test.name, annotation, test.annotation)
+		}
+	}
+}
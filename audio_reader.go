@@ -0,0 +1,128 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// The magnitudes below this level are plotted at it, as the log of silence
+// is -Inf.
+const audioFloorDB = -120
+
+// A DataRowReader that computes the spectrum of raw audio, such as the output
+// of arecord -t raw, and emits a row with the magnitude of every frequency
+// band for every window of fftSize samples. The X value is the receive
+// timestamp of the window.
+//
+// The audio must be mono, signed 16 bit little endian samples. The bands are
+// spaced logarithmically between the frequency of the first bin of the FFT and
+// the Nyquist frequency, as the pitch is perceived, and labeled with their
+// center frequency (e.g. 1.2 kHz). Their magnitude is the power of their bins
+// in dBFS, where 0 is a full scale sine wave.
+type AudioDataRowReader struct {
+	input      io.Reader
+	sampleRate int
+	fftSize    int
+
+	columns []string
+	// The first bin of every band, followed by the end of the last band.
+	bandBins []int
+
+	buf    []byte
+	window []float64
+	re, im []float64
+}
+
+func NewAudioDataRowReader(input io.Reader, sampleRate int, fftSize int, bands int) (*AudioDataRowReader, error) {
+	if !isPowerOfTwo(fftSize) || fftSize < 16 {
+		return nil, fmt.Errorf("the FFT size must be a power of 2 of at least 16, got %d", fftSize)
+	}
+
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("the sample rate must be positive, got %d", sampleRate)
+	}
+
+	// Every band needs at least one bin, and the bin 0 is the DC offset.
+	if bands <= 0 || bands > fftSize/2-1 {
+		return nil, fmt.Errorf("the number of bands must be between 1 and %d, got %d", fftSize/2-1, bands)
+	}
+
+	r := &AudioDataRowReader{
+		input:      input,
+		sampleRate: sampleRate,
+		fftSize:    fftSize,
+		buf:        make([]byte, fftSize*2),
+		window:     hannWindow(fftSize),
+		re:         make([]float64, fftSize),
+		im:         make([]float64, fftSize),
+	}
+
+	r.bandBins = logBandBins(fftSize, bands)
+	for band := 0; band < bands; band++ {
+		low := float64(r.bandBins[band]) * float64(sampleRate) / float64(fftSize)
+		high := float64(r.bandBins[band+1]) * float64(sampleRate) / float64(fftSize)
+		r.columns = append(r.columns, formatFrequency(math.Sqrt(low*high)))
+	}
+
+	return r, nil
+}
+
+func (r *AudioDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if err := ctx.Err(); err != nil {
+		return DataRow{}, err
+	}
+
+	_, err := io.ReadFull(r.input, r.buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// The last partial window is not plotted.
+		return DataRow{}, io.EOF
+	} else if err != nil {
+		return DataRow{}, err
+	}
+
+	for i := range r.re {
+		sample := float64(int16(binary.LittleEndian.Uint16(r.buf[i*2:]))) / 32768
+		r.re[i] = sample * r.window[i]
+		r.im[i] = 0
+	}
+
+	fft(r.re, r.im)
+
+	dataRow := DataRow{
+		X:  float64(time.Now().UnixNano()) / 1e9,
+		Ys: make([]float64, len(r.columns)),
+	}
+
+	// A full scale sine wave has an amplitude of 1, which is 0 dBFS.
+	for band := range r.columns {
+		amplitude := bandAmplitude(r.re, r.im, r.bandBins[band], r.bandBins[band+1])
+		dataRow.Ys[band] = math.Max(20*math.Log10(amplitude), audioFloorDB)
+	}
+
+	return dataRow, nil
+}
+
+func (r *AudioDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+// Closes the input if it can be closed, such as the command capturing the
+// audio.
+func (r *AudioDataRowReader) Close() {
+	if closer, ok := r.input.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func formatFrequency(hz float64) string {
+	if hz >= 1000 {
+		return fmt.Sprintf("%.1f kHz", hz/1000)
+	}
+
+	return fmt.Sprintf("%.0f Hz", hz)
+}
@@ -0,0 +1,80 @@
+package wesplot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// How the plot is opened once the server is listening. By default, it is
+// opened in the default browser.
+type BrowserOptions struct {
+	// Do not open the browser, such as over SSH where it would fail.
+	Disabled bool
+
+	// The command that opens the URL, which is appended as the last argument.
+	// If empty, the default browser of the platform is used.
+	Command []string
+
+	// Print the URL to stdout instead of opening the browser, so scripts can
+	// read it.
+	PrintURL bool
+
+	// Print a QR code of the URL to stderr, to open the plot on a phone. If
+	// the server listens on all interfaces, the URL uses the first non-loopback
+	// IPv4 address.
+	QRCode bool
+
+	// The page opened under the base path, such as dashboard. If empty, the
+	// plot is opened.
+	Page string
+}
+
+// Must be called before Run.
+func (s *HttpServer) SetBrowserOptions(options BrowserOptions) {
+	s.browserOptions = options
+}
+
+// The url is used by the browser on this computer, and the remoteURL by the
+// other devices.
+func (s *HttpServer) openPlot(url string, remoteURL string) {
+	options := s.browserOptions
+	url += options.Page
+	remoteURL += options.Page
+
+	if options.QRCode {
+		qrCode, err := qrcode.New(remoteURL, qrcode.Low)
+		if err != nil {
+			s.logger.WithError(err).Warn("cannot generate QR code")
+		} else {
+			fmt.Fprint(os.Stderr, qrCode.ToSmallString(false))
+		}
+	}
+
+	if options.PrintURL {
+		fmt.Println(remoteURL)
+		return
+	}
+
+	// Systemd services do not have a browser.
+	if options.Disabled || s.systemd {
+		return
+	}
+
+	if len(options.Command) == 0 {
+		openBrowser(url)
+		return
+	}
+
+	command := exec.Command(options.Command[0], append(options.Command[1:], url)...)
+	err := command.Start()
+	if err != nil {
+		s.logger.WithError(err).Warn("failed to start web browser")
+		return
+	}
+
+	// Reap the process once it exits.
+	go command.Wait()
+}
@@ -0,0 +1,67 @@
+package wesplot
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"golang.org/x/exp/slices"
+)
+
+// The maximum number of labels of a categorical X column, so a column that is
+// not categorical, such as a timestamp, does not grow the metadata forever.
+const maxXCategories = 1000
+
+// Maps the labels of a categorical X column, such as the keys of per-key
+// counts, to the X values they are plotted at: 0 for the first label seen, 1
+// for the second, and so on. The labels are sent to the clients in the
+// XCategories of the Metadata, so they can label the X axis.
+type XCategories struct {
+	mu      sync.Mutex
+	indices map[string]int
+	labels  []string
+
+	onNewLabel func(labels []string)
+}
+
+func NewXCategories() *XCategories {
+	return &XCategories{
+		indices: make(map[string]int),
+	}
+}
+
+// Returns the X value of the label, adding the label if it is new. This can be
+// used as the XParser of the TextToDataRowReader. An empty label is missing,
+// as with the other X values.
+func (c *XCategories) Parse(label string) (float64, error) {
+	if label == "" {
+		return math.NaN(), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if index, ok := c.indices[label]; ok {
+		return float64(index), nil
+	}
+
+	if len(c.labels) >= maxXCategories {
+		return 0, fmt.Errorf("too many x categories, %q would be the %dth", label, maxXCategories+1)
+	}
+
+	c.indices[label] = len(c.labels)
+	c.labels = append(c.labels, label)
+	if c.onNewLabel != nil {
+		c.onNewLabel(slices.Clone(c.labels))
+	}
+
+	return float64(len(c.labels) - 1), nil
+}
+
+// Calls the listener with all the labels whenever a label is added, such as
+// to update the XCategories of the Metadata (see HttpServer.UpdateMetadata).
+// The listener is called before the row with the new label is returned by the
+// reader. Must be called before Parse.
+func (c *XCategories) SetListener(listener func(labels []string)) {
+	c.onNewLabel = listener
+}
@@ -0,0 +1,131 @@
+package wesplot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Periodically rewrites a CSV file with the rows buffered by a
+// DataBroadcaster, which are the rows shown by the plot, so a crash loses at
+// most one interval of them. The file is written to a temporary file first and
+// renamed, so it is never seen half written.
+type Checkpointer struct {
+	broadcaster *DataBroadcaster
+	path        string
+	columns     []string
+
+	lastSeq uint64 // Of the last row written, to skip unchanged checkpoints
+}
+
+func NewCheckpointer(broadcaster *DataBroadcaster, path string, columns []string) *Checkpointer {
+	return &Checkpointer{
+		broadcaster: broadcaster,
+		path:        path,
+		columns:     columns,
+	}
+}
+
+// Writes the checkpoint every interval, if rows were emitted since the last
+// one. Failures are logged, and retried at the next interval. Returns when the
+// context is canceled.
+func (c *Checkpointer) Start(ctx context.Context, interval time.Duration) {
+	logger := logrus.WithFields(logrus.Fields{"tag": "Checkpointer", "path": c.path})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := c.Write()
+			if err != nil {
+				logger.WithError(err).Warn("cannot write the checkpoint")
+			}
+		}
+	}
+}
+
+// Writes the buffered rows to the file now, without the annotations, unless
+// they are the same as in the last checkpoint.
+func (c *Checkpointer) Write() error {
+	history := c.broadcaster.History()
+	if len(history) == 0 || history[len(history)-1].seq == c.lastSeq {
+		return nil
+	}
+
+	tempPath := c.path + ".tmp"
+	w, err := NewTeeFileWriter(tempPath, c.columns, TeeFormatCSV, 0, false)
+	if err != nil {
+		return err
+	}
+
+	for _, dataRow := range history {
+		if dataRow.annotation != nil || dataRow.gap || dataRow.streamEnded {
+			continue
+		}
+
+		err = w.Write(dataRow)
+		if err != nil {
+			w.Close()
+			os.Remove(tempPath)
+			return err
+		}
+	}
+
+	// Without the sync, the rename can reach the disk before the rows, and a
+	// crash then leaves an empty or truncated checkpoint in place of the last
+	// one.
+	err = w.Sync()
+	if err != nil {
+		w.Close()
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Rename(tempPath, c.path)
+	if err != nil {
+		return err
+	}
+
+	err = syncDir(filepath.Dir(c.path))
+	if err != nil {
+		return err
+	}
+
+	c.lastSeq = history[len(history)-1].seq
+	return nil
+}
+
+// Commits the renames in the directory to disk. Directories cannot be synced
+// on Windows, where the renames are committed with the file.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	err = dir.Sync()
+	closeErr := dir.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
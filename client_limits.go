@@ -0,0 +1,87 @@
+package wesplot
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// How long the rejected clients are asked to wait before retrying, in seconds.
+const clientLimitRetryAfter = 10
+
+// Limits the number of clients streaming the rows on /ws, /sse, and via gRPC,
+// as every client buffers up to MemoryLimits.ClientBufferSize rows, so a burst
+// of browser tabs or a misbehaving scraper cannot use memory without bound. The
+// clients over the limits are rejected with 503 Service Unavailable. 0 means no
+// limit. Must be called before Run.
+func (s *HttpServer) SetClientLimits(maxClients int, maxClientsPerIP int) {
+	s.maxClients = maxClients
+	s.maxClientsPerIP = maxClientsPerIP
+}
+
+// The effective limits, with MaxClients from SetClientLimits.
+func (s *HttpServer) memoryLimits() MemoryLimits {
+	limits := s.dataBroadcaster.MemoryLimits()
+	limits.MaxClients = s.maxClients
+	return limits
+}
+
+// The clients connected via unix sockets have no address and share the same
+// per-IP limit.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// Returns false if the client cannot connect because of the limits. Otherwise,
+// releaseClient must be called once the client disconnects.
+func (s *HttpServer) acquireClient(ip string) (ok bool, reason string) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	if s.maxClients > 0 && s.numClients >= s.maxClients {
+		return false, "too many clients are connected"
+	}
+
+	if s.maxClientsPerIP > 0 && s.clientsPerIP[ip] >= s.maxClientsPerIP {
+		return false, "too many clients are connected from " + ip
+	}
+
+	s.numClients++
+	s.clientsPerIP[ip]++
+	return true, ""
+}
+
+func (s *HttpServer) releaseClient(ip string) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	s.numClients--
+	s.clientsPerIP[ip]--
+	if s.clientsPerIP[ip] == 0 {
+		delete(s.clientsPerIP, ip)
+	}
+}
+
+// Rejects the clients over the limits set with SetClientLimits before next
+// accepts the connection.
+func (s *HttpServer) limitClients(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ip := clientIP(req)
+
+		ok, reason := s.acquireClient(ip)
+		if !ok {
+			s.logger.WithField("remoteAddr", req.RemoteAddr).Warnf("rejected client: %s", reason)
+			w.Header().Set("Retry-After", strconv.Itoa(clientLimitRetryAfter))
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+		defer s.releaseClient(ip)
+
+		next(w, req)
+	}
+}
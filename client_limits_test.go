@@ -0,0 +1,92 @@
+package wesplot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimitClients(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxClients      int
+		maxClientsPerIP int
+		remoteAddrs     []string
+		statuses        []int
+	}{
+		{
+			name:        "no limits",
+			remoteAddrs: []string{"10.0.0.1:1000", "10.0.0.1:1001", "10.0.0.1:1002"},
+			statuses:    []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:        "max clients",
+			maxClients:  2,
+			remoteAddrs: []string{"10.0.0.1:1000", "10.0.0.2:1000", "10.0.0.3:1000"},
+			statuses:    []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable},
+		},
+		{
+			name:            "max clients per ip",
+			maxClients:      10,
+			maxClientsPerIP: 1,
+			remoteAddrs:     []string{"10.0.0.1:1000", "10.0.0.1:1001", "10.0.0.2:1000"},
+			statuses:        []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK},
+		},
+	}
+
+	for _, test := range tests {
+		metadata := Metadata{WindowSize: 100, WesplotOptions: WesplotOptions{Columns: []string{"a"}}}
+		s := NewHttpServer(nil, "127.0.0.1", 0, metadata, 250*time.Millisecond)
+		s.SetClientLimits(test.maxClients, test.maxClientsPerIP)
+
+		// The clients stay connected until the end of the test.
+		release := make(chan struct{})
+		connected := make(chan struct{})
+		handler := s.limitClients(func(w http.ResponseWriter, req *http.Request) {
+			connected <- struct{}{}
+			<-release
+		})
+
+		results := make(chan int, len(test.remoteAddrs))
+		for i, remoteAddr := range test.remoteAddrs {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+			req.RemoteAddr = remoteAddr
+			recorder := httptest.NewRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				handler(recorder, req)
+				results <- recorder.Code
+				close(done)
+			}()
+
+			select {
+			case <-connected:
+			case <-done:
+			}
+
+			if test.statuses[i] == http.StatusOK {
+				continue
+			}
+
+			<-done
+			if code := <-results; code != test.statuses[i] {
+				t.Errorf("%s: client %d: got status %d, expected %d", test.name, i, code, test.statuses[i])
+			}
+		}
+
+		close(release)
+		for i := range test.remoteAddrs {
+			if test.statuses[i] == http.StatusOK {
+				if code := <-results; code != http.StatusOK {
+					t.Errorf("%s: got status %d for an accepted client", test.name, code)
+				}
+			}
+		}
+
+		if s.numClients != 0 || len(s.clientsPerIP) != 0 {
+			t.Errorf("%s: got %d clients after they disconnected", test.name, s.numClients)
+		}
+	}
+}
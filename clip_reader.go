@@ -0,0 +1,85 @@
+package wesplot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A DataRowReader that clamps the Y values to a range, so a single absurd
+// reading, such as a sensor glitch, does not squash the rest of the plot when
+// the Y axis is scaled automatically. The clamped values are counted in the
+// InputStats.
+type ClipDataRowReader struct {
+	input DataRowReader
+	min   float64
+	max   float64
+	stats *InputStats
+}
+
+// Creates the reader from a spec such as 0:100. Either bound can be omitted,
+// such as 0: to only clamp the negative values.
+func NewClipDataRowReader(input DataRowReader, spec string, stats *InputStats) (*ClipDataRowReader, error) {
+	minSpec, maxSpec, found := strings.Cut(spec, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid clip range %q, expected min:max (e.g. 0:100)", spec)
+	}
+
+	r := &ClipDataRowReader{
+		input: input,
+		min:   math.Inf(-1),
+		max:   math.Inf(1),
+		stats: stats,
+	}
+
+	var err error
+	if minSpec != "" {
+		r.min, err = strconv.ParseFloat(minSpec, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clip min %q: %w", minSpec, err)
+		}
+	}
+
+	if maxSpec != "" {
+		r.max, err = strconv.ParseFloat(maxSpec, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clip max %q: %w", maxSpec, err)
+		}
+	}
+
+	if r.min > r.max {
+		return nil, fmt.Errorf("invalid clip range %q, the max must not be lower than the min", spec)
+	}
+
+	return r, nil
+}
+
+func (r *ClipDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if err != nil {
+		return dataRow, err
+	}
+
+	for i, y := range dataRow.Ys {
+		// NaN is not compared, and stays a missing value.
+		if y < r.min {
+			dataRow.Ys[i] = r.min
+		} else if y > r.max {
+			dataRow.Ys[i] = r.max
+		} else {
+			continue
+		}
+
+		// The TeeWriter writes the clamped value.
+		dataRow.yTexts = nil
+		r.stats.ValueClipped()
+	}
+
+	return dataRow, nil
+}
+
+func (r *ClipDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+var annotateOptions struct {
+	URL   string  `long:"url" default:"http://localhost:5274" description:"The URL of the running wesplot"`
+	X     float64 `short:"x" long:"x" description:"The X value of the annotation. Default: the current time"`
+	Token string  `long:"token" env:"WESPLOT_CONTROL_TOKEN" description:"The --control-token of the running wesplot, if any"`
+
+	Args struct {
+		Label []string `positional-arg-name:"label" required:"1"`
+	} `positional-args:"yes"`
+}
+
+// Adds a labeled vertical marker to the plot of a running wesplot via POST
+// /annotations, for example from a deploy script:
+//
+//	wesplot annotate "deploy v2"
+func runAnnotate(args []string) {
+	parser := flags.NewParser(&annotateOptions, flags.Default)
+	parser.Usage = "[OPTIONS] label"
+
+	_, err := parser.ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	fields := map[string]any{
+		"Label": strings.Join(annotateOptions.Args.Label, " "),
+	}
+	if parser.FindOptionByLongName("x").IsSet() {
+		fields["X"] = annotateOptions.X
+	}
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+
+	url := strings.TrimSuffix(annotateOptions.URL, "/") + "/annotations"
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		logrus.WithError(err).Error("invalid --url")
+		os.Exit(1)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if annotateOptions.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+annotateOptions.Token)
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		logrus.WithError(err).Error("cannot reach wesplot")
+		os.Exit(1)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(response.Body)
+		logrus.Errorf("cannot add annotation: %s", strings.TrimSpace(string(message)))
+		os.Exit(1)
+	}
+
+	var annotation wesplot.Annotation
+	err = json.NewDecoder(response.Body).Decode(&annotation)
+	if err != nil {
+		logrus.WithError(err).Error("invalid response from wesplot")
+		os.Exit(1)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"x":     annotation.X,
+		"label": annotation.Label,
+	}).Info("added annotation")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+)
+
+var audioOptions struct {
+	Device     string `long:"device" default:"default" description:"The ALSA device to capture the audio from with arecord, on Linux"`
+	FFT        int    `long:"fft" default:"1024" description:"The number of samples of every FFT, a power of 2"`
+	Bands      int    `long:"bands" default:"16" description:"The number of frequency bands to plot"`
+	SampleRate int    `long:"sample-rate" default:"44100" description:"The sample rate of the audio captured, in Hz"`
+	Command    string `long:"command" description:"Capture the audio with this command instead, which must write raw mono 16 bit little endian samples at the --sample-rate to its stdout"`
+}
+
+// The spectrum of the audio captured by the audio subcommand, if any, which
+// is read instead of the input.
+var audioReader *wesplot.AudioDataRowReader
+
+// Turns the arguments of the audio subcommand into the options of wesplot, to
+// plot the spectrum of the audio captured from a device:
+//
+//	wesplot audio --device default --fft 1024 --bands 16
+//
+// The audio is captured with arecord on Linux and sox elsewhere, or with the
+// --command that writes raw mono 16 bit little endian samples at the
+// --sample-rate to its stdout. The other arguments are passed through and
+// take precedence.
+func audioArgs(args []string) []string {
+	parser := flags.NewParser(&audioOptions, flags.Default|flags.IgnoreUnknown)
+	parser.Usage = "[--device default] [--fft 1024] [--bands 16] [--sample-rate 44100] [--command CMD] [OPTIONS of wesplot]"
+	passed, err := parser.ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	hasColumns := false
+	for _, arg := range passed {
+		if arg == "-c" || strings.HasPrefix(arg, "--columns") || strings.HasPrefix(arg, "-n") || strings.HasPrefix(arg, "--num-columns") {
+			hasColumns = true
+		}
+	}
+
+	command := audioOptions.Command
+	if command == "" && runtime.GOOS == "linux" {
+		command = fmt.Sprintf("arecord -q -D '%s' -f S16_LE -c 1 -r %d -t raw", strings.ReplaceAll(audioOptions.Device, "'", `'\''`), audioOptions.SampleRate)
+	} else if command == "" {
+		// sox captures from the default device, or from the AUDIODEV
+		// environment variable.
+		command = fmt.Sprintf("sox -q -d -t raw -b 16 -e signed-integer -L -c 1 -r %d -", audioOptions.SampleRate)
+	}
+
+	capture, err := wesplot.NewExecReader(command, wesplot.RestartNever)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot capture the audio with %s: %v\n", command, err)
+		os.Exit(1)
+	}
+
+	audioReader, err = wesplot.NewAudioDataRowReader(capture, audioOptions.SampleRate, audioOptions.FFT, audioOptions.Bands)
+	if err != nil {
+		capture.Close()
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	audioArgs := []string{"--title", "Audio spectrum", "--yunit", "dBFS", "--ymin", "-120", "--ymax", "0"}
+	if !hasColumns {
+		for _, column := range audioReader.ColumnNames() {
+			audioArgs = append(audioArgs, "--columns", column)
+		}
+	}
+
+	return append(audioArgs, passed...)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+var benchOptions struct {
+	Rate     int           `long:"rate" default:"1000" description:"The number of rows to generate per second"`
+	Columns  int           `long:"columns" default:"1" description:"The number of columns in every row"`
+	Duration time.Duration `long:"duration" description:"Stop after this duration (e.g. 30s). By default, rows are generated until interrupted"`
+}
+
+// How often the rows are generated. The rows due since the last tick are
+// written at once, as sleeping between every row is not precise enough at
+// high rates.
+const benchTickInterval = 10 * time.Millisecond
+
+// Generates synthetic rows at a target rate and writes them to stdout, so it
+// can be piped into another wesplot to measure the throughput of the pipeline:
+//
+//	wesplot bench --rate 100000 --columns 8 | wesplot -n 8
+//
+// The rate actually achieved is logged every second, which is lower than the
+// target if the consumer cannot keep up.
+func runBench(args []string) {
+	_, err := flags.NewParser(&benchOptions, flags.Default).ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	if benchOptions.Rate <= 0 || benchOptions.Columns <= 0 {
+		logrus.Error("--rate and --columns must be positive")
+		os.Exit(1)
+	}
+
+	logger := logrus.WithField("tag", "Bench")
+	logger.WithFields(logrus.Fields{
+		"rate":    benchOptions.Rate,
+		"columns": benchOptions.Columns,
+	}).Info("generating rows")
+
+	output := bufio.NewWriterSize(os.Stdout, 64*1024)
+	line := make([]byte, 0, benchOptions.Columns*24)
+
+	ticker := time.NewTicker(benchTickInterval)
+	defer ticker.Stop()
+
+	startTime := time.Now()
+	lastLogTime := startTime
+	var rowsWritten, lastRowsWritten int64
+
+	for now := range ticker.C {
+		elapsed := now.Sub(startTime)
+		done := benchOptions.Duration > 0 && elapsed >= benchOptions.Duration
+		if done {
+			elapsed = benchOptions.Duration
+		}
+
+		rowsDue := int64(elapsed.Seconds() * float64(benchOptions.Rate))
+		for ; rowsWritten < rowsDue; rowsWritten++ {
+			line = appendBenchRow(line[:0], rowsWritten)
+			output.Write(line)
+		}
+
+		err := output.Flush()
+		if errors.Is(err, syscall.EPIPE) {
+			// The consumer exited.
+			break
+		} else if err != nil {
+			logger.WithError(err).Error("cannot write rows")
+			os.Exit(1)
+		}
+
+		if now.Sub(lastLogTime) >= time.Second {
+			rate := float64(rowsWritten-lastRowsWritten) / now.Sub(lastLogTime).Seconds()
+			logger.WithFields(logrus.Fields{
+				"rowsWritten": rowsWritten,
+				"rowsPerSec":  math.Round(rate),
+			}).Info("bench summary")
+
+			lastLogTime = now
+			lastRowsWritten = rowsWritten
+		}
+
+		if done {
+			break
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"rowsWritten": rowsWritten,
+		"duration":    time.Since(startTime).Round(time.Millisecond),
+	}).Info("bench ended")
+}
+
+// Every column is a sine wave with a different period plus some noise, so the
+// plot is easy to check visually.
+func appendBenchRow(line []byte, row int64) []byte {
+	for i := 0; i < benchOptions.Columns; i++ {
+		if i > 0 {
+			line = append(line, ',')
+		}
+
+		period := float64(1000 * (i + 1))
+		value := math.Sin(2*math.Pi*float64(row)/period) + float64(i) + rand.Float64()*0.1
+		line = strconv.AppendFloat(line, value, 'f', 4, 64)
+	}
+
+	return append(line, '\n')
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+)
+
+// The completion scripts call wesplot with the GO_FLAGS_COMPLETION environment
+// variable set, in which case go-flags prints the completions of the last
+// argument instead of running. This covers all the flags and their choices
+// (such as --chart-type and --preset) without having to update the scripts.
+var completionScripts = map[string]string{
+	"bash": `_wesplot() {
+    local IFS=$'\n'
+    COMPREPLY=($(GO_FLAGS_COMPLETION=1 "${COMP_WORDS[0]}" "${COMP_WORDS[@]:1:$COMP_CWORD}"))
+}
+complete -o default -F _wesplot wesplot
+`,
+	"zsh": `#compdef wesplot
+_wesplot() {
+    local -a completions
+    completions=("${(@f)$(GO_FLAGS_COMPLETION=1 "${words[1]}" "${(@)words[2,$CURRENT]}")}")
+    if [[ -n "${completions[1]}" ]]; then
+        compadd -Q -a completions
+    else
+        _files
+    fi
+}
+compdef _wesplot wesplot
+`,
+	"fish": `function __wesplot_complete
+    set -l args (commandline -opc)[2..-1] (commandline -ct)
+    GO_FLAGS_COMPLETION=1 wesplot $args
+end
+complete -c wesplot -a '(__wesplot_complete)'
+`,
+}
+
+// Prints the completion script for the shell, to be sourced by the shell:
+//
+//	source <(wesplot completion bash)
+func runCompletion(args []string) {
+	if len(args) != 1 || completionScripts[args[0]] == "" {
+		fmt.Fprintln(os.Stderr, "usage: wesplot completion bash|zsh|fish")
+		os.Exit(1)
+	}
+
+	fmt.Print(completionScripts[args[0]])
+}
+
+// The name of a preset for --preset, which completes the registered presets.
+type presetName string
+
+func (p presetName) Complete(match string) []flags.Completion {
+	completions := make([]flags.Completion, 0)
+	for _, name := range wesplot.PresetNames() {
+		if strings.HasPrefix(name, match) {
+			preset, _ := wesplot.LookupPreset(name)
+			completions = append(completions, flags.Completion{Item: name, Description: preset.Description})
+		}
+	}
+
+	return completions
+}
+
+// go-flags does not complete the choices of the flags, so they are completed
+// here. Returns false if the last argument is not the value of a flag with
+// choices, in which case go-flags completes it.
+func completeChoices(parser *flags.Parser, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	var option *flags.Option
+	prefix := ""
+	match := args[len(args)-1]
+
+	if name, value, found := strings.Cut(match, "="); found && strings.HasPrefix(name, "--") {
+		option = parser.FindOptionByLongName(strings.TrimPrefix(name, "--"))
+		prefix = name + "="
+		match = value
+	} else if len(args) >= 2 {
+		flag := args[len(args)-2]
+		if strings.HasPrefix(flag, "--") {
+			option = parser.FindOptionByLongName(strings.TrimPrefix(flag, "--"))
+		} else if len(flag) == 2 && flag[0] == '-' {
+			option = parser.FindOptionByShortName(rune(flag[1]))
+		}
+	}
+
+	if option == nil || len(option.Choices) == 0 {
+		return false
+	}
+
+	for _, choice := range option.Choices {
+		if strings.HasPrefix(choice, match) {
+			fmt.Println(prefix + choice)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/exp/slices"
+)
+
+// The config file contains default options, and named profiles of options
+// selected with --profile. The profiles can also be combined with a --preset. The keys are the long names of the flags:
+//
+//	window-size = 3600
+//
+//	[profiles.ping]
+//	regex = 'time=(?P<latency>[0-9.]+) ms'
+//	yunit = "ms"
+//	hline = ["100:label=SLO"]
+//
+// Flags that can be specified multiple times are arrays. The options specified
+// on the command line take precedence over the profile, the preset, and the
+// defaults, in that order.
+type configFile struct {
+	Options  map[string]any
+	Profiles map[string]map[string]any
+}
+
+// Returns the path of the default config file, ~/.config/wesplot/config.toml
+// on Linux.
+func defaultConfigPath() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(configDir, "wesplot", "config.toml")
+}
+
+func loadConfigFile(path string) (configFile, error) {
+	config := configFile{
+		Options:  make(map[string]any),
+		Profiles: make(map[string]map[string]any),
+	}
+
+	_, err := toml.DecodeFile(path, &config.Options)
+	if err != nil {
+		return config, err
+	}
+
+	profiles, found := config.Options["profiles"]
+	if !found {
+		return config, nil
+	}
+
+	delete(config.Options, "profiles")
+
+	profilesTable, ok := profiles.(map[string]any)
+	if !ok {
+		return config, errors.New("profiles must be a table")
+	}
+
+	for name, profile := range profilesTable {
+		profileOptions, ok := profile.(map[string]any)
+		if !ok {
+			return config, fmt.Errorf("profile %s must be a table", name)
+		}
+
+		config.Profiles[name] = profileOptions
+	}
+
+	return config, nil
+}
+
+// Converts the options of the config file, the preset, and the profile into
+// command line arguments, which must be parsed before the actual command line
+// arguments. The options that were set on the command line (as parsed by the
+// parser) are skipped, so the flags that can be specified multiple times are
+// replaced instead of appended to. The options of the profile take precedence
+// over the preset, which takes precedence over the defaults of the config
+// file. If configPath is empty, the default config file is used if it exists.
+func configArgs(parser *flags.Parser, configPath string, profile string, preset string) ([]string, error) {
+	explicitPath := configPath != ""
+	if !explicitPath {
+		configPath = defaultConfigPath()
+	}
+
+	config, err := loadConfigFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) && !explicitPath {
+		if profile != "" {
+			return nil, fmt.Errorf("--profile %s requires a config file at %s", profile, configPath)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read config file %s: %w", configPath, err)
+	}
+
+	err = checkOptionNames(parser, config.Options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", configPath, err)
+	}
+
+	layers := []map[string]any{config.Options}
+
+	if preset != "" {
+		presetOptions, found := wesplot.LookupPreset(preset)
+		if !found {
+			return nil, fmt.Errorf("unknown preset %s, available presets: %s", preset, strings.Join(wesplot.PresetNames(), ", "))
+		}
+
+		err = checkOptionNames(parser, presetOptions.Options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preset %s: %w", preset, err)
+		}
+
+		layers = append(layers, presetOptions.Options)
+	}
+
+	if profile != "" {
+		profileOptions, found := config.Profiles[profile]
+		if !found {
+			return nil, fmt.Errorf("profile %s not found in config file %s", profile, configPath)
+		}
+
+		err = checkOptionNames(parser, profileOptions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile %s in config file %s: %w", profile, configPath, err)
+		}
+
+		layers = append(layers, profileOptions)
+	}
+
+	// The flags that can be specified multiple times are replaced as a whole.
+	options := make(map[string]any)
+	for _, layer := range layers {
+		for name, value := range layer {
+			options[name] = value
+		}
+	}
+
+	return optionsToArgs(parser, options)
+}
+
+// These options select the other options, so they cannot be set by them.
+var selectorOptions = []string{"config", "profile", "preset"}
+
+func checkOptionNames(parser *flags.Parser, options map[string]any) error {
+	for name := range options {
+		if parser.FindOptionByLongName(name) == nil || slices.Contains(selectorOptions, name) {
+			return fmt.Errorf("unknown option %s", name)
+		}
+	}
+
+	return nil
+}
+
+func optionsToArgs(parser *flags.Parser, options map[string]any) ([]string, error) {
+	// Sorted so the arguments are deterministic.
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := make([]string, 0, len(options))
+	for _, name := range names {
+		option := parser.FindOptionByLongName(name)
+		if option.IsSet() && !option.IsSetDefault() {
+			// Set on the command line.
+			continue
+		}
+
+		values, ok := options[name].([]any)
+		if !ok {
+			values = []any{options[name]}
+		}
+
+		for _, value := range values {
+			switch value := value.(type) {
+			case bool:
+				if value {
+					args = append(args, "--"+name)
+				}
+			case string, int, int64, float64:
+				args = append(args, fmt.Sprintf("--%s=%v", name, value))
+			default:
+				return nil, fmt.Errorf("option %s has an unsupported value %v", name, value)
+			}
+		}
+	}
+
+	return args, nil
+}
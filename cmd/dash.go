@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+var dashOptions struct {
+	Attach    []string `long:"attach" required:"true" description:"The URL of a running wesplot to show in the dashboard. Can be repeated"`
+	Token     string   `long:"token" env:"WESPLOT_VIEW_TOKEN" description:"The --view-token of the attached plots, if any"`
+	Host      string   `short:"h" long:"host" default:"0.0.0.0" description:"the IP to start the server on. Default to 0.0.0.0 (all interfaces)"`
+	Port      uint16   `short:"p" long:"port" default:"5274"`
+	NoBrowser bool     `long:"no-browser" description:"Do not open the dashboard in the browser, such as over SSH"`
+}
+
+// Shows the plots of several running wesplot servers on a single page, for
+// example the ad-hoc plots started on different ports:
+//
+//	wesplot dash --attach http://localhost:5274 --attach http://localhost:5275
+//
+// The rows of every attached plot are read from its /ws endpoint and served
+// again as a stream of /ws-mux, which /dashboard shows. The first attached
+// plot is also served as the plot of this server.
+func runDash(args []string) {
+	_, err := flags.NewParser(&dashOptions, flags.Default).ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+
+	var server *wesplot.HttpServer
+	for _, remote := range dashOptions.Attach {
+		reader, err := wesplot.NewRemoteDataRowReader(ctx, remote, dashOptions.Token, wesplot.HistoryAll)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --attach")
+			os.Exit(1)
+		}
+
+		metadata := reader.Metadata()
+		dataBroadcaster := wesplot.NewDataBroadcaster(reader, metadata.WindowSize, false)
+		if metadata.WindowDuration > 0 {
+			dataBroadcaster.SetWindowDuration(time.Duration(metadata.WindowDuration * float64(time.Second)))
+		}
+
+		if server == nil {
+			server = wesplot.NewHttpServer(dataBroadcaster, dashOptions.Host, dashOptions.Port, metadata, 250*time.Millisecond)
+		} else {
+			err = server.AddStream(remoteName(remote), dataBroadcaster, metadata)
+			if err != nil {
+				logrus.WithError(err).Error("invalid --attach")
+				os.Exit(1)
+			}
+		}
+
+		dataBroadcaster.Start(ctx)
+	}
+
+	server.SetBrowserOptions(wesplot.BrowserOptions{
+		Disabled: dashOptions.NoBrowser,
+		Page:     "dashboard",
+	})
+
+	server.RunContext(ctx)
+}
+
+// Identifies a remote plot by its host and path, such as localhost:5275.
+func remoteName(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return remote
+	}
+
+	return u.Host + strings.TrimSuffix(u.Path, "/")
+}
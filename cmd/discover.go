@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cactusdynamics/wesplot/mdns"
+	"github.com/grandcat/zeroconf"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+var discoverOptions struct {
+	Timeout time.Duration `long:"timeout" default:"3s" description:"How long to wait for the plots to answer"`
+}
+
+// Lists the plots advertised on the local network with --mdns, one per line
+// with the URL and the title:
+//
+//	http://192.168.1.10:5274/	Latency
+func runDiscover(args []string) {
+	_, err := flags.NewParser(&discoverOptions, flags.Default).ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		logrus.WithError(err).Error("cannot start mDNS resolver")
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoverOptions.Timeout)
+	defer cancel()
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	err = resolver.Browse(ctx, mdns.Service, "local.", entries)
+	if err != nil {
+		logrus.WithError(err).Error("cannot browse mDNS")
+		os.Exit(1)
+	}
+
+	found := 0
+	for entry := range entries {
+		if len(entry.AddrIPv4) == 0 {
+			continue
+		}
+
+		title, path := "", "/"
+		for _, text := range entry.Text {
+			if value, ok := strings.CutPrefix(text, "title="); ok {
+				title = value
+			} else if value, ok := strings.CutPrefix(text, "path="); ok {
+				path = value
+			}
+		}
+
+		fmt.Printf("http://%s:%d%s\t%s\n", entry.AddrIPv4[0], entry.Port, path, title)
+		found++
+	}
+
+	if found == 0 {
+		logrus.Warn("no plots found, start wesplot with --mdns to advertise them")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+)
+
+var dockerOptions struct {
+	Container string `long:"container" required:"true" description:"The name or the ID of the container to plot"`
+	Metrics   string `long:"metrics" default:"cpu,mem" description:"The comma-separated metrics to plot, among cpu, mem, block, and net"`
+}
+
+// The statistics of the container of the docker subcommand, if any, which are
+// read instead of the input.
+var dockerReader *wesplot.DockerDataRowReader
+
+// Turns the arguments of the docker subcommand into the options of wesplot,
+// to plot the statistics of a container as shown by docker stats:
+//
+//	wesplot docker --container web --metrics cpu,mem,net
+//
+// The metrics default to cpu and mem. Unless --columns is specified, the
+// columns in bytes are plotted against the secondary Y axis. The other
+// arguments are passed through.
+func dockerArgs(args []string) []string {
+	parser := flags.NewParser(&dockerOptions, flags.Default|flags.IgnoreUnknown)
+	parser.Usage = "--container name [--metrics cpu,mem,block,net] [OPTIONS of wesplot]"
+	passed, err := parser.ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	hasColumns := false
+	hasTitle := false
+	for _, arg := range passed {
+		if arg == "-c" || strings.HasPrefix(arg, "--columns") || strings.HasPrefix(arg, "-n") || strings.HasPrefix(arg, "--num-columns") {
+			hasColumns = true
+		} else if arg == "-t" || strings.HasPrefix(arg, "--title") {
+			hasTitle = true
+		}
+	}
+
+	container := dockerOptions.Container
+	dockerReader, err = wesplot.NewDockerDataRowReader(context.Background(), container, strings.Split(dockerOptions.Metrics, ","))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var dockerArgs []string
+	if !hasTitle {
+		dockerArgs = append(dockerArgs, "--title", "container "+container)
+	}
+
+	if !hasColumns {
+		var y2Columns []string
+		for _, column := range dockerReader.ColumnNames() {
+			dockerArgs = append(dockerArgs, "--columns", column)
+			if strings.Contains(column, "bytes") {
+				y2Columns = append(y2Columns, column)
+			}
+		}
+
+		if len(y2Columns) > 0 && len(y2Columns) < len(dockerReader.ColumnNames()) {
+			for _, column := range y2Columns {
+				dockerArgs = append(dockerArgs, "--y2-columns", column)
+			}
+
+			dockerArgs = append(dockerArgs, "--y2label", "bytes")
+		}
+	}
+
+	return append(dockerArgs, passed...)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+var exportOptions struct {
+	URL   string `long:"url" default:"http://localhost:5274" description:"The URL of the running wesplot"`
+	Token string `long:"token" env:"WESPLOT_VIEW_TOKEN" description:"The --view-token of the running wesplot, if any"`
+	HTML  string `long:"html" required:"true" description:"Write the plot to this HTML file, which shows it without wesplot, even offline"`
+}
+
+// Saves the plot of a running wesplot with its buffered rows as a single HTML
+// file via /export.html, for example to attach it to a ticket:
+//
+//	wesplot export --html report.html
+func runExport(args []string) {
+	_, err := flags.NewParser(&exportOptions, flags.Default).ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	url := strings.TrimSuffix(exportOptions.URL, "/") + "/export.html"
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		logrus.WithError(err).Error("invalid --url")
+		os.Exit(1)
+	}
+
+	if exportOptions.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+exportOptions.Token)
+	}
+
+	client := http.Client{Timeout: time.Minute}
+	response, err := client.Do(request)
+	if err != nil {
+		logrus.WithError(err).Error("cannot reach wesplot")
+		os.Exit(1)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(response.Body)
+		logrus.Errorf("cannot export the plot: %s", strings.TrimSpace(string(message)))
+		os.Exit(1)
+	}
+
+	file, err := os.Create(exportOptions.HTML)
+	if err != nil {
+		logrus.WithError(err).Error("invalid --html")
+		os.Exit(1)
+	}
+
+	_, err = io.Copy(file, response.Body)
+	if err == nil {
+		err = file.Close()
+	}
+
+	if err != nil {
+		logrus.WithError(err).Error("cannot write the plot")
+		os.Exit(1)
+	}
+
+	logrus.WithField("file", exportOptions.HTML).Info("exported the plot")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+)
+
+var gpuOptions struct {
+	Metrics  string        `long:"metrics" default:"util" description:"The comma-separated metrics to plot, among util, mem, temp, and power"`
+	Interval time.Duration `long:"interval" default:"1s" description:"The interval between two samples"`
+	Tool     string        `long:"tool" choice:"auto" choice:"nvidia-smi" choice:"rocm-smi" default:"auto" description:"The tool reporting the metrics of the GPUs. auto uses the one that is installed"`
+}
+
+// The GPU metrics being sampled by the gpu subcommand, if any, which are read
+// instead of the input.
+var gpuReader *wesplot.GpuDataRowReader
+
+// The options of the plot of a single GPU metric, as with --preset.
+var gpuMetricOptions = map[string][]string{
+	"util":  {"--title", "GPU utilization", "--yunit", "%", "--ymin", "0", "--ymax", "100"},
+	"mem":   {"--title", "GPU memory used", "--yunit", "MiB", "--ymin", "0"},
+	"temp":  {"--title", "GPU temperature", "--yunit", "°C"},
+	"power": {"--title", "GPU power draw", "--yunit", "W", "--ymin", "0"},
+}
+
+// Turns the arguments of the gpu subcommand into the options of wesplot, to
+// plot the metrics of the GPUs reported by nvidia-smi or rocm-smi:
+//
+//	wesplot gpu --metrics util,temp --interval 2s --tool rocm-smi
+//
+// The metrics default to util, and the tool to the one that is installed.
+// With a single metric, the title and the Y axis are set for it, while the
+// other arguments are passed through and take precedence.
+func gpuArgs(args []string) []string {
+	parser := flags.NewParser(&gpuOptions, flags.Default|flags.IgnoreUnknown)
+	parser.Usage = "[--metrics util,mem,temp,power] [--interval 1s] [--tool auto|nvidia-smi|rocm-smi] [OPTIONS of wesplot]"
+	passed, err := parser.ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	hasColumns := false
+	for _, arg := range passed {
+		if arg == "-c" || strings.HasPrefix(arg, "--columns") || strings.HasPrefix(arg, "-n") || strings.HasPrefix(arg, "--num-columns") {
+			hasColumns = true
+		}
+	}
+
+	metricNames := strings.Split(gpuOptions.Metrics, ",")
+	gpuReader, err = wesplot.NewGpuDataRowReader(gpuOptions.Tool, metricNames, gpuOptions.Interval)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	gpuArgs := []string{"--title", "GPU metrics"}
+	if len(metricNames) == 1 {
+		gpuArgs = append([]string(nil), gpuMetricOptions[metricNames[0]]...)
+	}
+
+	if !hasColumns {
+		for _, column := range gpuReader.ColumnNames() {
+			gpuArgs = append(gpuArgs, "--columns", column)
+		}
+	}
+
+	return append(gpuArgs, passed...)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Filters the log entries by the level configured for their component, which
+// is the tag field set by every component of wesplot. Entries of components
+// without a configured level (and without a tag) use the default level.
+type componentLevelFormatter struct {
+	logrus.Formatter
+
+	defaultLevel    logrus.Level
+	componentLevels map[string]logrus.Level
+}
+
+func (f *componentLevelFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	level := f.defaultLevel
+	if tag, ok := entry.Data["tag"].(string); ok {
+		if componentLevel, ok := f.componentLevels[tag]; ok {
+			level = componentLevel
+		}
+	}
+
+	if entry.Level > level {
+		// Nothing is written for an empty entry.
+		return nil, nil
+	}
+
+	return f.Formatter.Format(entry)
+}
+
+// Configures the standard logrus logger used by all the components from the
+// --log-*, --verbose, and --quiet options. The logs are written to stderr
+// unless --log-file is specified, so they never interleave with the --tee
+// output on stdout.
+func configureLogging() error {
+	var formatter logrus.Formatter = &logrus.TextFormatter{}
+	if options.LogFormat == "json" {
+		formatter = &logrus.JSONFormatter{}
+	}
+
+	if options.Verbose && options.Quiet {
+		return fmt.Errorf("--verbose and --quiet are mutually exclusive")
+	}
+
+	defaultLevel := logrus.InfoLevel
+	if options.Verbose {
+		defaultLevel = logrus.DebugLevel
+	} else if options.Quiet {
+		defaultLevel = logrus.WarnLevel
+	}
+
+	componentLevels := make(map[string]logrus.Level)
+	for _, logLevel := range options.LogLevels {
+		component, levelName, found := strings.Cut(logLevel, "=")
+		if !found {
+			levelName = component
+		}
+
+		level, err := logrus.ParseLevel(levelName)
+		if err != nil {
+			return fmt.Errorf("invalid --log-level %q: %w", logLevel, err)
+		}
+
+		if found {
+			componentLevels[component] = level
+		} else {
+			defaultLevel = level
+		}
+	}
+
+	// The logger must let through the entries of the most verbose component, as
+	// the others are filtered by the formatter.
+	loggerLevel := defaultLevel
+	for _, level := range componentLevels {
+		if level > loggerLevel {
+			loggerLevel = level
+		}
+	}
+
+	if options.LogFile != "" {
+		logFile, err := os.OpenFile(options.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("cannot open --log-file: %w", err)
+		}
+
+		// The file is left open until the process exits.
+		logrus.SetOutput(logFile)
+	}
+
+	logrus.SetLevel(loggerLevel)
+	logrus.SetFormatter(&componentLevelFormatter{
+		Formatter:       formatter,
+		defaultLevel:    defaultLevel,
+		componentLevels: componentLevels,
+	})
+
+	return nil
+}
@@ -4,43 +4,212 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/cactusdynamics/wesplot"
+	"github.com/cactusdynamics/wesplot/mdns"
+	"github.com/cactusdynamics/wesplot/mqtt"
 	"github.com/jessevdk/go-flags"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 )
 
+// The speed factor for --replay-speed, specified like 10x or 10.
+type replaySpeed float64
+
+func (s *replaySpeed) UnmarshalFlag(value string) error {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	if err != nil {
+		return fmt.Errorf("invalid replay speed %q: %w", value, err)
+	}
+
+	if speed <= 0 {
+		return fmt.Errorf("replay speed must be positive, got %q", value)
+	}
+
+	*s = replaySpeed(speed)
+	return nil
+}
+
+// A size in bytes for --tee-rotate, specified like 100MB, 512KB, or 1GB. The
+// units are powers of 1024.
+type byteSize int64
+
+func (s *byteSize) UnmarshalFlag(value string) error {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("invalid size %q, expected a positive size like 100MB", value)
+	}
+
+	*s = byteSize(size * multiplier)
+	return nil
+}
+
 var options struct {
-	Host    string `short:"h" long:"host" default:"0.0.0.0" description:"the IP to start the server on. Default to 0.0.0.0 (all interfaces)"`
-	Port    uint16 `short:"p" long:"port" default:"5274"`
-	Verbose bool   `short:"v" long:"verbose" description:"Show debug logs"`
-	Tee     bool   `short:"T" long:"tee" description:"Write the data (and generated timestamp if applicable) in a CSV into stdout in addition to visualizing with the plot"`
-
-	Title     string   `short:"t" long:"title" default:"Wesplot" description:"Title of the plot. Defaults to 'Plot'"`
-	YMin      *float64 `short:"m" long:"ymin" description:"The minimum value for y (default: auto scaling)"`
-	YMax      *float64 `short:"M" long:"ymax" description:"The max value for y (default: auto scaling)"`
-	YUnit     string   `short:"u" long:"yunit" description:"The unit for the Y axis"`
-	XLabel    string   `long:"xlabel" description:"Label for the X axis"`
-	YLabel    string   `long:"ylabel" description:"Label for the Y axis"`
-	ChartType string   `long:"chart-type" choice:"scatter" choice:"line" default:"line" description:"The type of chart to plot (scatter or line). Defaults to 'line'"`
-
-	XIndex        int  `short:"x" long:"xindex" default:"-1" description:"The index for the x column. If not specified, the x value is generated as the receive timestamp. If specified, this is will let the front end know the x value is not a timestamp. Mutually exclusive with --tindex."`
-	TIndex        int  `long:"tindex" default:"-1" description:"The index for the timestamp column. If not specified, the x value is generated as the receive timestamp. Mutually exclusive with --xindex."`
-	RelativeStart bool `short:"s" long:"relative-start" description:"If this is specified, the X values will be normalized by the first value. i.e x_i = x_original_i - x_0. Applies to both timestamps and non timestamps."`
+	Config  string     `long:"config" description:"The config file with the default options and the --profile options. Default: ~/.config/wesplot/config.toml, if it exists"`
+	Profile string     `long:"profile" description:"Use the options of this profile from the config file. The options on the command line take precedence"`
+	Preset  presetName `long:"preset" description:"Use the built-in options to plot the output of a well known command: ping, sar-cpu (sar -u), or vmstat. The options on the command line take precedence"`
+
+	Host         string `short:"h" long:"host" default:"0.0.0.0" description:"the IP to start the server on. Default to 0.0.0.0 (all interfaces)"`
+	Port         uint16 `short:"p" long:"port" default:"5274"`
+	NoBrowser    bool   `long:"no-browser" description:"Do not open the plot in the browser, such as over SSH"`
+	Browser      string `long:"browser" description:"The command that opens the plot, with the URL appended (e.g. --browser \"firefox --new-window\"). Default: the default browser"`
+	PrintURLOnly bool   `long:"print-url-only" description:"Print the URL of the plot to stdout instead of opening the browser, for scripts"`
+	ViewToken    string `long:"view-token" env:"WESPLOT_VIEW_TOKEN" description:"Require this token to view the plot, passed as ?token= in the URL or as a bearer token"`
+	ControlToken string `long:"control-token" env:"WESPLOT_CONTROL_TOKEN" description:"Require this token to change the plot (options, annotations, pause, and ingest), so the viewers without it can only watch. Also allows viewing"`
+	MDNS         bool   `long:"mdns" description:"Advertise the plot on the local network via mDNS (as _wesplot._tcp), so it can be found with wesplot discover"`
+	QRCode       bool   `long:"qr" description:"Print a QR code of the URL of the plot, to open it on a phone on the same network"`
+	BasePath     string `long:"base-path" default:"/" description:"Serve the plot under this path (e.g. /wesplot/), for reverse proxies that forward the path without stripping it"`
+	Listen       string `long:"listen" description:"Listen on this address instead of --host and --port: a unix domain socket such as unix:/run/wesplot.sock, for reverse proxies, or a TCP host:port, which fails if the port is taken"`
+	Verbose      bool   `short:"v" long:"verbose" description:"Show debug logs"`
+	Systemd      bool   `long:"systemd" description:"Run as a systemd service: notify systemd once ready, do not open the browser, and shut down gracefully on SIGTERM. Sockets passed by systemd socket activation are used instead of --host and --port, with or without this flag"`
+	Quiet        bool   `short:"q" long:"quiet" description:"Only show warning and error logs"`
+	Tee          bool   `short:"T" long:"tee" description:"Write the data (and generated timestamp if applicable) in a CSV into stdout in addition to visualizing with the plot. The logs are always written to stderr (or --log-file), so stdout only contains the CSV"`
+
+	TeeFile      string   `long:"tee-file" description:"Write the --tee output into this file instead of stdout. CSV files start with a header row of the column names. Implies --tee"`
+	TeeFormat    string   `long:"tee-format" choice:"csv" choice:"jsonl" choice:"influx-line" default:"csv" description:"The format of the --tee output: csv, jsonl with one {\"X\": x, \"Ys\": [...]} object per line, which can be sent to a wesplot started with --ingest, or influx-line for the InfluxDB line protocol"`
+	TeePrecision int      `long:"tee-precision" default:"-1" description:"Write the Y values of the --tee CSV with this number of significant digits. By default, the values are written as they were in the input"`
+	TeeRotate    byteSize `long:"tee-rotate" description:"Once the --tee-file reaches this size (before compression), rename it with the current time appended and start a new file (e.g. 100MB)"`
+	TeeGzip      bool     `long:"tee-gzip" description:"Compress the --tee-file with gzip"`
+	Record       string   `long:"record" description:"Record the metadata and the rows of the plot into this session file (e.g. session.wplot), which wesplot replay shows with the same title, columns and axes"`
+	SQLite       string   `long:"sqlite" description:"Also append every value to the wesplot table of this SQLite database (e.g. data.db), with its --sqlite-stream, series, x and y, to keep more data than the plot and query it later with SQL. The database is created if it does not exist. Requires a wesplot built with -tags sqlite and cgo"`
+	SQLiteStream string   `long:"sqlite-stream" default:"default" description:"The stream of the values written to the --sqlite database, to tell apart several wesplots writing to the same database"`
+
+	GRPCListen string `long:"grpc-listen" description:"Also serve the gRPC API of proto/wesplot.proto on this address (e.g. :5275), for the programs that receive the rows. Requires a wesplot built with -tags grpc"`
+
+	Title     string                   `short:"t" long:"title" default:"Wesplot" description:"Title of the plot. Defaults to 'Plot'"`
+	YMin      *float64                 `short:"m" long:"ymin" description:"The minimum value for y (default: auto scaling)"`
+	YMax      *float64                 `short:"M" long:"ymax" description:"The max value for y (default: auto scaling)"`
+	YUnit     string                   `short:"u" long:"yunit" description:"The unit for the Y axis"`
+	YScale    float64                  `long:"yscale" default:"1" description:"Multiply the Y values by this scale, such as 1e-6 to plot bytes as megabytes. A --yunit with an SI prefix is changed accordingly (e.g. B to MB, or ns to ms)"`
+	SI        bool                     `long:"si" description:"Scale the Y values with the SI prefix that shows the first value between 1 and 1000, and add the prefix to the --yunit (e.g. B to MB)"`
+	XLabel    string                   `long:"xlabel" description:"Label for the X axis"`
+	YLabel    string                   `long:"ylabel" description:"Label for the Y axis"`
+	Y2Columns []string                 `long:"y2-columns" description:"The column labels of the series to plot against a secondary Y axis. Can be specified multiple times"`
+	Y2Label   string                   `long:"y2label" description:"Label for the secondary Y axis"`
+	Y2Min     *float64                 `long:"y2min" description:"The minimum value for the secondary Y axis (default: auto scaling)"`
+	Y2Max     *float64                 `long:"y2max" description:"The max value for the secondary Y axis (default: auto scaling)"`
+	HLines    []wesplot.HorizontalLine `long:"hline" description:"Draw a horizontal reference line at the given Y value, optionally labeled (e.g. 0.95:label=\"SLO\"). Can be specified multiple times"`
+	Panels    []wesplot.Panel          `long:"panel" description:"Plot a subset of the columns in a separate chart, specified as name:column1,column2 optionally followed by ;title=...;ylabel=...;yunit=...;ymin=...;ymax=... (e.g. 'cpu:user,system;ymax=100'). Can be specified multiple times"`
+	Layout    wesplot.Layout           `long:"layout" description:"Arrange the --panel charts in a grid of <rows>x<columns> (e.g. 2x2)"`
+	ChartType string                   `long:"chart-type" choice:"scatter" choice:"line" choice:"area" choice:"stacked" choice:"bar" default:"line" description:"The type of chart to plot: scatter, line, area to fill the area under every series, stacked to stack the areas of the series (see --stack-group), or bar, such as with --categorical-x. Defaults to 'line'"`
+	Series    []wesplot.SeriesColumns  `long:"series" description:"Plot a series with its own X column, specified as y=<index>,x=<index> (e.g. y=2,x=1), such as to plot together traces sampled at different X values. Can be specified multiple times, once per series, in the order of --columns. Mutually exclusive with --xindex and --tindex"`
+	Stacks    []wesplot.StackGroup     `long:"stack-group" description:"With --chart-type stacked, stack these series together, specified as name:column1,column2 (e.g. 'cpu0:user0,system0'). The groups are stacked separately, and the series in no group are not stacked. Can be specified multiple times. By default, all the series are stacked together"`
+
+	XIndex        int    `short:"x" long:"xindex" default:"-1" description:"The index for the x column. If not specified, the x value is generated as the receive timestamp. If specified, this is will let the front end know the x value is not a timestamp. Mutually exclusive with --tindex."`
+	TIndex        int    `long:"tindex" default:"-1" description:"The index for the timestamp column. If not specified, the x value is generated as the receive timestamp. Mutually exclusive with --xindex."`
+	ColorBy       int    `long:"color-by" default:"-1" description:"The index of a column that sets the color of the points instead of being plotted, such as to plot the trajectory of a system with the time as the color. The values are mapped from blue to red"`
+	ColorRange    string `long:"color-range" description:"With --color-by, the values mapped to blue and red, specified as min:max (e.g. 0:100). Either bound can be omitted. Defaults to the lowest and highest values received"`
+	ColorLabel    string `long:"color-label" description:"With --color-by, the label of the colors, such as the name of the column"`
+	CategoricalX  bool   `long:"categorical-x" description:"The --xindex column contains labels instead of numbers, such as the keys of per-key counts, which are plotted in the order they are first seen. Only the latest value of every label is plotted, such as with --chart-type bar"`
+	TimeFormat    string `long:"time-format" default:"epoch" description:"The format of the --tindex column: epoch (seconds), epoch-ms, epoch-us, epoch-ns, rfc3339, hh:mm:ss, or a Go time layout such as 2006-01-02T15:04:05"`
+	RelativeStart bool   `short:"s" long:"relative-start" description:"If this is specified, the X values will be normalized by the first value. i.e x_i = x_original_i - x_0. Applies to both timestamps and non timestamps."`
+	RelativeY     bool   `long:"relative-y" description:"Subtract the first value of every series from its values, i.e. y_i = y_original_i - y_0, such as to plot the growth of counters since wesplot started. Missing values are skipped"`
 
 	NumColumns int      `short:"n" long:"num-columns" description:"The number of columns expected for the input data. If specified, input data rows with different number of columns will be ignored."`
 	Columns    []string `short:"c" long:"columns" description:"The columns labels for the input data. This option supercedes num-columns and will also be used to validate the input data like --num-columns."`
 
-	WindowSize    int           `short:"w" long:"window-size" default:"1800" description:"the number of data rows cached on a rolling windows basis. default: 1800 which means 1800 data points will be cached by the tool and sent any time the browser connects"`
-	FlushInterval time.Duration `long:"flush-interval" default:"250ms" description:"the flush interval dictates how long the backend waits before flushing the data to the frontend. If the frontend is too slow and cannot keep up updating the plot, increase this number"`
+	OnParseError  string   `long:"on-parse-error" choice:"drop" choice:"halt" choice:"zero" choice:"warn-summary" default:"drop" description:"What to do with rows that cannot be parsed: drop the row with a warning, halt the stream with an error, replace unparsable values with zero, or drop the row and periodically log a summary"`
+	Aggregate     string   `long:"aggregate" description:"Instead of plotting the raw samples, bucket them into fixed windows of X and plot aggregates of each window, specified as functions:window (e.g. p99:10s or p50,p99,max:1m). Supported functions: min, max, mean, count, pNN, open, close, high, low, ohlc (drawn as candlesticks), and box (min, quartiles and max, drawn as box plots)"`
+	Resample      string   `long:"resample" description:"Resample the rows into rows at a fixed interval of X, specified as interval:function (e.g. 1s:mean), such as to overlay series sampled at different times or to plot fewer points. The function is applied to the values of every series within each interval, and is one of mean, min, max, or last. The X of a row is the end of its interval, and the intervals without rows are skipped"`
+	FFT           string   `long:"fft" description:"Plot the spectrum of every series instead of its values, such as to find the periodicity of a metric, specified as options separated by commas (e.g. window=1024). The spectrum of the last window rows, which must be a power of 2, is plotted every hop rows (a quarter of the window by default), as the amplitude of bands of periods (8 by default, e.g. bands=16) labeled with the series and their period (e.g. \"cpu 1m4s\"). The rows are assumed to be sampled every interval, which is the interval of --resample if any, or 1s by default (e.g. interval=10s)"`
+	Alerts        []string `long:"alert" description:"Evaluate a condition against every row, such as 'y1 > 100 for 30s', and fire --alert-cmd and/or --alert-webhook when it starts or stops being true. Can be specified multiple times. The alert status is available at /alerts, and the firing alerts are shown in the browser"`
+	AlertCmd      string   `long:"alert-cmd" description:"The command to run when an alert fires or resolves. The environment variables WESPLOT_ALERT, WESPLOT_ALERT_STATE, and WESPLOT_ALERT_VALUE are set"`
+	AlertWebhook  string   `long:"alert-webhook" description:"The URL to POST the alert status (as JSON) to when an alert fires or resolves"`
+	SkipHeader    bool     `long:"skip-header" description:"Ignore the first row of the input, such as the header row of a CSV file"`
+	MissingValues []string `long:"missing-value" description:"A value that indicates a missing data point, which is plotted as a gap. Can be specified multiple times. nan is always treated as missing. Default: - and empty fields"`
+	XMin          *float64 `long:"xmin" description:"Ignore the rows with an X value lower than this, such as to replay a part of a capture. Timestamps are in seconds. The ignored rows are counted in /stats"`
+	XMax          *float64 `long:"xmax" description:"Ignore the rows with an X value greater than this. Timestamps are in seconds. The ignored rows are counted in /stats"`
+	ClipY         string   `long:"clip-y" description:"Clamp the Y values to this range, specified as min:max (e.g. 0:100), so a single absurd reading does not squash the plot. Either bound can be omitted (e.g. 0:). The clamped values are counted in /stats"`
+	DropOutliers  string   `long:"drop-outliers" description:"Plot the outliers of every series as gaps, specified as zscore:threshold (e.g. zscore:4) to drop the values more than threshold standard deviations away from the mean of the last 100 values. The dropped values are counted in /stats"`
+
+	Follow      bool        `long:"follow" description:"Do not end the stream when stdin reaches EOF. Instead, reopen it (named pipes) or wait for more data (regular files), similar to tail -F. Not supported on Windows"`
+	File        string      `long:"file" description:"Read the data from this file instead of stdin"`
+	Tail        bool        `long:"tail" description:"After reading the --file to the end, keep reading new rows appended to it"`
+	ListenData  string      `long:"listen-data" description:"Read the data from remote producers connecting to this address instead of stdin (e.g. tcp://:9000 or udp://:9000)"`
+	Mqtt        string      `long:"mqtt" description:"Read the data from an MQTT broker instead of stdin (e.g. tcp://broker:1883). Each topic is plotted as a series. See --mqtt-topic"`
+	MqttTopics  []string    `long:"mqtt-topic" description:"The MQTT topic to subscribe to. Can be specified multiple times. If the topic contains wildcards, or if the payloads are CSV/JSON, --columns must list the series to plot (e.g. sensors/room1 or sensors/room1/temperature for JSON payloads)"`
+	Ingest      bool        `long:"ingest" description:"Read the data from HTTP POST requests to /ingest and websocket messages on /ws-ingest instead of stdin. The body can be text in the same format as stdin, or JSON (e.g. [{\"X\": 1, \"Ys\": [2, 3]}])"`
+	From        string      `long:"from" description:"Read the rows of another running wesplot instead of stdin (e.g. ws://capture-box:5274, or its http:// URL), such as to view the data of a remote capture with other options. Its columns are used, and it is reconnected to when the connection is lost. The --view-token of the remote, if any, is read from WESPLOT_FROM_TOKEN"`
+	Pcap        string      `long:"pcap" description:"Capture the packets of this network interface (e.g. eth0, or any) instead of reading stdin, and plot the bytes and packets per second every --pcap-interval, such as to graph the bandwidth of a link. Requires root or the CAP_NET_RAW capability, and a wesplot built with -tags pcap"`
+	PcapFilter  string      `long:"pcap-filter" description:"With --pcap, only count the packets matching this filter, in the syntax of tcpdump (e.g. \"port 443\")"`
+	Exec        string      `long:"exec" description:"Run this command and read the data from its stdout instead of stdin (e.g. \"vmstat 1\")"`
+	Sar         string      `long:"sar" description:"Read this binary file written by sar -o or sadc (e.g. /var/log/sa/sa17) instead of stdin, by converting it with sadf -d, which must be installed. The activities are selected by --sar-options, and the values are read as with --input-format sar"`
+	SarOptions  string      `long:"sar-options" default:"-u" description:"With --sar, the options of sar selecting the activities to read, passed with an equal sign as they start with a dash (e.g. --sar-options=-r for the memory usage, or --sar-options=\"-n DEV\" for the network interfaces)"`
+	JoinExec    string      `long:"join-exec" description:"Also run this command, and join the rows of its stdout to the rows of the input with the nearest timestamp within --join-tolerance, such as to compare the latency of ping to the CPU load of vmstat on one chart. Its rows are timestamped as they are read, and its columns (see --join-columns) are added after the columns of the input, missing where no row is near"`
+	JoinColumns []string    `long:"join-columns" description:"The labels of the columns of --join-exec. Can be specified multiple times. Required with --join-exec"`
+	Restart     string      `long:"restart" choice:"never" choice:"on-failure" choice:"always" default:"never" description:"Whether to restart the --exec command when it exits"`
+	Regex       string      `long:"regex" description:"Extract the columns from each input line with the named capture groups of this regex (e.g. 'time=(?P<latency>[0-9.]+) ms'). The group names are used as the column labels unless --columns is specified. Lines that do not match are ignored. --xindex and --tindex refer to the index of the group"`
+	InputFormat string      `long:"input-format" choice:"text" choice:"influx-line" choice:"graphite" choice:"statsd" choice:"collectd" choice:"histogram" choice:"sar" default:"text" description:"The format of the input: text with a row of values per line, influx-line for the InfluxDB line protocol, such as the output of Telegraf, where every field is a series labeled with its measurement and key, followed by the tags of the point (e.g. cpu.usage_idle or \"cpu.usage_idle host=a\"), graphite for the Graphite plaintext protocol, where every metric path is a series, or statsd for the metrics of StatsD clients, which are aggregated every --statsd-interval (e.g. requests, or \"latency p99\" for a timer), or collectd for the packets of the network plugin of collectd received on --listen-data udp://:25826, where every value is a series labeled with its identifier (e.g. web1/load/load/0), or histogram for the histograms printed every interval by bpftrace or the BCC tools (e.g. biolatency 1), where the series are the buckets labeled with their lower bound (e.g. 0, 1K, or 2K), or sar for the output of sadf -d, where every value is a series labeled with its field, preceded by its device if any (e.g. %user, or \"eth0 rxkB/s\"). With these formats, --columns must list the series to plot"`
+	ReplaySpeed replaySpeed `long:"replay-speed" description:"Replay the rows with the timing of the timestamp column (--tindex) sped up by this factor (e.g. 10x). By default, rows are read as fast as possible"`
+
+	WindowSize int           `short:"w" long:"window-size" default:"1800" description:"the number of data rows cached on a rolling windows basis. default: 1800 which means 1800 data points will be cached by the tool and sent any time the browser connects"`
+	Window     time.Duration `long:"window" description:"Keep the data of this duration (e.g. 10m) instead of --window-size rows. The duration applies to the X values, which are normally timestamps in seconds"`
+	RelayTo    string        `long:"relay-to" description:"Forward the rows to a remote wesplot started with --ingest and --control (e.g. http://central:5274), in addition to serving the plot locally"`
+	RelayOnly  bool          `long:"relay-only" description:"With --relay-to, only forward the rows without serving the plot locally. Useful on headless machines"`
+
+	StatsInterval   time.Duration `long:"stats-interval" default:"1m" description:"How often to log a summary of the rows read and ignored. The statistics are also available at /stats. Set to 0 to disable the log"`
+	GapThreshold    time.Duration `long:"gap-threshold" description:"Break the lines of the plot where the X of a row is more than this duration (e.g. 5s) after the previous row, such as while a sensor was disconnected, instead of drawing a line across. The duration applies to the X values, which are normally timestamps in seconds"`
+	StatsdInterval  time.Duration `long:"statsd-interval" default:"10s" description:"With --input-format statsd, how often to aggregate the metrics received into a row, as a StatsD server flushes them"`
+	PcapInterval    time.Duration `long:"pcap-interval" default:"1s" description:"With --pcap, how often to plot the bytes and packets per second captured"`
+	JoinTolerance   time.Duration `long:"join-tolerance" default:"1s" description:"With --join-exec, how far apart the timestamps of the joined rows can be. The rows of the input are delayed by this duration at most, while waiting for the rows of --join-exec"`
+	StaleAfter      time.Duration `long:"stale-after" description:"Show the plot as stale in the browser once no rows were read for this duration (e.g. 30s), such as when the command producing the data hangs, and as live again once rows are read. Set to 0 to disable"`
+	FlushInterval   time.Duration `long:"flush-interval" default:"250ms" description:"the flush interval dictates how long the backend waits before flushing the data to the frontend. If the frontend is too slow and cannot keep up updating the plot, increase this number"`
+	OnEOF           onEOF         `long:"on-eof" default:"keep-serving" description:"What to do once the input ends: keep-serving (the plot stays up until interrupted), exit once the connected browser tabs have received all the data and disconnected (with a non-zero exit code if the input failed), or exit-after a duration (e.g. --on-eof \"exit-after 5m\")"`
+	SettingsFile    string        `long:"settings-file" description:"Save the plot options changed in the browser (or via PUT /options) into this file, and load them from it on startup. By default, the changes are only kept in memory until wesplot exits"`
+	MaxClients      int           `long:"max-clients" default:"100" description:"The maximum number of browser tabs and other clients receiving the data at once. Every client can buffer up to 10000 rows, so this bounds the memory usage. The clients over the limit get a 503 error. Set to 0 for no limit"`
+	MaxMemory       byteSize      `long:"max-memory" description:"Keep the data buffered for the plot and the browsers under this size (e.g. 256MB), by shrinking the rows queued to each browser and then the --window-size (or the rows kept in the --window). The effective limits are shown in /stats. Requires --max-clients"`
+	ClientTimeout   time.Duration `long:"client-timeout" default:"30s" description:"Disconnect the browsers and other clients that do not accept the data within this duration, such as a laptop that went to sleep. Set to 0 to wait forever"`
+	Float32         bool          `long:"float32" description:"Store the data as float32 instead of float64, which halves the memory of large --window-size values and makes the data sent to the browser smaller, at the cost of precision (about 7 significant digits). X is kept as float64"`
+	MaxClientsPerIP int           `long:"max-clients-per-ip" description:"The maximum number of clients receiving the data at once from the same IP address, such as behind a NAT. By default, there is no limit per IP address"`
+	Checkpoint      string        `long:"checkpoint" description:"Rewrite this CSV file with the rows of the plot every --checkpoint-interval, so a crash loses at most one interval of them. The file is replaced atomically, so it is never seen half written"`
+	CheckpointEvery time.Duration `long:"checkpoint-interval" default:"1m" description:"How often to rewrite the --checkpoint file"`
+	Compression     string        `long:"compression" default:"context-takeover" choice:"context-takeover" choice:"per-message" choice:"disabled" description:"The compression of the data sent to the browser. context-takeover compresses best but uses more memory per browser. Use disabled to save CPU on fast links"`
+	Control         bool          `long:"control" description:"Allow changing the plot with PUT /options, POST /annotations, and POST /control/pause, and sending the rows with --ingest. Implied by --control-token. Pages of other sites can never change the plot"`
+
+	LogFormat string   `long:"log-format" choice:"text" choice:"json" default:"text" description:"The format of the logs. Use json to run under systemd/journald or a log collector"`
+	LogFile   string   `long:"log-file" description:"Append the logs to this file instead of writing them to stderr"`
+	LogLevels []string `long:"log-level" description:"The log level (trace, debug, info, warn, or error), or the log level of a component specified as component=level (e.g. DataBroadcaster=debug). The component is the tag field of the logs. Can be specified multiple times"`
 
 	xIsTimestamp bool
+	regex        *regexp.Regexp
 }
 
 func parseOptions() {
-	_, err := flags.ParseArgs(&options, os.Args)
+	// The command line is parsed first to find the config file and the profile,
+	// and which options are set. Then, it is parsed again after the options from
+	// the config file.
+	defaultOptions := options
+	parser := flags.NewParser(&options, flags.Default)
+	if os.Getenv("GO_FLAGS_COMPLETION") != "" && completeChoices(parser, os.Args[1:]) {
+		os.Exit(0)
+	}
+
+	_, err := parser.ParseArgs(os.Args)
 	if err != nil {
 		if flags.WroteHelp(err) {
 			os.Exit(0)
@@ -48,6 +217,236 @@ func parseOptions() {
 		panic(err)
 	}
 
+	args, err := configArgs(parser, options.Config, options.Profile, string(options.Preset))
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+
+	if len(args) > 0 {
+		options = defaultOptions
+		_, err = flags.ParseArgs(&options, append(append([]string{os.Args[0]}, args...), os.Args[1:]...))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = configureLogging()
+	if err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+
+	// Only one input source can be used at a time. If none are specified, stdin
+	// is used.
+	inputSources := 0
+	for _, specified := range []bool{options.File != "", options.ListenData != "", options.Mqtt != "", options.From != "", options.Pcap != "", options.Exec != "", options.Sar != "", options.Ingest} {
+		if specified {
+			inputSources++
+		}
+	}
+
+	if inputSources > 1 {
+		logrus.Error("only one of --file, --listen-data, --mqtt, --from, --pcap, --exec, --sar, and --ingest can be specified")
+		os.Exit(1)
+	}
+
+	if options.Ingest && !options.Control && options.ControlToken == "" {
+		logrus.Error("--ingest requires --control or --control-token")
+		os.Exit(1)
+	}
+
+	if options.Follow && inputSources > 0 && options.File == "" {
+		logrus.Error("--follow can only be used when reading from stdin or --file")
+		os.Exit(1)
+	}
+
+	if options.GRPCListen != "" && !wesplot.GRPCSupported {
+		logrus.Error("--grpc-listen requires a wesplot built with -tags grpc")
+		os.Exit(1)
+	}
+
+	if (options.TeeRotate > 0 || options.TeeGzip) && options.TeeFile == "" {
+		logrus.Error("--tee-rotate and --tee-gzip require --tee-file")
+		os.Exit(1)
+	}
+
+	if options.Checkpoint != "" && options.CheckpointEvery <= 0 {
+		logrus.Error("--checkpoint-interval must be positive")
+		os.Exit(1)
+	}
+
+	if options.SQLite != "" && !wesplot.SQLiteSupported {
+		logrus.Error("--sqlite requires a wesplot built with -tags sqlite and cgo enabled")
+		os.Exit(1)
+	}
+
+	if options.RelayOnly && options.RelayTo == "" {
+		logrus.Error("--relay-only requires --relay-to")
+		os.Exit(1)
+	}
+
+	if options.Mqtt != "" {
+		if len(options.MqttTopics) == 0 {
+			logrus.Error("--mqtt requires at least one --mqtt-topic")
+			os.Exit(1)
+		}
+
+		if options.XIndex != -1 || options.TIndex != -1 {
+			logrus.Error("--mqtt always uses the receive timestamp and cannot be used with --xindex or --tindex")
+			os.Exit(1)
+		}
+
+		if len(options.Columns) == 0 {
+			for _, topic := range options.MqttTopics {
+				if strings.ContainsAny(topic, "+#") {
+					logrus.Errorf("--mqtt-topic %s contains wildcards, so --columns must be specified", topic)
+					os.Exit(1)
+				}
+			}
+
+			options.Columns = options.MqttTopics
+		}
+	}
+
+	if options.From != "" {
+		if options.XIndex != -1 || options.TIndex != -1 || options.ColorBy != -1 || len(options.Series) > 0 || options.Regex != "" || options.InputFormat != "text" || len(options.Columns) > 0 || options.NumColumns > 0 {
+			logrus.Error("--from uses the rows and the columns of the remote plot, and cannot be used with --xindex, --tindex, --color-by, --series, --regex, --input-format, --columns, or --num-columns")
+			os.Exit(1)
+		}
+	}
+
+	if options.Pcap != "" {
+		if options.XIndex != -1 || options.TIndex != -1 || options.ColorBy != -1 || len(options.Series) > 0 || options.Regex != "" || options.InputFormat != "text" {
+			logrus.Error("--pcap cannot be used with --xindex, --tindex, --color-by, --series, --regex, or --input-format")
+			os.Exit(1)
+		}
+
+		if len(options.Columns) == 0 {
+			options.Columns = []string{"bytes/s", "packets/s"}
+		} else if len(options.Columns) != 2 {
+			logrus.Error("--pcap plots 2 columns, the bytes and the packets per second, but --columns has a different number")
+			os.Exit(1)
+		}
+	} else if options.PcapFilter != "" {
+		logrus.Error("--pcap-filter requires --pcap")
+		os.Exit(1)
+	}
+
+	if options.Sar != "" {
+		if options.InputFormat != "text" && options.InputFormat != "sar" {
+			logrus.Error("--sar reads the output of sadf, and cannot be used with another --input-format")
+			os.Exit(1)
+		}
+
+		options.InputFormat = "sar"
+	}
+
+	if options.InputFormat != "text" {
+		if options.Mqtt != "" || options.Ingest || options.Regex != "" {
+			logrus.Errorf("--input-format %s cannot be used with --mqtt, --ingest, or --regex", options.InputFormat)
+			os.Exit(1)
+		}
+
+		if options.XIndex != -1 || options.TIndex != -1 || options.ColorBy != -1 || len(options.Series) > 0 {
+			logrus.Errorf("--input-format %s uses the timestamps of the input and cannot be used with --xindex, --tindex, --color-by, or --series", options.InputFormat)
+			os.Exit(1)
+		}
+
+		// The series are only known once they are read, but the columns
+		// cannot change once the plot is served.
+		if len(options.Columns) == 0 && options.InputFormat == "influx-line" {
+			logrus.Error("--input-format influx-line requires --columns, such as cpu.usage_idle, or \"cpu.usage_idle host=a\" for the points tagged with host=a")
+			os.Exit(1)
+		} else if len(options.Columns) == 0 && options.InputFormat == "graphite" {
+			logrus.Error("--input-format graphite requires --columns, the metric paths to plot (e.g. servers.web1.load)")
+			os.Exit(1)
+		} else if len(options.Columns) == 0 && options.InputFormat == "collectd" {
+			logrus.Error("--input-format collectd requires --columns, the identifiers of the values to plot (e.g. web1/memory/memory-used)")
+			os.Exit(1)
+		} else if len(options.Columns) == 0 && options.InputFormat == "histogram" {
+			logrus.Error("--input-format histogram requires --columns, the lower bounds of the buckets to plot in increasing order (e.g. 0 1 2 4 8), into which the other buckets are merged")
+			os.Exit(1)
+		} else if len(options.Columns) == 0 && options.InputFormat == "sar" {
+			logrus.Error("--input-format sar requires --columns, the fields to plot, such as %user, or \"eth0 rxkB/s\" for a field of a device")
+			os.Exit(1)
+		} else if len(options.Columns) == 0 {
+			logrus.Error("--input-format statsd requires --columns, the metrics to plot, such as requests, or \"latency p99\" for the 99th percentile of a timer")
+			os.Exit(1)
+		}
+
+		// The packets are binary, so they cannot be read from a file or stdin.
+		if options.InputFormat == "collectd" && !strings.HasPrefix(options.ListenData, "udp") {
+			logrus.Error("--input-format collectd requires --listen-data with a UDP address, such as udp://:25826")
+			os.Exit(1)
+		}
+	}
+
+	if len(options.Series) > 0 {
+		if options.XIndex != -1 || options.TIndex != -1 {
+			logrus.Error("--series cannot be used with --xindex or --tindex, as every series has its own X column")
+			os.Exit(1)
+		}
+
+		// The aggregated rows and the MQTT payloads have a single X, and the
+		// X range and the colors are not supported per series.
+		if options.Aggregate != "" || options.Resample != "" || options.FFT != "" || options.Mqtt != "" || options.XMin != nil || options.XMax != nil || options.ColorBy != -1 {
+			logrus.Error("--series cannot be used with --aggregate, --resample, --fft, --mqtt, --xmin, --xmax, or --color-by")
+			os.Exit(1)
+		}
+
+		if len(options.Columns) == 0 && options.NumColumns == 0 {
+			for i := range options.Series {
+				options.Columns = append(options.Columns, fmt.Sprintf("y%d", i))
+			}
+		}
+
+		numColumns := len(options.Columns)
+		if numColumns == 0 {
+			numColumns = options.NumColumns
+		}
+
+		if numColumns != len(options.Series) {
+			logrus.Errorf("%d --series are specified, but there are %d columns", len(options.Series), numColumns)
+			os.Exit(1)
+		}
+	}
+
+	if options.Regex != "" {
+		if options.ListenData != "" || options.Mqtt != "" {
+			logrus.Error("--regex cannot be used with --listen-data or --mqtt")
+			os.Exit(1)
+		}
+
+		regex, err := regexp.Compile(options.Regex)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --regex")
+			os.Exit(1)
+		}
+
+		options.regex = regex
+
+		if len(options.Columns) == 0 && options.NumColumns == 0 {
+			xIndex := options.XIndex
+			if xIndex == -1 {
+				xIndex = options.TIndex
+			}
+
+			groupIndex := 0
+			for _, name := range regex.SubexpNames() {
+				if name == "" {
+					continue
+				}
+
+				if groupIndex != xIndex && groupIndex != options.ColorBy {
+					options.Columns = append(options.Columns, name)
+				}
+
+				groupIndex++
+			}
+		}
+	}
+
 	if options.NumColumns > 0 {
 		if len(options.Columns) == 0 {
 			// User specified --num-columns but not --columns, so we construct it
@@ -70,6 +469,25 @@ func parseOptions() {
 		}
 	}
 
+	if len(options.MissingValues) == 0 {
+		options.MissingValues = []string{"-", ""}
+	}
+
+	if options.YScale == 0 {
+		logrus.Error("--yscale must not be 0")
+		os.Exit(1)
+	}
+
+	if options.SI && options.YScale != 1 {
+		logrus.Error("--si and --yscale cannot be used together")
+		os.Exit(1)
+	}
+
+	if options.XMin != nil && options.XMax != nil && *options.XMin > *options.XMax {
+		logrus.Errorf("--xmax (%f) must not be lower than --xmin (%f)", *options.XMax, *options.XMin)
+		os.Exit(1)
+	}
+
 	if options.YMin != nil && options.YMax != nil {
 		if *options.YMin >= *options.YMax {
 			logrus.Errorf("YMax (%f) must be greater than YMin (%f)", *options.YMax, *options.YMin)
@@ -77,6 +495,34 @@ func parseOptions() {
 		}
 	}
 
+	if options.Y2Min != nil && options.Y2Max != nil {
+		if *options.Y2Min >= *options.Y2Max {
+			logrus.Errorf("Y2Max (%f) must be greater than Y2Min (%f)", *options.Y2Max, *options.Y2Min)
+			os.Exit(1)
+		}
+	}
+
+	// With --aggregate and --fft, the columns are renamed to include the
+	// aggregate function or the period, so we don't try to validate them here.
+	if options.Aggregate == "" && options.FFT == "" {
+		// The columns of --join-exec are added after the columns of the input.
+		columns := append(append([]string(nil), options.Columns...), options.JoinColumns...)
+		for _, y2Column := range options.Y2Columns {
+			found := false
+			for _, column := range columns {
+				if column == y2Column {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				logrus.Errorf("--y2-columns contains %s, which is not one of the columns %v", y2Column, columns)
+				os.Exit(1)
+			}
+		}
+	}
+
 	// TODO: this code is kind of funky but OK.
 	if options.XIndex != -1 {
 		if options.TIndex != -1 {
@@ -92,51 +538,859 @@ func parseOptions() {
 		options.xIsTimestamp = true
 	}
 
+	// The X columns of the series are not timestamps, like --xindex.
+	if len(options.Series) > 0 {
+		options.xIsTimestamp = false
+	}
+
+	if options.ColorBy != -1 {
+		if options.ColorBy < 0 || options.ColorBy == options.XIndex {
+			logrus.Error("--color-by must be the index of a column other than the X column")
+			os.Exit(1)
+		}
+
+		// The aggregated rows and the MQTT payloads have no color.
+		if options.Aggregate != "" || options.Resample != "" || options.FFT != "" || options.Mqtt != "" {
+			logrus.Error("--color-by cannot be used with --aggregate, --resample, --fft, or --mqtt")
+			os.Exit(1)
+		}
+	} else if options.ColorRange != "" || options.ColorLabel != "" {
+		logrus.Error("--color-range and --color-label require --color-by")
+		os.Exit(1)
+	}
+
+	if options.CategoricalX {
+		if options.XIndex == -1 || options.TIndex != -1 {
+			logrus.Error("--categorical-x requires the column of the labels specified via --xindex")
+			os.Exit(1)
+		}
+
+		// These use the X values, which are only the order of the labels.
+		if options.RelativeStart || options.Aggregate != "" || options.Resample != "" || options.FFT != "" || options.XMin != nil || options.XMax != nil || options.Window > 0 || options.GapThreshold > 0 {
+			logrus.Error("--categorical-x cannot be used with --relative-start, --aggregate, --resample, --fft, --xmin, --xmax, --window, or --gap-threshold")
+			os.Exit(1)
+		}
+
+		// The labels are only sent to the clients of this wesplot.
+		if options.Record != "" || options.RelayTo != "" {
+			logrus.Error("--categorical-x cannot be used with --record or --relay-to")
+			os.Exit(1)
+		}
+	}
+
+	if options.JoinExec != "" {
+		// The rows of the command are timestamped as they are read.
+		if !options.xIsTimestamp || options.CategoricalX {
+			logrus.Error("--join-exec joins the rows by timestamp, and cannot be used with --xindex, --series, or --categorical-x")
+			os.Exit(1)
+		}
+
+		if len(options.JoinColumns) == 0 {
+			logrus.Error("--join-exec requires --join-columns")
+			os.Exit(1)
+		}
+
+		if options.JoinTolerance <= 0 {
+			logrus.Error("--join-tolerance must be positive")
+			os.Exit(1)
+		}
+	} else if len(options.JoinColumns) > 0 {
+		logrus.Error("--join-columns requires --join-exec")
+		os.Exit(1)
+	}
+
+	// Both reduce the rows within fixed windows of X.
+	if options.Resample != "" && options.Aggregate != "" {
+		logrus.Error("--resample cannot be used with --aggregate")
+		os.Exit(1)
+	}
+
+	if options.FFT != "" && options.Aggregate != "" {
+		logrus.Error("--fft cannot be used with --aggregate")
+		os.Exit(1)
+	}
+
+	if options.Tail && options.File == "" {
+		logrus.Error("--tail can only be used with --file")
+		os.Exit(1)
+	}
+
+	if options.TimeFormat != wesplot.TimeFormatEpoch && options.TIndex < 0 {
+		logrus.Error("--time-format can only be used with --tindex")
+		os.Exit(1)
+	}
+
+	if options.ReplaySpeed > 0 && options.TIndex < 0 && replaySession == nil {
+		logrus.Error("--replay-speed requires a timestamp column specified via --tindex")
+		os.Exit(1)
+	}
+
 	if options.Verbose {
-		logrus.SetLevel(logrus.DebugLevel)
 		logrus.Debug("logging verbose output")
-		logrus.Debug("options:")
 		data, err := json.MarshalIndent(options, "", "  ")
 		if err != nil {
 			panic(err)
 		}
 
-		fmt.Println(string(data))
+		// Not printed to stdout, which only contains the --tee output.
+		logrus.Debugf("options:\n%s", data)
 	}
 }
 
+// Returns the URL of the web UI of a remote wesplot given as the URL of its
+// websocket endpoint (e.g. ws://host:5274/ws) or of its web UI.
+func remoteURL(remote string) (string, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/ws")
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// Creates the StringReader for text input as configured by the options.
+func newStringReader(input io.Reader) wesplot.StringReader {
+	if options.InputFormat != "text" {
+		return wesplot.NewLineStringReader(input)
+	}
+
+	if options.regex != nil {
+		regexReader, err := wesplot.NewRegexStringReader(input, options.regex)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --regex")
+			os.Exit(1)
+		}
+
+		return regexReader
+	}
+
+	return wesplot.NewRelaxedStringReader(input)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		runDiscover(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "dash" {
+		runDash(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "read" {
+		runRead(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+
+	// Replays are served like any other plot, with options of their own.
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Args = append([]string{os.Args[0]}, replayArgs(os.Args[2:])...)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "sys" {
+		os.Args = append([]string{os.Args[0]}, sysArgs(os.Args[2:])...)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "proc" {
+		os.Args = append([]string{os.Args[0]}, procArgs(os.Args[2:])...)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "gpu" {
+		os.Args = append([]string{os.Args[0]}, gpuArgs(os.Args[2:])...)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "docker" {
+		os.Args = append([]string{os.Args[0]}, dockerArgs(os.Args[2:])...)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "audio" {
+		os.Args = append([]string{os.Args[0]}, audioArgs(os.Args[2:])...)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "annotate" {
+		runAnnotate(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		runCompletion(os.Args[2:])
+		return
+	}
+
 	parseOptions()
 
 	logrus.Infof("starting wesplot %v", wesplot.Version)
 
 	metadata := wesplot.Metadata{
-		WindowSize:    options.WindowSize,
-		XIsTimestamp:  options.xIsTimestamp,
-		RelativeStart: options.RelativeStart,
+		WindowSize:     options.WindowSize,
+		WindowDuration: options.Window.Seconds(),
+		XIsTimestamp:   options.xIsTimestamp,
+		RelativeStart:  options.RelativeStart,
+		XIsCategorical: options.CategoricalX,
 		WesplotOptions: wesplot.WesplotOptions{
 			Title:     options.Title,
-			Columns:   options.Columns, // TODO: dynamic columns
 			XLabel:    options.XLabel,
 			YLabel:    options.YLabel,
 			YMin:      options.YMin,
 			YMax:      options.YMax,
 			YUnit:     options.YUnit,
 			ChartType: options.ChartType,
+			Y2Columns: options.Y2Columns,
+			Y2Label:   options.Y2Label,
+			Y2Min:     options.Y2Min,
+			Y2Max:     options.Y2Max,
+			HLines:    options.HLines,
 		},
 	}
 
-	var stringReader wesplot.StringReader = wesplot.NewRelaxedStringReader(os.Stdin)
-	var dataRowReader wesplot.DataRowReader = &wesplot.TextToDataRowReader{
-		Input:                  stringReader,
-		XIndex:                 options.XIndex,
-		Columns:                options.Columns,
-		ExpectExactColumnCount: true, // Not sure how to deal with dynamic columns so for now we need exact column count
+	if options.ColorBy >= 0 {
+		metadata.ColorScale = &wesplot.ColorScale{}
+		if options.ColorRange != "" {
+			err := metadata.ColorScale.UnmarshalFlag(options.ColorRange)
+			if err != nil {
+				logrus.WithError(err).Error("invalid --color-range")
+				os.Exit(1)
+			}
+		}
+
+		metadata.ColorScale.Label = options.ColorLabel
+	}
+
+	// A session is shown as it was recorded.
+	if replaySession != nil {
+		defer replaySession.Close()
+
+		metadata = replaySession.Metadata()
+		options.WindowSize = metadata.WindowSize
+		options.Window = time.Duration(metadata.WindowDuration * float64(time.Second))
+		options.xIsTimestamp = metadata.XIsTimestamp
+		options.Panels = metadata.Panels
+		options.Layout = metadata.Layout
+	}
+
+	var input io.Reader = os.Stdin
+	if options.File != "" {
+		if options.Tail || options.Follow {
+			input = wesplot.NewFollowReader(options.File, 250*time.Millisecond)
+		} else {
+			file, err := os.Open(options.File)
+			if err != nil {
+				logrus.WithError(err).Errorf("cannot open %s", options.File)
+				os.Exit(1)
+			}
+			defer file.Close()
+
+			input = file
+		}
+	} else if options.Follow {
+		input = wesplot.NewFollowReader("/dev/stdin", 250*time.Millisecond)
+	} else if options.Exec != "" {
+		execReader, err := wesplot.NewExecReader(options.Exec, wesplot.RestartPolicy(options.Restart))
+		if err != nil {
+			logrus.WithError(err).Error("cannot start --exec command")
+			os.Exit(1)
+		}
+		defer execReader.Close()
+
+		input = execReader
+	} else if options.Sar != "" {
+		execReader, err := wesplot.NewExecReader(wesplot.SadfCommand(options.Sar, options.SarOptions), wesplot.RestartNever)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot read %s with sadf", options.Sar)
+			os.Exit(1)
+		}
+		defer execReader.Close()
+
+		input = execReader
+	}
+
+	stats := wesplot.NewInputStats()
+	input = wesplot.NewCountingReader(input, stats)
+
+	var stringReader wesplot.StringReader = newStringReader(input)
+	if options.ListenData != "" && options.InputFormat != "collectd" {
+		newSocketReader := wesplot.NewSocketStringReader
+		if options.InputFormat != "text" {
+			newSocketReader = wesplot.NewSocketLineReader
+		}
+
+		socketReader, err := newSocketReader(options.ListenData)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot listen for data on %s", options.ListenData)
+			os.Exit(1)
+		}
+		defer socketReader.Close()
+
+		stringReader = socketReader
+	}
+
+	xParser, err := wesplot.NewTimeParser(options.TimeFormat)
+	if err != nil {
+		logrus.WithError(err).Error("invalid --time-format")
+		os.Exit(1)
+	}
+
+	var xCategories *wesplot.XCategories
+	if options.CategoricalX {
+		xCategories = wesplot.NewXCategories()
+		xParser = xCategories.Parse
+	}
+
+	newTextToDataRowReader := func(stringReader wesplot.StringReader, skipHeader bool) wesplot.DataRowReader {
+		return &wesplot.TextToDataRowReader{
+			Input:                  stringReader,
+			XIndex:                 options.XIndex,
+			ColorIndex:             options.ColorBy,
+			Series:                 options.Series,
+			XParser:                xParser,
+			Columns:                options.Columns,
+			ExpectExactColumnCount: true, // Not sure how to deal with dynamic columns so for now we need exact column count
+			MissingValues:          options.MissingValues,
+			OnParseError:           wesplot.ParseErrorPolicy(options.OnParseError),
+			SkipHeader:             skipHeader,
+		}
+	}
+
+	dataRowReader := newTextToDataRowReader(stringReader, options.SkipHeader)
+	switch options.InputFormat {
+	case "influx-line":
+		dataRowReader = wesplot.NewInfluxLineDataRowReader(stringReader, options.Columns)
+	case "graphite":
+		dataRowReader = wesplot.NewGraphiteDataRowReader(stringReader, options.Columns)
+	case "statsd":
+		dataRowReader, err = wesplot.NewStatsdDataRowReader(stringReader, options.Columns, options.StatsdInterval)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --statsd-interval")
+			os.Exit(1)
+		}
+	case "histogram":
+		dataRowReader, err = wesplot.NewHistogramDataRowReader(stringReader, options.Columns)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --columns")
+			os.Exit(1)
+		}
+	case "sar":
+		dataRowReader = wesplot.NewSarDataRowReader(stringReader, options.Columns)
+	case "collectd":
+		dataRowReader, err = wesplot.NewCollectdDataRowReader(options.ListenData, options.Columns)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot listen for data on %s", options.ListenData)
+			os.Exit(1)
+		}
+	}
+
+	if replaySession != nil {
+		dataRowReader = replaySession
+	}
+
+	if sysReader != nil {
+		dataRowReader = sysReader
+	}
+
+	if procReader != nil {
+		dataRowReader = procReader
+	}
+
+	if gpuReader != nil {
+		dataRowReader = gpuReader
+	}
+
+	if dockerReader != nil {
+		defer dockerReader.Close()
+
+		dataRowReader = dockerReader
+	}
+
+	if audioReader != nil {
+		defer audioReader.Close()
+
+		dataRowReader = audioReader
+	}
+
+	var ingestHandler *wesplot.IngestHandler
+	if options.Ingest {
+		channelReader := wesplot.NewChannelDataRowReader(options.Columns, options.WindowSize)
+		ingestHandler = wesplot.NewIngestHandler(channelReader, func(body io.Reader) wesplot.DataRowReader {
+			return newTextToDataRowReader(newStringReader(body), false)
+		})
+
+		dataRowReader = channelReader
+	}
+
+	if options.Mqtt != "" {
+		mqttReader, err := mqtt.NewDataRowReader(options.Mqtt, options.MqttTopics, options.Columns)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot connect to MQTT broker %s", options.Mqtt)
+			os.Exit(1)
+		}
+		defer mqttReader.Close()
+
+		dataRowReader = mqttReader
+	}
+
+	if options.From != "" {
+		remote, err := remoteURL(options.From)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --from")
+			os.Exit(1)
+		}
+
+		fromReader, err := wesplot.NewRemoteDataRowReader(context.Background(), remote, os.Getenv("WESPLOT_FROM_TOKEN"), wesplot.HistoryAll)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot read from %s", options.From)
+			os.Exit(1)
+		}
+
+		fromReader.SetReconnect(true)
+		options.xIsTimestamp = fromReader.Metadata().XIsTimestamp
+		metadata.XIsTimestamp = options.xIsTimestamp
+		dataRowReader = fromReader
+	}
+
+	if options.Pcap != "" {
+		pcapReader, err := wesplot.NewPcapDataRowReader(options.Pcap, options.PcapFilter, options.Columns, options.PcapInterval)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot capture packets on %s", options.Pcap)
+			os.Exit(1)
+		}
+		defer pcapReader.Close()
+
+		dataRowReader = pcapReader
+	}
+
+	if options.JoinExec != "" {
+		joinExecReader, err := wesplot.NewExecReader(options.JoinExec, wesplot.RestartNever)
+		if err != nil {
+			logrus.WithError(err).Error("cannot start --join-exec command")
+			os.Exit(1)
+		}
+		defer joinExecReader.Close()
+
+		joinReader := &wesplot.TextToDataRowReader{
+			Input:                  wesplot.NewRelaxedStringReader(joinExecReader),
+			XIndex:                 -1,
+			ColorIndex:             -1,
+			Columns:                options.JoinColumns,
+			ExpectExactColumnCount: true,
+			MissingValues:          options.MissingValues,
+			OnParseError:           wesplot.ParseErrorPolicy(options.OnParseError),
+		}
+
+		dataRowReader = wesplot.NewJoinDataRowReader(dataRowReader, joinReader, options.JoinTolerance)
+	}
+
+	// Before the replay, which would wait for the ignored rows.
+	if options.XMin != nil || options.XMax != nil {
+		dataRowReader = wesplot.NewXRangeDataRowReader(dataRowReader, options.XMin, options.XMax)
+	}
+
+	if options.ReplaySpeed > 0 {
+		dataRowReader = wesplot.NewReplayDataRowReader(dataRowReader, float64(options.ReplaySpeed))
+	}
+
+	// Before the stages using the Y values, so they use the plotted values.
+	var siReader *wesplot.ScaleDataRowReader
+	if options.SI {
+		siReader = wesplot.NewSIDataRowReader(dataRowReader)
+		dataRowReader = siReader
+	} else if options.YScale != 1 {
+		dataRowReader = wesplot.NewScaleDataRowReader(dataRowReader, options.YScale)
+	}
+
+	if options.RelativeY {
+		dataRowReader = wesplot.NewRelativeYDataRowReader(dataRowReader)
+	}
+
+	if options.ClipY != "" {
+		dataRowReader, err = wesplot.NewClipDataRowReader(dataRowReader, options.ClipY, stats)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --clip-y")
+			os.Exit(1)
+		}
+	}
+
+	if options.DropOutliers != "" {
+		dataRowReader, err = wesplot.NewOutlierDataRowReader(dataRowReader, options.DropOutliers, stats)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --drop-outliers")
+			os.Exit(1)
+		}
+	}
+
+	// The rows are assumed to be sampled every second, unless they are
+	// resampled.
+	fftInterval := time.Second
+	if options.Resample != "" {
+		resampleReader, err := wesplot.NewResampleDataRowReader(dataRowReader, options.Resample)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --resample")
+			os.Exit(1)
+		}
+
+		fftInterval = resampleReader.Interval()
+		dataRowReader = resampleReader
+	}
+
+	if options.FFT != "" {
+		dataRowReader, err = wesplot.NewFFTDataRowReader(dataRowReader, options.FFT, fftInterval)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --fft")
+			os.Exit(1)
+		}
+	}
+
+	if options.Aggregate != "" {
+		aggregateReader, err := wesplot.NewAggregateDataRowReader(dataRowReader, options.Aggregate)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --aggregate")
+			os.Exit(1)
+		}
+
+		metadata.Candles = aggregateReader.Candles()
+		dataRowReader = aggregateReader
+	}
+
+	var alertReader *wesplot.AlertDataRowReader
+	if len(options.Alerts) > 0 {
+		conditions := make([]wesplot.AlertCondition, 0, len(options.Alerts))
+		for _, alert := range options.Alerts {
+			condition, err := wesplot.ParseAlertCondition(alert, dataRowReader.ColumnNames())
+			if err != nil {
+				logrus.WithError(err).Error("invalid --alert")
+				os.Exit(1)
+			}
+
+			conditions = append(conditions, condition)
+		}
+
+		alertReader = wesplot.NewAlertDataRowReader(dataRowReader, conditions, options.AlertCmd, options.AlertWebhook)
+		dataRowReader = alertReader
+	}
+
+	var relayReader *wesplot.RelayDataRowReader
+	if options.RelayTo != "" {
+		relayReader, err = wesplot.NewRelayDataRowReader(dataRowReader, options.RelayTo, options.FlushInterval)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --relay-to")
+			os.Exit(1)
+		}
+
+		dataRowReader = relayReader
+	}
+
+	// After the relay, as the gaps are only for this plot.
+	if options.GapThreshold > 0 {
+		dataRowReader = wesplot.NewGapDataRowReader(dataRowReader, options.GapThreshold)
+	}
+
+	metadata.WesplotOptions.Columns = dataRowReader.ColumnNames() // TODO: dynamic columns
+
+	for _, panel := range options.Panels {
+		for _, panelColumn := range panel.Columns {
+			if !slices.Contains(metadata.WesplotOptions.Columns, panelColumn) {
+				logrus.Errorf("--panel %s contains %s, which is not one of the columns %v", panel.Name, panelColumn, metadata.WesplotOptions.Columns)
+				os.Exit(1)
+			}
+		}
+
+		if panel.YMin != nil && panel.YMax != nil && *panel.YMin >= *panel.YMax {
+			logrus.Errorf("--panel %s: ymax (%f) must be greater than ymin (%f)", panel.Name, *panel.YMax, *panel.YMin)
+			os.Exit(1)
+		}
+	}
+
+	if len(options.Stacks) > 0 && options.ChartType != "stacked" {
+		logrus.Error("--stack-group requires --chart-type stacked")
+		os.Exit(1)
+	}
+
+	stackGroupOf := make(map[string]string)
+	for _, group := range options.Stacks {
+		for _, groupColumn := range group.Columns {
+			if !slices.Contains(metadata.WesplotOptions.Columns, groupColumn) {
+				logrus.Errorf("--stack-group %s contains %s, which is not one of the columns %v", group.Name, groupColumn, metadata.WesplotOptions.Columns)
+				os.Exit(1)
+			}
+
+			if other, found := stackGroupOf[groupColumn]; found {
+				logrus.Errorf("%s is in both --stack-group %s and %s", groupColumn, other, group.Name)
+				os.Exit(1)
+			}
+
+			stackGroupOf[groupColumn] = group.Name
+		}
+	}
+
+	if options.Layout.Rows > 0 {
+		if len(options.Panels) == 0 {
+			logrus.Error("--layout requires --panel")
+			os.Exit(1)
+		}
+
+		if options.Layout.Rows*options.Layout.Columns < len(options.Panels) {
+			logrus.Errorf("--layout %dx%d cannot fit %d panels", options.Layout.Rows, options.Layout.Columns, len(options.Panels))
+			os.Exit(1)
+		}
+	}
+
+	metadata.WesplotOptions.StackGroups = options.Stacks
+	metadata.Panels = options.Panels
+	metadata.Layout = options.Layout
+
+	if options.YScale != 1 {
+		_, ok := wesplot.ScaledUnit(metadata.WesplotOptions.YUnit, options.YScale)
+		if !ok {
+			logrus.Warnf("cannot change the unit %q for --yscale %g, set the unit of the scaled values with --yunit", metadata.WesplotOptions.YUnit, options.YScale)
+		}
+
+		wesplot.ScaleUnits(&metadata, options.YScale)
+	}
+
+	dataBroadcaster := wesplot.NewDataBroadcaster(dataRowReader, options.WindowSize, false)
+	dataBroadcaster.SetInputStats(stats)
+	if options.Window > 0 {
+		dataBroadcaster.SetWindowDuration(options.Window)
+	}
+
+	dataBroadcaster.SetFloat32(options.Float32)
+
+	if options.MaxMemory > 0 {
+		// The number of rows in a window duration is not known, so it is
+		// bounded by the budget instead.
+		windowSize := options.WindowSize
+		if options.Window > 0 {
+			windowSize = math.MaxInt32
+		}
+
+		limits, err := wesplot.PlanMemory(int64(options.MaxMemory), options.MaxClients, windowSize, len(dataRowReader.ColumnNames()), options.Float32)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --max-memory, increase it or decrease --max-clients")
+			os.Exit(1)
+		}
+
+		logger := logrus.WithFields(logrus.Fields{
+			"windowSize":       limits.WindowSize,
+			"clientBufferSize": limits.ClientBufferSize,
+			"estimatedMemory":  limits.EstimatedMemory,
+		})
+		if options.Window == 0 && limits.WindowSize < options.WindowSize {
+			logger.Warnf("--max-memory reduced --window-size from %d rows", options.WindowSize)
+			metadata.WindowSize = limits.WindowSize
+		} else {
+			logger.Info("limited memory")
+		}
+
+		dataBroadcaster.SetMemoryLimits(limits)
+	}
+
+	teeFormat := wesplot.TeeFormat(options.TeeFormat)
+	// The Xs of the series are written after the Ys.
+	teeColumns := slices.Clone(dataRowReader.ColumnNames())
+	for _, column := range teeColumns[:len(options.Series)] {
+		teeColumns = append(teeColumns, column+" x")
+	}
+
+	if options.TeeFile != "" {
+		teeWriter, err := wesplot.NewTeeFileWriter(options.TeeFile, teeColumns, teeFormat, int64(options.TeeRotate), options.TeeGzip)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --tee-file")
+			os.Exit(1)
+		}
+
+		teeWriter.SetPrecision(options.TeePrecision)
+		teeWriter.SetXIsTimestamp(options.xIsTimestamp)
+		dataBroadcaster.AddTeeWriter(teeWriter)
+	} else if options.Tee {
+		if options.PrintURLOnly {
+			logrus.Error("--print-url-only cannot be used with --tee to stdout, use --tee-file instead")
+			os.Exit(1)
+		}
+
+		teeWriter := wesplot.NewTeeStdoutWriter(teeColumns, teeFormat)
+		teeWriter.SetPrecision(options.TeePrecision)
+		teeWriter.SetXIsTimestamp(options.xIsTimestamp)
+		dataBroadcaster.AddTeeWriter(teeWriter)
+	}
+
+	if options.Record != "" {
+		recorder, err := wesplot.NewSessionRecorder(options.Record, metadata)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --record")
+			os.Exit(1)
+		}
+
+		dataBroadcaster.AddTeeWriter(recorder)
+	}
+
+	var sqliteWriter *wesplot.SQLiteWriter
+	if options.SQLite != "" {
+		var err error
+		sqliteWriter, err = wesplot.NewSQLiteWriter(options.SQLite, options.SQLiteStream, dataRowReader.ColumnNames(), options.FlushInterval)
+		if err != nil {
+			logrus.WithError(err).Error("invalid --sqlite")
+			os.Exit(1)
+		}
+
+		dataBroadcaster.AddTeeWriter(sqliteWriter)
+		metadata.HasHistory = true
+	}
+
+	if options.Checkpoint != "" {
+		checkpointer := wesplot.NewCheckpointer(dataBroadcaster, options.Checkpoint, teeColumns)
+		go checkpointer.Start(context.Background(), options.CheckpointEvery)
+	}
+
+	if options.RelayOnly {
+		go stats.Start(context.Background(), options.StatsInterval)
+		dataBroadcaster.Start(context.Background())
+		dataBroadcaster.Wait()
+		relayReader.Wait()
+		return
 	}
 
-	dataBroadcaster := wesplot.NewDataBroadcaster(dataRowReader, options.WindowSize, options.Tee)
 	server := wesplot.NewHttpServer(dataBroadcaster, options.Host, options.Port, metadata, options.FlushInterval)
+	if siReader != nil {
+		siReader.SetScaleListener(func(scale float64) {
+			server.UpdateMetadata(func(metadata *wesplot.Metadata) {
+				wesplot.ScaleUnits(metadata, scale)
+				logrus.WithField("yunit", metadata.WesplotOptions.YUnit).Infof("--si scales the values by %g", scale)
+			})
+		})
+	}
+
+	if xCategories != nil {
+		xCategories.SetListener(func(labels []string) {
+			server.UpdateMetadata(func(metadata *wesplot.Metadata) {
+				metadata.XCategories = labels
+			})
+		})
+	}
+
+	server.SetCompression(wesplot.CompressionMode(options.Compression))
+	server.SetControl(options.Control)
+	server.SetSystemd(options.Systemd)
+	server.SetBasePath(options.BasePath)
+	server.SetBrowserOptions(wesplot.BrowserOptions{
+		Disabled: options.NoBrowser,
+		Command:  strings.Fields(options.Browser),
+		PrintURL: options.PrintURLOnly,
+		QRCode:   options.QRCode,
+	})
+	if options.MDNS {
+		server.SetAdvertiser(mdns.Advertise)
+	}
+	server.SetAccessTokens(options.ViewToken, options.ControlToken)
+	server.SetClientLimits(options.MaxClients, options.MaxClientsPerIP)
+	server.SetClientTimeout(options.ClientTimeout)
+	server.SetStaleAfter(options.StaleAfter)
+
+	listeners, err := wesplot.SystemdListeners()
+	if err != nil {
+		logrus.WithError(err).Error("invalid socket activation")
+		os.Exit(1)
+	}
+
+	if len(listeners) > 0 {
+		if len(listeners) > 1 {
+			logrus.Warnf("socket activation passed %d sockets, only the first one is used", len(listeners))
+		}
+
+		server.SetListener(listeners[0])
+	} else if options.Listen != "" {
+		listener, err := wesplot.Listen(options.Listen)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot listen on %s", options.Listen)
+			os.Exit(1)
+		}
 
+		server.SetListener(listener)
+	}
+
+	if options.SettingsFile != "" {
+		err = server.SetSettingsFile(options.SettingsFile)
+		if err != nil {
+			logrus.WithError(err).Error("cannot load --settings-file")
+			os.Exit(1)
+		}
+	}
+
+	if alertReader != nil {
+		server.SetAlerts(alertReader)
+	}
+
+	if sqliteWriter != nil {
+		server.Handle("/history", sqliteWriter)
+	}
+
+	if ingestHandler != nil {
+		server.HandleControl("/ingest", ingestHandler)
+		server.HandleControl("/ws-ingest", http.HandlerFunc(ingestHandler.ServeWebSocket))
+	}
+
+	if options.GRPCListen != "" {
+		listener, err := net.Listen("tcp", options.GRPCListen)
+		if err != nil {
+			logrus.WithError(err).Errorf("cannot listen on %s", options.GRPCListen)
+			os.Exit(1)
+		}
+
+		grpcServer := wesplot.NewGRPCServer(server)
+		go func() {
+			err := grpcServer.Serve(context.Background(), listener)
+			if err != nil {
+				logrus.WithError(err).Error("the gRPC API stopped")
+			}
+		}()
+	}
+
+	go stats.Start(context.Background(), options.StatsInterval)
 	dataBroadcaster.Start(context.Background())
-	server.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if options.Systemd || strings.HasPrefix(options.Listen, "unix:") {
+		// Shut down gracefully when the service is stopped, which also removes
+		// the unix socket file.
+		ctx, cancel = signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+		defer cancel()
+	}
+
+	if options.OnEOF.Exit {
+		go func() {
+			options.OnEOF.wait(dataBroadcaster)
+			cancel()
+		}()
+	}
+
+	server.RunContext(ctx)
+
+	// Let the scripts detect that the producer failed.
+	if options.OnEOF.Exit {
+		err = dataBroadcaster.Err()
+		if err != nil {
+			logrus.WithError(err).Error("input stream ended with an error")
+			os.Exit(1)
+		}
+	}
 }
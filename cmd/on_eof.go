@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+// How often the clients are checked with --on-eof exit.
+const onEOFPollInterval = time.Second
+
+// What to do once the input stream ends, for --on-eof: keep-serving, exit, or
+// exit-after followed by a duration (e.g. "exit-after 5m" or exit-after=5m).
+type onEOF struct {
+	Exit  bool
+	After time.Duration // With Exit, exit after this duration instead of once all clients disconnect
+}
+
+func (p *onEOF) UnmarshalFlag(value string) error {
+	name, after, hasAfter := strings.Cut(strings.TrimSpace(value), "=")
+	if !hasAfter {
+		name, after, hasAfter = strings.Cut(name, " ")
+	}
+
+	switch {
+	case name == "keep-serving" && !hasAfter:
+		*p = onEOF{}
+	case name == "exit" && !hasAfter:
+		*p = onEOF{Exit: true}
+	case name == "exit-after" && hasAfter:
+		duration, err := time.ParseDuration(strings.TrimSpace(after))
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("invalid duration in --on-eof %q, expected a positive duration like 5m", value)
+		}
+
+		*p = onEOF{Exit: true, After: duration}
+	default:
+		return fmt.Errorf("invalid --on-eof %q, expected keep-serving, exit, or exit-after followed by a duration", value)
+	}
+
+	return nil
+}
+
+func (p onEOF) Complete(match string) []flags.Completion {
+	completions := make([]flags.Completion, 0)
+	for _, item := range []string{"keep-serving", "exit", "exit-after="} {
+		if strings.HasPrefix(item, match) {
+			completions = append(completions, flags.Completion{Item: item})
+		}
+	}
+
+	return completions
+}
+
+// Waits until the stream has ended and wesplot should exit according to the
+// policy. Must only be called if Exit is true.
+func (p onEOF) wait(dataBroadcaster *wesplot.DataBroadcaster) {
+	dataBroadcaster.Wait()
+
+	if p.After > 0 {
+		logrus.Infof("stream ended, exiting in %v", p.After)
+		time.Sleep(p.After)
+		return
+	}
+
+	// The connections are closed once all the rows are sent to the client, so
+	// this waits for the clients to receive them.
+	logrus.Info("stream ended, exiting once all clients disconnect")
+
+	ticker := time.NewTicker(onEOFPollInterval)
+	defer ticker.Stop()
+
+	for len(dataBroadcaster.ClientStats()) > 0 {
+		<-ticker.C
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cactusdynamics/wesplot/sys"
+	"github.com/jessevdk/go-flags"
+)
+
+var procOptions struct {
+	Pid      int32         `long:"pid" description:"The ID of the process to plot"`
+	Match    string        `long:"match" description:"Plot the sums over the processes whose name matches this regex, as with pgrep, instead of a single process"`
+	Metrics  string        `long:"metrics" default:"cpu,rss" description:"The comma-separated metrics to plot, among cpu, rss, fds, threads, io, and processes"`
+	Interval time.Duration `long:"interval" default:"1s" description:"The interval between two samples"`
+}
+
+// The process statistics being sampled by the proc subcommand, if any, which
+// are read instead of the input.
+var procReader *sys.ProcDataRowReader
+
+// Turns the arguments of the proc subcommand into the options of wesplot, to
+// plot the statistics of a process, or of the processes whose name matches a
+// regex (as with pgrep):
+//
+//	wesplot proc --pid 1234 --metrics cpu,rss,fds
+//	wesplot proc --match postgres --interval 5s
+//
+// The metrics default to cpu and rss. Unless --columns is specified, the
+// columns in bytes are plotted against the secondary Y axis. The other
+// arguments are passed through.
+func procArgs(args []string) []string {
+	parser := flags.NewParser(&procOptions, flags.Default|flags.IgnoreUnknown)
+	parser.Usage = "(--pid 1234 | --match name) [--metrics cpu,rss,fds,threads,io,processes] [--interval 1s] [OPTIONS of wesplot]"
+	passed, err := parser.ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	if (procOptions.Pid == 0) == (procOptions.Match == "") {
+		fmt.Fprintln(os.Stderr, "exactly one of --pid and --match must be specified")
+		os.Exit(1)
+	}
+
+	hasColumns := false
+	hasTitle := false
+	for _, arg := range passed {
+		if arg == "-c" || strings.HasPrefix(arg, "--columns") || strings.HasPrefix(arg, "-n") || strings.HasPrefix(arg, "--num-columns") {
+			hasColumns = true
+		} else if arg == "-t" || strings.HasPrefix(arg, "--title") {
+			hasTitle = true
+		}
+	}
+
+	var regex *regexp.Regexp
+	title := fmt.Sprintf("process %d", procOptions.Pid)
+	if procOptions.Match != "" {
+		regex, err = regexp.Compile(procOptions.Match)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --match %s: %v\n", procOptions.Match, err)
+			os.Exit(1)
+		}
+
+		title = "processes matching " + procOptions.Match
+	}
+
+	procReader, err = sys.NewProcDataRowReader(procOptions.Pid, regex, strings.Split(procOptions.Metrics, ","), procOptions.Interval)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var procArgs []string
+	if !hasTitle {
+		procArgs = append(procArgs, "--title", title)
+	}
+
+	if !hasColumns {
+		var y2Columns []string
+		for _, column := range procReader.ColumnNames() {
+			procArgs = append(procArgs, "--columns", column)
+			if strings.Contains(column, "bytes") {
+				y2Columns = append(y2Columns, column)
+			}
+		}
+
+		if len(y2Columns) > 0 && len(y2Columns) < len(procReader.ColumnNames()) {
+			for _, column := range y2Columns {
+				procArgs = append(procArgs, "--y2-columns", column)
+			}
+
+			procArgs = append(procArgs, "--y2label", "bytes")
+		}
+	}
+
+	return append(procArgs, passed...)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+var readOptions struct {
+	URLs           []string `long:"url" default:"http://localhost:5274" description:"The URL of the running wesplot. Can be repeated to merge the rows of several plots, with a source column"`
+	Token          string   `long:"token" env:"WESPLOT_VIEW_TOKEN" description:"The --view-token of the running wesplot, if any"`
+	Format         string   `long:"format" choice:"csv" choice:"jsonl" choice:"influx-line" default:"csv" description:"The output format: csv with a header row, jsonl with one {\"X\": x, \"Ys\": [...]} object per line, or influx-line for the InfluxDB line protocol"`
+	ColumnsAsNames bool     `long:"columns-as-names" description:"Name the series with the column names of the plot in the CSV header and the influx-line fields, instead of their index"`
+	Precision      int      `long:"precision" default:"-1" description:"Write the Y values with this number of significant digits"`
+	Reconnect      bool     `long:"reconnect" description:"Reconnect when the connection is lost, such as when wesplot restarts, and resume after the last row printed"`
+	NoHistory      bool     `long:"no-history" description:"Only print the new rows, instead of starting with the rows buffered by wesplot"`
+	HistoryOnly    bool     `long:"history-only" description:"Only print the rows buffered by wesplot, and exit"`
+}
+
+// Prints the rows of a running wesplot to stdout as they are plotted, starting
+// with the rows it buffered (see --no-history and --history-only), until its
+// stream ends:
+//
+//	wesplot read --url http://localhost:5274 --format jsonl > rows.jsonl
+//
+// With several URLs, the rows of all the plots are printed as they arrive,
+// with the host and path of their plot as their source (see
+// TeeWriter.SetSource), until all the streams end. The CSV header is the one
+// of the first plot.
+func runRead(args []string) {
+	_, err := flags.NewParser(&readOptions, flags.Default).ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	history := wesplot.HistoryAll
+	switch {
+	case readOptions.NoHistory && readOptions.HistoryOnly:
+		logrus.Error("--no-history and --history-only cannot be used together")
+		os.Exit(1)
+	case readOptions.NoHistory:
+		history = wesplot.HistoryNone
+	case readOptions.HistoryOnly:
+		history = wesplot.HistoryOnly
+	}
+
+	ctx := context.Background()
+	readers := make([]*wesplot.RemoteDataRowReader, len(readOptions.URLs))
+	for i, url := range readOptions.URLs {
+		readers[i], err = wesplot.NewRemoteDataRowReader(ctx, url, readOptions.Token, history)
+		if err != nil {
+			logrus.WithError(err).Error("cannot read from wesplot")
+			os.Exit(1)
+		}
+
+		readers[i].SetReconnect(readOptions.Reconnect)
+	}
+
+	// Every row is written to stdout at once, so the rows of the plots are
+	// not interleaved.
+	failed := atomic.Bool{}
+	wg := sync.WaitGroup{}
+	for i, reader := range readers {
+		writer := newReadWriter(reader.Metadata())
+		if len(readers) > 1 {
+			writer.SetSource(remoteName(readOptions.URLs[i]))
+		}
+
+		if i == 0 {
+			err = writer.WriteHeader()
+			if err != nil {
+				logrus.WithError(err).Error("cannot write the output")
+				os.Exit(1)
+			}
+		}
+
+		wg.Add(1)
+		go func(url string, reader *wesplot.RemoteDataRowReader) {
+			defer wg.Done()
+
+			err := writer.WriteAll(ctx, reader)
+			if err != nil {
+				logrus.WithError(err).WithField("url", url).Error("cannot read from wesplot")
+				failed.Store(true)
+			}
+		}(readOptions.URLs[i], reader)
+	}
+
+	wg.Wait()
+	if failed.Load() {
+		os.Exit(1)
+	}
+}
+
+func newReadWriter(metadata wesplot.Metadata) *wesplot.TeeWriter {
+	columns := metadata.WesplotOptions.Columns
+	if !readOptions.ColumnsAsNames {
+		columns = make([]string, len(columns))
+		for i := range columns {
+			columns[i] = strconv.Itoa(i)
+		}
+	}
+
+	writer := wesplot.NewTeeStdoutWriter(columns, wesplot.TeeFormat(readOptions.Format))
+	writer.SetPrecision(readOptions.Precision)
+	writer.SetXIsTimestamp(metadata.XIsTimestamp)
+	return writer
+}
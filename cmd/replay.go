@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/jessevdk/go-flags"
+)
+
+var replayOptions struct {
+	Speed string `long:"speed" default:"1x" description:"Replay the capture sped up by this factor (e.g. 5x)"`
+}
+
+// The session file being replayed, if any, which is read instead of the input.
+var replaySession *wesplot.SessionDataRowReader
+
+// Turns the arguments of the replay subcommand into the options of wesplot,
+// to serve a capture (such as a --tee-file) with the timing of its X values:
+//
+//	wesplot replay capture.csv --speed 5x --title "Experiment 3"
+//
+// The first column of the capture is its X, which must be a timestamp. If the
+// capture starts with a header row, its column names are used as the column
+// labels unless --columns is specified. The other arguments are passed through
+// to wesplot.
+//
+// A session file recorded with --record is replayed with the metadata it
+// recorded instead, such as its title and axes.
+func replayArgs(args []string) []string {
+	path := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path, args = args[0], args[1:]
+	}
+
+	parser := flags.NewParser(&replayOptions, flags.Default|flags.IgnoreUnknown)
+	parser.Usage = "capture.csv [--speed 5x] [OPTIONS of wesplot]"
+	passed, err := parser.ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "usage: wesplot replay capture.csv [--speed 5x] [OPTIONS of wesplot]")
+		os.Exit(1)
+	}
+
+	hasColumns := false
+	for _, arg := range passed {
+		if arg == "-c" || strings.HasPrefix(arg, "--columns") || strings.HasPrefix(arg, "-n") || strings.HasPrefix(arg, "--num-columns") {
+			hasColumns = true
+		}
+	}
+
+	if wesplot.IsSessionFile(path) {
+		var err error
+		replaySession, err = wesplot.OpenSession(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot read %s: %v\n", path, err)
+			os.Exit(1)
+		}
+
+		return append([]string{"--replay-speed", replayOptions.Speed}, passed...)
+	}
+
+	header, numColumns, err := readCaptureHeader(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read %s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	replayArgs := []string{"--file", path, "--tindex", "0", "--replay-speed", replayOptions.Speed}
+	if header != nil {
+		replayArgs = append(replayArgs, "--skip-header")
+	}
+
+	if !hasColumns {
+		if header != nil {
+			for _, column := range header {
+				replayArgs = append(replayArgs, "--columns", column)
+			}
+		} else {
+			replayArgs = append(replayArgs, "--num-columns", strconv.Itoa(numColumns))
+		}
+	}
+
+	return append(replayArgs, passed...)
+}
+
+// Returns the column names of the series if the first row of the capture is a
+// header row (its X is not a number), and the number of series.
+func readCaptureHeader(path string) ([]string, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return nil, 0, scanner.Err()
+		}
+
+		return nil, 0, fmt.Errorf("the capture is empty")
+	}
+
+	fields := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+	if len(fields) < 2 {
+		return nil, 0, fmt.Errorf("expected the X column followed by at least one series")
+	}
+
+	series := fields[1:]
+	if _, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64); err == nil {
+		return nil, len(series), nil
+	}
+
+	for i, column := range series {
+		series[i] = strings.TrimSpace(column)
+	}
+
+	return series, len(series), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cactusdynamics/wesplot/sys"
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/exp/slices"
+)
+
+var sysOptions struct {
+	Metrics  string        `long:"metrics" default:"cpu,mem,net" description:"The comma-separated metrics to plot, among cpu, mem, swap, load, and net"`
+	Interval time.Duration `long:"interval" default:"1s" description:"The interval between two samples"`
+}
+
+// The system metrics being sampled by the sys subcommand, if any, which are
+// read instead of the input.
+var sysReader *sys.DataRowReader
+
+// Turns the arguments of the sys subcommand into the options of wesplot, to
+// plot the statistics of the local system:
+//
+//	wesplot sys --metrics cpu,mem,net --interval 2s
+//
+// The metrics default to cpu, mem and net. Unless --columns is specified, the
+// network traffic is plotted against the secondary Y axis, as it is in bytes
+// per second while the usages are percentages. The other arguments are passed
+// through.
+func sysArgs(args []string) []string {
+	parser := flags.NewParser(&sysOptions, flags.Default|flags.IgnoreUnknown)
+	parser.Usage = "[--metrics cpu,mem,swap,load,net] [--interval 1s] [OPTIONS of wesplot]"
+	passed, err := parser.ParseArgs(args)
+	if err != nil {
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+
+	hasColumns := false
+	hasTitle := false
+	for _, arg := range passed {
+		if arg == "-c" || strings.HasPrefix(arg, "--columns") || strings.HasPrefix(arg, "-n") || strings.HasPrefix(arg, "--num-columns") {
+			hasColumns = true
+		} else if arg == "-t" || strings.HasPrefix(arg, "--title") {
+			hasTitle = true
+		}
+	}
+
+	metricNames := strings.Split(sysOptions.Metrics, ",")
+	sysReader, err = sys.NewDataRowReader(metricNames, sysOptions.Interval)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var sysArgs []string
+	if !hasTitle {
+		hostname, err := os.Hostname()
+		if err == nil {
+			sysArgs = append(sysArgs, "--title", hostname)
+		}
+	}
+
+	if !hasColumns {
+		rates := sys.Metrics["net"]
+		for _, column := range sysReader.ColumnNames() {
+			sysArgs = append(sysArgs, "--columns", column)
+		}
+
+		if len(sysReader.ColumnNames()) > len(rates) && slices.Contains(metricNames, "net") {
+			for _, column := range rates {
+				sysArgs = append(sysArgs, "--y2-columns", column)
+			}
+
+			sysArgs = append(sysArgs, "--y2label", "bytes/s")
+		}
+	}
+
+	return append(sysArgs, passed...)
+}
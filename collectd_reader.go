@@ -0,0 +1,264 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The types of the parts of a collectd network packet, see
+// https://collectd.org/wiki/index.php/Binary_protocol.
+const (
+	collectdPartHost           = 0x0000
+	collectdPartTime           = 0x0001
+	collectdPartPlugin         = 0x0002
+	collectdPartPluginInstance = 0x0003
+	collectdPartType           = 0x0004
+	collectdPartTypeInstance   = 0x0005
+	collectdPartValues         = 0x0006
+	collectdPartTimeHires      = 0x0008
+	collectdPartEncryption     = 0x0210
+)
+
+// The types of the values of a collectd value list.
+const (
+	collectdCounter  = 0
+	collectdGauge    = 1
+	collectdDerive   = 2
+	collectdAbsolute = 3
+)
+
+// The default port of the collectd network plugin.
+const collectdDefaultPort = "25826"
+
+// Reads the value lists sent by the network plugin of collectd to the UDP
+// address (e.g. udp://:25826), so a live collectd can be plotted without
+// running a collectd server.
+//
+// Every value is labeled with the identifier of its value list, as written by
+// collectd (host/plugin-instance/type-instance, e.g.
+// web1/interface-eth0/if_octets), followed by the index of the value if the
+// list has more than one (e.g. web1/interface-eth0/if_octets/0 for the
+// received octets). Gauges are plotted as they are, while counters and derives
+// are plotted as rates per second, and absolutes as their value divided by the
+// time since the previous one. The X value is the time of the value list.
+// Signed packets are read without verifying their signature, and encrypted
+// packets are ignored.
+func NewCollectdDataRowReader(address string, columns []string) (*LabeledDataRowReader, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+
+	if u.Scheme != "udp" && u.Scheme != "udp4" && u.Scheme != "udp6" {
+		return nil, fmt.Errorf("unsupported listen address scheme %q, collectd sends UDP packets", u.Scheme)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), collectdDefaultPort)
+	}
+
+	conn, err := net.ListenPacket(u.Scheme, host)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logrus.WithFields(logrus.Fields{"tag": "Collectd", "address": address})
+	logger.Info("listening for collectd packets")
+
+	points := make(chan labeledPoint, bufferSize)
+	errs := make(chan error, 1)
+	go func() {
+		parser := newCollectdParser()
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				logger.WithError(err).Error("stopped listening for collectd packets")
+				errs <- err
+				return
+			}
+
+			packetPoints, err := parser.parse(buf[:n])
+			if err != nil {
+				logger.WithError(err).Warn("cannot parse packet, ignoring...")
+			}
+
+			for _, point := range packetPoints {
+				points <- point
+			}
+		}
+	}()
+
+	read := func(ctx context.Context) (labeledPoint, error) {
+		select {
+		case point := <-points:
+			return point, nil
+		case err := <-errs:
+			return labeledPoint{}, err
+		case <-ctx.Done():
+			conn.Close()
+			return labeledPoint{}, ctx.Err()
+		}
+	}
+
+	return newLabeledDataRowReader(read, columns, logger), nil
+}
+
+// The last value of a counter, derive or absolute, to compute its rate.
+type collectdSample struct {
+	value float64
+	time  float64
+}
+
+type collectdParser struct {
+	// The parts of the value lists are only sent when they change, so they
+	// are kept while parsing a packet.
+	host, plugin, pluginInstance, kind, typeInstance string
+	time                                             float64
+
+	lastSamples map[string]collectdSample // By label
+}
+
+func newCollectdParser() *collectdParser {
+	return &collectdParser{lastSamples: make(map[string]collectdSample)}
+}
+
+// Returns a point per value list of the packet. The points parsed before an
+// invalid part are returned with the error.
+func (p *collectdParser) parse(packet []byte) ([]labeledPoint, error) {
+	var points []labeledPoint
+
+	p.host, p.plugin, p.pluginInstance, p.kind, p.typeInstance = "", "", "", "", ""
+	p.time = 0
+
+	for len(packet) > 0 {
+		if len(packet) < 4 {
+			return points, errors.New("truncated part header")
+		}
+
+		partType := binary.BigEndian.Uint16(packet[0:2])
+		length := int(binary.BigEndian.Uint16(packet[2:4]))
+		if length < 4 || length > len(packet) {
+			return points, fmt.Errorf("invalid part length %d", length)
+		}
+
+		body := packet[4:length]
+		packet = packet[length:]
+
+		switch partType {
+		case collectdPartHost:
+			p.host = collectdString(body)
+		case collectdPartPlugin:
+			p.plugin = collectdString(body)
+		case collectdPartPluginInstance:
+			p.pluginInstance = collectdString(body)
+		case collectdPartType:
+			p.kind = collectdString(body)
+		case collectdPartTypeInstance:
+			p.typeInstance = collectdString(body)
+		case collectdPartTime:
+			if len(body) != 8 {
+				return points, errors.New("invalid time part")
+			}
+			p.time = float64(binary.BigEndian.Uint64(body))
+		case collectdPartTimeHires:
+			if len(body) != 8 {
+				return points, errors.New("invalid time part")
+			}
+			p.time = float64(binary.BigEndian.Uint64(body)) / (1 << 30)
+		case collectdPartValues:
+			point, err := p.parseValues(body)
+			if err != nil {
+				return points, err
+			}
+			points = append(points, point)
+		case collectdPartEncryption:
+			return points, errors.New("encrypted packets are not supported")
+		}
+	}
+
+	return points, nil
+}
+
+func (p *collectdParser) parseValues(body []byte) (labeledPoint, error) {
+	point := labeledPoint{values: make(map[string]float64)}
+	if len(body) < 2 {
+		return point, errors.New("invalid values part")
+	}
+
+	count := int(binary.BigEndian.Uint16(body[0:2]))
+	if len(body) != 2+count*9 {
+		return point, errors.New("invalid values part")
+	}
+
+	identifier := p.host + "/" + joinCollectdInstance(p.plugin, p.pluginInstance) + "/" + joinCollectdInstance(p.kind, p.typeInstance)
+	types := body[2 : 2+count]
+	values := body[2+count:]
+
+	for i := 0; i < count; i++ {
+		label := identifier
+		if count > 1 {
+			label += "/" + strconv.Itoa(i)
+		}
+
+		raw := values[i*8 : i*8+8]
+		var value float64
+		switch types[i] {
+		case collectdGauge:
+			// The only little endian value.
+			point.values[label] = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+			continue
+		case collectdCounter, collectdAbsolute:
+			value = float64(binary.BigEndian.Uint64(raw))
+		case collectdDerive:
+			value = float64(int64(binary.BigEndian.Uint64(raw)))
+		default:
+			return point, fmt.Errorf("unknown value type %d", types[i])
+		}
+
+		last, ok := p.lastSamples[label]
+		p.lastSamples[label] = collectdSample{value: value, time: p.time}
+		if !ok || p.time <= last.time {
+			continue
+		}
+
+		if types[i] == collectdAbsolute {
+			point.values[label] = value / (p.time - last.time)
+		} else if types[i] == collectdCounter && value < last.value {
+			// The counter wrapped or was reset, so the rate is unknown.
+			continue
+		} else {
+			point.values[label] = (value - last.value) / (p.time - last.time)
+		}
+	}
+
+	if p.time > 0 {
+		point.hasTimestamp = true
+		point.timestamp = p.time
+	}
+
+	return point, nil
+}
+
+// Strings are terminated by a null byte.
+func collectdString(body []byte) string {
+	return strings.TrimRight(string(body), "\x00")
+}
+
+func joinCollectdInstance(name string, instance string) string {
+	if instance == "" {
+		return name
+	}
+
+	return name + "-" + instance
+}
@@ -0,0 +1,246 @@
+package wesplot
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// The capacity of a ColumnarBuffer when the first row is pushed. It is doubled
+// whenever it is full, up to the maximum number of rows.
+const initialColumnarCapacity = 1024
+
+// The history of the DataBroadcaster, stored as columns used as circular
+// buffers: one for the X of the rows, one for their sequence numbers, and one
+// per series. Unlike a ring of DataRow, pushing a row does not allocate once
+// the buffer has grown to its capacity, and reading the rows allocates the Ys
+// of all the rows at once. With float32 columns, the Ys take half the memory
+// (see DataBroadcaster.SetFloat32).
+//
+// The rows that do not fit the columns (annotations, the end of the stream,
+// and the rows with a different number of Ys than the first row) are kept
+// aside, but still take a slot in the columns so the order is preserved.
+//
+// The oldest rows are evicted once there are maxRows rows, if positive, and
+// once their X is older than the window from the X of the most recent row, if
+// positive. Rows are expected to be pushed in increasing sequence number (and
+// X, if the window is positive) order.
+//
+// Like the rest of the state of the DataBroadcaster, this is not thread-safe.
+type ColumnarBuffer[F float32 | float64] struct {
+	maxRows int
+	window  float64
+
+	xs   []float64
+	seqs []uint64
+	ys   [][]F // One column per series, nil until the first data row
+
+	// The colors of the rows, if the first data row has a color (see
+	// DataRow.color). Not affected by float32, as there is a single column.
+	colors []float64
+
+	// The X of every series, if the first data row has Xs (see DataRow.Xs).
+	// Like X, they are not affected by float32.
+	seriesXs [][]float64
+
+	start  int // The index of the oldest row in the columns
+	length int
+
+	others map[uint64]DataRow // The rows that do not fit, by sequence number
+}
+
+func NewColumnarBuffer[F float32 | float64](maxRows int, window time.Duration) *ColumnarBuffer[F] {
+	return &ColumnarBuffer[F]{
+		maxRows: maxRows,
+		window:  window.Seconds(),
+		others:  make(map[uint64]DataRow),
+	}
+}
+
+func (b *ColumnarBuffer[F]) Push(dataRow DataRow) {
+	cutoff := dataRow.X - b.window
+	for b.length > 0 && ((b.maxRows > 0 && b.length >= b.maxRows) || (b.window > 0 && b.xs[b.start] < cutoff)) {
+		b.evictOldest()
+	}
+
+	isData := dataRow.annotation == nil && !dataRow.streamEnded
+	if b.ys == nil && isData {
+		b.ys = make([][]F, len(dataRow.Ys))
+		for j := range b.ys {
+			b.ys[j] = make([]F, len(b.xs))
+		}
+
+		if dataRow.hasColor {
+			b.colors = make([]float64, len(b.xs))
+		}
+
+		// The rows with other Xs are kept aside, so every row read has an X
+		// per Y.
+		if len(dataRow.Xs) > 0 && len(dataRow.Xs) == len(dataRow.Ys) {
+			b.seriesXs = make([][]float64, len(dataRow.Xs))
+			for j := range b.seriesXs {
+				b.seriesXs[j] = make([]float64, len(b.xs))
+			}
+		}
+	}
+
+	if b.length == len(b.xs) {
+		b.grow()
+	}
+
+	i := (b.start + b.length) % len(b.xs)
+	b.xs[i] = dataRow.X
+	b.seqs[i] = dataRow.seq
+	b.length++
+
+	if !isData || len(dataRow.Ys) != len(b.ys) || len(dataRow.Xs) != len(b.seriesXs) {
+		b.others[dataRow.seq] = dataRow
+		return
+	}
+
+	for j, y := range dataRow.Ys {
+		b.ys[j][i] = F(y)
+	}
+
+	for j, x := range dataRow.Xs {
+		b.seriesXs[j][i] = x
+	}
+
+	if b.colors != nil {
+		b.colors[i] = math.NaN()
+		if dataRow.hasColor {
+			b.colors[i] = dataRow.color
+		}
+	}
+}
+
+func (b *ColumnarBuffer[F]) evictOldest() {
+	if len(b.others) > 0 {
+		delete(b.others, b.seqs[b.start])
+	}
+
+	b.start = (b.start + 1) % len(b.xs)
+	b.length--
+}
+
+// Doubles the capacity of the columns, up to maxRows, and moves the oldest row
+// to the start.
+func (b *ColumnarBuffer[F]) grow() {
+	capacity := Max(2*len(b.xs), initialColumnarCapacity)
+	if b.maxRows > 0 {
+		capacity = Min(capacity, b.maxRows)
+	}
+
+	b.xs = resizeColumn(b.xs, b.start, b.length, capacity)
+	b.seqs = resizeColumn(b.seqs, b.start, b.length, capacity)
+	for j := range b.ys {
+		b.ys[j] = resizeColumn(b.ys[j], b.start, b.length, capacity)
+	}
+
+	if b.colors != nil {
+		b.colors = resizeColumn(b.colors, b.start, b.length, capacity)
+	}
+
+	for j := range b.seriesXs {
+		b.seriesXs[j] = resizeColumn(b.seriesXs[j], b.start, b.length, capacity)
+	}
+
+	b.start = 0
+}
+
+// Copies the rows of a column into a new column of the given capacity,
+// starting at index 0.
+func resizeColumn[T any](column []T, start int, length int, capacity int) []T {
+	resized := make([]T, capacity)
+	n := copy(resized, column[start:Min(start+length, len(column))])
+	copy(resized[n:], column[:length-n])
+	return resized
+}
+
+func (b *ColumnarBuffer[F]) Len() int {
+	return b.length
+}
+
+// Returns the sequence number of the oldest row, or false if the buffer is
+// empty.
+func (b *ColumnarBuffer[F]) OldestSeq() (uint64, bool) {
+	if b.length == 0 {
+		return 0, false
+	}
+
+	return b.seqs[b.start], true
+}
+
+func (b *ColumnarBuffer[F]) ReadAllOrdered() []DataRow {
+	return b.ReadAfter(0, 0)
+}
+
+// Returns the rows with a sequence number greater than afterSeq, oldest first,
+// up to limit rows if positive. The Ys of all the rows share the same
+// allocation.
+func (b *ColumnarBuffer[F]) ReadAfter(afterSeq uint64, limit int) []DataRow {
+	first := sort.Search(b.length, func(k int) bool {
+		return b.seqs[(b.start+k)%len(b.xs)] > afterSeq
+	})
+
+	n := b.length - first
+	if limit > 0 {
+		n = Min(n, limit)
+	}
+
+	if n == 0 {
+		return []DataRow{}
+	}
+
+	columns := len(b.ys)
+	dataRows := make([]DataRow, n)
+	values := make([]float64, n*columns)
+
+	var xValues []float64
+	if len(b.seriesXs) == columns && columns > 0 {
+		xValues = make([]float64, n*columns)
+	}
+
+	for k := 0; k < n; k++ {
+		i := (b.start + first + k) % len(b.xs)
+
+		if len(b.others) > 0 {
+			if other, ok := b.others[b.seqs[i]]; ok {
+				dataRows[k] = other
+				continue
+			}
+		}
+
+		ys := values[k*columns : (k+1)*columns : (k+1)*columns]
+		for j := range ys {
+			ys[j] = float64(b.ys[j][i])
+		}
+
+		dataRows[k] = DataRow{X: b.xs[i], Ys: ys, seq: b.seqs[i]}
+		if xValues != nil {
+			xs := xValues[k*columns : (k+1)*columns : (k+1)*columns]
+			for j := range xs {
+				xs[j] = b.seriesXs[j][i]
+			}
+
+			dataRows[k].Xs = xs
+		}
+		if b.colors != nil {
+			dataRows[k].color = b.colors[i]
+			dataRows[k].hasColor = true
+		}
+	}
+
+	return dataRows
+}
+
+// The memory taken by every row in a ColumnarBuffer with float32 or float64
+// columns, excluding the rows kept aside.
+func columnarRowSize(numSeries int, float32 bool) int64 {
+	valueSize := int64(8)
+	if float32 {
+		valueSize = 4
+	}
+
+	return 8 + 8 + valueSize*int64(numSeries) // X, sequence number, and Ys
+}
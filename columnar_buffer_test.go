@@ -0,0 +1,166 @@
+package wesplot
+
+import (
+	"testing"
+	"time"
+)
+
+func pushRows(b dataRowBuffer, xs ...float64) {
+	for _, x := range xs {
+		b.Push(DataRow{X: x, Ys: []float64{x * 10, x * 100}, seq: uint64(x)})
+	}
+}
+
+func checkRows(t *testing.T, name string, dataRows []DataRow, expectedXs ...float64) {
+	t.Helper()
+
+	if len(dataRows) != len(expectedXs) {
+		t.Errorf("%s: got %d rows, expected %d: %v", name, len(dataRows), len(expectedXs), dataRows)
+		return
+	}
+
+	for i, dataRow := range dataRows {
+		x := expectedXs[i]
+		if dataRow.X != x || dataRow.seq != uint64(x) || !equalFloats(dataRow.Ys, []float64{x * 10, x * 100}) {
+			t.Errorf("%s: got %v with seq %d, expected the row of X %v", name, dataRow, dataRow.seq, x)
+		}
+	}
+}
+
+func TestColumnarBufferMaxRows(t *testing.T) {
+	b := NewColumnarBuffer[float64](3, 0)
+
+	if _, ok := b.OldestSeq(); ok {
+		t.Error("expected no oldest row in an empty buffer")
+	}
+	checkRows(t, "empty", b.ReadAllOrdered())
+
+	pushRows(b, 1, 2)
+	checkRows(t, "not full", b.ReadAllOrdered(), 1, 2)
+
+	pushRows(b, 3, 4, 5)
+	checkRows(t, "full", b.ReadAllOrdered(), 3, 4, 5)
+	checkRows(t, "after seq 3", b.ReadAfter(3, 0), 4, 5)
+	checkRows(t, "after the last row", b.ReadAfter(5, 0))
+	checkRows(t, "after an evicted row", b.ReadAfter(1, 0), 3, 4, 5)
+	checkRows(t, "limited", b.ReadAfter(0, 2), 3, 4)
+
+	if seq, ok := b.OldestSeq(); !ok || seq != 3 {
+		t.Errorf("got oldest seq %d, expected 3", seq)
+	}
+}
+
+func TestColumnarBufferGrow(t *testing.T) {
+	// The columns grow past their initial capacity while wrapped around.
+	b := NewColumnarBuffer[float64](0, 0)
+	var xs []float64
+	for x := 1; x <= 3*initialColumnarCapacity; x++ {
+		xs = append(xs, float64(x))
+	}
+	pushRows(b, xs...)
+	checkRows(t, "grown", b.ReadAllOrdered(), xs...)
+
+	b = NewColumnarBuffer[float64](initialColumnarCapacity+10, 0)
+	pushRows(b, xs...)
+	checkRows(t, "grown to max rows", b.ReadAllOrdered(), xs[len(xs)-initialColumnarCapacity-10:]...)
+}
+
+func TestColumnarBufferWindow(t *testing.T) {
+	b := NewColumnarBuffer[float64](0, 10*time.Second)
+	pushRows(b, 1, 5, 10, 12)
+	checkRows(t, "within the window", b.ReadAllOrdered(), 5, 10, 12)
+
+	// The window and the maximum number of rows both apply.
+	b = NewColumnarBuffer[float64](2, 10*time.Second)
+	pushRows(b, 1, 5, 10, 12)
+	checkRows(t, "max rows within the window", b.ReadAllOrdered(), 10, 12)
+}
+
+func TestColumnarBufferOtherRows(t *testing.T) {
+	b := NewColumnarBuffer[float64](4, 0)
+	pushRows(b, 1)
+	b.Push(DataRow{X: 2, annotation: &Annotation{X: 2, Label: "deploy"}, seq: 2})
+	b.Push(DataRow{X: 3, Ys: []float64{30}, seq: 3})
+	pushRows(b, 4)
+	b.Push(DataRow{streamEnded: true, seq: 5})
+
+	dataRows := b.ReadAllOrdered()
+	if len(dataRows) != 4 {
+		t.Fatalf("got %d rows, expected 4: %v", len(dataRows), dataRows)
+	}
+
+	if dataRows[0].annotation == nil || dataRows[0].annotation.Label != "deploy" {
+		t.Errorf("got %v, expected the annotation", dataRows[0])
+	}
+
+	if dataRows[1].X != 3 || !equalFloats(dataRows[1].Ys, []float64{30}) {
+		t.Errorf("got %v, expected the row with a single Y", dataRows[1])
+	}
+
+	checkRows(t, "data row", dataRows[2:3], 4)
+
+	if !dataRows[3].streamEnded {
+		t.Errorf("got %v, expected the end of the stream", dataRows[3])
+	}
+
+	// The rows kept aside are evicted with the others.
+	pushRows(b, 6, 7, 8)
+	if len(b.others) != 1 {
+		t.Errorf("got %d rows kept aside, expected only the end of the stream", len(b.others))
+	}
+}
+
+func TestColumnarBufferSeriesXs(t *testing.T) {
+	b := NewColumnarBuffer[float64](10, 0)
+	b.Push(DataRow{X: 2, Ys: []float64{10, 20}, Xs: []float64{1, 2}, seq: 1})
+	b.Push(DataRow{X: 3, Ys: []float64{30, 40}, Xs: []float64{3}, seq: 2})
+	b.Push(DataRow{X: 4, Ys: []float64{50, 60}, seq: 3})
+
+	dataRows := b.ReadAllOrdered()
+	if len(dataRows) != 3 {
+		t.Fatalf("got %d rows, expected 3: %v", len(dataRows), dataRows)
+	}
+
+	if !equalFloats(dataRows[0].Xs, []float64{1, 2}) || !equalFloats(dataRows[0].Ys, []float64{10, 20}) {
+		t.Errorf("got %v, expected the row with its Xs", dataRows[0])
+	}
+
+	// The rows with other Xs are kept aside as is.
+	if !equalFloats(dataRows[1].Xs, []float64{3}) || dataRows[2].Xs != nil {
+		t.Errorf("got %v and %v, expected the rows with other Xs as is", dataRows[1], dataRows[2])
+	}
+
+	// The Xs of the first row do not match its Ys, so no row has Xs in the
+	// columns.
+	b = NewColumnarBuffer[float64](10, 0)
+	b.Push(DataRow{X: 2, Ys: []float64{10, 20}, Xs: []float64{}, seq: 1})
+	b.Push(DataRow{X: 3, Ys: []float64{30, 40}, Xs: []float64{3}, seq: 2})
+	dataRows = b.ReadAllOrdered()
+	if len(dataRows) != 2 || dataRows[0].Xs != nil || !equalFloats(dataRows[1].Xs, []float64{3}) {
+		t.Errorf("got %v, expected the rows without Xs in the columns", dataRows)
+	}
+}
+
+func TestColumnarBufferFloat32(t *testing.T) {
+	b := NewColumnarBuffer[float32](10, 0)
+	b.Push(DataRow{X: 1700000000.123, Ys: []float64{0.1}, seq: 1})
+
+	dataRows := b.ReadAllOrdered()
+	if len(dataRows) != 1 || dataRows[0].X != 1700000000.123 || dataRows[0].Ys[0] != float64(float32(0.1)) {
+		t.Errorf("got %v, expected the X as is and the Y rounded to float32", dataRows)
+	}
+}
+
+func BenchmarkColumnarBufferPush(b *testing.B) {
+	buffer := NewColumnarBuffer[float64](1800, 0)
+	dataRows := benchmarkDataRows(1000, 4)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		dataRow := dataRows[i%len(dataRows)]
+		dataRow.seq = uint64(i + 1)
+		buffer.Push(dataRow)
+	}
+}
@@ -0,0 +1,45 @@
+package wesplot
+
+import (
+	"net/http"
+)
+
+// Serves POST /control/pause and POST /control/resume, which pause and resume
+// the input of the DataBroadcaster, so users can freeze the plots to inspect
+// them while the producer keeps running. By default, the input is not read
+// while paused, which blocks the producer once the pipe is full. With
+// /control/pause?discard, the input is read and discarded instead. GET
+// /control returns the PauseState, as do the other requests. The POST requests
+// must be enabled with SetControl.
+func (s *HttpServer) handleControl(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/control" {
+		if req.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSONResponse(w, s.dataBroadcaster.PauseState())
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkControl(w, req) {
+		return
+	}
+
+	switch req.URL.Path {
+	case "/control/pause":
+		s.dataBroadcaster.Pause(req.URL.Query().Has("discard"))
+	case "/control/resume":
+		s.dataBroadcaster.Resume()
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	writeJSON(w, s.dataBroadcaster.PauseState())
+}
@@ -0,0 +1,111 @@
+package wesplot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleControlPause(t *testing.T) {
+	tests := []struct {
+		name    string
+		control bool
+		method  string
+		path    string
+		origin  string
+		status  int
+		state   PauseState
+	}{
+		{
+			name:   "get",
+			method: http.MethodGet,
+			path:   "/control",
+			status: http.StatusOK,
+		},
+		{
+			name:    "pause",
+			control: true,
+			method:  http.MethodPost,
+			path:    "/control/pause",
+			status:  http.StatusOK,
+			state:   PauseState{Paused: true},
+		},
+		{
+			name:    "pause and discard",
+			control: true,
+			method:  http.MethodPost,
+			path:    "/control/pause?discard",
+			status:  http.StatusOK,
+			state:   PauseState{Paused: true, Discard: true},
+		},
+		{
+			name:    "resume",
+			control: true,
+			method:  http.MethodPost,
+			path:    "/control/resume",
+			status:  http.StatusOK,
+		},
+		{
+			name:   "disabled",
+			method: http.MethodPost,
+			path:   "/control/pause",
+			status: http.StatusForbidden,
+		},
+		{
+			name:    "cross origin",
+			control: true,
+			method:  http.MethodPost,
+			path:    "/control/pause",
+			origin:  "http://evil.example.org",
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "get pause",
+			control: true,
+			method:  http.MethodGet,
+			path:    "/control/pause",
+			status:  http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		dataBroadcaster := NewDataBroadcaster(NewChannelDataRowReader([]string{"a"}, 10), 100, false)
+		metadata := Metadata{WindowSize: 100, WesplotOptions: WesplotOptions{Columns: []string{"a"}}}
+		s := NewHttpServer(dataBroadcaster, "127.0.0.1", 0, metadata, 250*time.Millisecond)
+		s.SetControl(test.control)
+
+		req := httptest.NewRequest(test.method, "http://example.com"+test.path, nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.mux.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: got status %d, expected %d: %s", test.name, recorder.Code, test.status, recorder.Body)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			// The input is not paused by the rejected requests.
+			if dataBroadcaster.PauseState().Paused {
+				t.Errorf("%s: the input was paused by a rejected request", test.name)
+			}
+
+			continue
+		}
+
+		var state PauseState
+		err := json.NewDecoder(recorder.Body).Decode(&state)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if state != test.state || dataBroadcaster.PauseState() != test.state {
+			t.Errorf("%s: got %+v, expected %+v", test.name, state, test.state)
+		}
+	}
+}
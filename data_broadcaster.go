@@ -2,21 +2,23 @@ package wesplot
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"io"
 	"runtime/trace"
-	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 )
 
 type DataBroadcaster struct {
 	// The data row reader to be read from.
 	input DataRowReader
 
-	teeMode bool
+	// Writes a copy of the rows, if not nil.
+	tees []RowWriter
 
 	mutex sync.Mutex
 	wg    sync.WaitGroup
@@ -25,38 +27,159 @@ type DataBroadcaster struct {
 	streamEnded atomic.Bool
 	err         error // The error emited by the Run(), if any. Should be read after streamEnded == true to ensure no data race.
 
-	// These are channels from open websockets where we are sending data to.
-	// Channels should be buffered, to not block the DataBroadcaster.
-	channelsForLiveUpdate []chan<- DataRow
+	// These are the channels from open websockets where we are sending data to.
+	// Live data is sent to the bounded queue of each subscription, and then
+	// forwarded to the channel by a goroutine per subscription. See
+	// RegisterChannel for details.
+	subscriptions []*subscription
 
-	// This contains the most recent data received. The data in this ring will be
-	// sent to channel upon registration. See RegisterChannel for details.
-	//
-	// TODO: potentially switch to an allocating, time-based ring buffer instead
-	// of this.
-	dataBuffer *ThreadUnsafeRing[DataRow]
+	// Used to identify the subscriptions in /stats.
+	lastSubscriptionID uint64
+
+	// Maps the registered channels to their subscriptions, so they can be
+	// stopped without taking the mutex.
+	subscriptionsByChannel sync.Map
+
+	// This contains the most recent data received. The data in this buffer will
+	// be sent to channel upon registration. See RegisterChannel for details.
+	dataBuffer dataRowBuffer
+
+	// How dataBuffer is created. See newDataBuffer.
+	windowDuration time.Duration
+	float32        bool
+
+	// The sizes of the buffers, which can be limited by SetMemoryLimits.
+	memoryLimits MemoryLimits
 
 	// Just for tracking how many rows are emitted when EOF is encountered.
 	numDataRowsEmitted int
 
+	// When the last data row was emitted (or when the broadcaster started), in
+	// unix nanoseconds. See LastRowTime.
+	lastRowTime atomic.Int64
+
+	// The sequence number of the last row cached, and of the row ending the
+	// stream once it is cached.
+	lastSeq uint64
+	endSeq  uint64
+
+	// Set via Pause and Resume. pauseChanged is closed and replaced whenever
+	// the pause state changes.
+	pauseMutex   sync.Mutex
+	pause        PauseState
+	pauseChanged chan struct{}
+
+	// Statistics about the rows read from the input and the summary statistics
+	// of each series, served via /stats.
+	stats       *InputStats
+	seriesStats *SeriesStats
+
+	// Served via /metrics.
+	metrics broadcasterMetrics
+
 	logger logrus.FieldLogger
 }
 
+// A ColumnarBuffer with float32 or float64 columns.
+type dataRowBuffer interface {
+	Push(dataRow DataRow)
+	ReadAfter(afterSeq uint64, limit int) []DataRow
+	OldestSeq() (uint64, bool)
+	Len() int
+}
+
 func NewDataBroadcaster(input DataRowReader, bufferCapacity int, teeMode bool) *DataBroadcaster {
+	var tees []RowWriter
+	if teeMode {
+		tees = append(tees, NewTeeStdoutWriter(input.ColumnNames(), TeeFormatCSV))
+	}
+
 	return &DataBroadcaster{
 		input: input,
 
-		teeMode: teeMode,
+		tees: tees,
+
+		mutex:              sync.Mutex{},
+		subscriptions:      make([]*subscription, 0),
+		dataBuffer:         NewColumnarBuffer[float64](bufferCapacity, 0),
+		memoryLimits:       MemoryLimits{WindowSize: bufferCapacity, ClientBufferSize: bufferSize},
+		numDataRowsEmitted: 0,
+		pauseChanged:       make(chan struct{}),
+		stats:              NewInputStats(),
+		seriesStats:        NewSeriesStats(input.ColumnNames()),
+		logger:             logrus.WithField("tag", "DataBroadcaster"),
+	}
+}
+
+// Counts the rows emitted and dropped into the stats, such as to share them
+// with the CountingReader of the input. By default, the broadcaster has stats
+// of its own. Must be called before Start.
+func (d *DataBroadcaster) SetInputStats(stats *InputStats) {
+	d.stats = stats
+}
+
+// Keeps the rows of the last window of X (normally a timestamp in seconds),
+// instead of a fixed number of rows. Must be called before Start.
+func (d *DataBroadcaster) SetWindowDuration(window time.Duration) {
+	d.windowDuration = window
+	d.dataBuffer = d.newDataBuffer()
+}
+
+// Stores the Ys of the history as float32 instead of float64, which halves
+// their memory for large windows at the cost of precision (about 7 significant
+// digits). The rows are then sent to all the clients with the precision of
+// float32. Must be called before Start.
+func (d *DataBroadcaster) SetFloat32(enabled bool) {
+	d.float32 = enabled
+	d.dataBuffer = d.newDataBuffer()
+}
 
-		mutex:                 sync.Mutex{},
-		channelsForLiveUpdate: make([]chan<- DataRow, 0),
-		dataBuffer:            NewRing[DataRow](bufferCapacity),
-		numDataRowsEmitted:    0,
-		logger:                logrus.WithField("tag", "DataBroadcaster"),
+func (d *DataBroadcaster) Float32() bool {
+	return d.float32
+}
+
+// Sizes the history and the queues of the clients as planned by PlanMemory.
+// With a window duration, the oldest rows are evicted early if the window
+// holds more rows than the limits allow. Must be called before Start.
+func (d *DataBroadcaster) SetMemoryLimits(limits MemoryLimits) {
+	d.memoryLimits = limits
+	d.dataBuffer = d.newDataBuffer()
+}
+
+func (d *DataBroadcaster) newDataBuffer() dataRowBuffer {
+	// The window size only bounds a window duration if the memory is limited.
+	maxRows := d.memoryLimits.WindowSize
+	if d.windowDuration > 0 && d.memoryLimits.MaxMemory == 0 {
+		maxRows = 0
+	}
+
+	if d.float32 {
+		return NewColumnarBuffer[float32](maxRows, d.windowDuration)
 	}
+	return NewColumnarBuffer[float64](maxRows, d.windowDuration)
+}
+
+func (d *DataBroadcaster) MemoryLimits() MemoryLimits {
+	return d.memoryLimits
+}
+
+// Also writes a copy of the rows to the RowWriter, such as a --tee-file, a
+// session file being recorded and a SQLite database. The RowWriter is closed
+// when the stream ends. Must be called before Start.
+func (d *DataBroadcaster) AddTeeWriter(tee RowWriter) {
+	d.tees = append(d.tees, tee)
+}
+
+// When the last row of data was emitted, or when the broadcaster was started
+// if no row was emitted yet, such as to tell the clients that the input has
+// stalled. Can be called from any goroutine.
+func (d *DataBroadcaster) LastRowTime() time.Time {
+	return time.Unix(0, d.lastRowTime.Load())
 }
 
 func (d *DataBroadcaster) Start(ctx context.Context) {
+	d.lastRowTime.Store(time.Now().UnixNano())
+
 	d.wg.Add(1)
 	go func() {
 		defer d.wg.Done()
@@ -64,6 +187,13 @@ func (d *DataBroadcaster) Start(ctx context.Context) {
 
 		d.err = err
 
+		for _, tee := range d.tees {
+			closeErr := tee.Close()
+			if closeErr != nil {
+				d.logger.WithError(closeErr).Error("cannot close tee output")
+			}
+		}
+
 		// Must set all variables to be read after DataBroadcaster is complete before
 		// this, as this atomic is used to "release" all the other variables (see Golang
 		// memory model)
@@ -88,12 +218,44 @@ func (d *DataBroadcaster) Wait() {
 	d.wg.Wait()
 }
 
+// Returns the error the input stream ended with, or nil if it reached EOF.
+// Must be called after Wait returns.
+func (d *DataBroadcaster) Err() error {
+	return d.err
+}
+
 // Register a new channel. Called from the HTTP server when a new websocket
 // connection is initiated.
 //
 // - ctx: is the HTTP call context.
-// - c: is the channel to send data on. This should be a buffered channel to ensure the DataBroadcaster is not blocked, as if any channel is blocked, everything is blocked.
+// - c: is the channel to send data on. If the client falls behind by more than MemoryLimits().ClientBufferSize rows, the oldest rows not yet sent to it are dropped, so a slow client never blocks the DataBroadcaster.
 func (d *DataBroadcaster) RegisterChannel(ctx context.Context, c chan<- DataRow) {
+	d.RegisterChannelFrom(ctx, c, 0, HistoryAll, "")
+}
+
+// Which rows are pushed to a new channel.
+type HistoryMode int
+
+const (
+	// The buffered rows, and then the new rows.
+	HistoryAll HistoryMode = iota
+
+	// Only the new rows, such as to tail the stream.
+	HistoryNone
+
+	// Only the buffered rows, followed by a row ending the stream, such as to
+	// take a snapshot of the stream.
+	HistoryOnly
+)
+
+// Same as RegisterChannel, but only the buffered rows with a sequence number
+// greater than afterSeq are pushed, so a reconnecting client only receives the
+// rows it missed. If some of those rows are no longer buffered, all the
+// buffered rows are pushed instead, which the client can detect from the
+// sequence number of the first row. The history mode selects whether the
+// buffered rows and the new rows are pushed. The name describes the client in
+// /stats.
+func (d *DataBroadcaster) RegisterChannelFrom(ctx context.Context, c chan<- DataRow, afterSeq uint64, history HistoryMode, name string) {
 	// Note: this method should only be called by the HTTP server thread and not
 	// the DataBroadcaster thread.
 	//
@@ -106,41 +268,77 @@ func (d *DataBroadcaster) RegisterChannel(ctx context.Context, c chan<- DataRow)
 	//
 	// To accomplish this, whenever we register a new channel (i.e. a new browser
 	// client opens against this process), we take a global mutex on the
-	// DataBroadcaster. The mutex will only be locked when no data is being sent
-	// to the existing channels for live update. While the mutex is locked, no
-	// additional data can be written to the buffers nor sent down the existing
-	// channels. At this time, this code will then send all the buffered data to
-	// the newly registered channels and add the channels into the list of
-	// channels for live update. Only then it will unlock, which allows the main
-	// DataBroadcaster to continue. Once continued, it will add the next message
-	// into the cache and also it to all the channels, which will now include the
-	// newly registered channels. This ensures no messages are missed in this
-	// pipeline.
+	// DataBroadcaster. While the mutex is locked, no additional data can be
+	// written to the buffer nor sent to the existing subscriptions. At this time,
+	// this code records the sequence number of the last buffered row and adds a
+	// subscription with an empty queue into the list of subscriptions for live
+	// update. Only then it will unlock, which allows the main DataBroadcaster to
+	// continue. Once continued, it will add the next message into the cache and
+	// also send it to the queue of all the subscriptions, which will now include
+	// the new one.
+	//
+	// Outside of the lock, a goroutine per subscription first pushes the
+	// buffered rows up to the recorded one to the channel, and then forwards the
+	// queue to it. This ensures no messages are reordered in this pipeline. The
+	// buffered rows are copied in chunks of historyChunkSize rows, with the lock
+	// only held while a chunk is copied, so registering a new tab on a large
+	// window neither stalls the live plots nor copies the whole window at once.
+	// If the client is so slow that some of the rows are evicted before they are
+	// copied, they are skipped, which the client can detect from the sequence
+	// numbers.
 	//
-	// This simple implementation means there can be a small amount of latency
-	// when adding channels which may result in the "lock up" of all real-time
-	// plots. This trade off is accepted as adding a new client (basically a new
-	// tab) is not common. The latency is logged and can be measured via pprof.
+	// The queues are bounded and the DataBroadcaster never waits on them: if a
+	// client is too slow to keep up and its queue fills up, the oldest queued
+	// row is dropped to make room for the new one. This decouples the read
+	// speed of the input from the slowest client. The dropped rows are counted
+	// and shown in /stats.
 
 	traceCtx, task := trace.NewTask(ctx, "RegisterChannel")
 	defer task.End()
 
-	trace.WithRegion(traceCtx, "Lock", d.mutex.Lock)
-	defer d.mutex.Unlock()
+	sub := &subscription{
+		c:           c,
+		name:        name,
+		connectedAt: time.Now(),
+		queue:       make(chan DataRow, d.memoryLimits.ClientBufferSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
 
-	// First, we push all the buffered data to this channel to make sure it has all the histories.
-	trace.WithRegion(traceCtx, "pushBufferedDataToChannel", func() {
-		d.pushBufferedDataToChannel(c)
-	})
+	trace.WithRegion(traceCtx, "Lock", d.lock)
+
+	d.lastSubscriptionID++
+	sub.id = d.lastSubscriptionID
+	sub.logger = d.logger.WithFields(logrus.Fields{"subscription": sub.id, "client": name})
+
+	// Not tracing the rest because it should be insignificant in terms of time
+	// taken, as the snapshot is just a copy of the buffer.
+	sub.historyAfterSeq = d.resumeSeq(afterSeq)
+	sub.historyUntilSeq = d.lastSeq
+	if history == HistoryNone {
+		// If the stream already ended, the row ending it is still pushed, so
+		// the client does not wait for rows that never come.
+		sub.historyAfterSeq = d.lastSeq
+		if d.endSeq != 0 {
+			sub.historyAfterSeq = d.endSeq - 1
+		}
+	}
 
-	// Second, we add the channel into the list of channels we want to live update.
-	// Not tracing this because it should be insignificant in terms of time taken
-	d.channelsForLiveUpdate = append(d.channelsForLiveUpdate, c)
+	sub.historyOnly = history == HistoryOnly
+	sub.readHistory = d.readHistory
+	sub.metrics = &d.metrics
+	d.subscriptions = append(d.subscriptions, sub)
+	d.subscriptionsByChannel.Store(c, sub)
 
-	d.logger.WithFields(logrus.Fields{
-		"newChannel": c,
-		"channels":   d.channelsForLiveUpdate,
+	sub.logger.WithFields(logrus.Fields{
+		"newChannel":    c,
+		"historyRows":   sub.historyUntilSeq - sub.historyAfterSeq,
+		"subscriptions": len(d.subscriptions),
 	}).Info("registered channel")
+
+	d.mutex.Unlock()
+
+	go sub.forward()
 }
 
 // Deregister a channel to get data updates. Called when a websocket client
@@ -156,16 +354,87 @@ func (d *DataBroadcaster) DeregisterChannel(ctx context.Context, c chan<- DataRo
 	traceCtx, task := trace.NewTask(ctx, "DeregisterChannel")
 	defer task.End()
 
-	trace.WithRegion(traceCtx, "Lock", d.mutex.Lock)
-	defer d.mutex.Unlock()
+	value, loaded := d.subscriptionsByChannel.LoadAndDelete(c)
+	if !loaded {
+		panic("deregistering a channel that is not registered")
+	}
+
+	// Stop the forwarding goroutine, which may be blocked sending to c.
+	sub := value.(*subscription)
+	close(sub.stop)
 
-	d.channelsForLiveUpdate = Filter(d.channelsForLiveUpdate, func(channel chan<- DataRow) bool {
-		return channel != c
+	trace.WithRegion(traceCtx, "Lock", d.lock)
+	d.subscriptions = Filter(d.subscriptions, func(other *subscription) bool {
+		return other != sub
 	})
-	d.logger.WithFields(logrus.Fields{
+
+	sub.logger.WithFields(logrus.Fields{
 		"removedChannel": c,
-		"channels":       d.channelsForLiveUpdate,
+		"rowsDropped":    sub.rowsDropped.Load(),
+		"subscriptions":  len(d.subscriptions),
 	}).Info("deregistered channel")
+
+	d.mutex.Unlock()
+
+	// Once the forwarding goroutine exits, nothing sends to c anymore.
+	<-sub.done
+}
+
+type PauseState struct {
+	Paused bool
+
+	// If true, the input is read and discarded while paused. Otherwise, the
+	// input is not read, so the producer is blocked once the pipe is full.
+	Discard bool
+}
+
+// Pauses the input, so the plots are frozen while the producer keeps running.
+// The rows that are already queued to the clients are still sent. Can be
+// called from any goroutine.
+func (d *DataBroadcaster) Pause(discard bool) {
+	d.setPauseState(PauseState{Paused: true, Discard: discard})
+	d.logger.WithField("discard", discard).Info("input paused")
+}
+
+func (d *DataBroadcaster) Resume() {
+	d.setPauseState(PauseState{})
+	d.logger.Info("input resumed")
+}
+
+func (d *DataBroadcaster) setPauseState(pause PauseState) {
+	d.pauseMutex.Lock()
+	defer d.pauseMutex.Unlock()
+
+	d.pause = pause
+	close(d.pauseChanged)
+	d.pauseChanged = make(chan struct{})
+}
+
+func (d *DataBroadcaster) PauseState() PauseState {
+	d.pauseMutex.Lock()
+	defer d.pauseMutex.Unlock()
+
+	return d.pause
+}
+
+// Blocks until the input is resumed, if it is paused without discarding.
+func (d *DataBroadcaster) waitUntilResumed(ctx context.Context) error {
+	for {
+		d.pauseMutex.Lock()
+		pause := d.pause
+		pauseChanged := d.pauseChanged
+		d.pauseMutex.Unlock()
+
+		if !pause.Paused || pause.Discard {
+			return nil
+		}
+
+		select {
+		case <-pauseChanged:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
 func (d *DataBroadcaster) run(ctx context.Context) error {
@@ -173,13 +442,19 @@ func (d *DataBroadcaster) run(ctx context.Context) error {
 	var err error
 
 	for {
+		err = d.waitUntilResumed(ctx)
+		if err != nil {
+			return err
+		}
+
 		traceCtx, task := trace.NewTask(ctx, "DataBroadcasterLoop")
 
 		trace.WithRegion(traceCtx, "DataSourceRead", func() {
 			dataRow, err = d.input.Read(traceCtx)
 		})
 
-		if err == errIgnoreThisRow {
+		if errors.Is(err, errIgnoreThisRow) {
+			d.stats.RowDropped(ignoredRowReason(err))
 			task.End()
 			continue
 		} else if err == io.EOF {
@@ -193,49 +468,271 @@ func (d *DataBroadcaster) run(ctx context.Context) error {
 			return err
 		}
 
-		if d.teeMode {
-			// Kind of inefficient, but probably OK.
-			dataLine := make([]string, 0, len(dataRow.Ys)+1)
-			dataLine = append(dataLine, fmt.Sprintf("%f", dataRow.X))
+		if d.PauseState().Discard {
+			d.stats.RowDropped(DropReasonPaused)
+			task.End()
+			continue
+		}
 
-			for _, y := range dataRow.Ys {
-				dataLine = append(dataLine, fmt.Sprintf("%f", y))
-			}
+		if dataRow.annotation != nil || dataRow.gap {
+			// Marker lines in the input and gaps, which are not data.
+			d.cacheAndBroadcastData(traceCtx, dataRow)
+			task.End()
+			continue
+		}
 
-			fmt.Println(strings.Join(dataLine, ","))
+		for i := 0; i < len(d.tees); i++ {
+			err := d.tees[i].Write(dataRow)
+			if err != nil {
+				// Keep plotting, but don't log the same error for every row.
+				d.logger.WithError(err).Error("cannot write tee output, disabling it")
+				d.tees[i].Close()
+				d.tees = slices.Delete(d.tees, i, i+1)
+				i--
+			}
 		}
 
+		// Only needed by the TeeWriter.
+		dataRow.xText = ""
+		dataRow.yTexts = nil
+
+		d.stats.RowEmitted()
+		d.lastRowTime.Store(time.Now().UnixNano())
+		d.seriesStats.Update(dataRow.Ys)
 		d.cacheAndBroadcastData(traceCtx, dataRow)
 		task.End()
 	}
 }
 
-func (d *DataBroadcaster) cacheAndBroadcastData(traceCtx context.Context, dataRow DataRow) {
-	d.numDataRowsEmitted++
+// Adds an annotation to the stream. It is cached and sent to the clients in
+// order with the rows, so it is dropped from the cache along with the rows
+// around it. Can be called from any goroutine.
+func (d *DataBroadcaster) Annotate(annotation Annotation) {
+	d.cacheAndBroadcastData(context.Background(), DataRow{
+		X:          annotation.X,
+		annotation: &annotation,
+	})
+}
 
-	trace.WithRegion(traceCtx, "Lock", d.mutex.Lock)
+func (d *DataBroadcaster) cacheAndBroadcastData(traceCtx context.Context, dataRow DataRow) {
+	// Annotate is called from the HTTP server, so everything is done under the
+	// mutex.
+	trace.WithRegion(traceCtx, "Lock", d.lock)
 	defer d.mutex.Unlock()
 
+	if dataRow.annotation == nil {
+		d.numDataRowsEmitted++
+	}
+
 	d.logger.WithFields(logrus.Fields{
 		"x":  dataRow.X,
 		"ys": dataRow.Ys,
 	}).Debug("new data row")
 
+	d.lastSeq++
+	dataRow.seq = d.lastSeq
+	if dataRow.streamEnded {
+		d.endSeq = dataRow.seq
+	}
+
+	start := time.Now()
+	defer func() {
+		d.metrics.broadcastNanos.Add(int64(time.Since(start)))
+		d.metrics.broadcasts.Add(1)
+	}()
+
 	trace.WithRegion(traceCtx, "Cache", func() {
 		d.dataBuffer.Push(dataRow)
 	})
 
 	trace.WithRegion(traceCtx, "Broadcast", func() {
-		for _, c := range d.channelsForLiveUpdate {
-			c <- dataRow
+		for _, sub := range d.subscriptions {
+			sub.send(dataRow)
 		}
 	})
 }
 
-func (d *DataBroadcaster) pushBufferedDataToChannel(c chan<- DataRow) {
-	bufferedData := d.dataBuffer.ReadAllOrdered()
+// Returns afterSeq, or 0 to push all the buffered rows if some of the rows
+// after afterSeq are no longer buffered. Must be called with the mutex locked.
+func (d *DataBroadcaster) resumeSeq(afterSeq uint64) uint64 {
+	if afterSeq > 0 {
+		oldestSeq, ok := d.dataBuffer.OldestSeq()
+		if ok && oldestSeq > afterSeq+1 {
+			d.logger.WithFields(logrus.Fields{
+				"afterSeq":  afterSeq,
+				"oldestSeq": oldestSeq,
+			}).Info("rows to resume from are no longer buffered, pushing all buffered rows")
+			return 0
+		}
+	}
+
+	return afterSeq
+}
+
+// Returns a copy of up to historyChunkSize buffered rows with a sequence
+// number greater than afterSeq.
+func (d *DataBroadcaster) readHistory(afterSeq uint64) []DataRow {
+	d.lock()
+	defer d.mutex.Unlock()
+
+	return d.dataBuffer.ReadAfter(afterSeq, historyChunkSize)
+}
+
+// Returns a copy of all the buffered rows, including the annotations, such as
+// to export a snapshot of the plot.
+func (d *DataBroadcaster) History() []DataRow {
+	var rows []DataRow
+	var afterSeq uint64
+	for {
+		chunk := d.readHistory(afterSeq)
+		if len(chunk) == 0 {
+			return rows
+		}
+
+		rows = append(rows, chunk...)
+		afterSeq = chunk[len(chunk)-1].seq
+	}
+}
+
+type ClientStatsSnapshot struct {
+	ID            uint64
+	Name          string
+	ConnectedFor  float64 // seconds
+	QueueDepth    int     // Rows waiting to be sent to the client
+	QueueCapacity int
+	RowsDropped   int64 // Rows dropped because the queue was full
+}
+
+// Returns the statistics of the queue of every registered channel.
+func (d *DataBroadcaster) ClientStats() []ClientStatsSnapshot {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	snapshots := make([]ClientStatsSnapshot, len(d.subscriptions))
+	for i, sub := range d.subscriptions {
+		snapshots[i] = ClientStatsSnapshot{
+			ID:            sub.id,
+			Name:          sub.name,
+			ConnectedFor:  time.Since(sub.connectedAt).Seconds(),
+			QueueDepth:    len(sub.queue),
+			QueueCapacity: cap(sub.queue),
+			RowsDropped:   sub.rowsDropped.Load(),
+		}
+	}
+
+	return snapshots
+}
+
+// A registered channel. Live data is sent to the bounded queue by the
+// DataBroadcaster and forwarded to the channel by forward, after the buffered
+// rows with a sequence number in (historyAfterSeq, historyUntilSeq]. If
+// historyOnly is set, the stream ends after the buffered rows instead.
+type subscription struct {
+	id          uint64
+	name        string
+	connectedAt time.Time
+
+	c     chan<- DataRow
+	queue chan DataRow
+
+	historyAfterSeq uint64
+	historyUntilSeq uint64
+	historyOnly     bool
+	readHistory     func(afterSeq uint64) []DataRow
+
+	metrics *broadcasterMetrics
+
+	rowsDropped atomic.Int64
+
+	stop chan struct{} // Closed when the channel is deregistered
+	done chan struct{} // Closed when forward exits
+
+	logger logrus.FieldLogger
+}
+
+// Queues the row without blocking. If the queue is full, the oldest queued row
+// is dropped to make room for it.
+func (s *subscription) send(dataRow DataRow) {
+	for {
+		select {
+		case s.queue <- dataRow:
+			return
+		default:
+		}
+
+		// The forwarding goroutine may have made room in the meantime, in which
+		// case nothing is dropped.
+		select {
+		case <-s.queue:
+			s.metrics.clientRowsDropped.Add(1)
+			if s.rowsDropped.Add(1) == 1 {
+				s.logger.Warn("client is too slow to keep up, dropping the oldest rows")
+			}
+		default:
+		}
+	}
+}
+
+func (s *subscription) forward() {
+	defer close(s.done)
+
+	afterSeq := s.historyAfterSeq
+history:
+	for afterSeq < s.historyUntilSeq {
+		dataRows := s.readHistory(afterSeq)
+		if len(dataRows) == 0 {
+			break
+		}
+
+		for _, dataRow := range dataRows {
+			if dataRow.seq > s.historyUntilSeq {
+				break history // Already in the queue
+			}
+
+			if !s.sendToChannel(dataRow) {
+				return
+			}
+
+			afterSeq = dataRow.seq
+		}
+	}
+
+	if s.historyOnly {
+		s.sendToChannel(DataRow{streamEnded: true})
+		return
+	}
+
+	for {
+		select {
+		case dataRow := <-s.queue:
+			if !s.sendToChannel(dataRow) {
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Sends the row to the channel, and records the time blocked if the client is
+// busy. Returns false if the channel is deregistered in the meantime.
+func (s *subscription) sendToChannel(dataRow DataRow) bool {
+	select {
+	case s.c <- dataRow:
+		return true
+	default:
+	}
+
+	start := time.Now()
+	defer func() {
+		s.metrics.sendBlockedNanos.Add(int64(time.Since(start)))
+		s.metrics.sendsBlocked.Add(1)
+	}()
 
-	for _, dataRow := range bufferedData {
-		c <- dataRow
+	select {
+	case s.c <- dataRow:
+		return true
+	case <-s.stop:
+		return false
 	}
 }
@@ -0,0 +1,134 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// Starts a broadcaster reading the rows sent to the returned reader, with
+// the default window and client buffer sizes.
+func startBenchmarkBroadcaster(b *testing.B, ctx context.Context, numColumns int) (*ChannelDataRowReader, *DataBroadcaster) {
+	// The broadcaster logs every registered channel and slow client.
+	level := logrus.GetLevel()
+	logrus.SetLevel(logrus.ErrorLevel)
+	b.Cleanup(func() { logrus.SetLevel(level) })
+
+	columns := make([]string, numColumns)
+	for i := range columns {
+		columns[i] = "y" + strconv.Itoa(i)
+	}
+
+	reader := NewChannelDataRowReader(columns, bufferSize)
+	broadcaster := NewDataBroadcaster(reader, 1800, false)
+	broadcaster.Start(ctx)
+	return reader, broadcaster
+}
+
+// Sends b.N rows into the reader, and then ends the stream.
+func sendBenchmarkDataRows(b *testing.B, reader *ChannelDataRowReader, dataRows []DataRow) {
+	go func() {
+		for i := 0; i < b.N; i++ {
+			reader.Send(dataRows[i%len(dataRows)])
+		}
+
+		reader.Close()
+	}()
+}
+
+// Measures the rows per second a subscribed channel receives from the input
+// of the broadcaster. The rows dropped because the channel did not keep up
+// are reported as dropped/op.
+func BenchmarkDataBroadcaster(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader, broadcaster := startBenchmarkBroadcaster(b, ctx, 4)
+	channel := make(chan DataRow, bufferSize)
+	broadcaster.RegisterChannelFrom(ctx, channel, 0, HistoryNone, "benchmark")
+	defer broadcaster.DeregisterChannel(ctx, channel)
+
+	dataRows := benchmarkDataRows(1000, 4)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	sendBenchmarkDataRows(b, reader, dataRows)
+
+	received := 0
+	for dataRow := range channel {
+		if dataRow.streamEnded {
+			break
+		}
+
+		received++
+	}
+
+	b.StopTimer()
+	broadcaster.Wait()
+	b.ReportMetric(float64(b.N-received)/float64(b.N), "dropped/op")
+}
+
+// Same as BenchmarkDataBroadcaster, but the rows are received by a websocket
+// client of /ws, so it also measures the batching and the encoding of the
+// rows.
+func BenchmarkDataBroadcasterWebSocket(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader, broadcaster := startBenchmarkBroadcaster(b, ctx, 4)
+	httpServer := NewHttpServer(broadcaster, "127.0.0.1", 0, Metadata{}, 20*time.Millisecond)
+	server := httptest.NewServer(httpServer.mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?history=none"
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer c.Close(websocket.StatusNormalClosure, "")
+	c.SetReadLimit(1 << 30)
+
+	// The rows sent before the client is registered would not be received.
+	for len(broadcaster.ClientStats()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	dataRows := benchmarkDataRows(1000, 4)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	sendBenchmarkDataRows(b, reader, dataRows)
+
+	// The server closes the websocket once the stream ended and the last rows
+	// are flushed.
+	received := 0
+	bytes := 0
+	for {
+		_, data, err := c.Read(ctx)
+		if err != nil {
+			break
+		}
+
+		var messageRows []json.RawMessage
+		if json.Unmarshal(data, &messageRows) == nil {
+			received += len(messageRows)
+		}
+
+		bytes += len(data)
+	}
+
+	b.StopTimer()
+	broadcaster.Wait()
+	b.SetBytes(int64(bytes / b.N))
+	b.ReportMetric(float64(b.N-received)/float64(b.N), "dropped/op")
+}
@@ -0,0 +1,65 @@
+package wesplot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Receives the rows of the channel until the row ending the stream.
+func receiveDataRows(t *testing.T, channel <-chan DataRow) []float64 {
+	t.Helper()
+
+	var xs []float64
+	for {
+		select {
+		case dataRow := <-channel:
+			if dataRow.streamEnded {
+				return xs
+			}
+
+			xs = append(xs, dataRow.X)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the stream did not end after %v", xs)
+			return nil
+		}
+	}
+}
+
+func TestRegisterChannelHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := NewChannelDataRowReader([]string{"a"}, 10)
+	broadcaster := NewDataBroadcaster(reader, 100, false)
+	broadcaster.Start(ctx)
+
+	for x := 1; x <= 3; x++ {
+		reader.Send(DataRow{X: float64(x), Ys: []float64{0}})
+	}
+
+	reader.Close()
+	broadcaster.Wait()
+
+	// The stream already ended, so every mode ends it right away.
+	tests := []struct {
+		name     string
+		history  HistoryMode
+		expected []float64
+	}{
+		{name: "all", history: HistoryAll, expected: []float64{1, 2, 3}},
+		{name: "none", history: HistoryNone},
+		{name: "only", history: HistoryOnly, expected: []float64{1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		channel := make(chan DataRow, 10)
+		broadcaster.RegisterChannelFrom(ctx, channel, 0, test.history, "test")
+		xs := receiveDataRows(t, channel)
+		broadcaster.DeregisterChannel(ctx, channel)
+
+		if !equalFloats(xs, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, xs, test.expected)
+		}
+	}
+}
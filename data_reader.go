@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -22,6 +24,17 @@ import (
 
 var errIgnoreThisRow = errors.New("ignore this row")
 
+// Lines starting with this prefix, such as "#wesplot:marker deploy v2", are
+// turned into annotations labeled with the rest of the line by the
+// TextToDataRowReader, so producers can mark the phases of an experiment
+// inline. The StringReaders return them as a single column, except the
+// CsvStringReader which splits them on commas.
+const markerPrefix = "#wesplot:marker"
+
+func isMarkerLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), markerPrefix)
+}
+
 // When Read is called, return an array of strings which are the columns.
 type StringReader interface {
 	Read(context.Context) ([]string, error)
@@ -31,8 +44,274 @@ type DataRow struct {
 	X  float64
 	Ys []float64
 
+	// If not nil, every series has its own X (see SeriesColumns): Xs[i] is the
+	// X of Ys[i], and X is the highest of them, which is used for the window
+	// and the stages that need a single X. See checkXs.
+	Xs []float64
+
 	streamEnded bool
 	streamErr   error
+
+	// If not nil, this row is an annotation instead of data, and Ys is nil. See
+	// DataBroadcaster.Annotate.
+	annotation *Annotation
+
+	// If true, this row only breaks the lines of the plot and all its Ys are
+	// missing. It is not a row of the input, so it is neither written by the
+	// TeeWriter nor counted. See GapDataRowReader.
+	gap bool
+
+	// Assigned by the DataBroadcaster, starting from 1. Allows reconnecting
+	// clients to resume from the last row they received.
+	seq uint64
+
+	// The X and Y values as they were in the input, if the row was parsed from
+	// text, so the TeeWriter can write them unchanged. xText is empty if X was
+	// generated, and yTexts is nil if any Y value was replaced. They are
+	// removed by the DataBroadcaster before the row is cached.
+	xText  string
+	yTexts []string
+
+	// The value that sets the color of the points of the row, if hasColor,
+	// such as from the --color-by column. It is encoded as "C" after the Ys,
+	// which the clients map to a color with the ColorScale of the Metadata.
+	color    float64
+	hasColor bool
+}
+
+// Encodes the DataRow as {"X": x, "Ys": [y1, y2, ...]}, followed by "Xs": [x1,
+// x2, ...] if the series have their own X, and "C": c if the row has a color.
+// Missing values are
+// represented by NaN in the DataRow, which cannot be encoded by encoding/json,
+// so they (and infinities) are encoded as null. The frontend will render them
+// as gaps.
+func (d DataRow) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, 16+len(d.Ys)*16)
+	return d.appendJSON(buf, rowEncoding{}), nil
+}
+
+// The variants of the JSON encoding of the rows sent to the clients, which
+// depend on the protocol they negotiated.
+type rowEncoding struct {
+	// With the sequence number of every row. See sequencedDataRows.
+	sequenced bool
+
+	// With the Ys rounded to the shortest representation of float32, which is
+	// much shorter for values that are not exactly representable. X is always
+	// encoded as a float64, as timestamps need its precision.
+	float32 bool
+
+	// With the X of the rows after the first one of every message replaced by
+	// DX, the rounded difference with the X of the previous row. See
+	// ProtocolV1DeltaFloat32.
+	deltaX bool
+
+	// With the rows of every message transposed into a single object, with the
+	// values of every series in an array. See ProtocolV1Columns.
+	columns bool
+}
+
+func (d DataRow) appendJSON(buf []byte, encoding rowEncoding) []byte {
+	buf = d.appendJSONSeq(buf, encoding)
+	buf = append(buf, `"X":`...)
+	buf = appendJSONFloat(buf, d.X, 64)
+	return d.appendJSONValues(buf, encoding)
+}
+
+// Appends the start of the object, with the sequence number if requested.
+func (d DataRow) appendJSONSeq(buf []byte, encoding rowEncoding) []byte {
+	buf = append(buf, '{')
+	if encoding.sequenced {
+		buf = append(buf, `"Seq":`...)
+		buf = strconv.AppendUint(buf, d.seq, 10)
+		buf = append(buf, ',')
+	}
+
+	return buf
+}
+
+// Appends the fields after the X, and the end of the object.
+func (d DataRow) appendJSONValues(buf []byte, encoding rowEncoding) []byte {
+	buf = append(buf, `,"Ys":`...)
+
+	if d.Ys == nil {
+		buf = append(buf, "null"...)
+	} else {
+		buf = append(buf, '[')
+		for i, y := range d.Ys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			if encoding.float32 {
+				buf = appendJSONFloat(buf, float64(float32(y)), 32)
+			} else {
+				buf = appendJSONFloat(buf, y, 64)
+			}
+		}
+		buf = append(buf, ']')
+	}
+
+	if d.Xs != nil {
+		buf = append(buf, `,"Xs":[`...)
+		for i, x := range d.Xs {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONFloat(buf, x, 64)
+		}
+		buf = append(buf, ']')
+	}
+
+	if d.hasColor {
+		buf = append(buf, `,"C":`...)
+		buf = appendJSONFloat(buf, d.color, 64)
+	}
+
+	return append(buf, '}')
+}
+
+// Encodes the rows as a JSON array in which every row also has its sequence
+// number, as {"Seq": n, "X": x, "Ys": [y1, y2, ...]}.
+type sequencedDataRows []DataRow
+
+func (rows sequencedDataRows) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, 2+len(rows)*32)
+	return appendDataRowsJSON(buf, rows, rowEncoding{sequenced: true}), nil
+}
+
+// Appends the rows as a JSON array to buf. Without deltaX, this is the same as
+// json.Marshal, without the allocations and the validation of the output of
+// MarshalJSON.
+func appendDataRowsJSON(buf []byte, rows []DataRow, encoding rowEncoding) []byte {
+	if encoding.columns {
+		return appendDataRowsColumnsJSON(buf, rows, encoding)
+	}
+
+	// The X of the previous row as the client computes it from the DXs, so the
+	// rounding errors of the DXs do not accumulate.
+	previousX := math.NaN()
+
+	buf = append(buf, '[')
+	for i, row := range rows {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+
+		if !encoding.deltaX {
+			buf = row.appendJSON(buf, encoding)
+			continue
+		}
+
+		// The rows after a non-finite X, which cannot be added to, have an X.
+		dx := roundSignificant(row.X-previousX, deltaXDigits)
+		if math.IsNaN(dx) || math.IsInf(dx, 0) {
+			buf = row.appendJSON(buf, encoding)
+			previousX = row.X
+			continue
+		}
+
+		buf = row.appendJSONSeq(buf, encoding)
+		buf = append(buf, `"DX":`...)
+		buf = appendJSONFloat(buf, dx, 64)
+		buf = row.appendJSONValues(buf, encoding)
+		previousX += dx
+	}
+
+	return append(buf, ']')
+}
+
+// Appends the rows as {"X": [x1, x2, ...], "Ys": [[y1 of series 1, y2 of
+// series 1, ...], [y1 of series 2, ...], ...]}, preceded by "Seq": [seq1,
+// seq2, ...] if requested. If a row has fewer Ys than the others, its missing
+// values are null.
+func appendDataRowsColumnsJSON(buf []byte, rows []DataRow, encoding rowEncoding) []byte {
+	numSeries := 0
+	for _, row := range rows {
+		numSeries = Max(numSeries, len(row.Ys))
+	}
+
+	bitSize := 64
+	if encoding.float32 {
+		bitSize = 32
+	}
+
+	buf = append(buf, '{')
+	if encoding.sequenced {
+		buf = append(buf, `"Seq":[`...)
+		for i, row := range rows {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = strconv.AppendUint(buf, row.seq, 10)
+		}
+		buf = append(buf, "],"...)
+	}
+
+	buf = append(buf, `"X":[`...)
+	for i, row := range rows {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONFloat(buf, row.X, 64)
+	}
+
+	buf = append(buf, `],"Ys":[`...)
+	for j := 0; j < numSeries; j++ {
+		if j > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = append(buf, '[')
+		for i, row := range rows {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+
+			y := math.NaN()
+			if j < len(row.Ys) {
+				y = row.Ys[j]
+			}
+
+			if encoding.float32 {
+				y = float64(float32(y))
+			}
+			buf = appendJSONFloat(buf, y, bitSize)
+		}
+		buf = append(buf, ']')
+	}
+
+	return append(buf, "]}"...)
+}
+
+// The significant digits of the DXs, see ProtocolV1DeltaFloat32.
+const deltaXDigits = 7
+
+// Rounds the value to the number of significant digits. The result is the
+// float64 nearest to the rounded decimal number, so its shortest
+// representation has at most that many digits, and is parsed back to it.
+func roundSignificant(value float64, digits int) float64 {
+	if value == 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+
+	// The powers of 10 are exact, so the division and the multiplication are
+	// correctly rounded.
+	exponent := digits - 1 - int(math.Floor(math.Log10(math.Abs(value))))
+	if exponent >= 0 {
+		scale := math.Pow10(exponent)
+		return math.Round(value*scale) / scale
+	}
+
+	scale := math.Pow10(-exponent)
+	return math.Round(value/scale) * scale
+}
+
+func appendJSONFloat(buf []byte, value float64, bitSize int) []byte {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return append(buf, "null"...)
+	}
+
+	return strconv.AppendFloat(buf, value, 'g', -1, bitSize)
 }
 
 // When Read is called, return the DataRow.
@@ -79,7 +358,7 @@ func (r *CsvStringReader) Read(ctx context.Context) ([]string, error) {
 		switch err.(type) {
 		case *csv.ParseError:
 			logger.WithError(err).Debug("unable to parse CSV, ignoring...")
-			return nil, errIgnoreThisRow
+			return nil, ignoreRow(DropReasonCsvParse)
 		default:
 			logger.WithError(err).Error("unable to read CSV")
 			return nil, err
@@ -107,28 +386,204 @@ func NewRelaxedStringReader(input io.Reader) *RelaxedStringReader {
 	}
 }
 
-// Split on either comma or any number of spaces or tabs
-var relaxedSplitter = regexp.MustCompile("[ \t]+|,")
+// Split on either comma (with optional spaces or tabs around it) or any number
+// of spaces or tabs
+var relaxedSplitter = regexp.MustCompile("[ \t]*,[ \t]*|[ \t]+")
 
 func (r *RelaxedStringReader) Read(ctx context.Context) ([]string, error) {
 	stillHasData := r.scanner.Scan()
 	if !stillHasData {
+		// Err returns nil if the input simply reached EOF.
+		err := r.scanner.Err()
+		if err != nil {
+			logrus.WithField("tag", "RelaxedString").WithError(err).Error("unable to read line")
+			return nil, err
+		}
+
 		return nil, io.EOF
 	}
 
 	line := r.scanner.Text()
-	err := r.scanner.Err()
-	if err != nil {
-		logrus.WithField("tag", "RelaxedString").WithError(err).Error("unable to read line")
-		return nil, err
+
+	return splitRelaxed(line), nil
+}
+
+// Splits a line the same way as the RelaxedStringReader.
+func splitRelaxed(line string) []string {
+	line = strings.Trim(line, " \t")
+	if len(line) == 0 {
+		return []string{}
 	}
 
-	// Return only non-empty lines
-	splittedLine := Filter(relaxedSplitter.Split(line, -1), func(value string) bool {
-		return len(value) > 0
-	})
+	if isMarkerLine(line) {
+		return []string{line}
+	}
+
+	// Empty fields between commas are kept, as they indicate missing values.
+	// A single trailing comma is ignored, as some programs end every line with
+	// one, so 1,2,3, still has 3 columns.
+	fields := relaxedSplitter.Split(line, -1)
+	if len(fields) > 1 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+
+	return fields
+}
+
+// A StringReader that extracts the columns from each line with the named
+// capture groups of a regular expression. This allows unstructured text, such
+// as log lines or the output of ping, to be plotted without preprocessing.
+// Lines that do not match are ignored.
+type RegexStringReader struct {
+	input   io.Reader
+	scanner *bufio.Scanner
+	regex   *regexp.Regexp
+
+	// Indices of the named groups in the submatches.
+	groupIndices []int
+
+	lineCount int
+}
+
+// Creates a RegexStringReader. The regex must contain at least one named
+// capture group (e.g. (?P<latency>\d+\.\d+) ms). The columns returned are
+// the values of the named groups in the order they appear in the regex.
+func NewRegexStringReader(input io.Reader, regex *regexp.Regexp) (*RegexStringReader, error) {
+	r := &RegexStringReader{
+		input:   input,
+		scanner: bufio.NewScanner(input),
+		regex:   regex,
+
+		lineCount: 0,
+	}
+
+	for i, name := range regex.SubexpNames() {
+		if name == "" {
+			continue
+		}
+
+		r.groupIndices = append(r.groupIndices, i)
+	}
+
+	if len(r.groupIndices) == 0 {
+		return nil, fmt.Errorf("regex %q does not contain any named capture groups", regex)
+	}
+
+	return r, nil
+}
+
+func (r *RegexStringReader) Read(ctx context.Context) ([]string, error) {
+	stillHasData := r.scanner.Scan()
+	if !stillHasData {
+		err := r.scanner.Err()
+		if err != nil {
+			logrus.WithField("tag", "RegexString").WithError(err).Error("unable to read line")
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	r.lineCount++
 
-	return splittedLine, nil
+	line := r.scanner.Text()
+	if isMarkerLine(line) {
+		return []string{line}, nil
+	}
+
+	submatches := r.regex.FindStringSubmatch(line)
+	if submatches == nil {
+		logrus.WithFields(logrus.Fields{
+			"tag":     "RegexString",
+			"line":    line,
+			"lineNum": r.lineCount,
+		}).Debug("line does not match regex, ignoring...")
+		return nil, ignoreRow(DropReasonRegexMismatch)
+	}
+
+	columns := make([]string, len(r.groupIndices))
+	for i, groupIndex := range r.groupIndices {
+		columns[i] = submatches[groupIndex]
+	}
+
+	return columns, nil
+}
+
+// A StringReader that returns every line whole, as a single column, for the
+// readers that parse the lines themselves, such as the
+// InfluxLineDataRowReader.
+type LineStringReader struct {
+	scanner *bufio.Scanner
+}
+
+func NewLineStringReader(input io.Reader) *LineStringReader {
+	return &LineStringReader{
+		scanner: bufio.NewScanner(input),
+	}
+}
+
+func (r *LineStringReader) Read(ctx context.Context) ([]string, error) {
+	stillHasData := r.scanner.Scan()
+	if !stillHasData {
+		err := r.scanner.Err()
+		if err != nil {
+			logrus.WithField("tag", "LineString").WithError(err).Error("unable to read line")
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	return []string{strings.TrimSpace(r.scanner.Text())}, nil
+}
+
+// Returns an error unless the row has no Xs or one X per Y, such as for the
+// rows decoded from JSON.
+func (d DataRow) checkXs() error {
+	if len(d.Xs) != 0 && len(d.Xs) != len(d.Ys) {
+		return fmt.Errorf("expected no Xs or one X per Y (%d), got %d", len(d.Ys), len(d.Xs))
+	}
+
+	return nil
+}
+
+// The input columns of a series with its own X column, such as to plot
+// together traces sampled at different X values.
+type SeriesColumns struct {
+	XIndex int
+	YIndex int
+}
+
+// Parses the columns of a series specified in the form of `y=2,x=1`. This
+// implements the go-flags Unmarshaler interface.
+func (c *SeriesColumns) UnmarshalFlag(value string) error {
+	*c = SeriesColumns{XIndex: -1, YIndex: -1}
+	for _, field := range strings.Split(value, ",") {
+		key, indexStr, found := strings.Cut(field, "=")
+		index, err := strconv.Atoi(strings.TrimSpace(indexStr))
+		if !found || err != nil || index < 0 {
+			return fmt.Errorf("invalid series %q, expected y=<index>,x=<index>", value)
+		}
+
+		switch strings.TrimSpace(key) {
+		case "x":
+			c.XIndex = index
+		case "y":
+			c.YIndex = index
+		default:
+			return fmt.Errorf("invalid series %q, expected y=<index>,x=<index>", value)
+		}
+	}
+
+	if c.XIndex < 0 || c.YIndex < 0 {
+		return fmt.Errorf("invalid series %q, expected y=<index>,x=<index>", value)
+	}
+
+	if c.XIndex == c.YIndex {
+		return fmt.Errorf("invalid series %q, x and y must be different columns", value)
+	}
+
+	return nil
 }
 
 // Generates the current unix timestamp in seconds.
@@ -153,44 +608,168 @@ type TextToDataRowReader struct {
 	// The generator function. Defaults to NowXGenerator.
 	XGenerator func([]float64) float64
 
+	// The index of the column that sets the color of the points (see
+	// DataRow.color). If this is <0, the rows have no color. Like the X column,
+	// this column is not put into DataRow.Ys.
+	ColorIndex int
+
+	// The parser for the X column. If this is nil, the X column is parsed as a
+	// float. This is used to parse non-numeric timestamps (see NewTimeParser).
+	XParser func(string) (float64, error)
+
 	// The labels of the columns excluding the X column.
 	Columns []string
 
+	// The columns of every series, if the series have their own X column. If
+	// this is not empty, XIndex, XGenerator and XParser are not used, the rows
+	// have Xs (see DataRow.Xs), and Columns are the labels of the series.
+	Series []SeriesColumns
+
 	// If the input row has a different length than Columns, ignore the row.
 	ExpectExactColumnCount bool
+
+	// Ignore the first row of the input, such as the header row of a CSV file.
+	SkipHeader    bool
+	headerSkipped bool
+
+	// Values (case insensitive) that indicate a missing Y value, such as "-" or
+	// an empty field. These are converted to NaN (as is "nan", which is parsed
+	// as such), and are plotted as gaps. A missing X value causes the row to be
+	// ignored.
+	MissingValues []string
+
+	// What to do with rows that cannot be parsed. Defaults to ParseErrorDrop.
+	OnParseError ParseErrorPolicy
+
+	// For ParseErrorWarnSummary
+	droppedRows     int
+	lastSummaryTime time.Time
+
+	// The X of the last row read, for the markers when X is not generated.
+	lastX    float64
+	hasLastX bool
 }
 
+type ParseErrorPolicy string
+
+const (
+	// Ignore the row and log a warning for each row.
+	ParseErrorDrop ParseErrorPolicy = "drop"
+
+	// End the stream with an error.
+	ParseErrorHalt ParseErrorPolicy = "halt"
+
+	// Replace unparsable Y values with 0. Rows with unparsable X values or an
+	// unexpected column count are still ignored.
+	ParseErrorZero ParseErrorPolicy = "zero"
+
+	// Ignore the row and periodically log the number of ignored rows, instead of
+	// a warning per row.
+	ParseErrorWarnSummary ParseErrorPolicy = "warn-summary"
+)
+
+const parseErrorSummaryInterval = 10 * time.Second
+
 func (r *TextToDataRowReader) Read(ctx context.Context) (DataRow, error) {
 	line, err := r.Input.Read(ctx)
 	if err != nil {
 		return DataRow{}, err
 	}
 
+	if r.SkipHeader && !r.headerSkipped {
+		r.headerSkipped = true
+		line, err = r.Input.Read(ctx)
+		if err != nil {
+			return DataRow{}, err
+		}
+	}
+
 	logger := logrus.WithFields(logrus.Fields{
 		"tag":  "TextToData",
 		"line": line,
 	})
 
+	if len(line) > 0 && isMarkerLine(line[0]) {
+		return r.markerRow(logger, strings.Join(line, ","))
+	}
+
+	if len(r.Series) > 0 {
+		return r.readSeries(logger, line)
+	}
+
 	dataRow := DataRow{}
+	yTexts := make([]string, 0, len(line))
+	yReplaced := false
 
 	for i, value := range line {
-		floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if i == r.XIndex && r.XParser != nil {
+			value = strings.TrimSpace(value)
+			x, err := r.XParser(value)
+			if err != nil {
+				return r.parseError(logger.WithError(err), DropReasonXParse, "cannot parse x value")
+			}
+
+			if math.IsNaN(x) {
+				return r.parseError(logger, DropReasonXMissing, "x value is missing")
+			}
+
+			dataRow.X = x
+			dataRow.xText = value
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if i == r.ColorIndex {
+			// A color that cannot be parsed is missing, as the point can still
+			// be plotted.
+			color, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				color = math.NaN()
+			}
+
+			dataRow.color = color
+			dataRow.hasColor = true
+			continue
+		}
+
+		if i != r.XIndex && r.isMissingValue(value) {
+			dataRow.Ys = append(dataRow.Ys, math.NaN())
+			yTexts = append(yTexts, value)
+			continue
+		}
+
+		floatValue, err := strconv.ParseFloat(value, 64)
 		if err != nil {
-			logger.Warn("cannot parse float, ignoring...")
-			return DataRow{}, errIgnoreThisRow
+			if i != r.XIndex && r.OnParseError == ParseErrorZero {
+				logger.Debug("cannot parse float, replacing with 0...")
+				dataRow.Ys = append(dataRow.Ys, 0)
+				yReplaced = true
+				continue
+			}
+
+			return r.parseError(logger, DropReasonYParse, "cannot parse float")
 		}
 
 		if i == r.XIndex {
+			if math.IsNaN(floatValue) {
+				return r.parseError(logger, DropReasonXMissing, "x value is missing")
+			}
+
 			dataRow.X = floatValue
+			dataRow.xText = value
 			continue
 		}
 
 		dataRow.Ys = append(dataRow.Ys, floatValue)
+		yTexts = append(yTexts, value)
+	}
+
+	if !yReplaced {
+		dataRow.yTexts = yTexts
 	}
 
 	if r.ExpectExactColumnCount && (len(r.Columns) != len(dataRow.Ys)) {
-		logger.Warnf("expected column count (%d) is not observed (%d). use `wesplot -n %d` to ensure this row is read", len(r.Columns), len(dataRow.Ys), len(dataRow.Ys))
-		return DataRow{}, errIgnoreThisRow
+		return r.parseError(logger, DropReasonColumnCount, fmt.Sprintf("expected column count (%d) is not observed (%d). use `wesplot -n %d` to ensure this row is read", len(r.Columns), len(dataRow.Ys), len(dataRow.Ys)))
 	}
 
 	if r.XIndex < 0 {
@@ -202,9 +781,128 @@ func (r *TextToDataRowReader) Read(ctx context.Context) (DataRow, error) {
 		dataRow.X = xGenerator(dataRow.Ys)
 	}
 
+	r.lastX = dataRow.X
+	r.hasLastX = true
+
 	return dataRow, nil
 }
 
+// Parses the X and the Y of every series. A series with a missing X is
+// missing from the row, which is ignored if all of them are.
+func (r *TextToDataRowReader) readSeries(logger logrus.FieldLogger, line []string) (DataRow, error) {
+	dataRow := DataRow{
+		X:  math.Inf(-1),
+		Ys: make([]float64, len(r.Series)),
+		Xs: make([]float64, len(r.Series)),
+	}
+
+	for i, series := range r.Series {
+		if series.XIndex >= len(line) || series.YIndex >= len(line) {
+			return r.parseError(logger, DropReasonColumnCount, fmt.Sprintf("expected at least %d columns, got %d", Max(series.XIndex, series.YIndex)+1, len(line)))
+		}
+
+		xText := strings.TrimSpace(line[series.XIndex])
+		x := math.NaN()
+		if !r.isMissingValue(xText) {
+			var err error
+			x, err = strconv.ParseFloat(xText, 64)
+			if err != nil {
+				return r.parseError(logger.WithError(err), DropReasonXParse, "cannot parse x value")
+			}
+		}
+
+		yText := strings.TrimSpace(line[series.YIndex])
+		y := math.NaN()
+		if !r.isMissingValue(yText) {
+			var err error
+			y, err = strconv.ParseFloat(yText, 64)
+			if err != nil {
+				if r.OnParseError != ParseErrorZero {
+					return r.parseError(logger, DropReasonYParse, "cannot parse float")
+				}
+
+				logger.Debug("cannot parse float, replacing with 0...")
+				y = 0
+			}
+		}
+
+		if math.IsNaN(x) {
+			y = math.NaN()
+		} else {
+			dataRow.X = math.Max(dataRow.X, x)
+		}
+
+		dataRow.Xs[i] = x
+		dataRow.Ys[i] = y
+	}
+
+	if math.IsInf(dataRow.X, -1) {
+		return r.parseError(logger, DropReasonXMissing, "x value is missing")
+	}
+
+	r.lastX = dataRow.X
+	r.hasLastX = true
+
+	return dataRow, nil
+}
+
+// Returns an annotation at the current time if X is generated as such, or at
+// the X of the last row otherwise.
+func (r *TextToDataRowReader) markerRow(logger logrus.FieldLogger, line string) (DataRow, error) {
+	annotation := Annotation{
+		Label: strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), markerPrefix)),
+	}
+
+	if r.XIndex < 0 && r.XGenerator == nil && len(r.Series) == 0 {
+		annotation.X = NowXGenerator(nil)
+	} else if r.hasLastX {
+		annotation.X = r.lastX
+	} else {
+		logger.Debug("marker before the first row has no x value, ignoring...")
+		return DataRow{}, ignoreRow(DropReasonXMissing)
+	}
+
+	return DataRow{X: annotation.X, annotation: &annotation}, nil
+}
+
+// Handles a row that cannot be parsed according to OnParseError. The
+// dropReason is one of the DropReason* constants and the message is logged.
+func (r *TextToDataRowReader) parseError(logger logrus.FieldLogger, dropReason string, message string) (DataRow, error) {
+	switch r.OnParseError {
+	case ParseErrorHalt:
+		logger.Error(message + ", halting...")
+		return DataRow{}, fmt.Errorf("unparsable row: %s", message)
+	case ParseErrorWarnSummary:
+		logger.Debug(message + ", ignoring...")
+		r.droppedRows++
+
+		now := time.Now()
+		if r.lastSummaryTime.IsZero() {
+			r.lastSummaryTime = now
+		}
+
+		if now.Sub(r.lastSummaryTime) >= parseErrorSummaryInterval {
+			logrus.WithField("tag", "TextToData").Warnf("ignored %d unparsable rows in the last %v", r.droppedRows, now.Sub(r.lastSummaryTime).Round(time.Second))
+			r.droppedRows = 0
+			r.lastSummaryTime = now
+		}
+	default:
+		logger.Warn(message + ", ignoring...")
+	}
+
+	return DataRow{}, ignoreRow(dropReason)
+}
+
+func (r *TextToDataRowReader) isMissingValue(value string) bool {
+	for _, missingValue := range r.MissingValues {
+		if strings.EqualFold(value, missingValue) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (r *TextToDataRowReader) ColumnNames() []string {
 	return r.Columns
 }
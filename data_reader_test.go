@@ -0,0 +1,126 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitRelaxed(t *testing.T) {
+	tests := []struct {
+		line   string
+		fields []string
+	}{
+		{"", []string{}},
+		{" \t ", []string{}},
+		{"1 2 3", []string{"1", "2", "3"}},
+		{"  1\t 2  ", []string{"1", "2"}},
+		{"1,2,3", []string{"1", "2", "3"}},
+		{"1 , 2,\t3", []string{"1", "2", "3"}},
+		// Empty fields between commas are missing values.
+		{"1,,3", []string{"1", "", "3"}},
+		{",2,3", []string{"", "2", "3"}},
+		{"1, ,3", []string{"1", "", "3"}},
+		// A single trailing comma is ignored.
+		{"1,2,3,", []string{"1", "2", "3"}},
+		{"1,2,,", []string{"1", "2", ""}},
+		{",", []string{""}},
+	}
+
+	for _, test := range tests {
+		fields := splitRelaxed(test.line)
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("splitRelaxed(%q) = %q, expected %q", test.line, fields, test.fields)
+		}
+	}
+}
+
+func TestTextToDataRowReaderMissingValues(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []struct {
+		line    string
+		ignored bool
+		x       float64
+		ys      []float64
+	}{
+		{line: "1,2,3", x: 1, ys: []float64{2, 3}},
+		{line: "1,,3", x: 1, ys: []float64{nan, 3}},
+		{line: "1,2,,", x: 1, ys: []float64{2, nan}},
+		{line: "1,nan,NaN", x: 1, ys: []float64{nan, nan}},
+		{line: "1,-,3", x: 1, ys: []float64{nan, 3}},
+		{line: "1,N/A,3", x: 1, ys: []float64{nan, 3}},
+		{line: "1,2,3,", x: 1, ys: []float64{2, 3}},
+		// A missing X value cannot be plotted.
+		{line: ",2,3", ignored: true},
+		{line: "nan,2,3", ignored: true},
+		{line: "1,abc,3", ignored: true},
+	}
+
+	for _, test := range tests {
+		reader := &TextToDataRowReader{
+			Input:         NewRelaxedStringReader(strings.NewReader(test.line + "\n")),
+			XIndex:        0,
+			ColorIndex:    -1,
+			Columns:       []string{"a", "b"},
+			MissingValues: []string{"-", "", "n/a"},
+		}
+
+		dataRow, err := reader.Read(context.Background())
+		if test.ignored {
+			if !errors.Is(err, errIgnoreThisRow) {
+				t.Errorf("%q: expected the row to be ignored, got %v, %v", test.line, dataRow, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+
+		if dataRow.X != test.x || !equalFloats(dataRow.Ys, test.ys) {
+			t.Errorf("%q: got X %v, Ys %v, expected X %v, Ys %v", test.line, dataRow.X, dataRow.Ys, test.x, test.ys)
+		}
+	}
+}
+
+func TestDataRowMarshalJSON(t *testing.T) {
+	tests := []struct {
+		dataRow DataRow
+		json    string
+	}{
+		{DataRow{X: 1.5, Ys: []float64{2, -3.25}}, `{"X":1.5,"Ys":[2,-3.25]}`},
+		{DataRow{X: 1, Ys: []float64{math.NaN(), math.Inf(1), 4}}, `{"X":1,"Ys":[null,null,4]}`},
+		{DataRow{X: 1}, `{"X":1,"Ys":null}`},
+	}
+
+	for _, test := range tests {
+		data, err := test.dataRow.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != test.json {
+			t.Errorf("got %s, expected %s", data, test.json)
+		}
+	}
+}
+
+// Same as reflect.DeepEqual, but NaN equals NaN.
+func equalFloats(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] && !(math.IsNaN(a[i]) && math.IsNaN(b[i])) {
+			return false
+		}
+	}
+
+	return true
+}
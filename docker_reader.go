@@ -0,0 +1,279 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The metrics of the DockerDataRowReader, with the labels of their columns.
+var DockerMetrics = map[string][]string{
+	"cpu":   {"cpu %"},
+	"mem":   {"mem bytes"},
+	"block": {"block read bytes/s", "block write bytes/s"},
+	"net":   {"net rx bytes/s", "net tx bytes/s"},
+}
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// The fields of the stats of the Docker API that are plotted.
+type dockerStats struct {
+	Read     time.Time `json:"read"`
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage  uint64   `json:"total_usage"`
+			PercpuUsage []uint64 `json:"percpu_usage"`
+		} `json:"cpu_usage"`
+		SystemUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs  uint32 `json:"online_cpus"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	BlkioStats struct {
+		IoServiceBytesRecursive []struct {
+			Op    string `json:"op"`
+			Value uint64 `json:"value"`
+		} `json:"io_service_bytes_recursive"`
+	} `json:"blkio_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+}
+
+// The counters of a sample, to compute the rates of the next one.
+type dockerCounters struct {
+	time                  time.Time
+	cpu, system           uint64
+	blockRead, blockWrite uint64
+	networkRx, networkTx  uint64
+}
+
+// A DataRowReader that streams the statistics of a container from the Docker
+// API, as shown by docker stats, which are sent every second. The X value is
+// the time of the statistics.
+//
+// The CPU usage is a percentage of one CPU, the memory usage excludes the
+// inactive page cache (as in docker stats), and the block and network I/O are
+// the bytes per second since the previous statistics (the first ones are NaN,
+// which is plotted as a gap). The stream ends when the container stops.
+//
+// The Docker daemon is reached with the DOCKER_HOST environment variable, as
+// with the docker command, or with its default socket.
+type DockerDataRowReader struct {
+	container string
+	metrics   []string
+	columns   []string
+
+	body    io.ReadCloser
+	decoder *json.Decoder
+	last    *dockerCounters
+
+	logger logrus.FieldLogger
+}
+
+// The metrics are the keys of DockerMetrics (e.g. cpu and mem), whose columns
+// are plotted in that order.
+func NewDockerDataRowReader(ctx context.Context, container string, metrics []string) (*DockerDataRowReader, error) {
+	r := &DockerDataRowReader{
+		container: container,
+		metrics:   metrics,
+		logger:    logrus.WithFields(logrus.Fields{"tag": "Docker", "container": container}),
+	}
+
+	for _, metric := range metrics {
+		columns, ok := DockerMetrics[metric]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q, expected cpu, mem, block, or net", metric)
+		}
+
+		r.columns = append(r.columns, columns...)
+	}
+
+	client, baseUrl, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseUrl+"/containers/"+url.PathEscape(container)+"/stats?stream=true", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach the Docker daemon: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		var message struct {
+			Message string `json:"message"`
+		}
+
+		json.NewDecoder(resp.Body).Decode(&message)
+		return nil, fmt.Errorf("cannot read the stats of container %s: %s (%s)", container, message.Message, resp.Status)
+	}
+
+	r.body = resp.Body
+	r.decoder = json.NewDecoder(resp.Body)
+	return r, nil
+}
+
+// Returns a client for the DOCKER_HOST, which is a unix socket or a TCP
+// address, and the base URL of its requests.
+func newDockerClient() (*http.Client, string, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid DOCKER_HOST %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", u.Path)
+			},
+		}
+
+		// The host is ignored, as every request goes to the socket.
+		return &http.Client{Transport: transport}, "http://docker", nil
+	case "tcp", "http":
+		return &http.Client{}, "http://" + u.Host, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported DOCKER_HOST %q, expected unix:// or tcp://", host)
+	}
+}
+
+func (r *DockerDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	var stats dockerStats
+	err := r.decoder.Decode(&stats)
+	if err != nil {
+		if ctx.Err() != nil {
+			return DataRow{}, ctx.Err()
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			r.logger.Info("the container stopped")
+			return DataRow{}, io.EOF
+		}
+
+		return DataRow{}, fmt.Errorf("cannot read the stats of container %s: %w", r.container, err)
+	}
+
+	// The stats of a stopped container are empty.
+	if stats.Read.IsZero() || stats.Read.Unix() <= 0 {
+		return DataRow{}, io.EOF
+	}
+
+	current := &dockerCounters{
+		time:   stats.Read,
+		cpu:    stats.CPUStats.CPUUsage.TotalUsage,
+		system: stats.CPUStats.SystemUsage,
+	}
+
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			current.blockRead += entry.Value
+		case "write":
+			current.blockWrite += entry.Value
+		}
+	}
+
+	for _, network := range stats.Networks {
+		current.networkRx += network.RxBytes
+		current.networkTx += network.TxBytes
+	}
+
+	dataRow := DataRow{
+		X:  float64(stats.Read.UnixNano()) / 1e9,
+		Ys: make([]float64, 0, len(r.columns)),
+	}
+
+	last := r.last
+	elapsed := math.NaN()
+	if last != nil {
+		elapsed = current.time.Sub(last.time).Seconds()
+	}
+
+	for _, metric := range r.metrics {
+		switch metric {
+		case "cpu":
+			dataRow.Ys = append(dataRow.Ys, dockerCPUPercent(last, current, stats))
+		case "mem":
+			dataRow.Ys = append(dataRow.Ys, dockerMemoryUsage(stats))
+		case "block":
+			if last == nil {
+				dataRow.Ys = append(dataRow.Ys, math.NaN(), math.NaN())
+			} else {
+				dataRow.Ys = append(dataRow.Ys, CounterRate(last.blockRead, current.blockRead, elapsed), CounterRate(last.blockWrite, current.blockWrite, elapsed))
+			}
+		case "net":
+			if last == nil {
+				dataRow.Ys = append(dataRow.Ys, math.NaN(), math.NaN())
+			} else {
+				dataRow.Ys = append(dataRow.Ys, CounterRate(last.networkRx, current.networkRx, elapsed), CounterRate(last.networkTx, current.networkTx, elapsed))
+			}
+		}
+	}
+
+	r.last = current
+	return dataRow, nil
+}
+
+func (r *DockerDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+func (r *DockerDataRowReader) Close() {
+	r.body.Close()
+}
+
+// Computed as docker stats does, from the CPU time of the container and of the
+// system since the previous statistics.
+func dockerCPUPercent(last *dockerCounters, current *dockerCounters, stats dockerStats) float64 {
+	if last == nil || current.system <= last.system || current.cpu < last.cpu {
+		return math.NaN()
+	}
+
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	return float64(current.cpu-last.cpu) / float64(current.system-last.system) * cpus * 100
+}
+
+// The inactive page cache can be reclaimed, so it is not counted, as in docker
+// stats. Its key depends on the version of cgroups.
+func dockerMemoryUsage(stats dockerStats) float64 {
+	usage := stats.MemoryStats.Usage
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if inactive, ok := stats.MemoryStats.Stats[key]; ok && inactive < usage {
+			return float64(usage - inactive)
+		}
+	}
+
+	return float64(usage)
+}
@@ -0,0 +1,160 @@
+package wesplot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type RestartPolicy string
+
+const (
+	RestartNever     RestartPolicy = "never"
+	RestartOnFailure RestartPolicy = "on-failure"
+	RestartAlways    RestartPolicy = "always"
+)
+
+// How long to wait before restarting the command, to avoid spinning on a
+// command that exits immediately.
+const execRestartDelay = time.Second
+
+// An io.Reader that spawns a command and reads its stdout. This allows wesplot
+// to own the producer process instead of relying on a shell pipeline. The
+// stderr of the command is forwarded to the stderr of wesplot.
+//
+// When the command exits, it is restarted according to the RestartPolicy. If
+// it is not restarted, Read will return io.EOF if the command succeeded and
+// an error otherwise.
+type ExecReader struct {
+	command string
+	restart RestartPolicy
+
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	closed bool
+
+	logger logrus.FieldLogger
+}
+
+// Creates the ExecReader and starts the command, which is interpreted by the
+// shell (sh -c on Unix, cmd /c on Windows).
+func NewExecReader(command string, restart RestartPolicy) (*ExecReader, error) {
+	r := &ExecReader{
+		command: command,
+		restart: restart,
+		logger:  logrus.WithFields(logrus.Fields{"tag": "ExecReader", "command": command}),
+	}
+
+	err := r.start()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *ExecReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.stdout.Read(p)
+		if err == nil || n > 0 {
+			return n, nil
+		}
+
+		// Read errors on the pipe happen when the process exits, so the actual
+		// error is given by Wait.
+		waitErr := r.cmd.Wait()
+
+		r.mutex.Lock()
+		closed := r.closed
+		r.mutex.Unlock()
+
+		if closed {
+			return 0, io.EOF
+		}
+
+		logger := r.logger.WithField("exitCode", r.cmd.ProcessState.ExitCode())
+		if !r.shouldRestart(waitErr) {
+			logger.WithError(waitErr).Info("command exited")
+			if waitErr != nil {
+				return 0, fmt.Errorf("command %q exited: %w", r.command, waitErr)
+			}
+
+			return 0, io.EOF
+		}
+
+		logger.WithError(waitErr).Warnf("command exited, restarting in %v", execRestartDelay)
+		time.Sleep(execRestartDelay)
+
+		err = r.start()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Kills the command if it is still running.
+func (r *ExecReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	err := r.cmd.Process.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+
+	return err
+}
+
+func (r *ExecReader) start() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return io.EOF
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/c", r.command)
+	} else {
+		cmd = exec.Command("sh", "-c", r.command)
+	}
+
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("cannot start command %q: %w", r.command, err)
+	}
+
+	r.logger.WithField("pid", cmd.Process.Pid).Info("started command")
+
+	r.cmd = cmd
+	r.stdout = stdout
+	return nil
+}
+
+func (r *ExecReader) shouldRestart(waitErr error) bool {
+	switch r.restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return waitErr != nil
+	default:
+		return false
+	}
+}
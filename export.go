@@ -0,0 +1,211 @@
+package wesplot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"time"
+)
+
+// A snapshot of the plot, embedded in the page served by /export.html as
+// window.wesplotExport, which the web UI shows instead of connecting to the
+// server.
+type ExportedPlot struct {
+	Metadata    Metadata
+	Rows        []DataRow
+	Annotations []Annotation
+	ExportedAt  time.Time
+}
+
+var (
+	exportScriptRegexp = regexp.MustCompile(`<script\b[^>]*\bsrc="([^"]+)"[^>]*></script>`)
+	exportLinkRegexp   = regexp.MustCompile(`<link\b[^>]*\brel="(stylesheet|modulepreload)"[^>]*\bhref="([^"]+)"[^>]*>`)
+	exportImportRegexp = regexp.MustCompile(`((?:\bfrom|\bimport)\s*\(?\s*)["'](\.\.?/[^"']+\.js)["']`)
+	exportCSSURLRegexp = regexp.MustCompile(`url\(\s*["']?(\.\.?/[^"')?#]+)[^"')]*["']?\s*\)`)
+)
+
+// Serves the plot as a single HTML page with the web UI, the metadata and the
+// buffered rows, which can be viewed offline, such as to attach the results of
+// an experiment to a ticket.
+func (s *HttpServer) handleExportHTML(w http.ResponseWriter, req *http.Request) {
+	plot := ExportedPlot{
+		Metadata:    s.currentMetadata(),
+		Rows:        []DataRow{},
+		Annotations: []Annotation{},
+		ExportedAt:  time.Now(),
+	}
+
+	for _, dataRow := range s.dataBroadcaster.History() {
+		if dataRow.streamEnded {
+			continue
+		}
+
+		if dataRow.annotation != nil {
+			plot.Annotations = append(plot.Annotations, *dataRow.annotation)
+		} else {
+			plot.Rows = append(plot.Rows, dataRow)
+		}
+	}
+
+	page, err := exportHTML(s.webui, "index.html", plot)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "the web UI is not embedded in this build of wesplot", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.logger.WithError(err).Error("cannot export the plot")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="wesplot.html"`)
+	w.Write(page)
+}
+
+// Builds a single page from the page of the web UI at name, with its scripts,
+// styles and fonts inlined as data URLs, and the plot embedded in its head.
+// The scripts are modules, which run once the page is parsed.
+func exportHTML(webui fs.FS, name string, plot ExportedPlot) ([]byte, error) {
+	page, err := fs.ReadFile(webui, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Go escapes <, > and & in JSON strings, so they cannot end the script.
+	plotJSON, err := json.Marshal(plot)
+	if err != nil {
+		return nil, err
+	}
+
+	inliner := &assetInliner{webui: webui, dataURLs: make(map[string]string)}
+	dir := path.Dir(name)
+
+	page = exportLinkRegexp.ReplaceAllFunc(page, func(link []byte) []byte {
+		match := exportLinkRegexp.FindSubmatch(link)
+		if string(match[1]) == "modulepreload" {
+			// Imported by the scripts anyway.
+			return nil
+		}
+
+		assetPath := path.Join(dir, string(match[2]))
+		css, cssErr := inliner.readCSS(assetPath)
+		if cssErr != nil {
+			err = cssErr
+			return link
+		}
+
+		return append(append([]byte("<style>"), css...), "</style>"...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page = exportScriptRegexp.ReplaceAllFunc(page, func(script []byte) []byte {
+		match := exportScriptRegexp.FindSubmatch(script)
+		dataURL, scriptErr := inliner.scriptDataURL(path.Join(dir, string(match[1])))
+		if scriptErr != nil {
+			err = scriptErr
+			return script
+		}
+
+		return bytes.Replace(script, match[1], []byte(dataURL), 1)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	embedded := fmt.Sprintf("<script>window.wesplotExport = %s;</script>\n", plotJSON)
+	head := bytes.Index(page, []byte("</head>"))
+	if head < 0 {
+		return nil, fmt.Errorf("%s has no head", name)
+	}
+
+	return append(page[:head:head], append([]byte(embedded), page[head:]...)...), nil
+}
+
+// Turns the assets of the web UI into data URLs, replacing the relative URLs
+// they contain, which cannot be loaded offline, with data URLs as well.
+type assetInliner struct {
+	webui    fs.FS
+	dataURLs map[string]string // By path, once inlined
+	inlining []string          // The scripts being inlined, to detect cycles
+}
+
+func (a *assetInliner) scriptDataURL(assetPath string) (string, error) {
+	if dataURL, ok := a.dataURLs[assetPath]; ok {
+		return dataURL, nil
+	}
+
+	for _, p := range a.inlining {
+		if p == assetPath {
+			return "", fmt.Errorf("cannot inline %s, which imports itself", assetPath)
+		}
+	}
+
+	a.inlining = append(a.inlining, assetPath)
+	defer func() {
+		a.inlining = a.inlining[:len(a.inlining)-1]
+	}()
+
+	script, err := fs.ReadFile(a.webui, assetPath)
+	if err != nil {
+		return "", err
+	}
+
+	// The imports of the bundled scripts are relative to the script.
+	script = exportImportRegexp.ReplaceAllFunc(script, func(statement []byte) []byte {
+		match := exportImportRegexp.FindSubmatch(statement)
+		dataURL, importErr := a.scriptDataURL(path.Join(path.Dir(assetPath), string(match[2])))
+		if importErr != nil {
+			err = importErr
+			return statement
+		}
+
+		return []byte(fmt.Sprintf(`%s"%s"`, match[1], dataURL))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	dataURL := "data:text/javascript;base64," + base64.StdEncoding.EncodeToString(script)
+	a.dataURLs[assetPath] = dataURL
+	return dataURL, nil
+}
+
+// Returns the stylesheet with its fonts and images inlined.
+func (a *assetInliner) readCSS(assetPath string) ([]byte, error) {
+	css, err := fs.ReadFile(a.webui, assetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	css = exportCSSURLRegexp.ReplaceAllFunc(css, func(u []byte) []byte {
+		match := exportCSSURLRegexp.FindSubmatch(u)
+		filePath := path.Join(path.Dir(assetPath), string(match[1]))
+
+		data, readErr := fs.ReadFile(a.webui, filePath)
+		if readErr != nil {
+			err = readErr
+			return u
+		}
+
+		contentType := mime.TypeByExtension(path.Ext(filePath))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+
+		return []byte(fmt.Sprintf(`url("data:%s;base64,%s")`, contentType, base64.StdEncoding.EncodeToString(data)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return css, nil
+}
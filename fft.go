@@ -0,0 +1,100 @@
+package wesplot
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Computes the discrete Fourier transform of the complex values in place,
+// with the iterative radix-2 Cooley-Tukey algorithm. The length must be a
+// power of 2.
+func fft(re []float64, im []float64) {
+	n := len(re)
+	if n <= 1 {
+		return
+	}
+
+	// Bit reversal permutation.
+	shift := 64 - bits.TrailingZeros(uint(n))
+	for i := 0; i < n; i++ {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if i < j {
+			re[i], re[j] = re[j], re[i]
+			im[i], im[j] = im[j], im[i]
+		}
+	}
+
+	for size := 2; size <= n; size *= 2 {
+		angle := -2 * math.Pi / float64(size)
+		stepRe, stepIm := math.Cos(angle), math.Sin(angle)
+		for start := 0; start < n; start += size {
+			wRe, wIm := 1.0, 0.0
+			for k := 0; k < size/2; k++ {
+				a := start + k
+				b := a + size/2
+				tRe := wRe*re[b] - wIm*im[b]
+				tIm := wRe*im[b] + wIm*re[b]
+				re[b], im[b] = re[a]-tRe, im[a]-tIm
+				re[a], im[a] = re[a]+tRe, im[a]+tIm
+				wRe, wIm = wRe*stepRe-wIm*stepIm, wRe*stepIm+wIm*stepRe
+			}
+		}
+	}
+}
+
+// Returns the coefficients of a Hann window of the size, which reduces the
+// leakage of the frequencies between the bins of the FFT.
+func hannWindow(size int) []float64 {
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+
+	return window
+}
+
+// Splits the bins of an FFT of the size, from the first bin after the DC offset
+// to the Nyquist frequency, into bands spaced logarithmically, as the bins are
+// spaced linearly. Returns the first bin of every band, followed by the end of
+// the last band. Every band has one bin at least, so there can be fftSize/2-1
+// bands at most.
+func logBandBins(fftSize int, bands int) []int {
+	lastBin := fftSize / 2
+	bin := 1
+	bandBins := []int{bin}
+	for i := 1; i <= bands; i++ {
+		end := int(math.Round(math.Pow(float64(lastBin), float64(i)/float64(bands))))
+		if end <= bin {
+			end = bin + 1
+		}
+
+		// The remaining bands need one bin at least.
+		if end > lastBin-(bands-i) {
+			end = lastBin - (bands - i)
+		}
+
+		bandBins = append(bandBins, end)
+		bin = end
+	}
+
+	return bandBins
+}
+
+// Returns the amplitude of a sine wave with the power of the bins of a band,
+// as computed by the FFT of a signal of the size with the Hann window. A sine
+// wave of amplitude 1 has a magnitude of fftSize/4 with the window, and its
+// power is spread over 1.5 bins (the equivalent noise bandwidth of the
+// window).
+func bandAmplitude(re []float64, im []float64, from int, to int) float64 {
+	power := 0.0
+	for bin := from; bin < to; bin++ {
+		power += re[bin]*re[bin] + im[bin]*im[bin]
+	}
+
+	scale := 4 / float64(len(re))
+	return math.Sqrt(power * scale * scale / 1.5)
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
@@ -0,0 +1,223 @@
+package wesplot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A DataRowReader that plots the spectrum of every series instead of its
+// values, such as to find the periodicity of a metric. It keeps a rolling
+// window of the last values of every series, and every hop rows, emits a row
+// with the amplitude of every band of periods of the spectrum of the windows.
+// The X value is the X of the last row read.
+//
+// The rows are assumed to be sampled every interval, which is used to label
+// the bands (e.g. "cpu 1m4s" for the periods around 1m4s of the cpu series).
+// The bands are spaced logarithmically between the period of the window and
+// twice the interval. Their amplitude is the amplitude of a sine wave with
+// their power, in the unit of the series, after the mean of the window is
+// removed. The missing values are replaced by the mean of the window.
+//
+// The rows are consumed without being emitted until the first window is full.
+type FFTDataRowReader struct {
+	input      DataRowReader
+	windowSize int
+	hop        int
+
+	columns  []string
+	bandBins []int
+
+	// The last values of every series, as ring buffers.
+	windows [][]float64
+	next    int
+	filled  int
+	// The rows read since the last row was emitted.
+	sinceLast int
+
+	hann   []float64
+	re, im []float64
+}
+
+// Creates the reader from a spec such as window=1024 or
+// window=256,bands=8,hop=16,interval=10s. The window is the number of rows,
+// which must be a power of 2, the bands default to 8, the hop to a quarter of
+// the window, and the interval to defaultInterval.
+func NewFFTDataRowReader(input DataRowReader, spec string, defaultInterval time.Duration) (*FFTDataRowReader, error) {
+	windowSize := 0
+	bands := 8
+	hop := 0
+	interval := defaultInterval
+
+	for _, option := range strings.Split(spec, ",") {
+		key, value, found := strings.Cut(option, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid fft option %q, expected key=value (e.g. window=1024)", option)
+		}
+
+		var err error
+		switch strings.TrimSpace(key) {
+		case "window":
+			windowSize, err = strconv.Atoi(strings.TrimSpace(value))
+		case "bands":
+			bands, err = strconv.Atoi(strings.TrimSpace(value))
+		case "hop":
+			hop, err = strconv.Atoi(strings.TrimSpace(value))
+		case "interval":
+			interval, err = time.ParseDuration(strings.TrimSpace(value))
+		default:
+			return nil, fmt.Errorf("unknown fft option %q, expected window, bands, hop, or interval", key)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid fft option %q: %w", option, err)
+		}
+	}
+
+	if !isPowerOfTwo(windowSize) || windowSize < 16 {
+		return nil, fmt.Errorf("the fft window must be a power of 2 of at least 16, got %d", windowSize)
+	}
+
+	// Every band needs at least one bin, and the bin 0 is the mean.
+	if bands <= 0 || bands > windowSize/2-1 {
+		return nil, fmt.Errorf("the number of fft bands must be between 1 and %d, got %d", windowSize/2-1, bands)
+	}
+
+	if hop == 0 {
+		hop = windowSize / 4
+	} else if hop < 0 {
+		return nil, fmt.Errorf("the fft hop must be positive, got %d", hop)
+	}
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("the fft interval must be positive, got %s", interval)
+	}
+
+	r := &FFTDataRowReader{
+		input:      input,
+		windowSize: windowSize,
+		hop:        hop,
+		bandBins:   logBandBins(windowSize, bands),
+		windows:    make([][]float64, len(input.ColumnNames())),
+		hann:       hannWindow(windowSize),
+		re:         make([]float64, windowSize),
+		im:         make([]float64, windowSize),
+	}
+
+	// The period of a bin is the duration of the window divided by the bin.
+	windowDuration := float64(windowSize) * interval.Seconds()
+	for i, column := range input.ColumnNames() {
+		r.windows[i] = make([]float64, windowSize)
+		for band := 0; band < bands; band++ {
+			period := math.Sqrt(windowDuration / float64(r.bandBins[band]) * windowDuration / float64(r.bandBins[band+1]))
+			r.columns = append(r.columns, column+" "+formatPeriod(period))
+		}
+	}
+
+	return r, nil
+}
+
+func (r *FFTDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	for {
+		dataRow, err := r.input.Read(ctx)
+		if err != nil {
+			return dataRow, err
+		}
+
+		if dataRow.annotation != nil || dataRow.gap {
+			return dataRow, nil
+		}
+
+		for i, window := range r.windows {
+			value := math.NaN()
+			if i < len(dataRow.Ys) {
+				value = dataRow.Ys[i]
+			}
+
+			window[r.next] = value
+		}
+
+		r.next = (r.next + 1) % r.windowSize
+		if r.filled < r.windowSize {
+			r.filled++
+		}
+
+		r.sinceLast++
+		if r.filled < r.windowSize || r.sinceLast < r.hop {
+			continue
+		}
+
+		r.sinceLast = 0
+		return r.spectrum(dataRow.X), nil
+	}
+}
+
+func (r *FFTDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+func (r *FFTDataRowReader) spectrum(x float64) DataRow {
+	bands := len(r.bandBins) - 1
+	dataRow := DataRow{
+		X:  x,
+		Ys: make([]float64, 0, len(r.columns)),
+	}
+
+	for _, window := range r.windows {
+		sum := 0.0
+		count := 0
+		for _, value := range window {
+			if !math.IsNaN(value) {
+				sum += value
+				count++
+			}
+		}
+
+		if count == 0 {
+			for band := 0; band < bands; band++ {
+				dataRow.Ys = append(dataRow.Ys, math.NaN())
+			}
+
+			continue
+		}
+
+		// The ring buffer starts at the oldest value.
+		mean := sum / float64(count)
+		for i := range r.re {
+			value := window[(r.next+i)%r.windowSize]
+			if math.IsNaN(value) {
+				value = mean
+			}
+
+			r.re[i] = (value - mean) * r.hann[i]
+			r.im[i] = 0
+		}
+
+		fft(r.re, r.im)
+
+		for band := 0; band < bands; band++ {
+			dataRow.Ys = append(dataRow.Ys, bandAmplitude(r.re, r.im, r.bandBins[band], r.bandBins[band+1]))
+		}
+	}
+
+	return dataRow
+}
+
+// Formats a period in seconds, rounded to the second from 10s (e.g. 17m4s), and
+// to 2 or 3 significant digits below (e.g. 2.5s or 250ms).
+func formatPeriod(seconds float64) string {
+	period := time.Duration(seconds * float64(time.Second))
+	switch {
+	case period >= 10*time.Second:
+		return period.Round(time.Second).String()
+	case period >= time.Second:
+		return period.Round(100 * time.Millisecond).String()
+	case period >= 10*time.Millisecond:
+		return period.Round(time.Millisecond).String()
+	default:
+		return period.Round(time.Microsecond).String()
+	}
+}
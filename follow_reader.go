@@ -0,0 +1,113 @@
+package wesplot
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// An io.Reader that never ends, similar to `tail -F`. When EOF is encountered
+// on the underlying file, it will either wait for more data to be appended
+// (regular files) or reopen the path (named pipes, files that are rotated or
+// truncated). This means intermittently writing producers will not end the
+// stream.
+//
+// This is meant to be placed at the very start of the pipeline, before the
+// StringReader.
+type FollowReader struct {
+	path         string
+	pollInterval time.Duration
+
+	file   *os.File
+	offset int64
+
+	logger logrus.FieldLogger
+}
+
+func NewFollowReader(path string, pollInterval time.Duration) *FollowReader {
+	return &FollowReader{
+		path:         path,
+		pollInterval: pollInterval,
+		logger:       logrus.WithFields(logrus.Fields{"tag": "FollowReader", "path": path}),
+	}
+}
+
+func (r *FollowReader) Read(p []byte) (int, error) {
+	for {
+		if r.file == nil {
+			// Opening a named pipe blocks until a writer opens the other end, which
+			// is exactly what we want here.
+			file, err := os.Open(r.path)
+			if os.IsNotExist(err) {
+				// The file may be temporarily missing if it is being rotated.
+				time.Sleep(r.pollInterval)
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+
+			r.file = file
+			r.offset = 0
+			r.logger.Debug("opened file")
+		}
+
+		n, err := r.file.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if r.shouldReopen() {
+			r.logger.Info("reached EOF, reopening")
+			r.file.Close()
+			r.file = nil
+			continue
+		}
+
+		time.Sleep(r.pollInterval)
+	}
+}
+
+func (r *FollowReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
+
+// Returns true if the currently opened file will not receive any more data and
+// the path should be reopened.
+func (r *FollowReader) shouldReopen() bool {
+	openedInfo, err := r.file.Stat()
+	if err != nil {
+		return true
+	}
+
+	// EOF on a pipe means all writers have closed it. Reading it again would
+	// continuously return EOF, so we must reopen it to wait for the next writer.
+	if openedInfo.Mode()&os.ModeNamedPipe != 0 {
+		return true
+	}
+
+	pathInfo, err := os.Stat(r.path)
+	if err != nil {
+		// The file may have been moved away but not yet recreated. Keep reading
+		// from the old one until it shows up.
+		return false
+	}
+
+	if !os.SameFile(openedInfo, pathInfo) {
+		// Rotated
+		return true
+	}
+
+	// Truncated
+	return pathInfo.Size() < r.offset
+}
@@ -0,0 +1,94 @@
+import "./styles/app.css";
+import "./styles/dashboard.css";
+
+import { MuxMessage, StreamInfo } from "./types";
+import { WesplotChart } from "./wesplot-chart";
+
+// See main.ts.
+let baseHost = location.host + location.pathname.replace(/\/[^/]*$/, "");
+if (import.meta.env.DEV) {
+  baseHost = `${location.hostname}:5274`;
+}
+
+function setStatus(text: string, indicator: string) {
+  document.getElementById("status-text")!.textContent = text;
+  for (const id of ["live-indicator", "not-live-indicator", "error-indicator"]) {
+    document.getElementById(id)!.style.display =
+      id === indicator ? "inline-block" : "none";
+  }
+}
+
+// Shows a plot per stream of /ws-mux (the plot of this server and the plots
+// attached with `wesplot dash --attach`), received on a single websocket.
+async function main() {
+  let streams: StreamInfo[];
+  try {
+    const response = await fetch(`${location.protocol}//${baseHost}/streams`);
+    streams = await response.json();
+  } catch (e) {
+    setStatus(`Backend unreachable: ${e}`, "error-indicator");
+    return;
+  }
+
+  const dashboard = document.getElementById("dashboard")!;
+  const template = document.getElementById(
+    "panel-template"
+  )! as HTMLTemplateElement;
+
+  // Square-ish grid, filled row by row.
+  const columns = Math.ceil(Math.sqrt(streams.length));
+  dashboard.style.gridTemplateColumns = `repeat(${columns}, minmax(0, 1fr))`;
+
+  const charts = new Map<string, WesplotChart>();
+  for (const stream of streams) {
+    const panel = (template.content.cloneNode(true) as DocumentFragment)
+      .firstElementChild as HTMLElement;
+    dashboard.appendChild(panel);
+    charts.set(stream.ID, new WesplotChart(panel, stream.Metadata));
+  }
+
+  let live = streams.length;
+  const scheme = location.protocol === "https:" ? "wss" : "ws";
+  const socket = new WebSocket(`${scheme}://${baseHost}/ws-mux`);
+
+  socket.addEventListener("open", () => {
+    setStatus(`Live: ${live} of ${streams.length} streams`, "live-indicator");
+  });
+
+  socket.addEventListener("close", (event) => {
+    if (live > 0) {
+      setStatus(`Stream aborted (${event.code})`, "error-indicator");
+    } else {
+      setStatus("All streams ended", "not-live-indicator");
+    }
+  });
+
+  socket.addEventListener("message", (event) => {
+    const message: MuxMessage = JSON.parse(event.data);
+    const chart = charts.get(message.Stream);
+    if (chart === undefined) {
+      return;
+    }
+
+    if ("Rows" in message) {
+      chart.update(message.Rows);
+    } else if ("Metadata" in message) {
+      chart.updateMetadata(message.Metadata);
+    } else if ("Annotation" in message) {
+      chart.addAnnotation(message.Annotation);
+    } else if ("Status" in message) {
+      chart.setStale(message.Status.State === "STALE");
+    } else if ("StreamEnded" in message) {
+      live--;
+      if (message.StreamError) {
+        console.warn(`stream ${message.Stream} failed`, message.StreamError);
+      }
+      setStatus(
+        `Live: ${live} of ${streams.length} streams`,
+        live > 0 ? "live-indicator" : "not-live-indicator"
+      );
+    }
+  });
+}
+
+window.addEventListener("load", main);
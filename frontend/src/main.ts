@@ -1,18 +1,58 @@
 import { Player } from "./player";
 import "./styles/app.css";
 
-import { Metadata } from "./types";
+import { ExportedPlot, Metadata } from "./types";
 import { WesplotChart } from "./wesplot-chart";
 
-let baseHost = location.host;
+// Includes the path of the page without the trailing slash, so the requests
+// also work when wesplot is served under a path (see --base-path).
+let baseHost = location.host + location.pathname.replace(/\/[^/]*$/, "");
 if (import.meta.env.DEV) {
   // This does mean in development, we can only run one of these at a time,
   // which I think is fine.
   baseHost = `${location.hostname}:5274`;
 }
 
+// Shows the plot embedded in a page exported by /export.html, which is viewed
+// offline.
+function showExportedPlot(player: Player, exported: ExportedPlot) {
+  const main_panel = document.getElementById("panel")!;
+  const chart = new WesplotChart(main_panel, exported.Metadata);
+  // Zooming out past the window shows the values stored by --sqlite
+  chart.fetchHistory = async (from, to) => {
+    try {
+      const response = await fetch(
+        `${location.protocol}//${baseHost}/history?from=${from}&to=${to}`
+      );
+      if (!response.ok) {
+        console.warn("cannot load the history", await response.text());
+        return undefined;
+      }
+
+      return await response.json();
+    } catch (e) {
+      console.warn("cannot load the history", e);
+      return undefined;
+    }
+  };
+
+  player.registerChart(chart);
+
+  chart.update(exported.Rows);
+  for (const annotation of exported.Annotations) {
+    chart.addAnnotation(annotation);
+  }
+
+  player.showExported(new Date(exported.ExportedAt));
+}
+
 async function main() {
   const player = new Player();
+  if (window.wesplotExport !== undefined) {
+    showExportedPlot(player, window.wesplotExport);
+    return;
+  }
+
   let response: Response;
   let metadata: Metadata;
 
@@ -27,6 +67,25 @@ async function main() {
 
   const chart = new WesplotChart(main_panel, metadata);
 
+  // Store the settings on the server, so they are kept when the page is
+  // reloaded and are applied to the other tabs.
+  chart.onSettingsSaved = async (options) => {
+    try {
+      const response = await fetch(
+        `${location.protocol}//${baseHost}/options`,
+        {
+          method: "PUT",
+          body: JSON.stringify(options),
+        }
+      );
+      if (!response.ok) {
+        console.warn("cannot save settings", await response.text());
+      }
+    } catch (e) {
+      console.warn("cannot save settings", e);
+    }
+  };
+
   player.registerChart(chart);
   player.connectToWebsocket(baseHost);
 }
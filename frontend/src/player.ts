@@ -1,7 +1,16 @@
-import { DataRow, StreamEndedMessage } from "./types";
+import {
+  AlertMessage,
+  AnnotationMessage,
+  DataRow,
+  MetadataMessage,
+  PauseState,
+  SeriesStatsMessage,
+  StatusMessage,
+  StreamEndedMessage,
+} from "./types";
 import { WesplotChart } from "./wesplot-chart";
 
-type PlayerState = "INIT" | "LIVE" | "ENDED" | "ERRORED";
+type PlayerState = "INIT" | "LIVE" | "ENDED" | "ERRORED" | "EXPORTED";
 
 export class Player {
   private _pause_button: HTMLButtonElement;
@@ -13,6 +22,8 @@ export class Player {
   private _error_indicator: HTMLElement;
 
   private _paused: boolean = false;
+  private _input_paused: boolean = false; // Paused on the server, for all tabs
+  private _base_host: string = "";
   private _state: PlayerState = "INIT";
   private _error: string = "";
 
@@ -22,6 +33,10 @@ export class Player {
   private _data_buffer: DataRow[] = [];
 
   private _last_data_received_time?: number;
+  private _stale_since?: number; // Set while the server reports no new rows
+  // The last value of every firing alert, by condition.
+  private _firing_alerts: Map<string, number | null> = new Map();
+  private _exported_at?: Date;
   private _interval_id: number;
 
   constructor() {
@@ -49,10 +64,42 @@ export class Player {
     // Update the status text every second
     // -----------------------------------
     this._interval_id = setInterval(this.updateStatusBar.bind(this), 1000);
+
+    // Pause the input on the server with the p key
+    // --------------------------------------------
+    document.addEventListener("keydown", (event) => {
+      if (
+        event.defaultPrevented ||
+        event.key !== "p" ||
+        event.ctrlKey ||
+        event.metaKey ||
+        event.altKey ||
+        event.target instanceof HTMLInputElement
+      ) {
+        return;
+      }
+
+      this.toggleInputPause();
+      event.preventDefault();
+    });
   }
 
   connectToWebsocket(baseHost: string) {
-    this._socket = new WebSocket(`ws://${baseHost}/ws`);
+    this._base_host = baseHost;
+    this.fetchPauseState("GET", "control");
+
+    // With the metadata parameter, the server also sends the metadata when it
+    // is changed via PUT /options. With the annotations parameter, it sends
+    // the annotations added via POST /annotations. With the status parameter,
+    // it tells when no rows were read for a while (see --stale-after). With
+    // the alerts parameter, it sends the alerts that start or stop firing, and
+    // with the stats parameter, the statistics of every series, which are
+    // shown when hovering over the status bar. Behind a reverse proxy with TLS,
+    // the websocket must also use TLS.
+    const scheme = location.protocol === "https:" ? "wss" : "ws";
+    this._socket = new WebSocket(
+      `${scheme}://${baseHost}/ws?metadata&annotations&status&alerts&stats`
+    );
 
     // Set socket handlers
     this._socket.addEventListener("open", () => {
@@ -91,9 +138,66 @@ export class Player {
 
     // On receiving a message, parse the data and update the chart (or cache it if paused)
     this._socket.addEventListener("message", (event) => {
+      const message:
+        | DataRow[]
+        | MetadataMessage
+        | AnnotationMessage
+        | StatusMessage
+        | AlertMessage
+        | SeriesStatsMessage = JSON.parse(event.data);
+      if ("Metadata" in message) {
+        this._chart?.updateMetadata(message.Metadata);
+        return;
+      }
+
+      if ("Annotation" in message) {
+        this._chart?.addAnnotation(message.Annotation);
+        return;
+      }
+
+      if ("Status" in message) {
+        const stale = message.Status.State === "STALE";
+        this._stale_since = stale
+          ? Date.now() - message.Status.Idle * 1000
+          : undefined;
+        this._chart?.setStale(stale);
+        this.updateStatusBar();
+        return;
+      }
+
+      if ("Series" in message) {
+        const format = (value: number) => Number(value.toPrecision(4));
+        const lines: string[] = [];
+        for (const series of message.Series) {
+          if (series.Count === 0) {
+            continue;
+          }
+          lines.push(
+            `${series.Name}: last ${format(series.Last)}, ` +
+              `min ${format(series.Min)}, max ${format(series.Max)}, ` +
+              `mean ${format(series.Mean)}, stddev ${format(series.Stddev)}`
+          );
+        }
+        this._status_text.title = lines.join("\n");
+        return;
+      }
+
+      if ("Alert" in message) {
+        if (message.Alert.State === "firing") {
+          this._firing_alerts.set(
+            message.Alert.Condition,
+            message.Alert.Value
+          );
+        } else {
+          this._firing_alerts.delete(message.Alert.Condition);
+        }
+        this.updateStatusBar();
+        return;
+      }
+
       this._last_data_received_time = Date.now();
 
-      const rows: DataRow[] = JSON.parse(event.data);
+      const rows: DataRow[] = message;
       // If paused, append new data to the buffer, but do not push this to the chart
       // If not paused, no need to push to the buffer, update the chart directly
       if (this._paused) {
@@ -111,6 +215,15 @@ export class Player {
     });
   }
 
+  // Shows that the plot is a snapshot exported at the given time, instead of
+  // connecting to the server.
+  showExported(exported_at: Date) {
+    clearInterval(this._interval_id);
+    this._exported_at = exported_at;
+    this._state = "EXPORTED";
+    this.updateStatusBar();
+  }
+
   registerChart(chart: WesplotChart) {
     this._chart = chart;
   }
@@ -121,6 +234,39 @@ export class Player {
     this.updateStatusBar();
   }
 
+  // Stops reading the input on the server, which freezes the plot in every tab
+  // while the producer keeps running.
+  private toggleInputPause() {
+    // There is no server to pause.
+    if (this._state === "EXPORTED") {
+      return;
+    }
+
+    this.fetchPauseState(
+      "POST",
+      this._input_paused ? "control/resume" : "control/pause"
+    );
+  }
+
+  private async fetchPauseState(method: string, path: string) {
+    try {
+      const response = await fetch(
+        `${location.protocol}//${this._base_host}/${path}`,
+        { method: method }
+      );
+      if (!response.ok) {
+        // Such as when wesplot is not started with --control.
+        console.warn("cannot pause or resume the input", await response.text());
+        return;
+      }
+      const state: PauseState = await response.json();
+      this._input_paused = state.Paused;
+      this.updateStatusBar();
+    } catch (e) {
+      console.warn("cannot pause or resume the input", e);
+    }
+  }
+
   private handlePause(_event: MouseEvent) {
     this._paused = !this._paused;
 
@@ -156,7 +302,21 @@ export class Player {
         break;
       case "LIVE":
         this.setIndicatorLive();
-        if (this._last_data_received_time === undefined) {
+        if (this._input_paused) {
+          this.setIndicatorNotLive();
+          this.setStatusText("Input paused on the server (press p to resume)");
+        } else if (this._firing_alerts.size > 0) {
+          this.setIndicatorError();
+          const alerts = Array.from(
+            this._firing_alerts,
+            ([condition, value]) => `${condition} (${value ?? "missing"})`
+          );
+          this.setStatusText(`Alert firing: ${alerts.join(", ")}`);
+        } else if (this._stale_since !== undefined) {
+          this.setIndicatorNotLive();
+          const idle = Math.round((Date.now() - this._stale_since) / 1000);
+          this.setStatusText(`Stale: no data for ${idle} second(s)`);
+        } else if (this._last_data_received_time === undefined) {
           this.setStatusText("Live: no data received");
         } else {
           // Convert to seconds and round to nearest int to prevent noise
@@ -172,6 +332,12 @@ export class Player {
         this.setIndicatorNotLive();
         this.setStatusText("Stream ended");
         break;
+      case "EXPORTED":
+        this.setIndicatorNotLive();
+        this.setStatusText(
+          `Exported on ${this._exported_at?.toLocaleString()}`
+        );
+        break;
       case "ERRORED":
         this.setIndicatorError();
         this.setStatusText(this._error);
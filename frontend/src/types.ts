@@ -3,8 +3,20 @@ import { LimitInput } from "./limits";
 export type DataRow = {
   X: number;
   Ys: number[];
+  Xs?: (number | null)[]; // The X of every series, if they have their own X
+  C?: number | null; // The color of the points, with a ColorScale
 };
 
+export interface HorizontalLine {
+  Y: number;
+  Label: string;
+}
+
+export interface StackGroup {
+  Name: string;
+  Columns: string[];
+}
+
 export interface WesplotOptions {
   Title: string;
   Columns: string[];
@@ -16,13 +28,127 @@ export interface WesplotOptions {
   XMax?: number;
   YUnit: string;
   ChartType: string;
+  StackGroups: StackGroup[] | null;
+  Y2Columns: string[] | null;
+  Y2Label: string;
+  Y2Min?: number;
+  Y2Max?: number;
+  HLines: HorizontalLine[] | null;
+}
+
+export interface Panel {
+  Name: string;
+  Title: string;
+  Columns: string[];
+  YLabel: string;
+  YUnit: string;
+  YMin?: number;
+  YMax?: number;
+}
+
+export interface Layout {
+  Rows: number;
+  Columns: number;
+}
+
+// A series aggregated into the columns of a candlestick (open, high, low,
+// close) or a box plot (min, p25, p50, p75, max) for every row
+export interface Candle {
+  Kind: "ohlc" | "box";
+  Series: string;
+  Columns: string[];
+}
+
+// Maps the C of the rows from Min (blue) to Max (red). Without Min or Max,
+// the lowest or highest C received is used.
+export interface ColorScale {
+  Label: string;
+  Min?: number;
+  Max?: number;
 }
 
 export interface Metadata {
   WindowSize: number;
+  WindowDuration: number;
   XIsTimestamp: boolean;
   RelativeStart: boolean;
   WesplotOptions: WesplotOptions;
+  // The labels of the X values, which are their indices, if XIsCategorical
+  XIsCategorical?: boolean;
+  XCategories?: string[];
+  Panels: Panel[] | null;
+  Layout: Layout;
+  Candles?: Candle[];
+  ColorScale?: ColorScale;
+  // The values older than the window can be queried on /history
+  HasHistory?: boolean;
+}
+
+// The stored values of a series, downsampled into buckets (see /history).
+export interface HistorySeries {
+  Name: string;
+  X: number[];
+  Y: number[];
+  Min: number[];
+  Max: number[];
+}
+
+export interface History {
+  From: number;
+  To: number;
+  Series: HistorySeries[];
+}
+
+// The statistics of a series since the start of the stream.
+export interface SeriesStats {
+  Name: string;
+  Count: number;
+  Min: number;
+  Max: number;
+  Mean: number;
+  Stddev: number;
+  Last: number;
+}
+
+export interface SeriesStatsMessage {
+  Series: SeriesStats[];
+}
+
+// Sent by the server when the state of an --alert changes. Value is null if
+// the last value of the column was missing.
+export interface AlertStatus {
+  Condition: string;
+  State: "ok" | "pending" | "firing";
+  Since: string;
+  Value: number | null;
+}
+
+export interface AlertMessage {
+  Alert: AlertStatus;
+}
+
+export interface MetadataMessage {
+  Metadata: Metadata;
+}
+
+export interface Annotation {
+  X: number;
+  Label: string;
+}
+
+export interface AnnotationMessage {
+  Annotation: Annotation;
+}
+
+// Sent by the server when no row was read for --stale-after (STALE), and once
+// rows are read again (ACTIVE). Idle is the seconds since the last row.
+export interface Status {
+  State: "ACTIVE" | "STALE";
+  Idle: number;
+}
+
+export interface StatusMessage {
+  Status: Status;
 }
 
 export interface ChartButtons {
@@ -48,7 +174,40 @@ export interface SettingsPanelInputs {
   relative_start: HTMLInputElement;
 }
 
+export interface PauseState {
+  Paused: boolean;
+  Discard: boolean;
+}
+
 export type StreamEndedMessage = {
   StreamEnded: boolean;
   StreamError: string;
 };
+
+export interface StreamInfo {
+  ID: string;
+  Metadata: Metadata;
+}
+
+// A snapshot of the plot, embedded in the page exported by /export.html.
+export interface ExportedPlot {
+  Metadata: Metadata;
+  Rows: DataRow[];
+  Annotations: Annotation[];
+  ExportedAt: string;
+}
+
+declare global {
+  interface Window {
+    wesplotExport?: ExportedPlot;
+  }
+}
+
+// The messages of /ws-mux, which carry the ID of their stream.
+export type MuxMessage = { Stream: string } & (
+  | { Rows: DataRow[] }
+  | MetadataMessage
+  | AnnotationMessage
+  | StatusMessage
+  | StreamEndedMessage
+);
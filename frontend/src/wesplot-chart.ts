@@ -1,4 +1,4 @@
-import { Chart, ChartConfiguration } from "chart.js/auto";
+import { Chart, ChartConfiguration, ChartDataset } from "chart.js/auto";
 
 import zoomPlugin from "chartjs-plugin-zoom";
 import "chartjs-adapter-date-fns";
@@ -6,8 +6,10 @@ import type { ZoomPluginOptions } from "chartjs-plugin-zoom/types/options";
 import { cloneDeep, merge } from "lodash-es";
 
 import {
+  Annotation,
   ChartButtons,
   DataRow,
+  History,
   Metadata,
   SettingsPanelInputs,
   WesplotOptions,
@@ -18,6 +20,10 @@ import classes from "./styles/dynamic-styles.module.css";
 
 Chart.register(zoomPlugin);
 
+// The annotations are never more than the rows, but an annotation is only
+// removed once a row is pushed after it leaves the window.
+const max_annotations = 1000;
+
 Chart.defaults.font.size = 16;
 Chart.defaults.elements.point.borderWidth = 0;
 Chart.defaults.elements.point.radius = 1;
@@ -74,9 +80,24 @@ export class WesplotChart {
   private _buttons: ChartButtons; // A container for the buttons in the top right
   private _settings: SettingsPanelInputs;
   private _x0: number = NaN; // To zero the X-axis
+  private _annotations: Annotation[] = []; // With X converted like the rows
+  private _candles: { kind: string; datasets: number[] }[] = []; // The indices of the datasets of every candle
+  private _color_min: number = Infinity; // The lowest C received
+  private _color_max: number = -Infinity; // The highest C received
+  // The datasets of the values older than the window, by their column index
+  private _history_datasets: Map<number, ChartDataset<"scatter">> = new Map();
 
   private _wesplot_options: WesplotOptions;
 
+  // Called with the options saved in the settings panel, to store them on the
+  // server. The X limits are not included, as they only affect this view.
+  onSettingsSaved?: (options: Partial<WesplotOptions>) => void;
+
+  // Called with the range of X values (as sent by the server) before the
+  // window when the view is zoomed or panned past it, if the server stores
+  // the values (see --sqlite).
+  fetchHistory?: (from: number, to: number) => Promise<History | undefined>;
+
   // States
   private _zoom_active: boolean;
   private _pan_active: boolean;
@@ -254,26 +275,96 @@ export class WesplotChart {
     // Toggling showLine from the frontend is buggy, see https://github.com/chartjs/Chart.js/issues/11333
     if (this._wesplot_options.ChartType === "scatter") {
       this._config.options.showLine = false;
-    } else if (this._wesplot_options.ChartType === "line") {
+    } else {
+      // line, area and stacked
       this._config.options.showLine = true;
     }
 
+    const stacked = this._wesplot_options.ChartType === "stacked";
+    if (stacked) {
+      this._config.options!.scales!.y!.stacked = true;
+    }
+
     // Set a linear timescape if we are not using timestamped data or if we have a relative start
     if (!this.xIsTime()) {
       this._config.options!.scales!.x!.type = "linear";
     }
 
+    // Categorical X values are the indices of their labels
+    if (this._metadata.XIsCategorical) {
+      merge(this._config.options!.scales!.x, {
+        offset: true,
+        ticks: { stepSize: 1, callback: this.categoryLabel.bind(this) },
+      });
+    }
+
     // We need to maintain a stable reference to zoom plugin options so it can
     // be accessed and mutated in the zoom/pan button handlers.
     this._config.options!.plugins!.zoom = this._zoom_plugin_options;
+    if (metadata.HasHistory && !metadata.XIsCategorical) {
+      this._zoom_plugin_options.zoom!.onZoomComplete = () => this.loadHistory();
+      this._zoom_plugin_options.pan!.onPanComplete = () => this.loadHistory();
+    }
 
     // Initialize a dataset for each data column as specified by the metadata
+    const stackGroups = this._wesplot_options.StackGroups ?? [];
     for (const column of this._wesplot_options.Columns) {
-      this._config.data.datasets.push({
+      const dataset: ChartDataset<"scatter"> = {
         label: column,
         data: [],
         borderWidth: 1,
-      });
+      };
+
+      // The points are colored by the C of their row
+      if (metadata.ColorScale) {
+        dataset.pointRadius = 3;
+        dataset.pointBackgroundColor = (context) =>
+          this.pointColor((context.raw as number[] | undefined)?.[2]);
+      }
+
+      if (this._wesplot_options.ChartType === "bar") {
+        (dataset as ChartDataset).type = "bar";
+      } else if (this._wesplot_options.ChartType === "area") {
+        dataset.fill = "origin";
+      } else if (stacked) {
+        // Without groups, all the series are stacked together. Otherwise, a
+        // series in no group is alone in its stack and not filled.
+        const group = stackGroups.find((g) => g.Columns.includes(column));
+        if (stackGroups.length === 0 || group !== undefined) {
+          dataset.stack = group?.Name ?? "all";
+          dataset.fill = "stack";
+        } else {
+          dataset.stack = `series:${column}`;
+        }
+      }
+
+      this._config.data.datasets.push(dataset);
+    }
+
+    // The columns of the candles are drawn as candles instead of points
+    for (const candle of metadata.Candles ?? []) {
+      const datasets = candle.Columns.map((column) =>
+        this._wesplot_options.Columns.indexOf(column)
+      );
+      if (datasets.includes(-1)) {
+        continue;
+      }
+
+      for (const index of datasets) {
+        const dataset = this._config.data.datasets[index];
+        dataset.showLine = false;
+        dataset.pointRadius = 0;
+      }
+
+      this._candles.push({ kind: candle.Kind, datasets: datasets });
+    }
+
+    // Explain the colors of the points
+    if (metadata.ColorScale?.Label) {
+      this._config.options!.plugins!.subtitle = {
+        display: true,
+        text: `Color: ${metadata.ColorScale.Label} (from blue for low to red for high)`,
+      };
     }
 
     // Do not display legend for 1 data set
@@ -281,6 +372,18 @@ export class WesplotChart {
       this._config.options!.plugins!.legend!.display = false;
     }
 
+    // Draw the annotations as labeled vertical lines
+    this._config.plugins = [
+      {
+        id: "wesplotAnnotations",
+        afterDatasetsDraw: this.drawAnnotations.bind(this),
+      },
+      {
+        id: "wesplotCandles",
+        beforeDatasetsDraw: this.drawCandles.bind(this),
+      },
+    ];
+
     this.updatePlotSettings();
     // Create the chart
     this._chart = new Chart(this._canvas, this._config);
@@ -338,41 +441,330 @@ export class WesplotChart {
     }
   }
 
+  // Applies the options changed on the server (via PUT /options). This
+  // overrides the changes made in the settings panel.
+  updateMetadata(metadata: Metadata) {
+    const options = metadata.WesplotOptions;
+    this._metadata.WesplotOptions = options;
+
+    this._wesplot_options.Title = options.Title;
+    this._wesplot_options.Columns = options.Columns;
+    this._wesplot_options.XLabel = options.XLabel;
+    this._wesplot_options.YLabel = options.YLabel;
+    this._wesplot_options.YUnit = options.YUnit;
+    this._wesplot_options.YMin = options.YMin;
+    this._wesplot_options.YMax = options.YMax;
+    this._wesplot_options.Y2Label = options.Y2Label;
+    this._wesplot_options.Y2Min = options.Y2Min;
+    this._wesplot_options.Y2Max = options.Y2Max;
+
+    // The labels are added as the server reads them
+    this._metadata.XCategories = metadata.XCategories;
+
+    this.updatePlotSettings();
+  }
+
   setTitle(title: string) {
     this._title.textContent = title;
     document.title = title;
   }
 
   update(rows: DataRow[]) {
+    if (this._metadata.ColorScale) {
+      for (const row of rows) {
+        const color = row.C ?? NaN;
+        if (Number.isFinite(color)) {
+          this._color_min = Math.min(this._color_min, color);
+          this._color_max = Math.max(this._color_max, color);
+        }
+      }
+    }
+
     for (const [i, _] of this._wesplot_options.Columns.entries()) {
       const data = this._chart.data.datasets[i].data;
       for (const row of rows) {
-        let x = row.X;
+        // A series with its own X is missing from the row if its X is
+        const row_x = row.Xs ? row.Xs[i] : row.X;
+        if (row_x === null || row_x === undefined) {
+          continue;
+        }
 
-        if (this._metadata.RelativeStart) {
-          // Inefficient code, yay.
-          // We want to display seconds if relative start is true, so we don't multiply
-          if (Number.isNaN(this._x0)) {
-            this._x0 = x;
+        const x = this.toChartX(row_x);
+
+        // Only the latest value of every label is plotted
+        if (this._metadata.XIsCategorical) {
+          const index = data.findIndex(
+            (point) => (point as [number, number])[0] === x
+          );
+          if (index >= 0) {
+            data[index] = [x, row.Ys[i]];
+            continue;
           }
+        }
 
-          x -= this._x0;
-        } else if (this._metadata.XIsTimestamp) {
-          // Server side seconds time in seconds.
-          x *= 1000;
+        if (this._metadata.ColorScale) {
+          // The color is ignored by Chart.js, but given to pointColor
+          const color = row.C ?? NaN;
+          data.push([x, row.Ys[i], color] as unknown as [number, number]);
+        } else {
+          data.push([x, row.Ys[i]]);
         }
 
-        data.push([x, row.Ys[i]]);
-        if (data.length > this._metadata.WindowSize) {
+        if (this._metadata.WindowDuration > 0) {
+          // x is in milliseconds if it is a timestamp, and seconds otherwise.
+          const windowDuration = this.xIsTime()
+            ? this._metadata.WindowDuration * 1000
+            : this._metadata.WindowDuration;
+
+          while ((data[0] as [number, number])[0] < x - windowDuration) {
+            data.shift();
+          }
+        } else if (data.length > this._metadata.WindowSize) {
           data.shift();
         }
       }
     }
 
+    // Remove the annotations that are no longer in the window of the rows
+    const first_row = this._chart.data.datasets[0]?.data[0] as
+      | [number, number]
+      | undefined;
+    if (first_row !== undefined) {
+      this._annotations = this._annotations.filter(
+        (annotation) => annotation.X >= first_row[0]
+      );
+    }
+
     // "none" means do not animate, this looks weird with an updating chart
     this._chart.update("none");
   }
 
+  addAnnotation(annotation: Annotation) {
+    this._annotations.push({
+      X: this.toChartX(annotation.X),
+      Label: annotation.Label,
+    });
+    if (this._annotations.length > max_annotations) {
+      this._annotations.shift();
+    }
+
+    this._chart.update("none");
+  }
+
+  // Greys out the plot while the server reports that no rows were read for a
+  // while (see --stale-after), so it does not look live when it is not.
+  setStale(stale: boolean) {
+    this._canvas.style.opacity = stale ? "0.4" : "";
+  }
+
+  // Shows the stored values between the left of the view and the window, as
+  // dashed lines, replacing those shown before.
+  private async loadHistory() {
+    const first_row = this._chart.data.datasets[0]?.data[0] as
+      | [number, number]
+      | undefined;
+    const view_min = this._chart.scales.x.min;
+    if (
+      this.fetchHistory === undefined ||
+      first_row === undefined ||
+      view_min >= first_row[0]
+    ) {
+      return;
+    }
+
+    const history = await this.fetchHistory(
+      this.fromChartX(view_min),
+      this.fromChartX(first_row[0])
+    );
+    if (history === undefined) {
+      return;
+    }
+
+    for (const series of history.Series) {
+      const index = this._wesplot_options.Columns.indexOf(series.Name);
+      if (index < 0) {
+        continue;
+      }
+
+      let dataset = this._history_datasets.get(index);
+      if (dataset === undefined) {
+        dataset = {
+          label: `${series.Name} (history)`,
+          data: [],
+          borderWidth: 1,
+          borderDash: [4, 4],
+        };
+        this._history_datasets.set(index, dataset);
+        this._chart.data.datasets.push(dataset);
+      }
+
+      dataset.data = series.X.map((x, i) => [this.toChartX(x), series.Y[i]]);
+    }
+
+    this._chart.update("none");
+  }
+
+  // Converts an X value from the server into the X value on the chart.
+  private toChartX(x: number): number {
+    if (this._metadata.RelativeStart) {
+      // Inefficient code, yay.
+      // We want to display seconds if relative start is true, so we don't multiply
+      if (Number.isNaN(this._x0)) {
+        this._x0 = x;
+      }
+
+      return x - this._x0;
+    } else if (this._metadata.XIsTimestamp) {
+      // Server side seconds time in seconds.
+      return x * 1000;
+    }
+
+    return x;
+  }
+
+  // Converts an X value on the chart into the X value from the server.
+  private fromChartX(x: number): number {
+    if (this._metadata.RelativeStart) {
+      return x + this._x0;
+    } else if (this._metadata.XIsTimestamp) {
+      return x / 1000;
+    }
+
+    return x;
+  }
+
+  private drawAnnotations(chart: Chart) {
+    const { ctx, chartArea, scales } = chart;
+
+    ctx.save();
+    ctx.strokeStyle = "rgba(0, 0, 0, 0.5)";
+    ctx.fillStyle = "rgba(0, 0, 0, 0.7)";
+    ctx.font = "12px sans-serif";
+    ctx.setLineDash([4, 4]);
+
+    for (const annotation of this._annotations) {
+      const x = scales.x.getPixelForValue(annotation.X);
+      if (x < chartArea.left || x > chartArea.right) {
+        continue;
+      }
+
+      ctx.beginPath();
+      ctx.moveTo(x, chartArea.top);
+      ctx.lineTo(x, chartArea.bottom);
+      ctx.stroke();
+      ctx.fillText(annotation.Label, x + 4, chartArea.top + 12);
+    }
+
+    ctx.restore();
+  }
+
+  private categoryLabel(value: number | string) {
+    const categories = this._metadata.XCategories ?? [];
+    if (typeof value !== "number" || !Number.isInteger(value)) {
+      return "";
+    }
+
+    // A label can be received after the first rows with it
+    return categories[value] ?? value;
+  }
+
+  // Draws a candlestick (the wick from low to high and the body from open to
+  // close) or a box plot (the whiskers from min to max and the box from p25 to
+  // p75 with the median) for every row of the candles
+  private drawCandles(chart: Chart) {
+    const { ctx, chartArea, scales } = chart;
+
+    ctx.save();
+    ctx.beginPath();
+    ctx.rect(chartArea.left, chartArea.top, chartArea.width, chartArea.height);
+    ctx.clip();
+    ctx.lineWidth = 1;
+
+    for (const candle of this._candles) {
+      const data = candle.datasets.map(
+        (index) => chart.data.datasets[index].data as [number, number][]
+      );
+      const yScale = scales[chart.getDatasetMeta(candle.datasets[0]).yAxisID!];
+      const rows = data[0];
+
+      // As wide as half of the distance between two rows
+      let width = 6;
+      if (rows.length > 1) {
+        const distance =
+          scales.x.getPixelForValue(rows[1][0]) -
+          scales.x.getPixelForValue(rows[0][0]);
+        width = Math.max(1, Math.abs(distance) / 2);
+      }
+
+      for (let row = 0; row < rows.length; row++) {
+        const values = data.map((points) => points[row]?.[1]);
+        if (!values.every((value) => Number.isFinite(value))) {
+          continue;
+        }
+
+        const x = scales.x.getPixelForValue(rows[row][0]);
+        const ys = values.map((value) => yScale.getPixelForValue(value));
+
+        if (candle.kind === "ohlc") {
+          const [open, high, low, close] = ys;
+          const color =
+            values[3] >= values[0]
+              ? "rgba(38, 166, 91, 0.9)"
+              : "rgba(214, 69, 65, 0.9)";
+
+          ctx.strokeStyle = color;
+          ctx.fillStyle = color;
+          ctx.beginPath();
+          ctx.moveTo(x, high);
+          ctx.lineTo(x, low);
+          ctx.stroke();
+          ctx.fillRect(
+            x - width / 2,
+            Math.min(open, close),
+            width,
+            Math.max(1, Math.abs(close - open))
+          );
+        } else {
+          const [min, p25, p50, p75, max] = ys;
+
+          ctx.strokeStyle = "rgba(54, 162, 235, 1)";
+          ctx.fillStyle = "rgba(54, 162, 235, 0.3)";
+          ctx.beginPath();
+          ctx.moveTo(x, max);
+          ctx.lineTo(x, p75);
+          ctx.moveTo(x, p25);
+          ctx.lineTo(x, min);
+          ctx.moveTo(x - width / 4, max);
+          ctx.lineTo(x + width / 4, max);
+          ctx.moveTo(x - width / 4, min);
+          ctx.lineTo(x + width / 4, min);
+          ctx.moveTo(x - width / 2, p50);
+          ctx.lineTo(x + width / 2, p50);
+          ctx.stroke();
+          ctx.fillRect(x - width / 2, p75, width, p25 - p75);
+          ctx.strokeRect(x - width / 2, p75, width, p25 - p75);
+        }
+      }
+    }
+
+    ctx.restore();
+  }
+
+  // Maps a C from the ColorScale to a color from blue to red, or grey if it is
+  // missing
+  private pointColor(value: number | undefined): string {
+    if (value === undefined || !Number.isFinite(value)) {
+      return "rgba(128, 128, 128, 0.5)";
+    }
+
+    const min = this._metadata.ColorScale?.Min ?? this._color_min;
+    const max = this._metadata.ColorScale?.Max ?? this._color_max;
+    let fraction = max > min ? (value - min) / (max - min) : 0.5;
+    fraction = Math.min(Math.max(fraction, 0), 1);
+
+    return `hsl(${240 * (1 - fraction)}, 80%, 50%)`;
+  }
+
   private addUnits(value: number | string, _index: unknown, _ticks: unknown) {
     let displayValue: string;
     let displayUnit: string = "";
@@ -547,6 +939,17 @@ export class WesplotChart {
 
     this.updatePlotSettings();
     this.closeSettings();
+
+    // NaN limits are sent as null, which resets them to auto.
+    this.onSettingsSaved?.({
+      Title: this._wesplot_options.Title,
+      Columns: this._wesplot_options.Columns,
+      XLabel: this._wesplot_options.XLabel,
+      YLabel: this._wesplot_options.YLabel,
+      YUnit: this._wesplot_options.YUnit,
+      YMin: this._wesplot_options.YMin,
+      YMax: this._wesplot_options.YMax,
+    });
   }
 
   showSettingsError(error_text: string) {
@@ -1,6 +1,20 @@
 import { visualizer } from "rollup-plugin-visualizer";
+import { fileURLToPath } from "node:url";
 
 export default {
+  // Relative URLs for the assets, so the page also works when served under a
+  // path (see --base-path).
+  base: "./",
+  build: {
+    rollupOptions: {
+      // The page of a plot, and the dashboard showing all the streams (see
+      // /dashboard).
+      input: {
+        main: fileURLToPath(new URL("./index.html", import.meta.url)),
+        dashboard: fileURLToPath(new URL("./dashboard.html", import.meta.url)),
+      },
+    },
+  },
   plugins: [
     // This will output size visualization for the JS bundle at stats.html in
     // this folder.
@@ -0,0 +1,69 @@
+package wesplot
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// A DataRowReader that breaks the lines of the plot where the input stopped,
+// such as while a sensor was disconnected, instead of drawing a straight line
+// across. When the X of a row is more than the threshold after the X of the
+// previous row, a row with missing Ys (which the clients plot as gaps) is
+// emitted between them first. The threshold applies to the X values, which
+// are normally timestamps in seconds.
+type GapDataRowReader struct {
+	input     DataRowReader
+	threshold float64
+
+	hasLastX bool
+	lastX    float64
+	pending  *DataRow // The row after the gap, once the gap is returned
+}
+
+func NewGapDataRowReader(input DataRowReader, threshold time.Duration) *GapDataRowReader {
+	return &GapDataRowReader{
+		input:     input,
+		threshold: threshold.Seconds(),
+	}
+}
+
+func (r *GapDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if r.pending != nil {
+		dataRow := *r.pending
+		r.pending = nil
+		return dataRow, nil
+	}
+
+	dataRow, err := r.input.Read(ctx)
+	if err != nil || dataRow.annotation != nil {
+		return dataRow, err
+	}
+
+	previousX := r.lastX
+	hadLastX := r.hasLastX
+	r.lastX = dataRow.X
+	r.hasLastX = true
+
+	if !hadLastX || dataRow.X-previousX <= r.threshold {
+		return dataRow, nil
+	}
+
+	r.pending = &dataRow
+
+	gap := DataRow{
+		X:   previousX + (dataRow.X-previousX)/2,
+		Ys:  make([]float64, len(dataRow.Ys)),
+		gap: true,
+	}
+
+	for i := range gap.Ys {
+		gap.Ys[i] = math.NaN()
+	}
+
+	return gap, nil
+}
+
+func (r *GapDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
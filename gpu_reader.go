@@ -0,0 +1,286 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The metrics of the GpuDataRowReader, with the labels of their columns,
+// which are prefixed with the device (e.g. gpu0 util %).
+var GpuMetrics = map[string]string{
+	"util":  "util %",
+	"mem":   "mem MiB",
+	"temp":  "temp °C",
+	"power": "power W",
+}
+
+// The fields of nvidia-smi --query-gpu for the metrics.
+var nvidiaSmiFields = map[string]string{
+	"util":  "utilization.gpu",
+	"mem":   "memory.used",
+	"temp":  "temperature.gpu",
+	"power": "power.draw",
+}
+
+// A DataRowReader that runs nvidia-smi or rocm-smi every interval and emits a
+// row with the metrics of every GPU, such as its utilization, in the order of
+// the devices and then of the metrics (e.g. gpu0 util %, gpu0 temp °C, gpu1
+// util %, gpu1 temp °C). The X value is the time of the sample.
+//
+// The memory is the memory used in MiB, the temperature is in degrees Celsius,
+// and the power draw is in watts. The metrics a device does not report are
+// NaN, which is plotted as a gap.
+type GpuDataRowReader struct {
+	tool     string
+	metrics  []string
+	columns  []string
+	interval time.Duration
+
+	ticker *time.Ticker
+}
+
+// The tool is nvidia-smi, rocm-smi, or auto to use the one that is installed.
+// It is run once to find the devices, which must not change.
+func NewGpuDataRowReader(tool string, metrics []string, interval time.Duration) (*GpuDataRowReader, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the interval must be positive, got %v", interval)
+	}
+
+	for _, metric := range metrics {
+		if _, ok := GpuMetrics[metric]; !ok {
+			return nil, fmt.Errorf("unknown metric %q, expected util, mem, temp, or power", metric)
+		}
+	}
+
+	if tool == "auto" {
+		for _, candidate := range []string{"nvidia-smi", "rocm-smi"} {
+			if _, err := exec.LookPath(candidate); err == nil {
+				tool = candidate
+				break
+			}
+		}
+
+		if tool == "auto" {
+			return nil, errors.New("neither nvidia-smi nor rocm-smi is installed")
+		}
+	} else if tool != "nvidia-smi" && tool != "rocm-smi" {
+		return nil, fmt.Errorf("unsupported tool %q, expected nvidia-smi or rocm-smi", tool)
+	}
+
+	r := &GpuDataRowReader{
+		tool:     tool,
+		metrics:  metrics,
+		interval: interval,
+	}
+
+	devices, err := r.sample(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("%s found no GPU", tool)
+	}
+
+	for i := range devices {
+		for _, metric := range metrics {
+			r.columns = append(r.columns, fmt.Sprintf("gpu%d %s", i, GpuMetrics[metric]))
+		}
+	}
+
+	return r, nil
+}
+
+func (r *GpuDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if r.ticker == nil {
+		r.ticker = time.NewTicker(r.interval)
+	}
+
+	select {
+	case now := <-r.ticker.C:
+		devices, err := r.sample(ctx)
+		if err != nil {
+			r.ticker.Stop()
+			return DataRow{}, err
+		}
+
+		dataRow := DataRow{
+			X:  float64(now.UnixNano()) / 1e9,
+			Ys: make([]float64, 0, len(r.columns)),
+		}
+
+		for _, values := range devices {
+			dataRow.Ys = append(dataRow.Ys, values...)
+		}
+
+		if len(dataRow.Ys) != len(r.columns) {
+			return DataRow{}, fmt.Errorf("expected %d GPUs, but %s reported %d", len(r.columns)/len(r.metrics), r.tool, len(devices))
+		}
+
+		return dataRow, nil
+	case <-ctx.Done():
+		r.ticker.Stop()
+		return DataRow{}, ctx.Err()
+	}
+}
+
+func (r *GpuDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+// Returns the values of the metrics of every device.
+func (r *GpuDataRowReader) sample(ctx context.Context) ([][]float64, error) {
+	if r.tool == "nvidia-smi" {
+		return r.sampleNvidiaSmi(ctx)
+	}
+
+	return r.sampleRocmSmi(ctx)
+}
+
+// Parses the CSV of nvidia-smi, with a line per device such as
+// `0, 35, 1024, 60, 120.50`.
+func (r *GpuDataRowReader) sampleNvidiaSmi(ctx context.Context) ([][]float64, error) {
+	fields := []string{"index"}
+	for _, metric := range r.metrics {
+		fields = append(fields, nvidiaSmiFields[metric])
+	}
+
+	output, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu="+strings.Join(fields, ","), "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot run nvidia-smi: %w", err)
+	}
+
+	var devices [][]float64
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+
+		values := strings.Split(line, ",")
+		if len(values) != len(fields) {
+			return nil, fmt.Errorf("expected %d values from nvidia-smi, got %q", len(fields), line)
+		}
+
+		device := make([]float64, len(r.metrics))
+		for i, value := range values[1:] {
+			// Such as [N/A] or [Not Supported].
+			device[i], err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				device[i] = math.NaN()
+			}
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// Parses the JSON of rocm-smi, with an object per device (e.g. card0) whose
+// keys describe the values, such as "GPU use (%)". The keys change between
+// versions, so they are matched loosely.
+func (r *GpuDataRowReader) sampleRocmSmi(ctx context.Context) ([][]float64, error) {
+	args := []string{"--json"}
+	for _, metric := range r.metrics {
+		switch metric {
+		case "util":
+			args = append(args, "--showuse")
+		case "mem":
+			args = append(args, "--showmeminfo", "vram")
+		case "temp":
+			args = append(args, "--showtemp")
+		case "power":
+			args = append(args, "--showpower")
+		}
+	}
+
+	output, err := exec.CommandContext(ctx, "rocm-smi", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot run rocm-smi: %w", err)
+	}
+
+	var cards map[string]map[string]string
+	err = json.Unmarshal(output, &cards)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the output of rocm-smi: %w", err)
+	}
+
+	// Sorted by the number of the card, as card10 comes before card2.
+	var names []string
+	for name := range cards {
+		if strings.HasPrefix(name, "card") {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimPrefix(names[i], "card"))
+		b, _ := strconv.Atoi(strings.TrimPrefix(names[j], "card"))
+		return a < b
+	})
+
+	devices := make([][]float64, 0, len(names))
+	for _, name := range names {
+		device := make([]float64, len(r.metrics))
+		for i, metric := range r.metrics {
+			device[i] = rocmSmiValue(cards[name], metric)
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+func rocmSmiValue(card map[string]string, metric string) float64 {
+	var keys []string
+	for key := range card {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	found := ""
+	for _, key := range keys {
+		var match bool
+		switch metric {
+		case "util":
+			match = strings.HasPrefix(key, "GPU use")
+		case "mem":
+			match = strings.Contains(key, "VRAM Total Used Memory")
+		case "temp":
+			// The edge temperature is preferred, as it is the one shown by
+			// rocm-smi without arguments.
+			match = strings.HasPrefix(key, "Temperature") && (found == "" || strings.Contains(key, "edge"))
+		case "power":
+			match = strings.Contains(key, "Power") && strings.HasSuffix(key, "(W)")
+		}
+
+		if match {
+			found = key
+		}
+	}
+
+	if found == "" {
+		return math.NaN()
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(card[found]), 64)
+	if err != nil {
+		return math.NaN()
+	}
+
+	// In bytes.
+	if metric == "mem" {
+		value /= 1 << 20
+	}
+
+	return value
+}
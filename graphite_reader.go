@@ -0,0 +1,45 @@
+package wesplot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Reads metrics in the Graphite plaintext protocol, with one metric per line
+// such as `servers.web1.load 0.42 1700000000`, from a StringReader returning
+// whole lines (see LineStringReader and NewSocketLineReader). The value is
+// labeled with the path of the metric (e.g. servers.web1.load). The X value is
+// the timestamp of the metric (in seconds), or the receive timestamp if it is
+// omitted or -1, which tells Graphite to use the receive time.
+func NewGraphiteDataRowReader(input StringReader, columns []string) *LabeledDataRowReader {
+	return newLabeledLineDataRowReader(input, columns, parseGraphiteLine, "Graphite")
+}
+
+func parseGraphiteLine(line string) (labeledPoint, error) {
+	point := labeledPoint{values: make(map[string]float64, 1)}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return point, fmt.Errorf("expected a metric path, a value and an optional timestamp, got %d fields", len(fields))
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return point, fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	point.values[fields[0]] = value
+
+	if len(fields) == 3 && fields[2] != "-1" {
+		point.timestamp, err = strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(point.timestamp) || math.IsInf(point.timestamp, 0) {
+			return point, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+
+		point.hasTimestamp = true
+	}
+
+	return point, nil
+}
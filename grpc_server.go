@@ -0,0 +1,530 @@
+//go:build grpc
+
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Whether wesplot was built with -tags grpc, and can serve the gRPC API.
+const GRPCSupported = true
+
+// The rows sent by the server are split into messages of about this size, as
+// the clients reject the messages over 4MB by default.
+const grpcMessageSize = 1 << 20
+
+// Serves the gRPC API of proto/wesplot.proto: the metadata and the rows of the
+// streams of an HttpServer, with its access tokens and client limits. The
+// messages are encoded by hand, so the server does not need generated code.
+//
+// A client that reads slowly blocks the sending of its rows by the flow
+// control of HTTP/2, which fills its buffer of rows, as on /ws. The dead
+// clients are detected with the keepalive pings of gRPC instead of the
+// heartbeats of /ws.
+type GRPCServer struct {
+	httpServer *HttpServer
+	server     *grpc.Server
+	logger     logrus.FieldLogger
+}
+
+func NewGRPCServer(httpServer *HttpServer) *GRPCServer {
+	g := &GRPCServer{
+		httpServer: httpServer,
+		logger:     logrus.WithField("tag", "GRPCServer"),
+	}
+
+	g.server = grpc.NewServer(
+		grpc.ForceServerCodec(protoCodec{}),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    heartbeatInterval,
+			Timeout: heartbeatTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             heartbeatInterval,
+			PermitWithoutStream: true,
+		}),
+	)
+	g.server.RegisterService(&wesplotServiceDesc, g)
+	return g
+}
+
+// Serves on the listener until the context is canceled, which closes the
+// connections.
+func (g *GRPCServer) Serve(ctx context.Context, listener net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			g.server.Stop()
+		case <-done:
+		}
+	}()
+
+	g.logger.WithField("address", listener.Addr().String()).Info("serving the gRPC API")
+	return g.server.Serve(listener)
+}
+
+// The methods of the wesplot.v1.Wesplot service.
+type wesplotService interface {
+	getMetadata(ctx context.Context, request *grpcMetadataRequest) (*grpcMetadata, error)
+	streamData(request *grpcStreamDataRequest, serverStream grpc.ServerStream) error
+}
+
+// What protoc-gen-go-grpc would generate from proto/wesplot.proto.
+var wesplotServiceDesc = grpc.ServiceDesc{
+	ServiceName: "wesplot.v1.Wesplot",
+	HandlerType: (*wesplotService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetMetadata",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				request := &grpcMetadataRequest{}
+				if err := dec(request); err != nil {
+					return nil, err
+				}
+
+				handler := func(ctx context.Context, request any) (any, error) {
+					return srv.(wesplotService).getMetadata(ctx, request.(*grpcMetadataRequest))
+				}
+
+				if interceptor == nil {
+					return handler(ctx, request)
+				}
+
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/wesplot.v1.Wesplot/GetMetadata"}
+				return interceptor(ctx, request, info, handler)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamData",
+			ServerStreams: true,
+			Handler: func(srv any, serverStream grpc.ServerStream) error {
+				request := &grpcStreamDataRequest{}
+				if err := serverStream.RecvMsg(request); err != nil {
+					return err
+				}
+
+				return srv.(wesplotService).streamData(request, serverStream)
+			},
+		},
+	},
+	Metadata: "proto/wesplot.proto",
+}
+
+func (g *GRPCServer) getMetadata(ctx context.Context, request *grpcMetadataRequest) (*grpcMetadata, error) {
+	err := g.checkAccess(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := g.findStream(request.stream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcMetadata{metadata: selected.metadata}, nil
+}
+
+func (g *GRPCServer) streamData(request *grpcStreamDataRequest, serverStream grpc.ServerStream) error {
+	s := g.httpServer
+	ctx := serverStream.Context()
+
+	err := g.checkAccess(ctx)
+	if err != nil {
+		return err
+	}
+
+	selected, err := g.findStream(request.stream)
+	if err != nil {
+		return err
+	}
+
+	ip, remoteAddr := peerAddr(ctx)
+	ok, reason := s.acquireClient(ip)
+	if !ok {
+		g.logger.WithField("remoteAddr", remoteAddr).Warnf("rejected client: %s", reason)
+		return status.Error(codes.ResourceExhausted, reason)
+	}
+	defer s.releaseClient(ip)
+
+	err = serverStream.SendMsg(&grpcStreamDataResponse{metadata: &grpcMetadata{metadata: selected.metadata}})
+	if err != nil {
+		return err
+	}
+
+	// The metadata of the stream of the server is sent by streamDataRows
+	// whenever it changes.
+	var writeMetadata func(Metadata) error
+	if selected.id == DefaultStreamID {
+		writeMetadata = func(metadata Metadata) error {
+			return serverStream.SendMsg(&grpcStreamDataResponse{metadata: &grpcMetadata{metadata: metadata}})
+		}
+	}
+
+	// SendMsg encodes the rows before returning, so the buffer of rows can be
+	// reused.
+	streamEnded := s.streamDataRows(ctx, selected.broadcaster, "grpc", remoteAddr, request.afterSeq, request.history, func(dataRows []DataRow) error {
+		for len(dataRows) > 0 {
+			size := 0
+			n := 0
+			for n < len(dataRows) && (n == 0 || size < grpcMessageSize) {
+				size += protoRowSize(dataRows[n])
+				n++
+			}
+
+			err := serverStream.SendMsg(&grpcStreamDataResponse{rows: dataRows[:n]})
+			if err != nil {
+				return err
+			}
+
+			dataRows = dataRows[n:]
+		}
+
+		return nil
+	}, func() error {
+		return nil
+	}, writeMetadata, func(annotation Annotation) error {
+		return serverStream.SendMsg(&grpcStreamDataResponse{annotation: &annotation})
+	}, func(current Status) error {
+		return serverStream.SendMsg(&grpcStreamDataResponse{status: &current})
+	}, nil, nil)
+
+	if !streamEnded {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+
+		return status.Error(codes.Unavailable, "the rows could not be sent")
+	}
+
+	if err := selected.broadcaster.Err(); err != nil {
+		return status.Error(codes.Aborted, err.Error())
+	}
+
+	return nil
+}
+
+// Same as checkAccess, with the token passed as "Bearer <token>" in the
+// authorization metadata.
+func (g *GRPCServer) checkAccess(ctx context.Context) error {
+	s := g.httpServer
+	if s.viewToken == "" && s.controlToken == "" {
+		return nil
+	}
+
+	token := ""
+	md, _ := grpcmetadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if value, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+			token = value
+		}
+	}
+
+	if s.accessLevel(token) == AccessNone {
+		return status.Error(codes.Unauthenticated, "a valid token is required")
+	}
+
+	return nil
+}
+
+// The stream with the ID, with its current metadata. The empty ID is the
+// stream of the server.
+func (g *GRPCServer) findStream(id string) (*stream, error) {
+	s := g.httpServer
+	if id == "" || id == DefaultStreamID {
+		return &stream{id: DefaultStreamID, broadcaster: s.dataBroadcaster, metadata: s.currentMetadata()}, nil
+	}
+
+	found := s.findStream(id)
+	if found == nil {
+		return nil, status.Errorf(codes.NotFound, "unknown stream %q", id)
+	}
+
+	return found, nil
+}
+
+// The IP of the client, and its address for the logs.
+func peerAddr(ctx context.Context) (ip string, remoteAddr string) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", ""
+	}
+
+	remoteAddr = p.Addr.String()
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr, remoteAddr
+	}
+
+	return ip, remoteAddr
+}
+
+// Encodes the messages of the server, and decodes the requests, in the
+// protobuf wire format.
+type protoCodec struct{}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	message, ok := v.(interface{ appendProto(b []byte) []byte })
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T", v)
+	}
+
+	return message.appendProto(nil), nil
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	message, ok := v.(interface{ unmarshalProto(b []byte) error })
+	if !ok {
+		return fmt.Errorf("cannot decode %T", v)
+	}
+
+	return message.unmarshalProto(data)
+}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+type grpcMetadataRequest struct {
+	stream string
+}
+
+func (r *grpcMetadataRequest) unmarshalProto(b []byte) error {
+	return consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num != 1 || typ != protowire.BytesType {
+			return -1
+		}
+
+		value, n := protowire.ConsumeString(b)
+		r.stream = value
+		return n
+	})
+}
+
+type grpcStreamDataRequest struct {
+	stream   string
+	afterSeq uint64
+	history  HistoryMode
+}
+
+func (r *grpcStreamDataRequest) unmarshalProto(b []byte) error {
+	history := uint64(0)
+	err := consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			value, n := protowire.ConsumeString(b)
+			r.stream = value
+			return n
+		case num == 2 && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(b)
+			r.afterSeq = value
+			return n
+		case num == 3 && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(b)
+			history = value
+			return n
+		default:
+			return -1
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// The values of the History enum.
+	switch history {
+	case 0:
+		r.history = HistoryAll
+	case 1:
+		r.history = HistoryNone
+	case 2:
+		r.history = HistoryOnly
+	default:
+		return status.Errorf(codes.InvalidArgument, "invalid history %d, expected HISTORY_ALL, HISTORY_NONE, or HISTORY_ONLY", history)
+	}
+
+	return nil
+}
+
+// Calls field with the number, the type, and the data starting at the value
+// of every field of the message. field returns the length of the value, or -1
+// to skip the field, such as the fields added by later versions.
+func consumeProtoFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+		n = field(num, typ, b)
+		if n == -1 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+	}
+
+	return nil
+}
+
+type grpcMetadata struct {
+	metadata Metadata
+}
+
+func (m *grpcMetadata) appendProto(b []byte) []byte {
+	metadata := m.metadata
+	b = appendProtoString(b, 1, metadata.WesplotOptions.Title)
+	for _, column := range metadata.WesplotOptions.Columns {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, column)
+	}
+
+	if metadata.WindowSize != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(metadata.WindowSize))
+	}
+
+	b = appendProtoDouble(b, 4, metadata.WindowDuration)
+	if metadata.XIsTimestamp {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		panic(err)
+	}
+
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	return protowire.AppendBytes(b, data)
+}
+
+// Exactly one of the fields is set.
+type grpcStreamDataResponse struct {
+	rows       []DataRow
+	annotation *Annotation
+	metadata   *grpcMetadata
+	status     *Status
+}
+
+func (r *grpcStreamDataResponse) appendProto(b []byte) []byte {
+	for _, dataRow := range r.rows {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(protoRowSize(dataRow)))
+		b = appendProtoRow(b, dataRow)
+	}
+
+	if r.annotation != nil {
+		var annotation []byte
+		annotation = appendProtoDouble(annotation, 1, r.annotation.X)
+		annotation = appendProtoString(annotation, 2, r.annotation.Label)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, annotation)
+	}
+
+	if r.metadata != nil {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.metadata.appendProto(nil))
+	}
+
+	if r.status != nil {
+		var current []byte
+		current = appendProtoString(current, 1, r.status.State)
+		current = appendProtoDouble(current, 2, r.status.Idle)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, current)
+	}
+
+	return b
+}
+
+// The size of the Row message of the row, which precedes it, so the rows are
+// encoded without copies.
+func protoRowSize(dataRow DataRow) int {
+	size := 0
+	if dataRow.seq != 0 {
+		size += protowire.SizeTag(1) + protowire.SizeVarint(dataRow.seq)
+	}
+
+	if math.Float64bits(dataRow.X) != 0 {
+		size += protowire.SizeTag(2) + protowire.SizeFixed64()
+	}
+
+	return size + protoDoublesSize(3, dataRow.Ys) + protoDoublesSize(4, dataRow.Xs)
+}
+
+func appendProtoRow(b []byte, dataRow DataRow) []byte {
+	if dataRow.seq != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, dataRow.seq)
+	}
+
+	b = appendProtoDouble(b, 2, dataRow.X)
+	b = appendProtoDoubles(b, 3, dataRow.Ys)
+	return appendProtoDoubles(b, 4, dataRow.Xs)
+}
+
+// The values of repeated fields are packed in proto3.
+func protoDoublesSize(num protowire.Number, values []float64) int {
+	if len(values) == 0 {
+		return 0
+	}
+
+	return protowire.SizeTag(num) + protowire.SizeBytes(len(values)*protowire.SizeFixed64())
+}
+
+func appendProtoDoubles(b []byte, num protowire.Number, values []float64) []byte {
+	if len(values) == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(len(values)*protowire.SizeFixed64()))
+	for _, value := range values {
+		b = protowire.AppendFixed64(b, math.Float64bits(value))
+	}
+
+	return b
+}
+
+// The fields with the default value are omitted in proto3.
+func appendProtoDouble(b []byte, num protowire.Number, value float64) []byte {
+	if math.Float64bits(value) == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(value))
+}
+
+func appendProtoString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
@@ -0,0 +1,24 @@
+//go:build !grpc
+
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Whether wesplot was built with -tags grpc, and can serve the gRPC API.
+const GRPCSupported = false
+
+// Serving the gRPC API requires the gRPC libraries, which are only linked with
+// -tags grpc to keep the binary small.
+type GRPCServer struct{}
+
+func NewGRPCServer(httpServer *HttpServer) *GRPCServer {
+	return &GRPCServer{}
+}
+
+func (g *GRPCServer) Serve(ctx context.Context, listener net.Listener) error {
+	return errors.New("wesplot was built without gRPC support, rebuild it with -tags grpc")
+}
@@ -0,0 +1,228 @@
+//go:build grpc
+
+package wesplot
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Passes the messages through as is, so the test decodes the responses
+// without the generated code.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+func decodeProtoRow(t *testing.T, b []byte) DataRow {
+	var dataRow DataRow
+	err := consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(b)
+			dataRow.seq = value
+			return n
+		case num == 2 && typ == protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(b)
+			dataRow.X = math.Float64frombits(value)
+			return n
+		case num == 3 && typ == protowire.BytesType:
+			values, n := protowire.ConsumeBytes(b)
+			for len(values) > 0 {
+				value, m := protowire.ConsumeFixed64(values)
+				dataRow.Ys = append(dataRow.Ys, math.Float64frombits(value))
+				values = values[m:]
+			}
+			return n
+		default:
+			return -1
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dataRow
+}
+
+func TestGRPCServerStreamData(t *testing.T) {
+	input := NewChannelDataRowReader([]string{"a", "b"}, 10)
+	expected := []DataRow{{X: 1, Ys: []float64{2, 3}}, {X: 2, Ys: []float64{4, 5}}}
+	for _, dataRow := range expected {
+		err := input.Send(dataRow)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	input.Close()
+
+	// The rows and the end of the stream are buffered for the client.
+	dataBroadcaster := NewDataBroadcaster(input, 100, false)
+	dataBroadcaster.Start(context.Background())
+	dataBroadcaster.Wait()
+
+	metadata := Metadata{WindowSize: 100, WesplotOptions: WesplotOptions{Title: "test", Columns: []string{"a", "b"}}}
+	httpServer := NewHttpServer(dataBroadcaster, "127.0.0.1", 0, metadata, 10*time.Millisecond)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go NewGRPCServer(httpServer).Serve(ctx, listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dataRows := streamGRPCDataRows(t, ctx, conn, nil)
+	if len(dataRows) != len(expected) {
+		t.Fatalf("got %d rows, expected %d", len(dataRows), len(expected))
+	}
+
+	for i, dataRow := range dataRows {
+		if dataRow.seq != uint64(i+1) || dataRow.X != expected[i].X || !equalFloats(dataRow.Ys, expected[i].Ys) {
+			t.Errorf("got %v, expected %v with seq %d", dataRow, expected[i], i+1)
+		}
+	}
+
+	// Only the rows after after_seq.
+	request := protowire.AppendTag(nil, 2, protowire.VarintType)
+	request = protowire.AppendVarint(request, 1)
+	dataRows = streamGRPCDataRows(t, ctx, conn, request)
+	if len(dataRows) != 1 || dataRows[0].seq != 2 {
+		t.Errorf("got %v after seq 1, expected the row with seq 2", dataRows)
+	}
+}
+
+func TestGRPCServerAccessTokens(t *testing.T) {
+	dataBroadcaster := NewDataBroadcaster(NewChannelDataRowReader([]string{"a"}, 10), 100, false)
+	metadata := Metadata{WindowSize: 100, WesplotOptions: WesplotOptions{Columns: []string{"a"}}}
+	httpServer := NewHttpServer(dataBroadcaster, "127.0.0.1", 0, metadata, 10*time.Millisecond)
+	httpServer.SetAccessTokens("view", "control")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go NewGRPCServer(httpServer).Serve(ctx, listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		authorization string
+		code          codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"Bearer wrong", codes.Unauthenticated},
+		{"Bearer view", codes.OK},
+		{"Bearer control", codes.OK},
+	}
+
+	for _, test := range tests {
+		callCtx := ctx
+		if test.authorization != "" {
+			callCtx = grpcmetadata.AppendToOutgoingContext(ctx, "authorization", test.authorization)
+		}
+
+		request := []byte{}
+		var response []byte
+		err := conn.Invoke(callCtx, "/wesplot.v1.Wesplot/GetMetadata", &request, &response)
+		if code := status.Code(err); code != test.code {
+			t.Errorf("%q: got %v, expected %v", test.authorization, code, test.code)
+		}
+	}
+}
+
+// Calls StreamData with the encoded request, and returns the rows received
+// until the end of the stream.
+func streamGRPCDataRows(t *testing.T, ctx context.Context, conn *grpc.ClientConn, request []byte) []DataRow {
+	clientStream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/wesplot.v1.Wesplot/StreamData")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request == nil {
+		request = []byte{}
+	}
+
+	err = clientStream.SendMsg(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = clientStream.CloseSend()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata first, and then the rows until the end of the stream.
+	metadataReceived := false
+	var dataRows []DataRow
+	for {
+		var response []byte
+		err := clientStream.RecvMsg(&response)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		err = consumeProtoFields(response, func(num protowire.Number, typ protowire.Type, b []byte) int {
+			value, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				dataRows = append(dataRows, decodeProtoRow(t, value))
+			case 3:
+				if len(dataRows) > 0 {
+					t.Error("expected the metadata before the rows")
+				}
+				metadataReceived = true
+			}
+			return n
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !metadataReceived {
+		t.Error("expected the metadata")
+	}
+
+	return dataRows
+}
@@ -0,0 +1,221 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// How long to wait for the next bucket before the histogram is considered
+// complete, as the tools only print the next line at the next interval.
+const histogramIdleTimeout = 100 * time.Millisecond
+
+// The bucket lines of the histograms of bpftrace, such as
+// `[4, 8)   12 |@@@@@   |`, `[0]   1 |@   |` or `[1K, 2K)   3 |@   |`.
+var bpftraceBucketRegex = regexp.MustCompile(`^\[(-?[0-9.]+[KMGTPE]?)(?:, ?[^\]\)]*)?[\]\)]\s+(\d+)\s*\|`)
+
+// The bucket lines of the histograms of the BCC tools, such as
+// `4 -> 7   : 12   |****   |`.
+var bccBucketRegex = regexp.MustCompile(`^(\d+)\s*->\s*\d+\s*:\s*(\d+)\s*\|`)
+
+// A DataRowReader that reads the histograms printed every interval by bpftrace
+// (e.g. print(@usecs) of a hist() or lhist() map) or the BCC tools (e.g.
+// biolatency 1), from a StringReader returning whole lines (see
+// LineStringReader), and emits a row with the counts of the buckets of every
+// histogram. The X value is the receive timestamp of the histogram.
+//
+// The columns are the lower bounds of the buckets to plot, in increasing order
+// (e.g. 0, 1, 2, 4 or 1K). The count of every bucket is added to the column
+// with the highest lower bound that is not above the lower bound of the
+// bucket, so the buckets can be merged into fewer columns (e.g. 0, 1000 and
+// 10000 for the latencies under 1ms, under 10ms, and above). The buckets under
+// the first column are added to it. The other lines, such as the names of the
+// maps, are ignored, so a single histogram should be printed every interval.
+type HistogramDataRowReader struct {
+	input   StringReader
+	columns []string
+	bounds  []float64
+
+	// Closed once the input ends, with the error of the input.
+	lines    chan string
+	inputErr error
+
+	// The counts of the histogram being read, if any of its buckets were read.
+	pending []float64
+
+	// Set after the last histogram is returned at EOF.
+	ended bool
+
+	logger logrus.FieldLogger
+}
+
+func NewHistogramDataRowReader(input StringReader, columns []string) (*HistogramDataRowReader, error) {
+	r := &HistogramDataRowReader{
+		input:   input,
+		columns: columns,
+		bounds:  make([]float64, len(columns)),
+		logger:  logrus.WithField("tag", "Histogram"),
+	}
+
+	for i, column := range columns {
+		bound, err := parseHistogramBound(column)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q, expected its lower bound (e.g. 4 or 1K)", column)
+		}
+
+		if i > 0 && bound <= r.bounds[i-1] {
+			return nil, fmt.Errorf("the buckets must be in increasing order, but %s is after %s", column, columns[i-1])
+		}
+
+		r.bounds[i] = bound
+	}
+
+	return r, nil
+}
+
+func (r *HistogramDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if r.ended {
+		return DataRow{}, io.EOF
+	}
+
+	if r.lines == nil {
+		r.lines = make(chan string, bufferSize)
+		go r.readLines(ctx)
+	}
+
+	// The buckets are not rows, so they are read until the histogram ends.
+	idle := time.NewTimer(histogramIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case line, ok := <-r.lines:
+			if !ok {
+				if r.inputErr != io.EOF || r.pending == nil {
+					return DataRow{}, r.inputErr
+				}
+
+				r.ended = true
+				return r.flush(), nil
+			}
+
+			if r.add(line) {
+				if !idle.Stop() {
+					<-idle.C
+				}
+				idle.Reset(histogramIdleTimeout)
+			} else if r.pending != nil {
+				return r.flush(), nil
+			}
+		case <-idle.C:
+			if r.pending != nil {
+				return r.flush(), nil
+			}
+
+			idle.Reset(histogramIdleTimeout)
+		case <-ctx.Done():
+			return DataRow{}, ctx.Err()
+		}
+	}
+}
+
+func (r *HistogramDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+func (r *HistogramDataRowReader) readLines(ctx context.Context) {
+	for {
+		fields, err := r.input.Read(ctx)
+		if errors.Is(err, errIgnoreThisRow) {
+			continue
+		} else if err != nil {
+			r.inputErr = err
+			close(r.lines)
+			return
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		select {
+		case r.lines <- fields[0]:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Adds the count of the bucket of the line to the pending histogram, and
+// returns false if the line is not a bucket.
+func (r *HistogramDataRowReader) add(line string) bool {
+	match := bpftraceBucketRegex.FindStringSubmatch(line)
+	if match == nil {
+		match = bccBucketRegex.FindStringSubmatch(line)
+	}
+
+	if match == nil {
+		return false
+	}
+
+	bound, err := parseHistogramBound(match[1])
+	if err != nil {
+		r.logger.WithError(err).WithField("line", line).Warn("cannot parse bucket, ignoring...")
+		return true
+	}
+
+	count, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		r.logger.WithError(err).WithField("line", line).Warn("cannot parse bucket, ignoring...")
+		return true
+	}
+
+	if r.pending == nil {
+		r.pending = make([]float64, len(r.columns))
+	}
+
+	// The index of the first column above the bucket, minus one.
+	i := sort.Search(len(r.bounds), func(i int) bool { return r.bounds[i] > bound }) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	r.pending[i] += count
+	return true
+}
+
+func (r *HistogramDataRowReader) flush() DataRow {
+	dataRow := DataRow{
+		X:  float64(time.Now().UnixNano()) / 1e9,
+		Ys: r.pending,
+	}
+
+	r.pending = nil
+	return dataRow
+}
+
+// Parses a bound such as 4 or 1K, where the suffixes are powers of 1024 as
+// printed by bpftrace.
+func parseHistogramBound(text string) (float64, error) {
+	multiplier := 1.0
+	if i := strings.IndexAny(text, "KMGTPE"); i > 0 && i == len(text)-1 {
+		multiplier = math.Pow(1024, float64(strings.IndexByte("KMGTPE", text[i])+1))
+		text = text[:i]
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid bound %q", text)
+	}
+
+	return value * multiplier, nil
+}
@@ -0,0 +1,87 @@
+package wesplot
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// The default and maximum number of points per series returned by /history.
+const (
+	defaultHistoryPoints = 1000
+	maxHistoryPoints     = 10000
+)
+
+// The values of a series between From and To of a History, downsampled into
+// buckets of equal width: X and Y are the averages of the values of each
+// bucket, and Min and Max the extremes of their Y, so the spikes are not
+// averaged away. Buckets without values are omitted.
+type HistorySeries struct {
+	Name string
+	X    []float64
+	Y    []float64
+	Min  []float64
+	Max  []float64
+}
+
+// The response of /history. From and To are the range of the X values
+// queried, which default to the range of the stored values.
+type History struct {
+	From   float64
+	To     float64
+	Series []HistorySeries
+}
+
+// The query of /history, such as ?from=1700000000&to=1700003600&series=cpu.
+// The series can be repeated, and defaults to all of them.
+type HistoryQuery struct {
+	From   *float64
+	To     *float64
+	Series []string
+	Points int // Per series
+}
+
+func parseHistoryQuery(req *http.Request) (HistoryQuery, error) {
+	values := req.URL.Query()
+	query := HistoryQuery{
+		Series: values["series"],
+		Points: defaultHistoryPoints,
+	}
+
+	var err error
+	query.From, err = parseHistoryBound(values.Get("from"), "from")
+	if err != nil {
+		return query, err
+	}
+
+	query.To, err = parseHistoryBound(values.Get("to"), "to")
+	if err != nil {
+		return query, err
+	}
+
+	if text := values.Get("points"); text != "" {
+		points, err := strconv.Atoi(text)
+		if err != nil || points < 1 || points > maxHistoryPoints {
+			return query, fmt.Errorf("invalid points %q, must be between 1 and %d", text, maxHistoryPoints)
+		}
+
+		query.Points = points
+	}
+
+	return query, nil
+}
+
+// Returns nil if the bound is omitted.
+func parseHistoryBound(text string, name string) (*float64, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("invalid %s %q", name, text)
+	}
+
+	return &value, nil
+}
@@ -1,34 +1,174 @@
 package wesplot
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"nhooyr.io/websocket"
-	"nhooyr.io/websocket/wsjson"
 )
 
 const bufferSize = 10000
 
+// The number of buffered rows copied at once when they are pushed to a new
+// client, which bounds the time the DataBroadcaster is locked and the memory
+// used by a new client, regardless of the window size.
+const historyChunkSize = 10000
+
+// The maximum number of rows sent to a client in one message, so the messages
+// stay small enough for the websocket clients and the browser to parse them
+// without stalling, even when a new client receives a large window.
+const maxBatchSize = 10000
+
+// How long a connection can be idle before a heartbeat is sent, so proxies and
+// NATs with idle timeouts do not drop quiet plots, and dead clients are
+// detected.
+const heartbeatInterval = 15 * time.Second
+
+// How long to wait for a client to answer a websocket ping.
+const heartbeatTimeout = 10 * time.Second
+
+// The default of SetClientTimeout.
+const DefaultClientTimeout = 30 * time.Second
+
 type StreamEndedMessage struct {
 	StreamEnded bool
 	StreamError error
 }
 
+// The permessage-deflate compression of the /ws websocket, if the browser
+// supports it. The JSON messages are very repetitive, so compressing them
+// significantly reduces the bandwidth when plotting over slow links.
+type CompressionMode string
+
+const (
+	// Every message is compressed independently. This uses little memory per
+	// connection, but only messages larger than 512 bytes are compressed.
+	CompressionPerMessage CompressionMode = "per-message"
+
+	// The compression window is kept across messages, which compresses much
+	// better at the cost of extra memory per connection.
+	CompressionContextTakeover CompressionMode = "context-takeover"
+
+	CompressionDisabled CompressionMode = "disabled"
+)
+
+func (m CompressionMode) websocketMode() websocket.CompressionMode {
+	switch m {
+	case CompressionContextTakeover:
+		return websocket.CompressionContextTakeover
+	case CompressionDisabled:
+		return websocket.CompressionDisabled
+	default:
+		return websocket.CompressionNoContextTakeover
+	}
+}
+
+// The version of the JSON format of the rows sent on /ws and received on
+// /ws-ingest, as a websocket subprotocol. Clients can offer the versions they
+// support with the Sec-WebSocket-Protocol header and the first one supported by
+// the server is selected. Clients that do not offer any are assumed to speak
+// this version, for compatibility with older clients.
+const ProtocolV1 = "wesplot.v1"
+
+// Same as ProtocolV1, but the rows sent on /ws are about half as large, for
+// high-rate timestamped data. The Ys are rounded to float32, and only the first
+// row of every message has an X. The other rows have a DX instead, the
+// difference between their X and the X of the previous row, rounded to 7
+// significant digits, which is much shorter than a timestamp. Clients add up
+// the DXs to get the Xs, which are accurate to 7 significant digits of the
+// interval between the rows, as the DXs are computed from the Xs the clients
+// get, so the rounding errors do not accumulate.
+const ProtocolV1DeltaFloat32 = "wesplot.v1.delta-float32"
+
+// Same as ProtocolV1, but the rows of every flush sent on /ws are packed into a
+// single object with an array per series, such as {"X": [1, 2], "Ys": [[3, 4],
+// [5, 6]]} for the rows {"X": 1, "Ys": [3, 5]} and {"X": 2, "Ys": [4, 6]}.
+// This removes the keys repeated in every row, which dominate the size of the
+// messages with many rows, and lets clients append the values of a series
+// without iterating over the rows.
+const ProtocolV1Columns = "wesplot.v1.columns"
+
+// Same as ProtocolV1, but the Ys of the rows sent on /ws are encoded with the
+// precision of float32, which makes the messages smaller. Clients that can
+// tolerate about 7 significant digits can offer it along with ProtocolV1.
+const ProtocolV1Float32 = "wesplot.v1.float32"
+
+// The protocols supported on /ws, preferred first.
+var websocketProtocols = []string{ProtocolV1DeltaFloat32, ProtocolV1Columns, ProtocolV1Float32, ProtocolV1}
+
+// The encoding of the rows sent with the negotiated protocol.
+func protocolEncoding(protocol string) rowEncoding {
+	return rowEncoding{
+		float32: protocol == ProtocolV1Float32 || protocol == ProtocolV1DeltaFloat32,
+		deltaX:  protocol == ProtocolV1DeltaFloat32,
+		columns: protocol == ProtocolV1Columns,
+	}
+}
+
+// The protocols supported on /ws-ingest.
+var ingestProtocols = []string{ProtocolV1}
+
+// Closes the connection and returns false if the client only offered protocol
+// versions that are not supported.
+func negotiateProtocol(c *websocket.Conn, req *http.Request, protocols []string) bool {
+	if c.Subprotocol() == "" && req.Header.Get("Sec-WebSocket-Protocol") != "" {
+		c.Close(websocket.StatusPolicyViolation, "unsupported protocol, supported: "+strings.Join(protocols, ", "))
+		return false
+	}
+
+	return true
+}
+
 type HttpServer struct {
 	dataBroadcaster *DataBroadcaster
 	host            string
 	port            uint16
-	metadata        Metadata
-	flushInterval   time.Duration
+	compression     CompressionMode
+	control         bool
+	basePath        string       // Starts and ends with a slash
+	listener        net.Listener // If not nil, used instead of host and port
+	systemd         bool
+	browserOptions  BrowserOptions
+	advertiser      Advertiser // If not nil, advertises the plot while running
+	viewToken       string
+	controlToken    string
+	maxClients      int
+	maxClientsPerIP int
+	clientTimeout   time.Duration
+	staleAfter      time.Duration
+	streams         []*stream // Added with AddStream
+	webui           fs.FS     // The files of the web UI, empty in dev builds
 	mux             *http.ServeMux
 	logger          logrus.FieldLogger
+
+	// The metadata and the flush interval can be changed at runtime via PUT
+	// /options, so they are protected by the mutex, like the settings file. The
+	// connections that want to receive the updated metadata register a channel
+	// in metadataListeners.
+	optionsMutex      sync.Mutex
+	metadata          Metadata
+	flushInterval     time.Duration
+	metadataListeners map[chan Metadata]struct{}
+	settingsFile      string // Where the options are saved, if not empty
+
+	// Sent to the clients that ask for them, if set with SetAlerts.
+	alerts *AlertDataRowReader
+
+	// The clients streaming the rows, limited by SetClientLimits.
+	clientsMutex sync.Mutex
+	numClients   int
+	clientsPerIP map[string]int
 }
 
 func NewHttpServer(dataBroadcaster *DataBroadcaster, host string, port uint16, metadata Metadata, flushInterval time.Duration) *HttpServer {
@@ -37,10 +177,15 @@ func NewHttpServer(dataBroadcaster *DataBroadcaster, host string, port uint16, m
 		dataBroadcaster: dataBroadcaster,
 		host:            host,
 		port:            port,
-		metadata:        metadata,
-		flushInterval:   flushInterval,
+		basePath:        "/",
+		clientTimeout:   DefaultClientTimeout,
 		mux:             http.NewServeMux(),
 		logger:          logrus.WithField("tag", "HttpServer"),
+
+		metadata:          metadata,
+		flushInterval:     flushInterval,
+		metadataListeners: make(map[chan Metadata]struct{}),
+		clientsPerIP:      make(map[string]int),
 	}
 
 	subFS, err := fs.Sub(webuiFiles, "webui")
@@ -48,28 +193,424 @@ func NewHttpServer(dataBroadcaster *DataBroadcaster, host string, port uint16, m
 		panic(err)
 	}
 
-	s.mux.Handle("/", http.FileServer(http.FS(subFS)))
-	s.mux.HandleFunc("/ws", s.handleWebSocket)
+	s.webui = subFS
+	webui := http.FileServer(http.FS(subFS))
+	s.mux.Handle("/", webui)
+	s.mux.Handle("/dashboard", dashboardHandler(webui))
+	s.mux.HandleFunc("/ws", s.limitClients(s.handleWebSocket))
+	s.mux.HandleFunc("/sse", s.limitClients(s.handleSSE))
+	s.mux.HandleFunc("/ws-mux", s.limitClients(s.handleWebSocketMux))
+	s.mux.HandleFunc("/streams", s.handleStreams)
+	s.mux.HandleFunc("/export.html", s.handleExportHTML)
 	s.mux.HandleFunc("/metadata", s.handleMetadata)
 	s.mux.HandleFunc("/errors", s.handleErrors)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/options", s.handleOptions)
+	s.mux.HandleFunc("/annotations", s.handleAnnotations)
+	s.mux.HandleFunc("/control", s.handleControl)
+	s.mux.HandleFunc("/control/", s.handleControl)
 
 	return s
 }
 
+// The series statistics change with every row, so they are sent to the clients
+// at most this often (see SeriesStatsMessage).
+const seriesStatsInterval = time.Second
+
+// Clients that pass the stats query parameter (e.g. /ws?stats) receive the
+// statistics of every series since the start of the stream, as in /stats,
+// after the rows that changed them, at most every seriesStatsInterval. On
+// /sse, the stats event is always sent.
+type SeriesStatsMessage struct {
+	Series []SeriesStatsSnapshot
+}
+
+// Clients that pass the alerts query parameter (e.g. /ws?alerts) receive the
+// status of every alert that is not ok when they connect, and then the status
+// of an alert as {"Alert": {...}} whenever its state changes (see SetAlerts).
+// On /sse, the alert event is always sent.
+type AlertMessage struct {
+	Alert AlertStatus
+}
+
+// Clients that pass the metadata query parameter (e.g. /ws?metadata) receive
+// the updated metadata whenever it is changed via PUT /options. On /ws, it is
+// sent as {"Metadata": {...}}, which clients can tell apart from the arrays of
+// rows. On /sse, the metadata event is always sent, as EventSource ignores the
+// events without listeners.
+type MetadataMessage struct {
+	Metadata Metadata
+}
+
+// The states of Status.
+const (
+	StatusActive = "ACTIVE"
+	StatusStale  = "STALE"
+)
+
+// Sent to the clients when no row was emitted for the duration set with
+// SetStaleAfter (with State StatusStale), and once rows are emitted again
+// (StatusActive), so they can show that the plot is not frozen for unknown
+// reasons. Idle is the number of seconds since the last row, when the status
+// is sent.
+type Status struct {
+	State string
+	Idle  float64
+}
+
+// Clients that pass the status query parameter (e.g. /ws?status) receive the
+// status as {"Status": {...}} whenever it changes. On /sse, the status event
+// is always sent.
+type StatusMessage struct {
+	Status Status
+}
+
+// Clients that pass the resume query parameter receive the sequence number of
+// every row (see sequencedDataRows). When reconnecting, they can pass the
+// sequence number of the last row received (e.g. /ws?resume=1234) to only
+// receive the rows they missed. Returns an error if the parameter is invalid.
+func parseResumeParam(req *http.Request) (sequenced bool, afterSeq uint64, err error) {
+	if !req.URL.Query().Has("resume") {
+		return false, 0, nil
+	}
+
+	resume := req.URL.Query().Get("resume")
+	if resume == "" {
+		return true, 0, nil
+	}
+
+	afterSeq, err = strconv.ParseUint(resume, 10, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("invalid resume sequence number %q", resume)
+	}
+
+	return true, afterSeq, nil
+}
+
+// Parses the history query parameter of /ws and /sse: all (the default) for
+// the buffered rows and then the new rows, none for only the new rows, or only
+// for only the buffered rows, after which the stream ends.
+func parseHistoryParam(req *http.Request) (HistoryMode, error) {
+	switch history := req.URL.Query().Get("history"); history {
+	case "", "all":
+		return HistoryAll, nil
+	case "none":
+		return HistoryNone, nil
+	case "only":
+		return HistoryOnly, nil
+	default:
+		return HistoryAll, fmt.Errorf("invalid history %q, expected all, none, or only", history)
+	}
+}
+
+// The value of the history query parameter for the mode.
+func (h HistoryMode) param() string {
+	switch h {
+	case HistoryNone:
+		return "none"
+	case HistoryOnly:
+		return "only"
+	default:
+		return "all"
+	}
+}
+
+// The buffers the batches of rows are encoded into. They are reused across
+// flushes and connections to reduce the garbage collection at high data rates.
+var encodeBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 64*1024)
+		return &buf
+	},
+}
+
+// Encodes the rows as a JSON array terminated by a newline, as requested, and
+// passes it to write. The data is only valid until write returns.
+func encodeDataRows(dataRows []DataRow, encoding rowEncoding, write func(data []byte) error) error {
+	bufp := encodeBufferPool.Get().(*[]byte)
+
+	buf := appendDataRowsJSON((*bufp)[:0], dataRows, encoding)
+	buf = append(buf, '\n')
+	err := write(buf)
+
+	*bufp = buf
+	encodeBufferPool.Put(bufp)
+	return err
+}
+
 func (s *HttpServer) handleWebSocket(w http.ResponseWriter, req *http.Request) {
+	sequenced, afterSeq, err := parseResumeParam(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := parseHistoryParam(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// TODO: need to ensure that we allow CORS.
 	c, err := websocket.Accept(w, req, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
+		OriginPatterns:  []string{"*"},
+		Subprotocols:    websocketProtocols,
+		CompressionMode: s.compression.websocketMode(),
 	})
 	if err != nil {
 		s.logger.WithError(err).Warn("failed to accept new websocket connection")
 		return
 	}
 
+	if !negotiateProtocol(c, req, websocketProtocols) {
+		s.logger.WithField("protocols", req.Header.Get("Sec-WebSocket-Protocol")).Warn("rejected websocket connection with unsupported protocol")
+		return
+	}
+
+	// The history is already rounded to float32 if it is stored as float32.
+	encoding := protocolEncoding(c.Subprotocol())
+	encoding.sequenced = sequenced
+	encoding.float32 = encoding.float32 || s.dataBroadcaster.Float32()
+
 	ctx := req.Context()
 	ctx = c.CloseRead(ctx) // This means we no longer want to read from the websocket, which is true because we just want to write.
 
-	channel := make(chan DataRow, bufferSize)
+	// The connection is closed if a message is not written within the client
+	// timeout.
+	writeMessage := func(data []byte) error {
+		writeCtx, cancel := s.clientTimeoutContext(ctx)
+		defer cancel()
+		return c.Write(writeCtx, websocket.MessageText, data)
+	}
+
+	var writeMetadata func(Metadata) error
+	if req.URL.Query().Has("metadata") {
+		writeMetadata = func(metadata Metadata) error {
+			return writeJSONMessage(writeMessage, MetadataMessage{Metadata: metadata})
+		}
+	}
+
+	var writeAnnotation func(Annotation) error
+	if req.URL.Query().Has("annotations") {
+		writeAnnotation = func(annotation Annotation) error {
+			return writeJSONMessage(writeMessage, AnnotationMessage{Annotation: annotation})
+		}
+	}
+
+	var writeStatus func(Status) error
+	if req.URL.Query().Has("status") {
+		writeStatus = func(status Status) error {
+			return writeJSONMessage(writeMessage, StatusMessage{Status: status})
+		}
+	}
+
+	var writeAlert func(AlertStatus) error
+	if req.URL.Query().Has("alerts") {
+		writeAlert = func(alert AlertStatus) error {
+			return writeJSONMessage(writeMessage, AlertMessage{Alert: alert})
+		}
+	}
+
+	var writeSeriesStats func([]SeriesStatsSnapshot) error
+	if req.URL.Query().Has("stats") {
+		writeSeriesStats = func(series []SeriesStatsSnapshot) error {
+			return writeJSONMessage(writeMessage, SeriesStatsMessage{Series: series})
+		}
+	}
+
+	// When the stream ends, the websocket is closed. The client should issue
+	// another request to /errors after the websocket connection closes to see
+	// if there are any stream errors so it can display it.
+	s.streamDataRows(ctx, s.dataBroadcaster, "websocket", req.RemoteAddr, afterSeq, history, func(dataRows []DataRow) error {
+		// Same as wsjson.Write, which also terminates the message with a newline.
+		return encodeDataRows(dataRows, encoding, writeMessage)
+	}, func() error {
+		pingCtx, cancel := context.WithTimeout(ctx, heartbeatTimeout)
+		defer cancel()
+		return c.Ping(pingCtx)
+	}, writeMetadata, writeAnnotation, writeStatus, writeAlert, writeSeriesStats)
+
+	c.Close(websocket.StatusNormalClosure, "")
+}
+
+// Same as wsjson.Write, which also terminates the message with a newline.
+func writeJSONMessage(writeMessage func(data []byte) error, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeMessage(append(data, '\n'))
+}
+
+// Disconnects the clients that do not accept the data within the timeout,
+// such as a laptop that went to sleep in the middle of a transfer, so their
+// goroutines and buffers are released. 0 means no timeout. Must be called
+// before Run.
+func (s *HttpServer) SetClientTimeout(timeout time.Duration) {
+	s.clientTimeout = timeout
+}
+
+// Tells the clients that the broadcaster is stale once no row was emitted for
+// this duration (see Status), and that it is active again once a row is
+// emitted. 0 means never. Must be called before Run.
+func (s *HttpServer) SetStaleAfter(staleAfter time.Duration) {
+	s.staleAfter = staleAfter
+}
+
+func (s *HttpServer) clientTimeoutContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.clientTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, s.clientTimeout)
+}
+
+// Serves the same batches of rows as /ws as server-sent events, for
+// environments where websockets are blocked by proxies. Every batch is sent as
+// a JSON array in the data of a message. When the metadata is changed, an
+// event named metadata is sent, and every annotation is sent as an event named
+// annotation. The AlertStatus and the SeriesStatsMessage are sent as the alert
+// and stats events. When the stream ends, an event named end is sent, after
+// which the client should query /errors.
+func (s *HttpServer) handleSSE(w http.ResponseWriter, req *http.Request) {
+	sequenced, afterSeq, err := parseResumeParam(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := parseHistoryParam(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// The write deadline is extended before every write, and the connection is
+	// closed if a write does not complete before it.
+	controller := http.NewResponseController(w)
+	extendWriteDeadline := func() {
+		if s.clientTimeout > 0 {
+			controller.SetWriteDeadline(time.Now().Add(s.clientTimeout))
+		}
+	}
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoding := rowEncoding{sequenced: sequenced, float32: s.dataBroadcaster.Float32()}
+
+	ctx := req.Context()
+	streamEnded := s.streamDataRows(ctx, s.dataBroadcaster, "sse", req.RemoteAddr, afterSeq, history, func(dataRows []DataRow) error {
+		extendWriteDeadline()
+		err := encodeDataRows(dataRows, encoding, func(data []byte) error {
+			// The data is already terminated by a newline.
+			_, err := fmt.Fprintf(w, "data: %s\n", data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}, func() error {
+		// Comment lines are ignored by EventSource.
+		extendWriteDeadline()
+		_, err := fmt.Fprint(w, ": heartbeat\n\n")
+		if err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}, func(metadata Metadata) error {
+		extendWriteDeadline()
+		return writeSSEEvent(w, flusher, "metadata", metadata)
+	}, func(annotation Annotation) error {
+		extendWriteDeadline()
+		return writeSSEEvent(w, flusher, "annotation", annotation)
+	}, func(status Status) error {
+		extendWriteDeadline()
+		return writeSSEEvent(w, flusher, "status", status)
+	}, func(alert AlertStatus) error {
+		extendWriteDeadline()
+		return writeSSEEvent(w, flusher, "alert", alert)
+	}, func(series []SeriesStatsSnapshot) error {
+		extendWriteDeadline()
+		return writeSSEEvent(w, flusher, "stats", SeriesStatsMessage{Series: series})
+	})
+
+	if streamEnded {
+		extendWriteDeadline()
+		fmt.Fprint(w, "event: end\ndata: {}\n\n")
+		flusher.Flush()
+	}
+}
+
+// Writes v as JSON in the data of an event with the given name.
+func writeSSEEvent(w http.ResponseWriter, flusher http.Flusher, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return err
+	}
+
+	flusher.Flush()
+	return nil
+}
+
+// Registers a channel with the broadcaster (see RegisterChannelFrom for
+// afterSeq and history) and writes the rows received from it to a client in
+// batches, until the stream ends, a write or heartbeat fails, or the context is
+// canceled. The heartbeat is called when nothing was written for
+// heartbeatInterval. If writeMetadata is not nil, it is called
+// with the metadata of the server whenever it is changed, after the rows
+// received before the change are written, so it must be nil for the other
+// streams (see AddStream). Likewise, writeAnnotation is called with the
+// annotations in order with the rows, which are skipped if it is nil, and
+// writeStatus is called whenever the Status of the broadcaster changes, if it
+// is not nil. If writeAlert is not nil, it is called whenever the state of an
+// alert of the server changes (see SetAlerts), so it must be nil for the other
+// streams as well. If writeSeriesStats is not nil, it is called with the
+// statistics of the series of the broadcaster after the rows are written, at
+// most every seriesStatsInterval. Returns true if the stream ended and all the
+// rows were written.
+func (s *HttpServer) streamDataRows(ctx context.Context, broadcaster *DataBroadcaster, transport string, remoteAddr string, afterSeq uint64, history HistoryMode, write func([]DataRow) error, heartbeat func() error, writeMetadata func(Metadata) error, writeAnnotation func(Annotation) error, writeStatus func(Status) error, writeAlert func(AlertStatus) error, writeSeriesStats func([]SeriesStatsSnapshot) error) bool {
+	limits := broadcaster.MemoryLimits()
+	clientBufferSize := limits.ClientBufferSize
+	channel := make(chan DataRow, clientBufferSize)
+	streamEnded := false
+
+	// Stays nil if writeMetadata is nil, so it is never selected.
+	var metadataChannel chan Metadata
+	if writeMetadata != nil {
+		metadataChannel = s.addMetadataListener()
+		defer s.removeMetadataListener(metadataChannel)
+	}
+
+	// Stays nil if there are no alerts or the client does not want them, so it
+	// is never selected.
+	var alertChannel chan AlertStatus
+	if writeAlert != nil && s.alerts != nil {
+		alertChannel = s.alerts.addListener()
+		defer s.alerts.removeListener(alertChannel)
+	}
+
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 
@@ -77,76 +618,194 @@ func (s *HttpServer) handleWebSocket(w http.ResponseWriter, req *http.Request) {
 		defer wg.Done()
 
 		// We buffer data for at least X milliseconds or if it reaches capacity before sending it to the client.
-		// Note: tune or allow configuration
-		bufferItemCapacity := Min(s.metadata.WindowSize, 25000)
+		bufferItemCapacity := Min(Min(limits.WindowSize, maxBatchSize), clientBufferSize)
 		lastSendTime := time.Now()
 		dataBuffer := make([]DataRow, 0, bufferItemCapacity)
 
-		flushBufferToWebsocket := func() error {
-			err := wsjson.Write(ctx, c, dataBuffer)
+		// Set when rows are written, until the series stats are sent.
+		seriesStatsChanged := false
+		lastSeriesStatsTime := time.Time{}
+		updateSeriesStats := func(force bool) error {
+			if writeSeriesStats == nil || !seriesStatsChanged || (!force && time.Since(lastSeriesStatsTime) < seriesStatsInterval) {
+				return nil
+			}
+
+			seriesStatsChanged = false
+			lastSeriesStatsTime = time.Now()
+			return writeSeriesStats(broadcaster.seriesStats.Snapshot())
+		}
+
+		flushBuffer := func() error {
+			err := write(dataBuffer)
 			if err != nil {
 				return err
 			}
 
-			dataBuffer = make([]DataRow, 0, bufferItemCapacity) // TODO: try to clear the buffer without allocating
+			seriesStatsChanged = seriesStatsChanged || len(dataBuffer) > 0
+
+			// The rows are already encoded, so the buffer can be reused.
+			dataBuffer = dataBuffer[:0]
 			lastSendTime = time.Now()
-			return nil
+			return updateSeriesStats(false)
 		}
 
-		logger := s.logger.WithField("channel", channel)
+		logger := s.logger.WithFields(logrus.Fields{"channel": channel, "transport": transport, "remoteAddr": remoteAddr})
+
+		// The client assumes that the broadcaster is active until told
+		// otherwise.
+		stale := false
+		updateStatus := func() error {
+			if writeStatus == nil || s.staleAfter <= 0 {
+				return nil
+			}
+
+			idle := time.Since(broadcaster.LastRowTime())
+			if (idle > s.staleAfter) == stale {
+				return nil
+			}
+
+			stale = !stale
+			status := Status{State: StatusActive, Idle: idle.Seconds()}
+			if stale {
+				status.State = StatusStale
+			}
+
+			return writeStatus(status)
+		}
 
 		for {
+			flushInterval := s.currentFlushInterval()
+
 			select {
 			case dataRow, open := <-channel:
 				if !open {
 					// Not sure why this would ever happen, but sure
 					// TODO: maybe panic here
-					logger.Warn("data channel closed, closing websocket")
-					c.Close(websocket.StatusNormalClosure, "channel closed")
+					logger.Warn("data channel closed, closing connection")
 					return
 				}
 
 				if dataRow.streamEnded {
-					// Stream has ended. We should close the the websocket. The client
-					// should issue another request to /errors after the websocket
-					// connection closes to see if there are any stream errors so it can
-					// display it.
-					logger.Info("stream ended, flushing and then closing websocket connection")
-					err := flushBufferToWebsocket()
+					logger.Info("stream ended, flushing and then closing connection")
+					err := flushBuffer()
+					if err == nil {
+						err = updateSeriesStats(true)
+					}
+
 					if err != nil {
-						logger.Warn("websocket flush failed and closed")
+						logger.Warn("flush failed and connection closed")
 						return
 					}
 
-					c.Close(websocket.StatusNormalClosure, "")
+					streamEnded = true
 					return
 				}
 
+				if dataRow.annotation != nil {
+					if writeAnnotation == nil {
+						continue
+					}
+
+					if len(dataBuffer) > 0 {
+						err := flushBuffer()
+						if err != nil {
+							logger.Warn("write failed and connection closed")
+							return
+						}
+					}
+
+					err := writeAnnotation(*dataRow.annotation)
+					if err != nil {
+						logger.WithError(err).Warn("annotation write failed and connection closed")
+						return
+					}
+
+					continue
+				}
+
+				// Sent before the row, which made the broadcaster active.
+				if stale {
+					err := updateStatus()
+					if err != nil {
+						logger.WithError(err).Warn("status write failed and connection closed")
+						return
+					}
+				}
+
 				dataBuffer = append(dataBuffer, dataRow)
-				if len(dataBuffer) >= bufferItemCapacity || time.Since(lastSendTime) > s.flushInterval {
+				if len(dataBuffer) >= bufferItemCapacity || time.Since(lastSendTime) > flushInterval {
 					logger.WithField("buflen", len(dataBuffer)).Debug("buffer capacity reached, flushing")
-					err := flushBufferToWebsocket()
+					err := flushBuffer()
+					if err != nil {
+						// At this point the connection closed, so we don't even need to send anything
+						logger.Warn("write failed and connection closed")
+						return
+					}
+				}
+
+			case metadata := <-metadataChannel:
+				if len(dataBuffer) > 0 {
+					err := flushBuffer()
+					if err != nil {
+						logger.Warn("write failed and connection closed")
+						return
+					}
+				}
+
+				err := writeMetadata(metadata)
+				if err != nil {
+					logger.WithError(err).Warn("metadata write failed and connection closed")
+					return
+				}
+
+			case alert := <-alertChannel:
+				if len(dataBuffer) > 0 {
+					err := flushBuffer()
 					if err != nil {
-						// At this point the websocket closed, so we don't even need to send anything
-						logger.Warn("websocket write failed and closed")
+						logger.Warn("write failed and connection closed")
 						return
 					}
 				}
 
-			case <-time.After(s.flushInterval):
+				err := writeAlert(alert)
+				if err != nil {
+					logger.WithError(err).Warn("alert write failed and connection closed")
+					return
+				}
+
+			case <-time.After(flushInterval):
+				err := updateStatus()
+				if err != nil {
+					logger.WithError(err).Warn("status write failed and connection closed")
+					return
+				}
+
+				err = updateSeriesStats(false)
+				if err != nil {
+					logger.WithError(err).Warn("series stats write failed and connection closed")
+					return
+				}
+
 				if len(dataBuffer) > 0 {
 					logger.WithField("buflen", len(dataBuffer)).Debug("timed out waiting for more data, flushing")
-					err := flushBufferToWebsocket()
+					err := flushBuffer()
+					if err != nil {
+						// At this point the connection closed, so we don't even need to send anything
+						logger.Warn("write failed and connection closed")
+						return
+					}
+				} else if time.Since(lastSendTime) > heartbeatInterval {
+					err := heartbeat()
 					if err != nil {
-						// At this point the websocket closed, so we don't even need to send anything
-						logger.Warn("websocket write failed and closed")
+						logger.WithError(err).Warn("heartbeat failed, closing connection")
 						return
 					}
+
+					lastSendTime = time.Now()
 				}
 
 			case <-ctx.Done(): // client connection closes causes the req.Context to be canceled?
 				logger.Info("client closed connection or context canceled")
-				c.Close(websocket.StatusNormalClosure, "")
 				return
 			}
 		}
@@ -154,13 +813,15 @@ func (s *HttpServer) handleWebSocket(w http.ResponseWriter, req *http.Request) {
 
 	// The channel is already being received from in another goroutine and we
 	// register the channels in the main thread.
-	s.dataBroadcaster.RegisterChannel(ctx, channel)
+	broadcaster.RegisterChannelFrom(ctx, channel, afterSeq, history, transport+" "+remoteAddr)
 
-	// Once the websocket writing thread finishes, we want to deregister the
-	// channel from the broadcaster.
+	// Once the writing thread finishes, we want to deregister the channel from
+	// the broadcaster.
 	wg.Wait()
-	s.dataBroadcaster.DeregisterChannel(ctx, channel)
+	broadcaster.DeregisterChannel(ctx, channel)
 	close(channel)
+
+	return streamEnded
 }
 
 func (s *HttpServer) handleMetadata(w http.ResponseWriter, req *http.Request) {
@@ -168,7 +829,7 @@ func (s *HttpServer) handleMetadata(w http.ResponseWriter, req *http.Request) {
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	w.Header().Add("Access-Control-Allow-Headers", "content-type")
 	w.Header().Add("Access-Control-Allow-Methods", "*")
-	err := json.NewEncoder(w).Encode(s.metadata)
+	err := json.NewEncoder(w).Encode(s.currentMetadata())
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
@@ -195,7 +856,178 @@ func (s *HttpServer) handleErrors(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Serves the status of the alerts at /alerts, and sends it to the clients
+// whenever it changes (see AlertMessage). Must be called before Run.
+func (s *HttpServer) SetAlerts(alerts *AlertDataRowReader) {
+	s.alerts = alerts
+	s.mux.Handle("/alerts", alerts)
+}
+
+// Registers an additional handler on the server. Must be called before Run.
+func (s *HttpServer) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Same as Handle, for a handler that changes the plot, such as an
+// IngestHandler. Its requests are rejected unless enabled with SetControl.
+func (s *HttpServer) HandleControl(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !s.checkControl(w, req) {
+			return
+		}
+
+		handler.ServeHTTP(w, req)
+	}))
+}
+
+// Returns false if the request is sent by a page of another origin than the
+// server. Requests without an Origin header, such as those of scripts, are not
+// sent by a page.
+func isSameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	return err == nil && originURL.Host == req.Host
+}
+
+// Enables the requests that change the plot, such as PUT /options and the
+// handlers registered with HandleControl. They are disabled by default, as any
+// program that can reach the server could otherwise change the plot. Must be
+// called before Run.
+func (s *HttpServer) SetControl(enabled bool) {
+	s.control = enabled
+}
+
+// Rejects the request if the requests that change the plot are not enabled,
+// with SetControl or with a control token (see SetAccessTokens), or if it is
+// sent by a page of another origin. Browsers send such requests without asking
+// for CORS permissions first when they have no body or a text body, so a page
+// could otherwise change a wesplot on localhost.
+func (s *HttpServer) checkControl(w http.ResponseWriter, req *http.Request) bool {
+	if !s.control && s.controlToken == "" {
+		http.Error(w, "changing the plot is disabled, start wesplot with --control or --control-token", http.StatusForbidden)
+		return false
+	}
+
+	if !isSameOrigin(req) {
+		http.Error(w, "cross-origin requests cannot change the plot", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// Sets the compression of the /ws websocket. Must be called before Run. The
+// default is CompressionPerMessage, while the wesplot command defaults to
+// CompressionContextTakeover (see --compression).
+func (s *HttpServer) SetCompression(mode CompressionMode) {
+	s.compression = mode
+}
+
+// Listens on a unix domain socket if the address starts with unix: (e.g.
+// unix:/run/wesplot.sock), or on a TCP host:port otherwise. A stale socket
+// file left by a wesplot that was killed is removed. The socket file is
+// removed when the listener is closed, which happens when Run returns.
+func Listen(address string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(address, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", address)
+	}
+
+	info, err := os.Stat(path)
+	if err == nil && info.Mode()&fs.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is used by another process", path)
+		}
+
+		os.Remove(path)
+	}
+
+	return net.Listen("unix", path)
+}
+
+// Serves on the listener, such as one passed by systemd socket activation,
+// instead of listening on the host and port. Must be called before Run.
+func (s *HttpServer) SetListener(listener net.Listener) {
+	s.listener = listener
+}
+
+// Serves all the routes under the path instead of the root, for reverse
+// proxies that forward a path such as /wesplot/ without stripping it. The
+// requests to the path without the trailing slash are redirected. Must be
+// called before Run.
+func (s *HttpServer) SetBasePath(basePath string) {
+	s.basePath = "/" + strings.Trim(basePath, "/") + "/"
+	if s.basePath == "//" {
+		s.basePath = "/"
+	}
+}
+
+// Notifies systemd once the server is ready to serve and when it is shutting
+// down, and does not open the browser. Must be called before Run.
+func (s *HttpServer) SetSystemd(enabled bool) {
+	s.systemd = enabled
+}
+
+// Writes v as JSON with the same headers as the other JSON endpoints.
+func writeJSONResponse(w http.ResponseWriter, v any) {
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Allow-Headers", "content-type")
+	w.Header().Add("Access-Control-Allow-Methods", "*")
+	writeJSON(w, v)
+}
+
+// Same as writeJSONResponse, but without the CORS headers, for the requests
+// that change the plot, which the pages of other origins must not send.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Add("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
+
+type StatsMessage struct {
+	Input   InputStatsSnapshot
+	Series  []SeriesStatsSnapshot
+	Clients []ClientStatsSnapshot
+	Limits  MemoryLimits
+}
+
+func (s *HttpServer) handleStats(w http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(w, StatsMessage{
+		Input:   s.dataBroadcaster.stats.Snapshot(),
+		Series:  s.dataBroadcaster.seriesStats.Snapshot(),
+		Clients: s.dataBroadcaster.ClientStats(),
+		Limits:  s.memoryLimits(),
+	})
+}
+
+// Listens and serves forever.
 func (s *HttpServer) Run() error {
+	return s.RunContext(context.Background())
+}
+
+// Listens and serves until the context is canceled, at which point the server
+// is shut down gracefully.
+func (s *HttpServer) RunContext(ctx context.Context) error {
+	if s.listener != nil {
+		if s.listener.Addr().Network() == "unix" {
+			logrus.Infof("Plot is accessible via the unix socket %s", s.listener.Addr())
+			return s.serve(ctx, s.listener, "", "")
+		}
+
+		url := "http://" + s.listener.Addr().String() + s.basePath
+		return s.serve(ctx, s.listener, url, url)
+	}
+
 	tries := 0
 	var addr string
 	var listener net.Listener
@@ -221,9 +1053,8 @@ func (s *HttpServer) Run() error {
 	}
 
 	// These log lines don't need to be tagged (as that introduces more confusion)
-	url := fmt.Sprintf("http://%s:%d", s.host, s.port)
-	openBrowser(url)
-
+	url := fmt.Sprintf("http://%s:%d%s", s.host, s.port, s.basePath)
+	remoteURL := url
 	if s.host == "0.0.0.0" {
 		ifaces, err := net.Interfaces()
 		if err != nil {
@@ -247,15 +1078,80 @@ func (s *HttpServer) Run() error {
 
 				ipv4 := ip.To4()
 				if ipv4 != nil {
-					logrus.Infof("  - http://%s:%d", ipv4, s.port)
+					ipURL := fmt.Sprintf("http://%s:%d%s", ipv4, s.port, s.basePath)
+					logrus.Infof("  - %s", ipURL)
+					if remoteURL == url && !ipv4.IsLoopback() {
+						remoteURL = ipURL
+					}
 				}
 			}
 
 		}
-	} else {
-		logrus.Info("Plot is accessible at: %s", url)
 	}
 
-	server := http.Server{Addr: addr, Handler: s.mux}
-	return server.Serve(listener)
+	return s.serve(ctx, listener, url, remoteURL)
+}
+
+// Returns the mux under the base path, which checks the access tokens.
+func (s *HttpServer) handler() http.Handler {
+	handler := s.checkAccess(s.mux)
+	if s.basePath == "/" {
+		return handler
+	}
+
+	prefix := strings.TrimSuffix(s.basePath, "/")
+	mux := http.NewServeMux()
+	mux.Handle(s.basePath, http.StripPrefix(prefix, handler))
+	mux.Handle(prefix, http.RedirectHandler(s.basePath, http.StatusMovedPermanently))
+	return mux
+}
+
+// The urls are empty if the plot cannot be opened in the browser directly.
+// See openPlot.
+func (s *HttpServer) serve(ctx context.Context, listener net.Listener, url string, remoteURL string) error {
+	if url != "" && (s.listener != nil || s.host != "0.0.0.0") {
+		logrus.Infof("Plot is accessible at: %s", url)
+	}
+
+	if s.systemd {
+		err := SystemdNotify("READY=1")
+		if err != nil {
+			s.logger.WithError(err).Warn("cannot notify systemd")
+		}
+	}
+
+	if s.advertiser != nil {
+		stop, err := s.advertiser(listener, s.currentMetadata().WesplotOptions.Title, s.basePath)
+		if err != nil {
+			s.logger.WithError(err).Warn("cannot advertise the plot")
+		} else {
+			defer stop()
+		}
+	}
+
+	if url != "" {
+		s.openPlot(url, remoteURL)
+	}
+
+	server := http.Server{Handler: s.handler()}
+
+	go func() {
+		<-ctx.Done()
+
+		if s.systemd {
+			SystemdNotify("STOPPING=1")
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err := server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
 }
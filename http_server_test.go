@@ -0,0 +1,236 @@
+package wesplot
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// A batch of rows as flushed to a client at a high data rate.
+func benchmarkDataRows(numRows int, numColumns int) []DataRow {
+	random := rand.New(rand.NewSource(1))
+
+	dataRows := make([]DataRow, numRows)
+	for i := range dataRows {
+		dataRows[i] = DataRow{
+			X:  1700000000 + float64(i)*0.001,
+			Ys: make([]float64, numColumns),
+		}
+
+		for j := range dataRows[i].Ys {
+			dataRows[i].Ys[j] = random.NormFloat64() * 100
+		}
+	}
+
+	return dataRows
+}
+
+func benchmarkEncodeDataRows(b *testing.B, encoding rowEncoding) {
+	dataRows := benchmarkDataRows(1000, 4)
+	write := func(data []byte) error {
+		b.SetBytes(int64(len(data)))
+		return nil
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := encodeDataRows(dataRows, encoding, write)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeDataRows(b *testing.B) {
+	benchmarkEncodeDataRows(b, rowEncoding{})
+}
+
+func BenchmarkEncodeDataRowsFloat32(b *testing.B) {
+	benchmarkEncodeDataRows(b, protocolEncoding(ProtocolV1Float32))
+}
+
+func BenchmarkEncodeDataRowsDeltaFloat32(b *testing.B) {
+	benchmarkEncodeDataRows(b, protocolEncoding(ProtocolV1DeltaFloat32))
+}
+
+func BenchmarkEncodeDataRowsColumns(b *testing.B) {
+	benchmarkEncodeDataRows(b, protocolEncoding(ProtocolV1Columns))
+}
+
+func TestEncodeDataRowsDeltaX(t *testing.T) {
+	dataRows := benchmarkDataRows(1000, 4)
+	dataRows[500].X += 3600 // A gap in the data
+	dataRows[700].X = math.NaN()
+
+	buf := appendDataRowsJSON(nil, dataRows, protocolEncoding(ProtocolV1DeltaFloat32))
+
+	var decoded []struct {
+		X  *float64
+		DX *float64
+		Ys []float64
+	}
+
+	err := json.Unmarshal(buf, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != len(dataRows) {
+		t.Fatalf("decoded %d rows, expected %d", len(decoded), len(dataRows))
+	}
+
+	x := math.NaN()
+	for i, row := range decoded {
+		switch {
+		case row.X != nil:
+			x = *row.X
+		case row.DX != nil:
+			x += *row.DX
+		case math.IsNaN(dataRows[i].X):
+			// null, as NaN cannot be encoded, and the next row has an X.
+			x = math.NaN()
+			continue
+		default:
+			t.Fatalf("row %d has neither X nor DX", i)
+		}
+
+		// 7 significant digits of the interval, and the precision of X.
+		tolerance := 1e-6
+		if i > 0 {
+			tolerance += math.Abs(dataRows[i].X-dataRows[i-1].X) * 1e-6
+		}
+
+		if math.Abs(x-dataRows[i].X) > tolerance {
+			t.Fatalf("row %d has X %v, expected %v", i, x, dataRows[i].X)
+		}
+
+		if float32(row.Ys[0]) != float32(dataRows[i].Ys[0]) {
+			t.Fatalf("row %d has Y %v, expected %v as a float32", i, row.Ys[0], dataRows[i].Ys[0])
+		}
+	}
+
+	if decoded[0].X == nil || decoded[1].DX == nil || decoded[701].X == nil {
+		t.Fatal("expected an X in the first row and after NaN, and DX otherwise")
+	}
+}
+
+func TestEncodeDataRowsColumns(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []struct {
+		dataRows []DataRow
+		json     string
+	}{
+		{nil, `{"X":[],"Ys":[]}`},
+		{
+			[]DataRow{{X: 1, Ys: []float64{3, 5}}, {X: 2, Ys: []float64{4, 6}}},
+			`{"X":[1,2],"Ys":[[3,4],[5,6]]}`,
+		},
+		{
+			[]DataRow{{X: 1, Ys: []float64{nan, 5.5}}, {X: 2, Ys: []float64{4}}},
+			`{"X":[1,2],"Ys":[[null,4],[5.5,null]]}`,
+		},
+	}
+
+	for _, test := range tests {
+		buf := appendDataRowsJSON(nil, test.dataRows, protocolEncoding(ProtocolV1Columns))
+		if string(buf) != test.json {
+			t.Errorf("got %s, expected %s", buf, test.json)
+		}
+	}
+
+	// The values of every series are in the same order as the rows.
+	dataRows := benchmarkDataRows(100, 4)
+	var decoded struct {
+		X  []float64
+		Ys [][]float64
+	}
+
+	err := json.Unmarshal(appendDataRowsJSON(nil, dataRows, protocolEncoding(ProtocolV1Columns)), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.X) != len(dataRows) || len(decoded.Ys) != 4 {
+		t.Fatalf("decoded %d Xs and %d series, expected %d and 4", len(decoded.X), len(decoded.Ys), len(dataRows))
+	}
+
+	for i, dataRow := range dataRows {
+		if decoded.X[i] != dataRow.X {
+			t.Fatalf("row %d has X %v, expected %v", i, decoded.X[i], dataRow.X)
+		}
+
+		for j, y := range dataRow.Ys {
+			if decoded.Ys[j][i] != y {
+				t.Fatalf("row %d has Y %v in series %d, expected %v", i, decoded.Ys[j][i], j, y)
+			}
+		}
+	}
+}
+
+func TestEncodeDataRowsFloat32(t *testing.T) {
+	// As stored with --float32. X keeps the precision of float64.
+	dataRows := []DataRow{{X: 1700000000.123, Ys: []float64{float64(float32(0.1)), math.NaN()}}}
+
+	tests := []struct {
+		protocol string
+		json     string
+	}{
+		{ProtocolV1, `[{"X":1.700000000123e+09,"Ys":[0.10000000149011612,null]}]`},
+		{ProtocolV1Float32, `[{"X":1.700000000123e+09,"Ys":[0.1,null]}]`},
+	}
+
+	for _, test := range tests {
+		buf := appendDataRowsJSON(nil, dataRows, protocolEncoding(test.protocol))
+		if string(buf) != test.json {
+			t.Errorf("%q: got %s, expected %s", test.protocol, buf, test.json)
+		}
+	}
+}
+
+func TestEncodeDataRowsSequenced(t *testing.T) {
+	dataRows := []DataRow{{X: 1, Ys: []float64{3}, seq: 7}, {X: 2, Ys: []float64{4}, seq: 8}}
+
+	tests := []struct {
+		protocol string
+		json     string
+	}{
+		{"", `[{"Seq":7,"X":1,"Ys":[3]},{"Seq":8,"X":2,"Ys":[4]}]`},
+		{ProtocolV1DeltaFloat32, `[{"Seq":7,"X":1,"Ys":[3]},{"Seq":8,"DX":1,"Ys":[4]}]`},
+		{ProtocolV1Columns, `{"Seq":[7,8],"X":[1,2],"Ys":[[3,4]]}`},
+	}
+
+	for _, test := range tests {
+		encoding := protocolEncoding(test.protocol)
+		encoding.sequenced = true
+
+		buf := appendDataRowsJSON(nil, dataRows, encoding)
+		if string(buf) != test.json {
+			t.Errorf("%q: got %s, expected %s", test.protocol, buf, test.json)
+		}
+	}
+}
+
+func TestRoundSignificant(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected float64
+	}{
+		{0, 0},
+		{0.001, 0.001},
+		{0.0010000000474974513, 0.001},
+		{123456789, 123456800},
+		{-1.23456789, -1.234568},
+		{3600.0010000467, 3600.001},
+	}
+
+	for _, test := range tests {
+		rounded := roundSignificant(test.value, 7)
+		if rounded != test.expected {
+			t.Errorf("roundSignificant(%v, 7) = %v, expected %v", test.value, rounded, test.expected)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package wesplot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Reads points in the InfluxDB line protocol, such as the output of Telegraf,
+// from a StringReader returning whole lines (see LineStringReader and
+// NewSocketLineReader).
+//
+// Every numeric field of a point is labeled with its measurement and its key
+// (e.g. cpu.usage_idle), followed by the tags of the point sorted by their
+// key (e.g. cpu.usage_idle cpu=cpu0 host=a), so the points of every host are
+// plotted as different series. Integers are read as floats, booleans as 0 and
+// 1, and strings are ignored. The X value is the timestamp of the point (in
+// nanoseconds), or the receive timestamp if it has none.
+func NewInfluxLineDataRowReader(input StringReader, columns []string) *LabeledDataRowReader {
+	return newLabeledLineDataRowReader(input, columns, parseInfluxLine, "InfluxLine")
+}
+
+// Parses a line such as `cpu,host=a usage_idle=92.5,usage_user=3i 1700000000000000000`
+// into labeled values, as documented on NewInfluxLineDataRowReader.
+func parseInfluxLine(line string) (labeledPoint, error) {
+	point := labeledPoint{values: make(map[string]float64)}
+
+	sections := splitInflux(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return point, errors.New("expected a measurement, fields and an optional timestamp separated by spaces")
+	}
+
+	series := splitInflux(sections[0], ',')
+	measurement := unescapeInflux(series[0])
+	if measurement == "" {
+		return point, errors.New("missing measurement")
+	}
+
+	tags := make([]string, 0, len(series)-1)
+	for _, tag := range series[1:] {
+		key, value, ok := cutInflux(tag)
+		if !ok {
+			return point, fmt.Errorf("invalid tag %q", tag)
+		}
+
+		tags = append(tags, key+"="+value)
+	}
+
+	sort.Strings(tags)
+	suffix := ""
+	if len(tags) > 0 {
+		suffix = " " + strings.Join(tags, " ")
+	}
+
+	for _, field := range splitInflux(sections[1], ',') {
+		key, text, ok := cutInflux(field)
+		if !ok {
+			return point, fmt.Errorf("invalid field %q", field)
+		}
+
+		value, ok, err := parseInfluxValue(text)
+		if err != nil {
+			return point, fmt.Errorf("invalid value of field %s: %w", key, err)
+		} else if !ok {
+			continue
+		}
+
+		point.values[measurement+"."+key+suffix] = value
+	}
+
+	if len(sections) == 3 {
+		timestamp, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return point, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+
+		point.hasTimestamp = true
+		point.timestamp = float64(timestamp) / 1e9
+	}
+
+	return point, nil
+}
+
+// Returns false if the value is a string, which cannot be plotted.
+func parseInfluxValue(text string) (float64, bool, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		return 0, false, nil
+	case text == "t" || text == "T" || text == "true" || text == "True" || text == "TRUE":
+		return 1, true, nil
+	case text == "f" || text == "F" || text == "false" || text == "False" || text == "FALSE":
+		return 0, true, nil
+	case strings.HasSuffix(text, "i"):
+		value, err := strconv.ParseInt(text[:len(text)-1], 10, 64)
+		return float64(value), true, err
+	case strings.HasSuffix(text, "u"):
+		value, err := strconv.ParseUint(text[:len(text)-1], 10, 64)
+		return float64(value), true, err
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	return value, true, err
+}
+
+// Splits on the separator, except where it is escaped with a backslash or in
+// a double quoted string. Consecutive spaces are a single separator.
+func splitInflux(s string, separator byte) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == separator && !quoted:
+			if separator != ' ' || i > start {
+				parts = append(parts, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+
+	if separator != ' ' || len(s) > start {
+		parts = append(parts, s[start:])
+	}
+
+	return parts
+}
+
+// Splits a key=value pair at the first unescaped equal sign, and unescapes
+// the key and the value.
+func cutInflux(pair string) (string, string, bool) {
+	for i := 0; i < len(pair); i++ {
+		switch pair[i] {
+		case '\\':
+			i++
+		case '=':
+			key := unescapeInflux(pair[:i])
+			return key, unescapeInflux(pair[i+1:]), key != "" && i+1 < len(pair)
+		}
+	}
+
+	return "", "", false
+}
+
+// Removes the backslashes escaping commas, equal signs and spaces. String
+// field values are not unescaped, as they are ignored.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, =`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,288 @@
+package wesplot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// Serves POST /ingest, which allows scripts in any language to push rows to a
+// running wesplot over HTTP. The rows are sent into a ChannelDataRowReader,
+// which should be the input of the DataBroadcaster.
+//
+// The body can be:
+//
+//   - JSON (Content-Type: application/json): a row object or an array of row
+//     objects such as [{"X": 1.5, "Ys": [1, 2]}, ...]. If X is omitted, the
+//     current timestamp is used. null values in Ys are missing values.
+//   - Text (any other content type): lines in the same format as stdin, which
+//     are parsed by the DataRowReader created by newTextReader.
+//
+// The response contains the number of rows accepted and ignored.
+//
+// Requests sent by the pages of other origins are rejected, as browsers send
+// POST requests with a text body without asking for CORS permissions first, so
+// any page could otherwise push data into a wesplot on localhost.
+type IngestHandler struct {
+	output        *ChannelDataRowReader
+	newTextReader func(io.Reader) DataRowReader
+
+	logger logrus.FieldLogger
+}
+
+// The largest body accepted, so a client cannot exhaust the memory of the
+// server with a single request.
+const maxIngestBodySize = 32 << 20
+
+type IngestResponse struct {
+	Accepted int
+	Ignored  int
+}
+
+type ingestRow struct {
+	X  *float64
+	Ys []*float64
+	Xs []*float64 // The X of every series, if they have their own X (see DataRow.Xs)
+	C  *float64   // The color of the points, if any (see DataRow.color)
+}
+
+// Returns the values decoded from JSON with NaN for the null values, or nil if
+// values is nil, such as the Xs of a row without Xs.
+func decodeNullableFloats(values []*float64) []float64 {
+	if values == nil {
+		return nil
+	}
+
+	floats := make([]float64, len(values))
+	for i, value := range values {
+		if value == nil {
+			floats[i] = math.NaN()
+		} else {
+			floats[i] = *value
+		}
+	}
+
+	return floats
+}
+
+func NewIngestHandler(output *ChannelDataRowReader, newTextReader func(io.Reader) DataRowReader) *IngestHandler {
+	return &IngestHandler{
+		output:        output,
+		newTextReader: newTextReader,
+		logger:        logrus.WithField("tag", "IngestHandler"),
+	}
+}
+
+func (h *IngestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isSameOrigin(req) {
+		http.Error(w, "cross-origin requests cannot ingest data", http.StatusForbidden)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxIngestBodySize)
+
+	var dataRows []DataRow
+	var response IngestResponse
+	var err error
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		dataRows, err = h.parseJSON(req.Body)
+	} else {
+		dataRows, response.Ignored, err = h.parseText(req)
+	}
+
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		http.Error(w, fmt.Sprintf("the body is larger than %d bytes", maxBytesError.Limit), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		h.logger.WithError(err).Warn("cannot parse ingest request")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.send(dataRows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response.Accepted = len(dataRows)
+	writeJSON(w, response)
+}
+
+// Serves /ws-ingest, which allows a remote producer to stream rows over a
+// single websocket connection instead of issuing a request per batch. Every
+// text message must be JSON in the same format as the POST body, which is also
+// the format of the messages sent on /ws. This means the data of another
+// wesplot instance can be forwarded as is, which splits the collector and the
+// viewer across hosts.
+//
+// Invalid messages are logged and skipped without closing the connection. As
+// with POST /ingest, the connections of the pages of other origins are
+// rejected.
+func (h *IngestHandler) ServeWebSocket(w http.ResponseWriter, req *http.Request) {
+	// Without OriginPatterns, the connections whose Origin is not the host of the
+	// server are rejected.
+	c, err := websocket.Accept(w, req, &websocket.AcceptOptions{
+		Subprotocols: ingestProtocols,
+	})
+	if err != nil {
+		h.logger.WithError(err).Warn("failed to accept new ingest websocket connection")
+		return
+	}
+
+	if !negotiateProtocol(c, req, ingestProtocols) {
+		h.logger.WithField("protocols", req.Header.Get("Sec-WebSocket-Protocol")).Warn("rejected ingest websocket connection with unsupported protocol")
+		return
+	}
+	defer c.Close(websocket.StatusInternalError, "")
+
+	// Batches can be larger than the default limit of 32KiB.
+	c.SetReadLimit(maxIngestBodySize)
+
+	logger := h.logger.WithField("remote", req.RemoteAddr)
+	logger.Info("ingest websocket connected")
+
+	for {
+		messageType, data, err := c.Read(req.Context())
+		if err != nil {
+			status := websocket.CloseStatus(err)
+			if status == websocket.StatusNormalClosure || status == websocket.StatusGoingAway {
+				logger.Info("ingest websocket closed")
+				c.Close(websocket.StatusNormalClosure, "")
+			} else {
+				logger.WithError(err).Warn("ingest websocket read failed")
+			}
+			return
+		}
+
+		if messageType != websocket.MessageText {
+			logger.Warn("ignoring binary message on ingest websocket, only JSON text messages are supported")
+			continue
+		}
+
+		dataRows, err := h.parseJSON(bytes.NewReader(data))
+		if err == nil {
+			err = h.send(dataRows)
+		}
+
+		if err != nil {
+			logger.WithError(err).Warn("ignoring invalid message on ingest websocket")
+		}
+	}
+}
+
+// Validates the whole batch before sending any of it, so a bad request does
+// not result in partially ingested data.
+func (h *IngestHandler) send(dataRows []DataRow) error {
+	columns := h.output.ColumnNames()
+	for _, dataRow := range dataRows {
+		// Marker lines in the text body.
+		if dataRow.annotation != nil {
+			continue
+		}
+
+		if len(dataRow.Ys) != len(columns) {
+			return fmt.Errorf("expected %d values per row, got %d", len(columns), len(dataRow.Ys))
+		}
+
+		if err := dataRow.checkXs(); err != nil {
+			return err
+		}
+	}
+
+	for _, dataRow := range dataRows {
+		err := h.output.Send(dataRow)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *IngestHandler) parseJSON(body io.Reader) ([]DataRow, error) {
+	var raw json.RawMessage
+	err := json.NewDecoder(body).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []ingestRow
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+		err = json.Unmarshal(raw, &rows)
+	} else {
+		rows = make([]ingestRow, 1)
+		err = json.Unmarshal(raw, &rows[0])
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	dataRows := make([]DataRow, 0, len(rows))
+	for _, row := range rows {
+		dataRow := DataRow{
+			Ys: make([]float64, len(row.Ys)),
+		}
+
+		for i, y := range row.Ys {
+			if y == nil {
+				dataRow.Ys[i] = math.NaN()
+			} else {
+				dataRow.Ys[i] = *y
+			}
+		}
+
+		dataRow.Xs = decodeNullableFloats(row.Xs)
+
+		if row.C != nil {
+			dataRow.color = *row.C
+			dataRow.hasColor = true
+		}
+
+		if row.X != nil {
+			dataRow.X = *row.X
+		} else {
+			dataRow.X = NowXGenerator(dataRow.Ys)
+		}
+
+		dataRows = append(dataRows, dataRow)
+	}
+
+	return dataRows, nil
+}
+
+func (h *IngestHandler) parseText(req *http.Request) ([]DataRow, int, error) {
+	reader := h.newTextReader(req.Body)
+	dataRows := make([]DataRow, 0)
+	ignored := 0
+
+	for {
+		dataRow, err := reader.Read(req.Context())
+		if errors.Is(err, errIgnoreThisRow) {
+			ignored++
+			continue
+		} else if err == io.EOF {
+			return dataRows, ignored, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		dataRows = append(dataRows, dataRow)
+	}
+}
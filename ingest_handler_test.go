@@ -0,0 +1,249 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+func newTestIngestHandler() *IngestHandler {
+	columns := []string{"a", "b"}
+	return NewIngestHandler(NewChannelDataRowReader(columns, 100), func(body io.Reader) DataRowReader {
+		return &TextToDataRowReader{
+			Input:                  NewRelaxedStringReader(body),
+			XIndex:                 0,
+			ColorIndex:             -1,
+			Columns:                columns,
+			ExpectExactColumnCount: true,
+			MissingValues:          []string{"-", ""},
+		}
+	})
+}
+
+func TestIngestHandler(t *testing.T) {
+	nan := math.NaN()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		origin      string
+		body        string
+		status      int
+		response    IngestResponse
+		dataRows    []DataRow
+	}{
+		{
+			name:        "json row",
+			contentType: "application/json",
+			body:        `{"X": 1, "Ys": [2, 3]}`,
+			status:      http.StatusOK,
+			response:    IngestResponse{Accepted: 1},
+			dataRows:    []DataRow{{X: 1, Ys: []float64{2, 3}}},
+		},
+		{
+			name:        "json rows with missing values",
+			contentType: "application/json; charset=utf-8",
+			body:        `[{"X": 1, "Ys": [2, null]}, {"X": 2, "Ys": [4, 5]}]`,
+			status:      http.StatusOK,
+			response:    IngestResponse{Accepted: 2},
+			dataRows:    []DataRow{{X: 1, Ys: []float64{2, nan}}, {X: 2, Ys: []float64{4, 5}}},
+		},
+		{
+			name:     "text rows",
+			body:     "1,2,3\n2,-,5\nabc,1,2\n",
+			status:   http.StatusOK,
+			response: IngestResponse{Accepted: 2, Ignored: 1},
+			dataRows: []DataRow{{X: 1, Ys: []float64{2, 3}}, {X: 2, Ys: []float64{nan, 5}}},
+		},
+		{
+			name:     "same origin",
+			origin:   "http://example.com",
+			body:     "1,2,3\n",
+			status:   http.StatusOK,
+			response: IngestResponse{Accepted: 1},
+			dataRows: []DataRow{{X: 1, Ys: []float64{2, 3}}},
+		},
+		{
+			name:        "wrong number of values",
+			contentType: "application/json",
+			body:        `[{"X": 1, "Ys": [2, 3]}, {"X": 2, "Ys": [4]}]`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "series with their own x",
+			contentType: "application/json",
+			body:        `{"X": 2, "Ys": [2, 3], "Xs": [1, 2]}`,
+			status:      http.StatusOK,
+			response:    IngestResponse{Accepted: 1},
+			dataRows:    []DataRow{{X: 2, Ys: []float64{2, 3}, Xs: []float64{1, 2}}},
+		},
+		{
+			name:        "wrong number of xs",
+			contentType: "application/json",
+			body:        `{"X": 2, "Ys": [2, 3], "Xs": [1]}`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "invalid json",
+			contentType: "application/json",
+			body:        `{"X": 1, "Ys": [2, 3]`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:   "cross origin",
+			origin: "http://evil.example.org",
+			body:   "1,2,3\n",
+			status: http.StatusForbidden,
+		},
+		{
+			name:        "body too large",
+			contentType: "application/json",
+			body:        strings.Repeat(" ", maxIngestBodySize) + `{"X": 1, "Ys": [2, 3]}`,
+			status:      http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "get",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "cors preflight",
+			method: http.MethodOptions,
+			origin: "http://evil.example.org",
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		handler := newTestIngestHandler()
+
+		method := test.method
+		if method == "" {
+			method = http.MethodPost
+		}
+
+		req := httptest.NewRequest(method, "http://example.com/ingest", strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: got status %d, expected %d: %s", test.name, recorder.Code, test.status, recorder.Body)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var response IngestResponse
+		err := json.NewDecoder(recorder.Body).Decode(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response != test.response {
+			t.Errorf("%s: got response %+v, expected %+v", test.name, response, test.response)
+		}
+
+		for _, expected := range test.dataRows {
+			dataRow, err := handler.output.Read(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dataRow.X != expected.X || !equalFloats(dataRow.Ys, expected.Ys) || !equalFloats(dataRow.Xs, expected.Xs) {
+				t.Errorf("%s: got %v, expected %v", test.name, dataRow, expected)
+			}
+		}
+	}
+}
+
+func TestIngestHandlerNowX(t *testing.T) {
+	handler := newTestIngestHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"Ys": [2, 3]}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	dataRow, err := handler.output.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without X, the current timestamp is used.
+	if dataRow.X < 1e9 {
+		t.Errorf("expected the current timestamp as X, got %v", dataRow.X)
+	}
+}
+
+func TestIngestHandlerWebSocket(t *testing.T) {
+	handler := newTestIngestHandler()
+	server := httptest.NewServer(http.HandlerFunc(handler.ServeWebSocket))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The connections of the pages of other origins are rejected.
+	_, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": []string{"http://evil.example.org"}},
+	})
+	if err == nil {
+		t.Error("expected the cross-origin connection to be rejected")
+	}
+
+	c, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(websocket.StatusNormalClosure, "")
+
+	// The invalid message is skipped without closing the connection.
+	messages := []string{
+		`[{"X": 1, "Ys": [2, 3]}]`,
+		`[{"X": 2, "Ys": [4]}]`,
+		`{"X": 3, "Ys": [null, 5]}`,
+	}
+
+	for _, message := range messages {
+		err = c.Write(ctx, websocket.MessageText, []byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []DataRow{{X: 1, Ys: []float64{2, 3}}, {X: 3, Ys: []float64{math.NaN(), 5}}}
+	for _, expectedRow := range expected {
+		dataRow, err := handler.output.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dataRow.X != expectedRow.X || !equalFloats(dataRow.Ys, expectedRow.Ys) {
+			t.Errorf("got %v, expected %v", dataRow, expectedRow)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons for ignoring rows, used as keys in InputStatsSnapshot.RowsDropped.
+const (
+	DropReasonXParse        = "x_parse"
+	DropReasonXMissing      = "x_missing"
+	DropReasonYParse        = "y_parse"
+	DropReasonColumnCount   = "column_count"
+	DropReasonCsvParse      = "csv_parse"
+	DropReasonRegexMismatch = "regex_mismatch"
+	DropReasonPayloadParse  = "payload_parse"
+	DropReasonPaused        = "paused"
+	DropReasonXRange        = "x_range"
+	DropReasonOther         = "other"
+)
+
+// Returned by the readers when a row should be ignored. This wraps
+// errIgnoreThisRow with the reason the row is ignored, which is tracked by
+// the InputStats.
+type ignoredRowError struct {
+	reason string
+}
+
+func ignoreRow(reason string) error {
+	return ignoredRowError{reason: reason}
+}
+
+func (e ignoredRowError) Error() string {
+	return errIgnoreThisRow.Error() + ": " + e.reason
+}
+
+func (e ignoredRowError) Is(target error) bool {
+	return target == errIgnoreThisRow
+}
+
+func ignoredRowReason(err error) string {
+	var ignoredErr ignoredRowError
+	if errors.As(err, &ignoredErr) {
+		return ignoredErr.reason
+	}
+
+	return DropReasonOther
+}
+
+// Tracks statistics about the input pipeline so users can tell if their data
+// is ingested or ignored. The counters are updated by the DataBroadcaster and
+// the CountingReader, and can be read concurrently via Snapshot.
+type InputStats struct {
+	startTime time.Time
+
+	bytesRead   atomic.Int64
+	rowsEmitted atomic.Int64
+
+	// Y values changed by the ClipDataRowReader and the OutlierDataRowReader.
+	valuesClipped   atomic.Int64
+	outliersDropped atomic.Int64
+
+	mutex       sync.Mutex
+	rowsDropped map[string]int64
+
+	// Updated by Start once per second.
+	rowsPerSecond atomic.Uint64 // float64 bits
+}
+
+type InputStatsSnapshot struct {
+	Uptime        float64 // seconds
+	BytesRead     int64
+	RowsRead      int64 // RowsEmitted + all RowsDropped
+	RowsEmitted   int64
+	RowsDropped   map[string]int64
+	RowsPerSecond float64 // Rows emitted in the last second
+
+	ValuesClipped   int64 // Y values clamped to --clip-y
+	OutliersDropped int64 // Y values replaced by NaN by --drop-outliers
+}
+
+func NewInputStats() *InputStats {
+	return &InputStats{
+		startTime:   time.Now(),
+		rowsDropped: make(map[string]int64),
+	}
+}
+
+func (s *InputStats) AddBytesRead(n int) {
+	s.bytesRead.Add(int64(n))
+}
+
+func (s *InputStats) RowEmitted() {
+	s.rowsEmitted.Add(1)
+}
+
+func (s *InputStats) ValueClipped() {
+	s.valuesClipped.Add(1)
+}
+
+func (s *InputStats) OutlierDropped() {
+	s.outliersDropped.Add(1)
+}
+
+func (s *InputStats) RowDropped(reason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rowsDropped[reason]++
+}
+
+func (s *InputStats) Snapshot() InputStatsSnapshot {
+	snapshot := InputStatsSnapshot{
+		Uptime:        time.Since(s.startTime).Seconds(),
+		BytesRead:     s.bytesRead.Load(),
+		RowsEmitted:   s.rowsEmitted.Load(),
+		RowsPerSecond: math.Float64frombits(s.rowsPerSecond.Load()),
+
+		ValuesClipped:   s.valuesClipped.Load(),
+		OutliersDropped: s.outliersDropped.Load(),
+	}
+
+	snapshot.RowsRead = snapshot.RowsEmitted
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot.RowsDropped = make(map[string]int64, len(s.rowsDropped))
+	for reason, count := range s.rowsDropped {
+		snapshot.RowsDropped[reason] = count
+		snapshot.RowsRead += count
+	}
+
+	return snapshot
+}
+
+// Periodically computes the row rate and logs a one line summary every
+// logInterval. If logInterval is 0, the summary is not logged. Returns when
+// the context is canceled.
+func (s *InputStats) Start(ctx context.Context, logInterval time.Duration) {
+	logger := logrus.WithField("tag", "InputStats")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastRowsEmitted := s.rowsEmitted.Load()
+	lastTickTime := time.Now()
+	lastLogTime := lastTickTime
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			rowsEmitted := s.rowsEmitted.Load()
+			rate := float64(rowsEmitted-lastRowsEmitted) / now.Sub(lastTickTime).Seconds()
+			s.rowsPerSecond.Store(math.Float64bits(rate))
+
+			lastRowsEmitted = rowsEmitted
+			lastTickTime = now
+
+			if logInterval <= 0 || now.Sub(lastLogTime) < logInterval {
+				continue
+			}
+
+			lastLogTime = now
+			snapshot := s.Snapshot()
+			logger.WithFields(logrus.Fields{
+				"bytesRead":   snapshot.BytesRead,
+				"rowsRead":    snapshot.RowsRead,
+				"rowsEmitted": snapshot.RowsEmitted,
+				"rowsDropped": snapshot.RowsDropped,
+				"rowsPerSec":  snapshot.RowsPerSecond,
+			}).Info("input summary")
+		}
+	}
+}
+
+// An io.Reader that counts the bytes read into the InputStats.
+type CountingReader struct {
+	input io.Reader
+	stats *InputStats
+}
+
+func NewCountingReader(input io.Reader, stats *InputStats) *CountingReader {
+	return &CountingReader{
+		input: input,
+		stats: stats,
+	}
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.input.Read(p)
+	r.stats.AddBytesRead(n)
+	return n, err
+}
@@ -0,0 +1,201 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The rows or the error read from one of the inputs of a JoinDataRowReader.
+type joinInputResult struct {
+	dataRow DataRow
+	err     error
+}
+
+// A row of the primary input waiting for the rows of the secondary input
+// around its X.
+type pendingJoinRow struct {
+	dataRow  DataRow
+	received time.Time
+}
+
+// A DataRowReader that joins the rows of two inputs sampled independently,
+// such as the latency of ping and the CPU load of vmstat, into rows with the
+// columns of both, so they can be compared on a single chart. Every row of the
+// primary input is emitted with the Ys of the row of the secondary input with
+// the nearest X, or with missing Ys (which the clients plot as gaps) if there
+// is none within the tolerance. The rows of the secondary input only set the
+// Ys of the primary rows, and are not emitted on their own.
+//
+// The X values are normally the receive timestamps of both inputs. A primary
+// row is emitted once the secondary input has a row at least the tolerance
+// after it, or once it waited for the tolerance, so the inputs should be read
+// as they are produced, and the rows are delayed by the tolerance at most.
+type JoinDataRowReader struct {
+	primary   DataRowReader
+	secondary DataRowReader
+	tolerance time.Duration
+
+	primaryRows   chan joinInputResult
+	secondaryRows chan joinInputResult
+
+	// The primary rows not emitted yet, in the order they were read.
+	pending []pendingJoinRow
+	// The error that ended the primary input, once the pending rows are
+	// emitted.
+	primaryErr error
+
+	// The secondary rows that can still be joined, in the order they were
+	// read.
+	secondaryBuffer []DataRow
+	secondaryMaxX   float64
+	secondaryEnded  bool
+
+	logger logrus.FieldLogger
+}
+
+func NewJoinDataRowReader(primary DataRowReader, secondary DataRowReader, tolerance time.Duration) *JoinDataRowReader {
+	return &JoinDataRowReader{
+		primary:       primary,
+		secondary:     secondary,
+		tolerance:     tolerance,
+		secondaryMaxX: math.Inf(-1),
+		logger:        logrus.WithField("tag", "Join"),
+	}
+}
+
+func (r *JoinDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if r.primaryRows == nil {
+		r.primaryRows = make(chan joinInputResult, bufferSize)
+		r.secondaryRows = make(chan joinInputResult, bufferSize)
+		go readJoinInput(ctx, r.primary, r.primaryRows)
+		go readJoinInput(ctx, r.secondary, r.secondaryRows)
+	}
+
+	for {
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if len(r.pending) > 0 {
+			first := r.pending[0]
+			wait := r.tolerance - time.Since(first.received)
+			if r.secondaryEnded || r.secondaryMaxX >= first.dataRow.X+r.tolerance.Seconds() || wait <= 0 {
+				r.pending = r.pending[1:]
+				return r.join(first.dataRow), nil
+			}
+
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		} else if r.primaryErr != nil {
+			return DataRow{}, r.primaryErr
+		}
+
+		// Nil channels block, so the inputs that ended are not read.
+		primaryRows := r.primaryRows
+		if r.primaryErr != nil {
+			primaryRows = nil
+		}
+
+		secondaryRows := r.secondaryRows
+		if r.secondaryEnded {
+			secondaryRows = nil
+		}
+
+		select {
+		case result := <-primaryRows:
+			if errors.Is(result.err, errIgnoreThisRow) {
+				// Returned as is, so the ignored rows are counted.
+				return DataRow{}, result.err
+			} else if result.err != nil {
+				r.primaryErr = result.err
+			} else if result.dataRow.annotation != nil || result.dataRow.gap {
+				return result.dataRow, nil
+			} else {
+				r.pending = append(r.pending, pendingJoinRow{dataRow: result.dataRow, received: time.Now()})
+			}
+		case result := <-secondaryRows:
+			if errors.Is(result.err, errIgnoreThisRow) {
+				// The ignored secondary rows leave the columns missing.
+			} else if result.err == io.EOF {
+				r.logger.Info("the joined input ended, its columns are now missing")
+				r.secondaryEnded = true
+			} else if result.err != nil {
+				r.logger.WithError(result.err).Warn("the joined input failed, its columns are now missing")
+				r.secondaryEnded = true
+			} else if result.dataRow.annotation == nil && !result.dataRow.gap {
+				r.secondaryBuffer = append(r.secondaryBuffer, result.dataRow)
+				r.secondaryMaxX = math.Max(r.secondaryMaxX, result.dataRow.X)
+			}
+		case <-timeout:
+		case <-ctx.Done():
+			return DataRow{}, ctx.Err()
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (r *JoinDataRowReader) ColumnNames() []string {
+	columns := make([]string, 0, len(r.primary.ColumnNames())+len(r.secondary.ColumnNames()))
+	columns = append(columns, r.primary.ColumnNames()...)
+	return append(columns, r.secondary.ColumnNames()...)
+}
+
+// Reads the rows of an input into the channel until it ends. The ignored rows
+// are sent as well.
+func readJoinInput(ctx context.Context, input DataRowReader, results chan<- joinInputResult) {
+	for {
+		dataRow, err := input.Read(ctx)
+
+		select {
+		case results <- joinInputResult{dataRow: dataRow, err: err}:
+		case <-ctx.Done():
+			return
+		}
+
+		if err != nil && !errors.Is(err, errIgnoreThisRow) {
+			return
+		}
+	}
+}
+
+// Appends the Ys of the nearest secondary row within the tolerance to the
+// primary row, and forgets the secondary rows that are too old to be joined to
+// the next primary rows.
+func (r *JoinDataRowReader) join(dataRow DataRow) DataRow {
+	tolerance := r.tolerance.Seconds()
+
+	var nearest *DataRow
+	for i := range r.secondaryBuffer {
+		distance := math.Abs(r.secondaryBuffer[i].X - dataRow.X)
+		if distance <= tolerance && (nearest == nil || distance < math.Abs(nearest.X-dataRow.X)) {
+			nearest = &r.secondaryBuffer[i]
+		}
+	}
+
+	numColumns := len(r.secondary.ColumnNames())
+	ys := make([]float64, 0, len(dataRow.Ys)+numColumns)
+	ys = append(ys, dataRow.Ys...)
+	if nearest != nil {
+		ys = append(ys, nearest.Ys...)
+	} else {
+		for i := 0; i < numColumns; i++ {
+			ys = append(ys, math.NaN())
+		}
+	}
+
+	dataRow.Ys = ys
+
+	old := 0
+	for old < len(r.secondaryBuffer) && r.secondaryBuffer[old].X < dataRow.X-tolerance {
+		old++
+	}
+
+	r.secondaryBuffer = r.secondaryBuffer[old:]
+	return dataRow
+}
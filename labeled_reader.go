@@ -0,0 +1,126 @@
+package wesplot
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The values of a point of a protocol where every value is labeled with the
+// series it belongs to, such as a line of the InfluxDB line protocol.
+type labeledPoint struct {
+	values       map[string]float64 // By label
+	hasTimestamp bool
+	timestamp    float64 // In seconds
+}
+
+// A DataRowReader that reads a protocol where every value is labeled with its
+// series, such as the lines of the InfluxDB line protocol (see
+// NewInfluxLineDataRowReader) or the packets of collectd (see
+// NewCollectdDataRowReader).
+//
+// As with the MqttDataRowReader, every point emits a row containing the most
+// recent value of every column, once every column has received at least one
+// value. Values with labels not in the columns are ignored. The X value is the
+// timestamp of the point, or the receive timestamp if it has none.
+type LabeledDataRowReader struct {
+	read    func(ctx context.Context) (labeledPoint, error)
+	columns []string
+
+	// Maps the column labels to the index in columns.
+	columnIndices map[string]int
+	lastValues    []float64
+	seenColumns   int
+
+	logger logrus.FieldLogger
+}
+
+func newLabeledDataRowReader(read func(ctx context.Context) (labeledPoint, error), columns []string, logger logrus.FieldLogger) *LabeledDataRowReader {
+	r := &LabeledDataRowReader{
+		read:          read,
+		columns:       columns,
+		columnIndices: make(map[string]int, len(columns)),
+		lastValues:    make([]float64, len(columns)),
+		logger:        logger,
+	}
+
+	for i, column := range columns {
+		r.columnIndices[column] = i
+		r.lastValues[i] = math.NaN()
+	}
+
+	return r
+}
+
+// Reads the points from the lines returned whole by the StringReader (see
+// LineStringReader and NewSocketLineReader). Empty lines and lines starting
+// with # are ignored.
+func newLabeledLineDataRowReader(input StringReader, columns []string, parse func(line string) (labeledPoint, error), tag string) *LabeledDataRowReader {
+	logger := logrus.WithField("tag", tag)
+	read := func(ctx context.Context) (labeledPoint, error) {
+		fields, err := input.Read(ctx)
+		if err != nil {
+			return labeledPoint{}, err
+		}
+
+		if len(fields) == 0 || fields[0] == "" || strings.HasPrefix(fields[0], "#") {
+			return labeledPoint{}, errIgnoreThisRow
+		}
+
+		point, err := parse(fields[0])
+		if err != nil {
+			logger.WithError(err).WithField("line", fields[0]).Warn("cannot parse line, ignoring...")
+			return labeledPoint{}, ignoreRow(DropReasonPayloadParse)
+		}
+
+		return point, nil
+	}
+
+	return newLabeledDataRowReader(read, columns, logger)
+}
+
+func (r *LabeledDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	point, err := r.read(ctx)
+	if err != nil {
+		return DataRow{}, err
+	}
+
+	updated := false
+	for label, value := range point.values {
+		i, ok := r.columnIndices[label]
+		if !ok {
+			r.logger.WithField("label", label).Debug("value not in columns, ignoring...")
+			continue
+		}
+
+		if math.IsNaN(r.lastValues[i]) {
+			r.seenColumns++
+		}
+
+		r.lastValues[i] = value
+		updated = true
+	}
+
+	if !updated || r.seenColumns < len(r.columns) {
+		return DataRow{}, errIgnoreThisRow
+	}
+
+	dataRow := DataRow{
+		Ys: make([]float64, len(r.lastValues)),
+	}
+
+	copy(dataRow.Ys, r.lastValues)
+	if point.hasTimestamp {
+		dataRow.X = point.timestamp
+	} else {
+		dataRow.X = NowXGenerator(dataRow.Ys)
+	}
+
+	return dataRow, nil
+}
+
+func (r *LabeledDataRowReader) ColumnNames() []string {
+	return r.columns
+}
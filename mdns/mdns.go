@@ -0,0 +1,44 @@
+// Package mdns advertises the plots of wesplot on the local network via mDNS,
+// so they can be found with wesplot discover or any zeroconf browser. It is
+// separate from the wesplot package so the programs embedding wesplot do not
+// depend on zeroconf unless they use it.
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/grandcat/zeroconf"
+)
+
+// The mDNS service type of the plots advertised with Advertise. The TXT record
+// contains the title of the plot and the base path of the server, such as
+// title=Latency and path=/.
+const Service = "_wesplot._tcp"
+
+// Advertises the plot served on the listener until stop is called. This is a
+// wesplot.Advertiser, to be passed to HttpServer.SetAdvertiser.
+func Advertise(listener net.Listener, title string, basePath string) (stop func(), err error) {
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("cannot advertise a server listening on %s", listener.Addr())
+	}
+
+	// The instance names must be unique on the network.
+	hostname, _ := os.Hostname()
+	instance := fmt.Sprintf("wesplot on %s:%d", strings.TrimSuffix(hostname, ".local"), addr.Port)
+	if title != "" {
+		instance = fmt.Sprintf("%s (%s)", title, instance)
+	}
+
+	text := []string{"title=" + title, "path=" + basePath, "version=" + wesplot.Version}
+	server, err := zeroconf.Register(instance, Service, "local.", addr.Port, text, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.Shutdown, nil
+}
@@ -0,0 +1,92 @@
+package wesplot
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// The smallest number of rows that can be queued to a client before the
+// oldest rows are dropped when the memory is limited by PlanMemory.
+const minClientBufferSize = 1000
+
+// The series assumed by PlanMemory when the columns are not known until the
+// first row is read.
+const defaultNumSeries = 4
+
+// The sizes of the buffers that keep the memory usage under a budget, as
+// planned by PlanMemory. Served in /stats.
+type MemoryLimits struct {
+	MaxMemory int64 // In bytes. 0 if not limited
+
+	// The number of rows kept in the history. With a window duration, the
+	// oldest rows are evicted early if there are more rows than this.
+	WindowSize int
+
+	// The number of rows queued to each client before the oldest are dropped.
+	ClientBufferSize int
+
+	MaxClients int
+
+	// The estimated memory used once the history is full and MaxClients
+	// clients are connected and falling behind.
+	EstimatedMemory int64
+}
+
+// Sizes the history and the buffers of each client so the data stays under
+// maxMemory bytes with maxClients clients, which must be limited. The buffers
+// of the clients are shrunk first, down to minClientBufferSize rows, and then
+// the history. Returns an error if even the smallest buffers do not fit.
+//
+// The estimate only counts the rows: every row in the history takes its slot
+// in the columns of the ColumnarBuffer (with float32 Ys if float32 is set, see
+// DataBroadcaster.SetFloat32), and every client holds up to three batches of
+// DataRow (the channel, the queue of its subscription, and the rows being
+// encoded), which share their Ys with the other clients, plus a chunk of the
+// history while it connects. The actual usage is higher by a constant
+// overhead for the runtime, the web UI, and the compression of each
+// connection.
+func PlanMemory(maxMemory int64, maxClients int, windowSize int, numSeries int, float32 bool) (MemoryLimits, error) {
+	limits := MemoryLimits{
+		MaxMemory:        maxMemory,
+		WindowSize:       windowSize,
+		ClientBufferSize: bufferSize,
+		MaxClients:       maxClients,
+	}
+
+	if maxClients <= 0 {
+		return limits, fmt.Errorf("the number of clients must be limited to limit the memory")
+	}
+
+	if numSeries <= 0 {
+		numSeries = defaultNumSeries
+	}
+
+	// The memory of a row of the history, and of a row queued to the clients.
+	dataRowSize := int64(unsafe.Sizeof(DataRow{})) + 8*int64(numSeries)
+	clients := int64(maxClients)
+	perHistoryRow := columnarRowSize(numSeries, float32)
+	perQueuedRow := dataRowSize + 3*clients*int64(unsafe.Sizeof(DataRow{}))
+	historyChunks := clients * historyChunkSize * dataRowSize
+
+	estimate := func(windowSize int, clientBufferSize int) int64 {
+		return int64(windowSize)*perHistoryRow + int64(clientBufferSize)*perQueuedRow + historyChunks
+	}
+
+	if estimate(windowSize, bufferSize) > maxMemory {
+		// Solve for the client buffers with the full history.
+		available := maxMemory - int64(windowSize)*perHistoryRow - historyChunks
+		limits.ClientBufferSize = int(Max(available/perQueuedRow, minClientBufferSize))
+	}
+
+	if estimate(windowSize, limits.ClientBufferSize) > maxMemory {
+		// Solve for the history with the smallest client buffers.
+		available := maxMemory - int64(limits.ClientBufferSize)*perQueuedRow - historyChunks
+		limits.WindowSize = int(available / perHistoryRow)
+		if limits.WindowSize < 1 {
+			return limits, fmt.Errorf("%d bytes cannot fit %d clients, which need at least %d bytes", maxMemory, maxClients, estimate(1, minClientBufferSize))
+		}
+	}
+
+	limits.EstimatedMemory = estimate(limits.WindowSize, limits.ClientBufferSize)
+	return limits, nil
+}
@@ -1,5 +1,238 @@
 package wesplot
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A static horizontal reference line drawn across the chart, such as an SLO
+// or an alarm threshold.
+type HorizontalLine struct {
+	Y     float64
+	Label string
+}
+
+// Parses a line specified in the form of `0.95` or `0.95:label="SLO"`. This
+// implements the go-flags Unmarshaler interface so it can be used directly as
+// a command line option.
+func (l *HorizontalLine) UnmarshalFlag(value string) error {
+	yStr, attributes, hasAttributes := strings.Cut(value, ":")
+
+	y, err := strconv.ParseFloat(strings.TrimSpace(yStr), 64)
+	if err != nil {
+		return fmt.Errorf("invalid horizontal line value %q: %w", yStr, err)
+	}
+
+	l.Y = y
+	l.Label = ""
+
+	if !hasAttributes {
+		return nil
+	}
+
+	key, label, found := strings.Cut(attributes, "=")
+	if !found || strings.TrimSpace(key) != "label" {
+		return fmt.Errorf("invalid horizontal line attribute %q, expected label=...", attributes)
+	}
+
+	label = strings.TrimSpace(label)
+	if strings.HasPrefix(label, "\"") {
+		label, err = strconv.Unquote(label)
+		if err != nil {
+			return fmt.Errorf("invalid horizontal line label %q: %w", attributes, err)
+		}
+	}
+
+	l.Label = label
+	return nil
+}
+
+// A chart that plots a subset of the columns, which allows a single input to
+// be split into multiple charts. Each panel has independent Y axis options.
+type Panel struct {
+	Name    string
+	Title   string // Defaults to Name if empty
+	Columns []string
+	YLabel  string
+	YUnit   string
+	YMin    *float64 `json:",omitempty"`
+	YMax    *float64 `json:",omitempty"`
+}
+
+// Parses a panel specified in the form of `name:col1,col2`, optionally
+// followed by axis options separated by semicolons, such as
+// `cpu:user,system;ymin=0;ymax=100;yunit=%`. The supported options are
+// title, ylabel, yunit, ymin, and ymax. This implements the go-flags
+// Unmarshaler interface.
+func (p *Panel) UnmarshalFlag(value string) error {
+	parts := strings.Split(value, ";")
+
+	name, columns, found := strings.Cut(parts[0], ":")
+	if !found || len(strings.TrimSpace(name)) == 0 || len(strings.TrimSpace(columns)) == 0 {
+		return fmt.Errorf("invalid panel %q, expected name:column1,column2", value)
+	}
+
+	*p = Panel{
+		Name: strings.TrimSpace(name),
+	}
+
+	for _, column := range strings.Split(columns, ",") {
+		p.Columns = append(p.Columns, strings.TrimSpace(column))
+	}
+
+	for _, option := range parts[1:] {
+		key, optionValue, found := strings.Cut(option, "=")
+		if !found {
+			return fmt.Errorf("invalid panel option %q, expected key=value", option)
+		}
+
+		key = strings.TrimSpace(key)
+		optionValue = strings.TrimSpace(optionValue)
+
+		switch key {
+		case "title":
+			p.Title = optionValue
+		case "ylabel":
+			p.YLabel = optionValue
+		case "yunit":
+			p.YUnit = optionValue
+		case "ymin", "ymax":
+			limit, err := strconv.ParseFloat(optionValue, 64)
+			if err != nil {
+				return fmt.Errorf("invalid panel option %q: %w", option, err)
+			}
+
+			if key == "ymin" {
+				p.YMin = &limit
+			} else {
+				p.YMax = &limit
+			}
+		default:
+			return fmt.Errorf("unknown panel option %q", key)
+		}
+	}
+
+	return nil
+}
+
+// A group of series stacked on top of each other by the stacked chart type,
+// identified by their column labels. The groups are stacked separately.
+type StackGroup struct {
+	Name    string
+	Columns []string
+}
+
+// Parses a stack group specified in the form of `name:col1,col2`. This
+// implements the go-flags Unmarshaler interface.
+func (g *StackGroup) UnmarshalFlag(value string) error {
+	name, columns, found := strings.Cut(value, ":")
+	if !found || len(strings.TrimSpace(name)) == 0 || len(strings.TrimSpace(columns)) == 0 {
+		return fmt.Errorf("invalid stack group %q, expected name:column1,column2", value)
+	}
+
+	*g = StackGroup{
+		Name: strings.TrimSpace(name),
+	}
+
+	for _, column := range strings.Split(columns, ",") {
+		g.Columns = append(g.Columns, strings.TrimSpace(column))
+	}
+
+	return nil
+}
+
+// A grid layout hint for the panels, such as 2x2. The panels are placed in the
+// grid in order, row by row. If the layout is not specified (zero), the
+// frontend decides.
+type Layout struct {
+	Rows    int
+	Columns int
+}
+
+// Parses a layout specified in the form of `<rows>x<columns>`. This implements
+// the go-flags Unmarshaler interface.
+func (l *Layout) UnmarshalFlag(value string) error {
+	rowsStr, columnsStr, found := strings.Cut(strings.ToLower(value), "x")
+	if !found {
+		return fmt.Errorf("invalid layout %q, expected <rows>x<columns> (e.g. 2x2)", value)
+	}
+
+	rows, err := strconv.Atoi(rowsStr)
+	if err != nil || rows <= 0 {
+		return fmt.Errorf("invalid layout %q, rows must be a positive integer", value)
+	}
+
+	columns, err := strconv.Atoi(columnsStr)
+	if err != nil || columns <= 0 {
+		return fmt.Errorf("invalid layout %q, columns must be a positive integer", value)
+	}
+
+	l.Rows = rows
+	l.Columns = columns
+	return nil
+}
+
+// How the colors of the rows (the "C" of the rows, see DataRow.color) are
+// mapped to the colors of the points, such as for phase-space plots. The
+// values from Min to Max are mapped from blue to red. If Min or Max is nil,
+// the clients use the lowest or highest color they received.
+type ColorScale struct {
+	Label string
+	Min   *float64 `json:",omitempty"`
+	Max   *float64 `json:",omitempty"`
+}
+
+// Parses a range of colors specified in the form of `min:max`, such as 0:100.
+// Either bound can be omitted. This implements the go-flags Unmarshaler
+// interface.
+func (c *ColorScale) UnmarshalFlag(value string) error {
+	minStr, maxStr, found := strings.Cut(value, ":")
+	if !found {
+		return fmt.Errorf("invalid color range %q, expected min:max", value)
+	}
+
+	*c = ColorScale{}
+	if strings.TrimSpace(minStr) != "" {
+		min, err := strconv.ParseFloat(strings.TrimSpace(minStr), 64)
+		if err != nil {
+			return fmt.Errorf("invalid color range min %q: %w", minStr, err)
+		}
+
+		c.Min = &min
+	}
+
+	if strings.TrimSpace(maxStr) != "" {
+		max, err := strconv.ParseFloat(strings.TrimSpace(maxStr), 64)
+		if err != nil {
+			return fmt.Errorf("invalid color range max %q: %w", maxStr, err)
+		}
+
+		c.Max = &max
+	}
+
+	if c.Min != nil && c.Max != nil && *c.Min >= *c.Max {
+		return fmt.Errorf("invalid color range %q, min must be lower than max", value)
+	}
+
+	return nil
+}
+
+// The kinds of Candle.
+const (
+	CandleOHLC = "ohlc" // Columns are open, high, low, and close
+	CandleBox  = "box"  // Columns are min, p25, p50, p75, and max
+)
+
+// A series aggregated into the columns of a candlestick or a box plot for
+// every window (see AggregateDataRowReader), which the clients draw as one
+// candle per row instead of plotting the columns separately.
+type Candle struct {
+	Kind    string // CandleOHLC or CandleBox
+	Series  string // The input column
+	Columns []string
+}
+
 type WesplotOptions struct {
 	Title     string
 	Columns   []string
@@ -8,12 +241,47 @@ type WesplotOptions struct {
 	YMin      *float64 `json:",omitempty"`
 	YMax      *float64 `json:",omitempty"`
 	YUnit     string
-	ChartType string
+	ChartType string // scatter, line, area, stacked, or bar
+
+	// The series stacked by the stacked chart type. If empty, all the series
+	// are stacked together. The series in no group are not stacked.
+	StackGroups []StackGroup
+
+	// Series assigned to the secondary Y axis, identified by their column
+	// labels. All other series are plotted against the primary Y axis.
+	Y2Columns []string
+	Y2Label   string
+	Y2Min     *float64 `json:",omitempty"`
+	Y2Max     *float64 `json:",omitempty"`
+
+	HLines []HorizontalLine
 }
 
 type Metadata struct {
 	WindowSize     int
+	WindowDuration float64 // In seconds. If positive, the data of this duration is kept instead of WindowSize rows.
 	XIsTimestamp   bool
 	RelativeStart  bool
 	WesplotOptions WesplotOptions
+
+	// If XIsCategorical, the X values are the indices of the labels in
+	// XCategories, such as the keys of per-key counts (see XCategories). The
+	// labels are added as they are read, and the updated metadata is sent to
+	// the clients.
+	XIsCategorical bool     `json:",omitempty"`
+	XCategories    []string `json:",omitempty"`
+
+	// If empty, all columns are plotted in a single chart.
+	Panels []Panel
+	Layout Layout
+
+	// The columns drawn as candles instead, if any.
+	Candles []Candle `json:",omitempty"`
+
+	// If not nil, the rows have a color (see ColorScale).
+	ColorScale *ColorScale `json:",omitempty"`
+
+	// If HasHistory, the values older than the window can be queried on
+	// /history (see SQLiteWriter.History).
+	HasHistory bool `json:",omitempty"`
 }
@@ -0,0 +1,124 @@
+package wesplot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Counters about the internals of the DataBroadcaster, to diagnose
+// performance issues without attaching a tracer. Served in the Prometheus
+// text format via /metrics. The durations are in nanoseconds.
+type broadcasterMetrics struct {
+	// The time spent waiting for the mutex of the DataBroadcaster.
+	lockWaitNanos atomic.Int64
+	lockWaits     atomic.Int64
+
+	// The time spent caching a row and queueing it to every client, with the
+	// mutex locked.
+	broadcastNanos atomic.Int64
+	broadcasts     atomic.Int64
+
+	// The time the subscriptions spent blocked sending a row to the channel of
+	// their client, because the client was busy writing the previous rows.
+	sendBlockedNanos atomic.Int64
+	sendsBlocked     atomic.Int64
+
+	// The rows dropped from the queues of all the clients, including the
+	// disconnected ones.
+	clientRowsDropped atomic.Int64
+}
+
+// Locks the mutex and records the time waited for it.
+func (d *DataBroadcaster) lock() {
+	start := time.Now()
+	d.mutex.Lock()
+	d.metrics.lockWaitNanos.Add(int64(time.Since(start)))
+	d.metrics.lockWaits.Add(1)
+}
+
+// The values of the gauges of the DataBroadcaster, read under the mutex.
+type broadcasterGauges struct {
+	bufferRows    int
+	clients       int
+	queuedRows    int
+	queueCapacity int
+}
+
+func (d *DataBroadcaster) gauges() broadcasterGauges {
+	d.lock()
+	defer d.mutex.Unlock()
+
+	gauges := broadcasterGauges{
+		bufferRows: d.dataBuffer.Len(),
+		clients:    len(d.subscriptions),
+	}
+
+	for _, sub := range d.subscriptions {
+		gauges.queuedRows += len(sub.queue)
+		gauges.queueCapacity += cap(sub.queue)
+	}
+
+	return gauges
+}
+
+// Serves the statistics of the input, the clients, and the internals of the
+// DataBroadcaster in the Prometheus text format, so they can be scraped
+// alongside the other metrics of a host.
+func (s *HttpServer) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	d := s.dataBroadcaster
+	input := d.stats.Snapshot()
+	gauges := d.gauges()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "wesplot_uptime_seconds", "gauge", "The time since wesplot started.", input.Uptime)
+	writeMetric(w, "wesplot_input_bytes_read_total", "counter", "The bytes read from the input.", float64(input.BytesRead))
+	writeMetric(w, "wesplot_input_rows_emitted_total", "counter", "The rows read from the input and plotted.", float64(input.RowsEmitted))
+
+	reasons := make([]string, 0, len(input.RowsDropped))
+	for reason := range input.RowsDropped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	writeMetricHeader(w, "wesplot_input_rows_dropped_total", "counter", "The rows read from the input and ignored, by reason.")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "wesplot_input_rows_dropped_total{reason=%q} %d\n", reason, input.RowsDropped[reason])
+	}
+
+	writeMetric(w, "wesplot_input_values_clipped_total", "counter", "The Y values clamped to the --clip-y range.", float64(input.ValuesClipped))
+	writeMetric(w, "wesplot_input_outliers_dropped_total", "counter", "The Y values ignored as outliers by --drop-outliers.", float64(input.OutliersDropped))
+
+	limits := s.memoryLimits()
+	writeMetric(w, "wesplot_buffer_rows", "gauge", "The rows in the history sent to new clients.", float64(gauges.bufferRows))
+	writeMetric(w, "wesplot_buffer_capacity_rows", "gauge", "The maximum number of rows in the history, unless it is limited by a window duration.", float64(limits.WindowSize))
+	writeMetric(w, "wesplot_clients", "gauge", "The clients receiving the rows.", float64(gauges.clients))
+	writeMetric(w, "wesplot_client_queued_rows", "gauge", "The rows queued to all the clients.", float64(gauges.queuedRows))
+	writeMetric(w, "wesplot_client_queue_capacity_rows", "gauge", "The rows that can be queued to all the clients before the oldest are dropped.", float64(gauges.queueCapacity))
+	writeMetric(w, "wesplot_client_rows_dropped_total", "counter", "The rows dropped because a client could not keep up.", float64(d.metrics.clientRowsDropped.Load()))
+
+	writeSummary(w, "wesplot_broadcast_seconds", "The time spent caching a row and queueing it to the clients.", &d.metrics.broadcastNanos, &d.metrics.broadcasts)
+	writeSummary(w, "wesplot_lock_wait_seconds", "The time spent waiting for the lock of the broadcaster.", &d.metrics.lockWaitNanos, &d.metrics.lockWaits)
+	writeSummary(w, "wesplot_client_send_blocked_seconds", "The time spent waiting for the clients to accept a row while they were busy.", &d.metrics.sendBlockedNanos, &d.metrics.sendsBlocked)
+}
+
+func writeMetricHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeMetric(w io.Writer, name string, metricType string, help string, value float64) {
+	writeMetricHeader(w, name, metricType, help)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+// Writes a summary without quantiles, from which the rate and the average can
+// be computed.
+func writeSummary(w io.Writer, name string, help string, nanos *atomic.Int64, count *atomic.Int64) {
+	writeMetricHeader(w, name, "summary", help)
+	fmt.Fprintf(w, "%s_sum %g\n", name, time.Duration(nanos.Load()).Seconds())
+	fmt.Fprintf(w, "%s_count %d\n", name, count.Load())
+}
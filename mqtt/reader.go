@@ -0,0 +1,96 @@
+// Package mqtt reads the data of wesplot from the topics of an MQTT broker.
+// It is separate from the wesplot package so the programs embedding wesplot
+// do not depend on the MQTT client unless they use it.
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cactusdynamics/wesplot"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// The number of messages received but not read yet, above which the client
+// blocks.
+const messageBufferSize = 10000
+
+// A DataRowReader that subscribes to one or more MQTT topics and converts the
+// messages into DataRows, as described on wesplot.TopicDataRowReader.
+type DataRowReader struct {
+	*wesplot.TopicDataRowReader
+
+	client   paho.Client
+	messages chan wesplot.TopicMessage
+	errs     chan error
+
+	logger logrus.FieldLogger
+}
+
+// Connects to the broker and subscribe to the topics. The columns are the
+// labels (see wesplot.TopicDataRowReader) that should be plotted. Messages
+// with labels not in columns are ignored.
+func NewDataRowReader(broker string, topics []string, columns []string) (*DataRowReader, error) {
+	r := &DataRowReader{
+		messages: make(chan wesplot.TopicMessage, messageBufferSize),
+		errs:     make(chan error, 1),
+		logger:   logrus.WithFields(logrus.Fields{"tag": "Mqtt", "broker": broker}),
+	}
+
+	r.TopicDataRowReader = wesplot.NewTopicDataRowReader(r.receive, columns, r.logger)
+
+	subscriptions := make(map[string]byte, len(topics))
+	for _, topic := range topics {
+		subscriptions[topic] = 0
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("wesplot-%d", os.Getpid())).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(client paho.Client) {
+			// Subscribing here ensures we resubscribe after reconnecting.
+			token := client.SubscribeMultiple(subscriptions, func(_ paho.Client, message paho.Message) {
+				r.messages <- wesplot.TopicMessage{Topic: message.Topic(), Payload: message.Payload()}
+			})
+
+			if token.Wait() && token.Error() != nil {
+				r.logger.WithError(token.Error()).Error("failed to subscribe")
+				select {
+				case r.errs <- token.Error():
+				default:
+				}
+				return
+			}
+
+			r.logger.WithField("topics", topics).Info("subscribed")
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			r.logger.WithError(err).Warn("connection lost, reconnecting")
+		})
+
+	r.client = paho.NewClient(opts)
+	token := r.client.Connect()
+	if token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	return r, nil
+}
+
+func (r *DataRowReader) receive(ctx context.Context) (wesplot.TopicMessage, error) {
+	select {
+	case message := <-r.messages:
+		return message, nil
+	case err := <-r.errs:
+		return wesplot.TopicMessage{}, err
+	case <-ctx.Done():
+		return wesplot.TopicMessage{}, ctx.Err()
+	}
+}
+
+func (r *DataRowReader) Close() {
+	r.client.Disconnect(250)
+}
@@ -0,0 +1,269 @@
+package wesplot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+)
+
+// The options that can be changed via PUT /options, as returned by it.
+type RuntimeOptions struct {
+	Title         string
+	Columns       []string // Renames the series. Must have the same number of columns.
+	XLabel        string
+	YLabel        string
+	YUnit         string
+	YMin          *float64
+	YMax          *float64
+	Y2Label       string
+	Y2Min         *float64
+	Y2Max         *float64
+	FlushInterval string // A Go duration, such as 250ms
+}
+
+// Serves PUT /options, which changes the options of a running server without
+// restarting the pipe. The body is a JSON object with any of the fields of
+// RuntimeOptions, such as {"Title": "Latency", "YMax": 100}. The fields that
+// are not in the body are left unchanged, and a limit set to null is reset to
+// auto scaling. The updated metadata is sent to the connected clients that
+// asked for it (see MetadataMessage), and the response contains the options.
+// Must be enabled with SetControl.
+func (s *HttpServer) handleOptions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		http.Error(w, "only PUT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.checkControl(w, req) {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	err := json.NewDecoder(req.Body).Decode(&fields)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s.optionsMutex.Lock()
+
+	metadata := s.metadata
+	flushInterval := s.flushInterval
+	err = applyRuntimeOptions(&metadata.WesplotOptions, &flushInterval, fields)
+	if err != nil {
+		s.optionsMutex.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.metadata = metadata
+	s.flushInterval = flushInterval
+	s.notifyMetadataListeners(metadata)
+
+	if s.settingsFile != "" {
+		err = saveSettingsFile(s.settingsFile, runtimeOptionsOf(metadata.WesplotOptions, flushInterval))
+		if err != nil {
+			s.logger.WithError(err).Error("cannot save settings file")
+		}
+	}
+
+	s.optionsMutex.Unlock()
+
+	s.logger.WithField("fields", len(fields)).Info("options changed")
+	writeJSON(w, runtimeOptionsOf(metadata.WesplotOptions, flushInterval))
+}
+
+func applyRuntimeOptions(options *WesplotOptions, flushInterval *time.Duration, fields map[string]json.RawMessage) error {
+	for name, value := range fields {
+		var err error
+
+		switch name {
+		case "Title":
+			err = json.Unmarshal(value, &options.Title)
+		case "Columns":
+			var columns []string
+			err = json.Unmarshal(value, &columns)
+			if err == nil && len(columns) != len(options.Columns) {
+				err = fmt.Errorf("expected %d columns, got %d", len(options.Columns), len(columns))
+			}
+
+			if err == nil {
+				options.Columns = columns
+			}
+		case "XLabel":
+			err = json.Unmarshal(value, &options.XLabel)
+		case "YLabel":
+			err = json.Unmarshal(value, &options.YLabel)
+		case "YUnit":
+			err = json.Unmarshal(value, &options.YUnit)
+		case "YMin":
+			options.YMin, err = unmarshalLimit(value)
+		case "YMax":
+			options.YMax, err = unmarshalLimit(value)
+		case "Y2Label":
+			err = json.Unmarshal(value, &options.Y2Label)
+		case "Y2Min":
+			options.Y2Min, err = unmarshalLimit(value)
+		case "Y2Max":
+			options.Y2Max, err = unmarshalLimit(value)
+		case "FlushInterval":
+			var duration string
+			err = json.Unmarshal(value, &duration)
+			if err == nil {
+				*flushInterval, err = time.ParseDuration(duration)
+			}
+
+			if err == nil && *flushInterval <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		default:
+			return fmt.Errorf("option %s cannot be changed", name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if options.YMin != nil && options.YMax != nil && *options.YMin >= *options.YMax {
+		return fmt.Errorf("YMax (%f) must be greater than YMin (%f)", *options.YMax, *options.YMin)
+	}
+
+	if options.Y2Min != nil && options.Y2Max != nil && *options.Y2Min >= *options.Y2Max {
+		return fmt.Errorf("Y2Max (%f) must be greater than Y2Min (%f)", *options.Y2Max, *options.Y2Min)
+	}
+
+	return nil
+}
+
+// Returns nil (auto scaling) for null.
+func unmarshalLimit(value json.RawMessage) (*float64, error) {
+	var limit *float64
+	err := json.Unmarshal(value, &limit)
+	return limit, err
+}
+
+func runtimeOptionsOf(options WesplotOptions, flushInterval time.Duration) RuntimeOptions {
+	return RuntimeOptions{
+		Title:         options.Title,
+		Columns:       options.Columns,
+		XLabel:        options.XLabel,
+		YLabel:        options.YLabel,
+		YUnit:         options.YUnit,
+		YMin:          options.YMin,
+		YMax:          options.YMax,
+		Y2Label:       options.Y2Label,
+		Y2Min:         options.Y2Min,
+		Y2Max:         options.Y2Max,
+		FlushInterval: flushInterval.String(),
+	}
+}
+
+// Loads the options from the file, if it exists, and saves the options into
+// it whenever they are changed via PUT /options. This keeps the changes made
+// in the browser across restarts. The options in the file take precedence
+// over the options passed to NewHttpServer. Must be called before Run.
+func (s *HttpServer) SetSettingsFile(path string) error {
+	s.optionsMutex.Lock()
+	defer s.optionsMutex.Unlock()
+
+	s.settingsFile = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return fmt.Errorf("invalid settings file %s: %w", path, err)
+	}
+
+	err = applyRuntimeOptions(&s.metadata.WesplotOptions, &s.flushInterval, fields)
+	if err != nil {
+		return fmt.Errorf("invalid settings file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Writes to a temporary file first, so the file is not corrupted if wesplot
+// is killed while writing.
+func saveSettingsFile(path string, options RuntimeOptions) error {
+	data, err := json.MarshalIndent(options, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+	err = os.WriteFile(tempPath, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+// Changes the metadata at runtime, such as once the unit of the Y axis is
+// known, and sends it to the clients receiving the metadata. Can be called
+// from any goroutine.
+func (s *HttpServer) UpdateMetadata(update func(metadata *Metadata)) {
+	s.optionsMutex.Lock()
+	defer s.optionsMutex.Unlock()
+
+	metadata := s.metadata
+	update(&metadata)
+	s.metadata = metadata
+	s.notifyMetadataListeners(metadata)
+}
+
+// Must be called with the optionsMutex locked.
+func (s *HttpServer) notifyMetadataListeners(metadata Metadata) {
+	for listener := range s.metadataListeners {
+		// Only the latest metadata matters, so the pending one is replaced if the
+		// client has not received it yet.
+		select {
+		case <-listener:
+		default:
+		}
+
+		listener <- metadata
+	}
+}
+
+func (s *HttpServer) currentMetadata() Metadata {
+	s.optionsMutex.Lock()
+	defer s.optionsMutex.Unlock()
+
+	return s.metadata
+}
+
+func (s *HttpServer) currentFlushInterval() time.Duration {
+	s.optionsMutex.Lock()
+	defer s.optionsMutex.Unlock()
+
+	return s.flushInterval
+}
+
+func (s *HttpServer) addMetadataListener() chan Metadata {
+	s.optionsMutex.Lock()
+	defer s.optionsMutex.Unlock()
+
+	listener := make(chan Metadata, 1)
+	s.metadataListeners[listener] = struct{}{}
+	return listener
+}
+
+func (s *HttpServer) removeMetadataListener(listener chan Metadata) {
+	s.optionsMutex.Lock()
+	defer s.optionsMutex.Unlock()
+
+	delete(s.metadataListeners, listener)
+}
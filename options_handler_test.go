@@ -0,0 +1,282 @@
+package wesplot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestOptionsServer(control bool) *HttpServer {
+	yMin := 0.0
+	metadata := Metadata{WindowSize: 100, WesplotOptions: WesplotOptions{Title: "test", YLabel: "latency", YMin: &yMin, Columns: []string{"a"}}}
+	s := NewHttpServer(nil, "127.0.0.1", 0, metadata, 250*time.Millisecond)
+	s.SetControl(control)
+	return s
+}
+
+func TestHandleOptions(t *testing.T) {
+	yMin := 0.0
+	yMax := 100.0
+
+	tests := []struct {
+		name     string
+		control  bool
+		method   string
+		origin   string
+		body     string
+		status   int
+		response RuntimeOptions
+	}{
+		{
+			name:    "title and limit",
+			control: true,
+			body:    `{"Title": "Build 42", "YMax": 100}`,
+			status:  http.StatusOK,
+			response: RuntimeOptions{
+				Title:         "Build 42",
+				Columns:       []string{"a"},
+				YLabel:        "latency",
+				YMin:          &yMin,
+				YMax:          &yMax,
+				FlushInterval: "250ms",
+			},
+		},
+		{
+			name:    "reset limit and flush interval",
+			control: true,
+			origin:  "http://example.com",
+			body:    `{"YMin": null, "FlushInterval": "1s"}`,
+			status:  http.StatusOK,
+			response: RuntimeOptions{
+				Title:         "test",
+				Columns:       []string{"a"},
+				YLabel:        "latency",
+				FlushInterval: "1s",
+			},
+		},
+		{
+			name:    "rename columns",
+			control: true,
+			body:    `{"Columns": ["latency"]}`,
+			status:  http.StatusOK,
+			response: RuntimeOptions{
+				Title:         "test",
+				Columns:       []string{"latency"},
+				YLabel:        "latency",
+				YMin:          &yMin,
+				FlushInterval: "250ms",
+			},
+		},
+		{
+			name:   "disabled",
+			body:   `{"Title": "Build 42"}`,
+			status: http.StatusForbidden,
+		},
+		{
+			name:    "cross origin",
+			control: true,
+			origin:  "http://evil.example.org",
+			body:    `{"Title": "Build 42"}`,
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "cors preflight",
+			control: true,
+			method:  http.MethodOptions,
+			origin:  "http://evil.example.org",
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "get",
+			control: true,
+			method:  http.MethodGet,
+			status:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:    "invalid json",
+			control: true,
+			body:    `{"Title": "Build 42"`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "unknown option",
+			control: true,
+			body:    `{"Legend": false}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "wrong number of columns",
+			control: true,
+			body:    `{"Columns": ["a", "b"]}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "max below min",
+			control: true,
+			body:    `{"YMax": -1}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "zero flush interval",
+			control: true,
+			body:    `{"FlushInterval": "0s"}`,
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		s := newTestOptionsServer(test.control)
+		listener := s.addMetadataListener()
+
+		method := test.method
+		if method == "" {
+			method = http.MethodPut
+		}
+
+		req := httptest.NewRequest(method, "http://example.com/options", strings.NewReader(test.body))
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.mux.ServeHTTP(recorder, req)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: got status %d, expected %d: %s", test.name, recorder.Code, test.status, recorder.Body)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			// The options are unchanged, and the clients are not notified.
+			if title := s.currentMetadata().WesplotOptions.Title; title != "test" {
+				t.Errorf("%s: got title %q after a rejected request", test.name, title)
+			}
+
+			select {
+			case metadata := <-listener:
+				t.Errorf("%s: got metadata %+v after a rejected request", test.name, metadata)
+			default:
+			}
+
+			continue
+		}
+
+		var response RuntimeOptions
+		err := json.NewDecoder(recorder.Body).Decode(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !equalRuntimeOptions(response, test.response) {
+			t.Errorf("%s: got response %+v, expected %+v", test.name, response, test.response)
+		}
+
+		select {
+		case metadata := <-listener:
+			if !equalRuntimeOptions(runtimeOptionsOf(metadata.WesplotOptions, s.currentFlushInterval()), test.response) {
+				t.Errorf("%s: got metadata %+v, expected %+v", test.name, metadata.WesplotOptions, test.response)
+			}
+		default:
+			t.Errorf("%s: expected the updated metadata to be sent", test.name)
+		}
+	}
+}
+
+func TestHandleControl(t *testing.T) {
+	for _, control := range []bool{false, true} {
+		s := newTestOptionsServer(control)
+		handler := newTestIngestHandler()
+		s.HandleControl("/ingest", handler)
+
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/ingest", strings.NewReader("1,2,3\n"))
+		recorder := httptest.NewRecorder()
+		s.mux.ServeHTTP(recorder, req)
+
+		expected := http.StatusForbidden
+		if control {
+			expected = http.StatusOK
+		}
+
+		if recorder.Code != expected {
+			t.Errorf("control %v: got status %d, expected %d: %s", control, recorder.Code, expected, recorder.Body)
+		}
+	}
+}
+
+func TestSettingsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+
+	// The file does not exist yet, so the options are unchanged.
+	s := newTestOptionsServer(true)
+	err := s.SetSettingsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if title := s.currentMetadata().WesplotOptions.Title; title != "test" {
+		t.Errorf("got title %q, expected the options passed to NewHttpServer", title)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "http://example.com/options", strings.NewReader(`{"Title": "Build 42", "YMin": null, "FlushInterval": "1s"}`))
+	recorder := httptest.NewRecorder()
+	s.mux.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// A restarted server loads the changed options from the file.
+	s = newTestOptionsServer(false)
+	err = s.SetSettingsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := RuntimeOptions{Title: "Build 42", Columns: []string{"a"}, YLabel: "latency", FlushInterval: "1s"}
+	options := runtimeOptionsOf(s.currentMetadata().WesplotOptions, s.currentFlushInterval())
+	if !equalRuntimeOptions(options, expected) {
+		t.Errorf("got %+v, expected %+v", options, expected)
+	}
+
+	for _, data := range []string{`{"Title": "Build 42"`, `{"Columns": ["a", "b"]}`} {
+		err := os.WriteFile(path, []byte(data), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = newTestOptionsServer(false).SetSettingsFile(path)
+		if err == nil {
+			t.Errorf("%s: expected an error", data)
+		}
+	}
+}
+
+func equalRuntimeOptions(a, b RuntimeOptions) bool {
+	equalLimit := func(a, b *float64) bool {
+		return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+	}
+
+	return a.Title == b.Title && equalStrings(a.Columns, b.Columns) &&
+		a.XLabel == b.XLabel && a.YLabel == b.YLabel && a.YUnit == b.YUnit &&
+		equalLimit(a.YMin, b.YMin) && equalLimit(a.YMax, b.YMax) &&
+		a.Y2Label == b.Y2Label && equalLimit(a.Y2Min, b.Y2Min) && equalLimit(a.Y2Max, b.Y2Max) &&
+		a.FlushInterval == b.FlushInterval
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
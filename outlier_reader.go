@@ -0,0 +1,134 @@
+package wesplot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The number of recent values of a series the outliers are detected against.
+const outlierWindowSize = 100
+
+// The number of values of a series to read before detecting outliers, as the
+// deviation of fewer values is not meaningful.
+const outlierMinValues = 10
+
+// A DataRowReader that replaces the outliers of every series with NaN, so they
+// are plotted as gaps instead of squashing the rest of the plot when the Y
+// axis is scaled automatically. A value is an outlier if its z-score, computed
+// against the last outlierWindowSize values of its series, is greater than a
+// threshold. The outliers are added to the window clamped to the threshold,
+// so a single absurd value barely changes the detection of the next ones,
+// while the window still follows a lasting change of level. If the values of the window are all the same, their
+// z-score is not defined, and no value is an outlier. The outliers are counted
+// in the InputStats.
+type OutlierDataRowReader struct {
+	input     DataRowReader
+	threshold float64
+	stats     *InputStats
+
+	series []outlierWindow
+}
+
+// The recent values of a series.
+type outlierWindow struct {
+	values []float64
+	next   int // Where the next value is stored once the window is full
+}
+
+// Creates the reader from a spec such as zscore:4, which is the only method
+// supported.
+func NewOutlierDataRowReader(input DataRowReader, spec string, stats *InputStats) (*OutlierDataRowReader, error) {
+	method, thresholdSpec, found := strings.Cut(spec, ":")
+	if !found || method != "zscore" {
+		return nil, fmt.Errorf("invalid outlier detection %q, expected zscore:threshold (e.g. zscore:4)", spec)
+	}
+
+	threshold, err := strconv.ParseFloat(thresholdSpec, 64)
+	if err != nil || threshold <= 0 {
+		return nil, fmt.Errorf("invalid z-score threshold %q, expected a positive number", thresholdSpec)
+	}
+
+	return &OutlierDataRowReader{
+		input:     input,
+		threshold: threshold,
+		stats:     stats,
+		series:    make([]outlierWindow, len(input.ColumnNames())),
+	}, nil
+}
+
+func (r *OutlierDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if err != nil {
+		return dataRow, err
+	}
+
+	for i, y := range dataRow.Ys {
+		if i >= len(r.series) || math.IsNaN(y) || math.IsInf(y, 0) {
+			continue
+		}
+
+		window := &r.series[i]
+		clamped, outlier := window.clamp(y, r.threshold)
+		window.push(clamped)
+
+		if outlier {
+			dataRow.Ys[i] = math.NaN()
+			dataRow.yTexts = nil
+			r.stats.OutlierDropped()
+		}
+	}
+
+	return dataRow, nil
+}
+
+// Returns the value clamped to threshold standard deviations around the mean
+// of the window, and whether it is an outlier.
+func (w *outlierWindow) clamp(y float64, threshold float64) (float64, bool) {
+	if len(w.values) < outlierMinValues {
+		return y, false
+	}
+
+	// Not computed from running sums, which lose the precision of large values
+	// such as timestamps.
+	mean := 0.0
+	for _, value := range w.values {
+		mean += value
+	}
+	mean /= float64(len(w.values))
+
+	variance := 0.0
+	for _, value := range w.values {
+		variance += (value - mean) * (value - mean)
+	}
+	variance /= float64(len(w.values))
+
+	if variance == 0 {
+		return y, false
+	}
+
+	bound := threshold * math.Sqrt(variance)
+	if y > mean+bound {
+		return mean + bound, true
+	} else if y < mean-bound {
+		return mean - bound, true
+	}
+
+	return y, false
+}
+
+func (w *outlierWindow) push(y float64) {
+	if len(w.values) < outlierWindowSize {
+		w.values = append(w.values, y)
+		return
+	}
+
+	w.values[w.next] = y
+	w.next = (w.next + 1) % outlierWindowSize
+}
+
+func (r *OutlierDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
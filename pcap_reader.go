@@ -0,0 +1,129 @@
+//go:build pcap
+
+package wesplot
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+	"github.com/sirupsen/logrus"
+)
+
+// How long libpcap waits for packets before returning, so the capture can be
+// stopped on an idle interface.
+const pcapReadTimeout = 250 * time.Millisecond
+
+// A DataRowReader that captures the packets of a network interface with
+// libpcap and emits a row with the bytes and packets per second captured
+// during every interval, such as to graph the bandwidth of a link live. The X
+// value is the time at the end of the interval.
+//
+// Capturing packets usually requires root or the CAP_NET_RAW capability.
+// wesplot must be built with -tags pcap and the libpcap headers.
+type PcapDataRowReader struct {
+	handle   *pcap.Handle
+	columns  []string
+	interval time.Duration
+
+	bytes   atomic.Uint64
+	packets atomic.Uint64
+	errs    chan error
+
+	ticker *time.Ticker
+	last   time.Time
+
+	logger logrus.FieldLogger
+}
+
+// Opens the device (e.g. eth0, or any for every interface) and captures the
+// packets matching the filter, in the syntax of tcpdump (e.g. port 443). An
+// empty filter captures every packet. The columns label the bytes and the
+// packets per second.
+func NewPcapDataRowReader(device string, filter string, columns []string, interval time.Duration) (*PcapDataRowReader, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the pcap interval must be positive, got %v", interval)
+	}
+
+	if len(columns) != 2 {
+		return nil, fmt.Errorf("expected 2 columns for the bytes and the packets per second, got %d", len(columns))
+	}
+
+	// Only the length of the packets is needed, so as little as possible of
+	// them is captured.
+	handle, err := pcap.OpenLive(device, 64, false, pcapReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter != "" {
+		err = handle.SetBPFFilter(filter)
+		if err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("invalid filter %q: %w", filter, err)
+		}
+	}
+
+	return &PcapDataRowReader{
+		handle:   handle,
+		columns:  columns,
+		interval: interval,
+		errs:     make(chan error, 1),
+		logger:   logrus.WithFields(logrus.Fields{"tag": "Pcap", "device": device}),
+	}, nil
+}
+
+func (r *PcapDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if r.ticker == nil {
+		go r.capture(ctx)
+
+		r.ticker = time.NewTicker(r.interval)
+		r.last = time.Now()
+	}
+
+	select {
+	case now := <-r.ticker.C:
+		elapsed := now.Sub(r.last).Seconds()
+		r.last = now
+
+		return DataRow{
+			X: float64(now.UnixNano()) / 1e9,
+			Ys: []float64{
+				float64(r.bytes.Swap(0)) / elapsed,
+				float64(r.packets.Swap(0)) / elapsed,
+			},
+		}, nil
+	case err := <-r.errs:
+		r.ticker.Stop()
+		return DataRow{}, err
+	case <-ctx.Done():
+		return DataRow{}, ctx.Err()
+	}
+}
+
+func (r *PcapDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+func (r *PcapDataRowReader) Close() {
+	r.handle.Close()
+}
+
+func (r *PcapDataRowReader) capture(ctx context.Context) {
+	for ctx.Err() == nil {
+		_, info, err := r.handle.ZeroCopyReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		} else if err != nil {
+			r.logger.WithError(err).Error("stopped capturing packets")
+			r.errs <- err
+			return
+		}
+
+		// The original length, as the packets are truncated to the snaplen.
+		r.bytes.Add(uint64(info.Length))
+		r.packets.Add(1)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !pcap
+
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Capturing packets requires libpcap, which is only linked with -tags pcap so
+// wesplot can be built without cgo.
+type PcapDataRowReader struct{}
+
+func NewPcapDataRowReader(device string, filter string, columns []string, interval time.Duration) (*PcapDataRowReader, error) {
+	return nil, errors.New("wesplot was built without packet capture support, rebuild it with -tags pcap and the libpcap headers installed")
+}
+
+func (r *PcapDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	return DataRow{}, errors.New("packet capture is not supported")
+}
+
+func (r *PcapDataRowReader) ColumnNames() []string {
+	return nil
+}
+
+func (r *PcapDataRowReader) Close() {}
@@ -0,0 +1,215 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Returned when sending rows after the ChannelDataRowReader or the Plot was
+// closed.
+var ErrClosed = errors.New("wesplot: send after close")
+
+// A DataRowReader backed by a channel, which allows Go programs to send data
+// rows into the pipeline directly.
+type ChannelDataRowReader struct {
+	columns []string
+	rows    chan DataRow
+
+	// Closed by Close. The rows channel itself is never closed, so a Send
+	// racing with Close cannot panic.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// The capacity is the size of the channel buffer. Send blocks if the buffer is
+// full, which applies backpressure to the sender.
+func NewChannelDataRowReader(columns []string, capacity int) *ChannelDataRowReader {
+	return &ChannelDataRowReader{
+		columns: columns,
+		rows:    make(chan DataRow, capacity),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Sends a row into the pipeline. Returns ErrClosed if called after Close, in
+// which case the row is dropped.
+func (r *ChannelDataRowReader) Send(dataRow DataRow) error {
+	select {
+	case <-r.closed:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case r.rows <- dataRow:
+		return nil
+	case <-r.closed:
+		return ErrClosed
+	}
+}
+
+// Ends the stream. Rows already sent are still read, after which Read returns
+// io.EOF. Can be called multiple times.
+func (r *ChannelDataRowReader) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+	})
+}
+
+func (r *ChannelDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	select {
+	case dataRow := <-r.rows:
+		return dataRow, nil
+	case <-r.closed:
+		// Read the rows sent before Close first.
+		select {
+		case dataRow := <-r.rows:
+			return dataRow, nil
+		default:
+			return DataRow{}, io.EOF
+		}
+	case <-ctx.Done():
+		return DataRow{}, ctx.Err()
+	}
+}
+
+func (r *ChannelDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+// The options for a Plot. Zero values are replaced with the same defaults as
+// the wesplot command, except for Host.
+type PlotOptions struct {
+	Host string // Default: 127.0.0.1, so the plot is only reachable from this machine, unlike with the wesplot command (0.0.0.0)
+	Port uint16 // Default: 5274. If taken, the next available port is used.
+
+	// The number of rows cached and sent to newly connected browsers.
+	WindowSize    int           // Default: 1800
+	FlushInterval time.Duration // Default: 250ms
+
+	// If set, the data of this duration is kept instead of WindowSize rows.
+	WindowDuration time.Duration
+
+	// Set this to true if the X values passed to Feed are not unix timestamps in
+	// seconds. Must be false if FeedNow is used.
+	XIsNotTimestamp bool
+	RelativeStart   bool
+
+	// The chart options, such as the title and the column labels. If Columns
+	// is empty, a single column named y1 is assumed.
+	WesplotOptions WesplotOptions
+}
+
+// A live plot server that can be embedded in Go programs, as an alternative to
+// piping text into the wesplot command. For example:
+//
+//	plot := wesplot.New(wesplot.PlotOptions{
+//		WesplotOptions: wesplot.WesplotOptions{
+//			Title:   "Queue depth",
+//			Columns: []string{"depth"},
+//		},
+//	})
+//
+//	go plot.Serve(ctx)
+//
+//	for {
+//		plot.FeedNow(float64(queue.Len()))
+//		time.Sleep(time.Second)
+//	}
+type Plot struct {
+	reader      *ChannelDataRowReader
+	broadcaster *DataBroadcaster
+	server      *HttpServer
+	stats       *InputStats
+}
+
+func New(options PlotOptions) *Plot {
+	if options.Host == "" {
+		options.Host = "127.0.0.1"
+	}
+
+	if options.Port == 0 {
+		options.Port = 5274
+	}
+
+	if options.WindowSize <= 0 {
+		options.WindowSize = 1800
+	}
+
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 250 * time.Millisecond
+	}
+
+	if len(options.WesplotOptions.Columns) == 0 {
+		options.WesplotOptions.Columns = []string{"y1"}
+	}
+
+	if options.WesplotOptions.ChartType == "" {
+		options.WesplotOptions.ChartType = "line"
+	}
+
+	metadata := Metadata{
+		WindowSize:     options.WindowSize,
+		WindowDuration: options.WindowDuration.Seconds(),
+		XIsTimestamp:   !options.XIsNotTimestamp,
+		RelativeStart:  options.RelativeStart,
+		WesplotOptions: options.WesplotOptions,
+	}
+
+	p := &Plot{
+		reader: NewChannelDataRowReader(options.WesplotOptions.Columns, bufferSize),
+		stats:  NewInputStats(),
+	}
+
+	p.broadcaster = NewDataBroadcaster(p.reader, options.WindowSize, false)
+	p.broadcaster.SetInputStats(p.stats)
+	if options.WindowDuration > 0 {
+		p.broadcaster.SetWindowDuration(options.WindowDuration)
+	}
+
+	p.server = NewHttpServer(p.broadcaster, options.Host, options.Port, metadata, options.FlushInterval)
+	return p
+}
+
+// Sends a data row to the plot, with one y value per column. The values are
+// copied, so the slice can be reused once Feed returns. Returns ErrClosed after
+// Close.
+func (p *Plot) Feed(x float64, ys ...float64) error {
+	return p.send(x, ys)
+}
+
+// Sends a data row with the current timestamp as the X value.
+func (p *Plot) FeedNow(ys ...float64) error {
+	return p.send(NowXGenerator(ys), ys)
+}
+
+func (p *Plot) send(x float64, ys []float64) error {
+	columns := p.reader.ColumnNames()
+	if len(ys) != len(columns) {
+		return fmt.Errorf("expected %d values per row, got %d", len(columns), len(ys))
+	}
+
+	// The row is read by the broadcaster later, possibly after the caller
+	// reused the slice passed with plot.Feed(x, values...).
+	return p.reader.Send(DataRow{X: x, Ys: append([]float64(nil), ys...)})
+}
+
+// Ends the data stream. The plot is still served with the data already fed
+// until the Serve context is canceled. Feed returns ErrClosed after Close.
+func (p *Plot) Close() {
+	p.reader.Close()
+}
+
+// Starts the plot server and blocks until the context is canceled.
+func (p *Plot) Serve(ctx context.Context) error {
+	go p.stats.Start(ctx, 0)
+	p.broadcaster.Start(ctx)
+
+	err := p.server.RunContext(ctx)
+	p.broadcaster.Wait()
+	return err
+}
@@ -0,0 +1,50 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPlotFeed(t *testing.T) {
+	plot := New(PlotOptions{
+		WesplotOptions: WesplotOptions{Columns: []string{"a", "b"}},
+	})
+
+	ys := []float64{1, 2}
+	err := plot.Feed(1, ys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The row must not change when the caller reuses the slice.
+	ys[0] = 100
+
+	err = plot.Feed(2, 1, 2, 3)
+	if err == nil {
+		t.Error("expected an error for a row with too many values")
+	}
+
+	plot.Close()
+
+	err = plot.Feed(3, 1, 2)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+
+	// The rows sent before Close are still read.
+	dataRow, err := plot.reader.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dataRow.X != 1 || !equalFloats(dataRow.Ys, []float64{1, 2}) {
+		t.Errorf("got %v, expected X 1, Ys [1 2]", dataRow)
+	}
+
+	_, err = plot.reader.Read(context.Background())
+	if err != io.EOF {
+		t.Errorf("expected io.EOF after the rows sent before Close, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package wesplot
+
+import (
+	"sort"
+	"sync"
+)
+
+// A set of options to plot the output of a well known command, such as ping,
+// selected with --preset. The options are keyed by the long name of the
+// command line flag, with the same values as in the config file: strings,
+// numbers, booleans, or slices of them for flags that can be specified
+// multiple times.
+type Preset struct {
+	Description string
+	Options     map[string]any
+}
+
+var (
+	presetsMutex sync.Mutex
+	presets      = map[string]Preset{
+		"ping": {
+			Description: "The round trip time of ping",
+			Options: map[string]any{
+				"regex":  `time=(?P<rtt>[0-9.]+) ?ms`,
+				"title":  "Ping",
+				"ylabel": "Round trip time",
+				"yunit":  "ms",
+				"ymin":   0.0,
+			},
+		},
+		"sar-cpu": {
+			Description: "The CPU usage of all CPUs from sar -u (e.g. sar -u 1)",
+			Options: map[string]any{
+				"regex": `all\s+(?P<user>[0-9.]+)\s+(?P<nice>[0-9.]+)\s+(?P<system>[0-9.]+)\s+(?P<iowait>[0-9.]+)\s+(?P<steal>[0-9.]+)\s+(?P<idle>[0-9.]+)\s*$`,
+				"title": "CPU usage",
+				"yunit": "%",
+				"ymin":  0.0,
+				"ymax":  100.0,
+			},
+		},
+		"vmstat": {
+			Description: "The CPU usage from vmstat (e.g. vmstat 1)",
+			Options: map[string]any{
+				// Only the lines starting with numbers match, so the headers are
+				// ignored. Newer versions of vmstat have an additional column at the
+				// end, which is not captured.
+				"regex": `^\s*(?:\d+\s+){12}(?P<us>\d+)\s+(?P<sy>\d+)\s+(?P<id>\d+)\s+(?P<wa>\d+)`,
+				"title": "CPU usage",
+				"yunit": "%",
+				"ymin":  0.0,
+				"ymax":  100.0,
+			},
+		},
+	}
+)
+
+// Registers a preset, replacing any preset with the same name.
+func RegisterPreset(name string, preset Preset) {
+	presetsMutex.Lock()
+	defer presetsMutex.Unlock()
+
+	presets[name] = preset
+}
+
+func LookupPreset(name string) (Preset, bool) {
+	presetsMutex.Lock()
+	defer presetsMutex.Unlock()
+
+	preset, found := presets[name]
+	return preset, found
+}
+
+// Returns the names of the registered presets, sorted.
+func PresetNames() []string {
+	presetsMutex.Lock()
+	defer presetsMutex.Unlock()
+
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
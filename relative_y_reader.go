@@ -0,0 +1,61 @@
+package wesplot
+
+import (
+	"context"
+	"math"
+)
+
+// A DataRowReader that subtracts the first value of every series from its
+// values, so absolute counters are plotted as their growth since wesplot
+// started. This is the counterpart of Metadata.RelativeStart for the Y values,
+// but the first values are the first ones read, rather than the first ones
+// shown by the client. Missing values are skipped, so the first value of a
+// series is its first value that is not missing.
+type RelativeYDataRowReader struct {
+	input DataRowReader
+
+	// NaN until the first value of the series is read.
+	firstYs []float64
+}
+
+func NewRelativeYDataRowReader(input DataRowReader) *RelativeYDataRowReader {
+	firstYs := make([]float64, len(input.ColumnNames()))
+	for i := range firstYs {
+		firstYs[i] = math.NaN()
+	}
+
+	return &RelativeYDataRowReader{
+		input:   input,
+		firstYs: firstYs,
+	}
+}
+
+func (r *RelativeYDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if err != nil {
+		return dataRow, err
+	}
+
+	for i, y := range dataRow.Ys {
+		if i >= len(r.firstYs) || math.IsNaN(y) {
+			continue
+		}
+
+		if math.IsNaN(r.firstYs[i]) {
+			r.firstYs[i] = y
+		}
+
+		dataRow.Ys[i] = y - r.firstYs[i]
+	}
+
+	// The TeeWriter writes the relative values.
+	if dataRow.Ys != nil {
+		dataRow.yTexts = nil
+	}
+
+	return dataRow, nil
+}
+
+func (r *RelativeYDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
@@ -0,0 +1,223 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// How many rows can be queued for the remote before new rows are dropped.
+// This prevents a slow or unreachable remote from blocking the local plot.
+const relayQueueCapacity = 100000
+
+// How long to wait before reconnecting to the remote after a failure.
+const relayReconnectDelay = time.Second
+
+// A DataRowReader that passes the rows from the input through unmodified,
+// while forwarding them to a remote wesplot started with --ingest via its
+// /ws-ingest endpoint. This allows a headless machine to collect the data
+// while the plot is served from another host.
+//
+// Rows are sent in batches every flush interval. If the remote is unreachable,
+// the connection is retried and rows are queued in the meantime, up to
+// relayQueueCapacity, after which they are dropped.
+type RelayDataRowReader struct {
+	input         DataRowReader
+	url           string
+	flushInterval time.Duration
+
+	queue     chan DataRow
+	closeOnce sync.Once
+	done      chan struct{}
+
+	logger logrus.FieldLogger
+}
+
+// Creates the reader and starts forwarding in the background. The remote can
+// be given as the base URL of the remote wesplot (http://host:5274), in which
+// case /ws-ingest is used, or as a full websocket URL.
+func NewRelayDataRowReader(input DataRowReader, remote string, flushInterval time.Duration) (*RelayDataRowReader, error) {
+	remoteUrl, err := relayWebSocketUrl(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RelayDataRowReader{
+		input:         input,
+		url:           remoteUrl,
+		flushInterval: flushInterval,
+		queue:         make(chan DataRow, bufferSize),
+		done:          make(chan struct{}),
+		logger:        logrus.WithFields(logrus.Fields{"tag": "Relay", "remote": remoteUrl}),
+	}
+
+	go r.forward()
+	return r, nil
+}
+
+func relayWebSocketUrl(remote string) (string, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return "", fmt.Errorf("invalid relay remote %q: %w", remote, err)
+	}
+
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid relay remote %q, expected an http(s) or ws(s) URL", remote)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/ws-ingest"
+	}
+
+	return u.String(), nil
+}
+
+func (r *RelayDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if err != nil {
+		if !errors.Is(err, errIgnoreThisRow) {
+			// The stream has ended, so flush the queue and disconnect.
+			r.closeOnce.Do(func() {
+				close(r.queue)
+			})
+		}
+
+		return dataRow, err
+	}
+
+	// The ingest endpoints only accept rows.
+	if dataRow.annotation != nil {
+		return dataRow, nil
+	}
+
+	select {
+	case r.queue <- dataRow:
+	default:
+		r.logger.Warn("relay queue is full, dropping row")
+	}
+
+	return dataRow, nil
+}
+
+func (r *RelayDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
+
+// Waits until all rows are forwarded after the input stream ended.
+func (r *RelayDataRowReader) Wait() {
+	<-r.done
+}
+
+func (r *RelayDataRowReader) forward() {
+	defer close(r.done)
+
+	var conn *websocket.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close(websocket.StatusNormalClosure, "")
+		}
+	}()
+
+	batch := make([]DataRow, 0)
+	dropped := 0
+	ended := false
+	var nextAttempt time.Time
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	for !ended {
+		select {
+		case dataRow, open := <-r.queue:
+			if !open {
+				ended = true
+				break
+			}
+
+			// The batch keeps growing while the remote is unreachable.
+			if len(batch) >= relayQueueCapacity {
+				dropped++
+			} else {
+				batch = append(batch, dataRow)
+			}
+			continue
+		case <-ticker.C:
+		}
+
+		// After a failure, wait before retrying unless this is the final attempt.
+		if len(batch) == 0 || (!ended && time.Now().Before(nextAttempt)) {
+			continue
+		}
+
+		if dropped > 0 {
+			r.logger.WithField("rows", dropped).Warn("relay queue was full, dropped rows")
+			dropped = 0
+		}
+
+		var err error
+		if conn == nil {
+			conn, err = r.connect()
+		}
+
+		if err == nil {
+			err = r.send(conn, batch)
+		}
+
+		if err != nil {
+			if conn != nil {
+				conn.Close(websocket.StatusInternalError, "")
+				conn = nil
+			}
+
+			if ended {
+				r.logger.WithError(err).WithField("rows", len(batch)).Error("stream ended and the remote is unreachable, dropping rows")
+			} else {
+				r.logger.WithError(err).Warn("cannot forward rows to remote, retrying")
+				nextAttempt = time.Now().Add(relayReconnectDelay)
+			}
+
+			continue
+		}
+
+		batch = batch[:0]
+	}
+}
+
+func (r *RelayDataRowReader) connect() (*websocket.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, r.url, &websocket.DialOptions{
+		Subprotocols: []string{ProtocolV1},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Info("connected to remote")
+	return conn, nil
+}
+
+func (r *RelayDataRowReader) send(conn *websocket.Conn, batch []DataRow) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return conn.Write(ctx, websocket.MessageText, data)
+}
@@ -0,0 +1,304 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"nhooyr.io/websocket"
+)
+
+// The largest message accepted from a remote wesplot. The history is sent in
+// chunks of historyChunkSize rows, which are larger than the default limit of
+// the websocket library.
+const remoteReadLimit = 64 << 20
+
+// How long to wait before reconnecting to the remote after the first failure.
+// The delay is doubled after every failure, up to remoteReconnectMaxDelay.
+const remoteReconnectMinDelay = 500 * time.Millisecond
+const remoteReconnectMaxDelay = 30 * time.Second
+
+// How much the start time of the remote, computed from its uptime, can differ
+// between two connections before the remote is considered restarted.
+const remoteRestartTolerance = time.Second
+
+var errRemoteConnectionLost = errors.New("lost connection to remote")
+
+// A DataRowReader that reads the rows and the annotations of another running
+// wesplot from its /ws endpoint, so its plot can be served again, for example
+// next to other plots in a dashboard (see HttpServer.AddStream).
+//
+// The reader starts with the history of the remote, unless the history mode
+// says otherwise (see HistoryMode). The stream ends with
+// io.EOF when the stream of the remote ends, or with an error if the remote
+// stream failed or the connection was lost (unless it reconnects, see
+// SetReconnect).
+type RemoteDataRowReader struct {
+	baseUrl   string
+	token     string
+	metadata  Metadata
+	history   HistoryMode
+	reconnect bool
+
+	conn    *websocket.Conn
+	pending []DataRow
+
+	// The sequence number of the last row read, to resume from it after a
+	// reconnection, and the time the remote started, to detect that it
+	// restarted with new sequence numbers.
+	lastSeq   uint64
+	startTime time.Time
+
+	logger logrus.FieldLogger
+}
+
+// Fetches the metadata of the remote and connects to its /ws endpoint. The
+// remote is given as the URL of its web UI (http://host:5274, or with its
+// --base-path). The token is sent as a bearer token if the remote requires
+// one (see HttpServer.SetAccessTokens).
+func NewRemoteDataRowReader(ctx context.Context, remote string, token string, history HistoryMode) (*RemoteDataRowReader, error) {
+	u, err := url.Parse(remote)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote %q, expected an http(s) URL", remote)
+	}
+
+	r := &RemoteDataRowReader{
+		baseUrl: strings.TrimSuffix(u.String(), "/"),
+		token:   token,
+		history: history,
+		logger:  logrus.WithFields(logrus.Fields{"tag": "Remote", "remote": remote}),
+	}
+
+	err = r.getJSON(ctx, "/metadata", &r.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the metadata of %s: %w", remote, err)
+	}
+
+	err = r.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", remote, err)
+	}
+
+	return r, nil
+}
+
+// Reconnects to the remote when the connection is lost, instead of ending the
+// stream, such as when the network is down or the remote restarts. The rows
+// are resumed after the last row read (see the resume parameter of /ws), so
+// they are not duplicated, unless they are no longer buffered by the remote.
+// If the remote restarted, all its buffered rows are read, even with
+// HistoryNone. Must be called before Read.
+func (r *RemoteDataRowReader) SetReconnect(reconnect bool) {
+	r.reconnect = reconnect
+}
+
+func (r *RemoteDataRowReader) connect(ctx context.Context) error {
+	var stats StatsMessage
+	err := r.getJSON(ctx, "/stats", &stats)
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now().Add(-time.Duration(stats.Input.Uptime * float64(time.Second)))
+	restarted := !r.startTime.IsZero() && (startTime.Sub(r.startTime) > remoteRestartTolerance || r.startTime.Sub(startTime) > remoteRestartTolerance)
+	if restarted {
+		r.logger.Warn("remote restarted, reading all its buffered rows")
+		r.lastSeq = 0
+	}
+	r.startTime = startTime
+
+	// Once rows are read, the rows after them are not old.
+	history := r.history
+	if history == HistoryNone && (r.lastSeq > 0 || restarted) {
+		history = HistoryAll
+	}
+
+	wsUrl := fmt.Sprintf("ws%s/ws?annotations&resume=%d&history=%s", strings.TrimPrefix(r.baseUrl, "http"), r.lastSeq, history.param())
+	r.conn, _, err = websocket.Dial(ctx, wsUrl, &websocket.DialOptions{
+		Subprotocols: []string{ProtocolV1},
+		HTTPHeader:   r.header(),
+	})
+	if err != nil {
+		return err
+	}
+
+	r.conn.SetReadLimit(remoteReadLimit)
+	r.logger.WithField("lastSeq", r.lastSeq).Info("connected to remote")
+	return nil
+}
+
+// Waits twice as long after every failure to reconnect, up to
+// remoteReconnectMaxDelay.
+func (r *RemoteDataRowReader) reconnectWithBackoff(ctx context.Context, cause error) error {
+	delay := remoteReconnectMinDelay
+	for {
+		r.logger.WithError(cause).Warnf("reconnecting in %v", delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		cause = r.connect(ctx)
+		if cause == nil {
+			return nil
+		}
+
+		delay = Min(2*delay, remoteReconnectMaxDelay)
+	}
+}
+
+func (r *RemoteDataRowReader) header() http.Header {
+	header := http.Header{}
+	if r.token != "" {
+		header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	return header
+}
+
+func (r *RemoteDataRowReader) getJSON(ctx context.Context, path string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseUrl+path, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header = r.header()
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// The metadata of the remote when it was connected.
+func (r *RemoteDataRowReader) Metadata() Metadata {
+	return r.metadata
+}
+
+func (r *RemoteDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	for len(r.pending) == 0 {
+		err := r.readMessage(ctx)
+		if r.reconnect && errors.Is(err, errRemoteConnectionLost) {
+			err = r.reconnectWithBackoff(ctx, err)
+		}
+
+		if err != nil {
+			return DataRow{}, err
+		}
+	}
+
+	dataRow := r.pending[0]
+	r.pending = r.pending[1:]
+	return dataRow, nil
+}
+
+// A row received from /ws, in which the missing values are null.
+type remoteRow struct {
+	Seq uint64
+	X   float64
+	Ys  []*float64
+	Xs  []*float64
+}
+
+func (r *RemoteDataRowReader) readMessage(ctx context.Context) error {
+	_, data, err := r.conn.Read(ctx)
+	if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+		return r.streamEnded(ctx)
+	} else if err != nil {
+		r.conn.Close(websocket.StatusInternalError, "")
+		return fmt.Errorf("%w: %v", errRemoteConnectionLost, err)
+	}
+
+	if len(data) > 0 && data[0] == '[' {
+		var rows []remoteRow
+		err = json.Unmarshal(data, &rows)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			// Resent if the remote no longer buffers the rows to resume from.
+			if row.Seq <= r.lastSeq {
+				continue
+			}
+
+			r.lastSeq = row.Seq
+			dataRow := DataRow{X: row.X, Ys: make([]float64, len(row.Ys))}
+			for i, y := range row.Ys {
+				if y == nil {
+					dataRow.Ys[i] = math.NaN()
+				} else {
+					dataRow.Ys[i] = *y
+				}
+			}
+
+			dataRow.Xs = decodeNullableFloats(row.Xs)
+			if err := dataRow.checkXs(); err != nil {
+				return err
+			}
+
+			r.pending = append(r.pending, dataRow)
+		}
+
+		return nil
+	}
+
+	var message struct {
+		Annotation *Annotation
+	}
+
+	err = json.Unmarshal(data, &message)
+	if err != nil {
+		return err
+	}
+
+	if message.Annotation != nil {
+		r.pending = append(r.pending, DataRow{X: message.Annotation.X, annotation: message.Annotation})
+	}
+
+	return nil
+}
+
+// Returns io.EOF, or an error if the stream of the remote ended with one.
+func (r *RemoteDataRowReader) streamEnded(ctx context.Context) error {
+	// The error itself is not serialized by /errors, only whether there is one.
+	var message struct {
+		StreamError json.RawMessage
+	}
+
+	err := r.getJSON(ctx, "/errors", &message)
+	if err != nil {
+		r.logger.WithError(err).Warn("cannot check if the remote stream ended with an error")
+		return io.EOF
+	}
+
+	if len(message.StreamError) > 0 && string(message.StreamError) != "null" {
+		return errors.New("remote stream ended with an error")
+	}
+
+	return io.EOF
+}
+
+func (r *RemoteDataRowReader) ColumnNames() []string {
+	return r.metadata.WesplotOptions.Columns
+}
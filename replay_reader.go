@@ -0,0 +1,77 @@
+package wesplot
+
+import (
+	"context"
+	"time"
+)
+
+// A DataRowReader that paces the rows read from the input so they are emitted
+// with the same timing as their X values (which should be timestamps in
+// seconds), sped up by a factor. This allows historical data, such as a
+// previously recorded CSV file, to be replayed as if it is live.
+//
+// Rows with timestamps after the reader is created are considered live (for
+// example, new rows appended to a file being tailed) and are passed through
+// without delay.
+type ReplayDataRowReader struct {
+	input DataRowReader
+	speed float64
+
+	liveAfter float64
+
+	started   bool
+	lastX     float64
+	scheduled time.Time
+}
+
+func NewReplayDataRowReader(input DataRowReader, speed float64) *ReplayDataRowReader {
+	return &ReplayDataRowReader{
+		input:     input,
+		speed:     speed,
+		liveAfter: NowXGenerator(nil),
+	}
+}
+
+func (r *ReplayDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if err != nil {
+		return dataRow, err
+	}
+
+	// Annotations are sent as soon as the row before them.
+	if dataRow.X >= r.liveAfter || dataRow.annotation != nil {
+		return dataRow, nil
+	}
+
+	if !r.started {
+		r.started = true
+		r.lastX = dataRow.X
+		r.scheduled = time.Now()
+		return dataRow, nil
+	}
+
+	// The schedule is advanced based on the previous scheduled time rather than
+	// the actual time to ensure processing time does not accumulate as drift.
+	delta := (dataRow.X - r.lastX) / r.speed
+	r.lastX = dataRow.X
+	r.scheduled = r.scheduled.Add(time.Duration(delta * float64(time.Second)))
+
+	wait := time.Until(r.scheduled)
+	if wait <= 0 {
+		return dataRow, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return dataRow, nil
+	case <-ctx.Done():
+		return DataRow{}, ctx.Err()
+	}
+}
+
+func (r *ReplayDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
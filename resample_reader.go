@@ -0,0 +1,79 @@
+package wesplot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A DataRowReader that resamples irregularly timed rows into rows at a fixed
+// interval of X (which is normally a timestamp in seconds), such as to overlay
+// series sampled at different times, or to plot fewer points. Every interval
+// is reduced to a single row by applying the same function to every series,
+// and the columns keep their names.
+//
+// This is an AggregateDataRowReader with a single function, so the X value of
+// the emitted row is the end of the interval, and an interval is only emitted
+// once a row from a later interval (or EOF) is read. The intervals without
+// rows are not emitted.
+type ResampleDataRowReader struct {
+	input     DataRowReader
+	interval  time.Duration
+	aggregate *AggregateDataRowReader
+}
+
+// Creates the reader from a spec such as 1s:mean or 10s:max. The supported
+// functions are mean, min, max, and last (the last value of the interval), as
+// well as the other functions of AggregateDataRowReader that return a single
+// value, such as first or p50. The function defaults to mean.
+func NewResampleDataRowReader(input DataRowReader, spec string) (*ResampleDataRowReader, error) {
+	intervalSpec, function, found := strings.Cut(spec, ":")
+	if !found {
+		function = "mean"
+	}
+
+	interval, err := time.ParseDuration(intervalSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resample interval %q, expected interval:function (e.g. 1s:mean): %w", intervalSpec, err)
+	}
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("resample interval must be positive, got %q", intervalSpec)
+	}
+
+	switch function {
+	case "last":
+		function = "close"
+	case "first":
+		function = "open"
+	}
+
+	if _, ok := candleAggregates[function]; ok || strings.Contains(function, ",") {
+		return nil, fmt.Errorf("invalid resample function %q, expected a single function such as mean, min, max, or last", function)
+	}
+
+	aggregate, err := NewAggregateDataRowReader(input, function+":"+interval.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResampleDataRowReader{
+		input:     input,
+		interval:  interval,
+		aggregate: aggregate,
+	}, nil
+}
+
+func (r *ResampleDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	return r.aggregate.Read(ctx)
+}
+
+func (r *ResampleDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
+
+// The interval of the rows emitted, when there is a row in every interval.
+func (r *ResampleDataRowReader) Interval() time.Duration {
+	return r.interval
+}
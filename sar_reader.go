@@ -0,0 +1,232 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The command converting the binary file written by sar -o or sadc (e.g.
+// /var/log/sa/sa17) into the lines read by NewSarDataRowReader, with the
+// timestamps in seconds since the epoch. The sar options select the
+// activities, such as -u for the CPU usage or "-n DEV" for the network
+// interfaces, and are passed to the shell as they are.
+func SadfCommand(path string, sarOptions string) string {
+	return "sadf -d -U " + shellQuote(path) + " -- " + sarOptions
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Reads the output of sadf -d, where every line has the values of an activity
+// at a time, separated by semicolons, after a header line with the names of
+// the fields of the activity:
+//
+//	# hostname;interval;timestamp;CPU;%user;%nice;%system;%iowait;%steal;%idle
+//	web1;600;1697501401;-1;2.10;0.00;1.02;0.13;0.00;96.75
+//
+// Every value is a series labeled with the name of its field (e.g. %user or
+// kbmemfree). The activities of devices, such as the network interfaces (-n
+// DEV) or the CPUs (-P ALL), have the name of the device before the values,
+// which prefixes the label (e.g. "eth0 rxkB/s", or "cpu0 %user" for the CPU
+// 0). The line of all the CPUs (-1) is labeled without a device. The X value
+// is the timestamp of the line, in seconds since the epoch with sadf -U, or
+// as printed by sadf otherwise (in UTC, or in local time with -t). The
+// restarts of the system are ignored.
+//
+// sadf can print all the times of an activity before the next activity, so
+// the lines are read until EOF before the first row is emitted, and the
+// values of the same time are emitted together, in the order of the times.
+func NewSarDataRowReader(input StringReader, columns []string) *LabeledDataRowReader {
+	logger := logrus.WithField("tag", "Sar")
+
+	var points []labeledPoint
+	var endErr error
+	loaded := false
+	read := func(ctx context.Context) (labeledPoint, error) {
+		if !loaded {
+			points, endErr = readSarPoints(ctx, input, logger)
+			loaded = true
+		}
+
+		if len(points) == 0 {
+			return labeledPoint{}, endErr
+		}
+
+		point := points[0]
+		points = points[1:]
+		return point, nil
+	}
+
+	return newLabeledDataRowReader(read, columns, logger)
+}
+
+// Reads the lines until the input ends, and returns their values merged by
+// time, ordered by time, and the error that ended the input.
+func readSarPoints(ctx context.Context, input StringReader, logger logrus.FieldLogger) ([]labeledPoint, error) {
+	pointsByTime := make(map[float64]labeledPoint)
+	parser := sarParser{}
+
+	for {
+		fields, err := input.Read(ctx)
+		if errors.Is(err, errIgnoreThisRow) {
+			continue
+		}
+
+		if err != nil {
+			points := make([]labeledPoint, 0, len(pointsByTime))
+			for _, point := range pointsByTime {
+				points = append(points, point)
+			}
+
+			sort.Slice(points, func(i, j int) bool {
+				return points[i].timestamp < points[j].timestamp
+			})
+
+			logger.WithField("points", len(points)).Info("read the output of sadf")
+			return points, err
+		}
+
+		if len(fields) == 0 || fields[0] == "" {
+			continue
+		}
+
+		timestamp, values, err := parser.parse(fields[0])
+		if err != nil {
+			logger.WithError(err).WithField("line", fields[0]).Warn("cannot parse line, ignoring...")
+			continue
+		}
+
+		if values == nil {
+			continue
+		}
+
+		point, ok := pointsByTime[timestamp]
+		if !ok {
+			point = labeledPoint{values: values, hasTimestamp: true, timestamp: timestamp}
+			pointsByTime[timestamp] = point
+			continue
+		}
+
+		for label, value := range values {
+			point.values[label] = value
+		}
+	}
+}
+
+// Parses the lines of sadf -d, with the fields of the last header line.
+type sarParser struct {
+	device string // The name of the device field, if the activity has one
+	fields []string
+}
+
+// Returns nil values for the header lines and the restarts.
+func (p *sarParser) parse(line string) (timestamp float64, values map[string]float64, err error) {
+	if header, ok := strings.CutPrefix(line, "#"); ok {
+		fields := strings.Split(strings.TrimSpace(header), ";")
+		if len(fields) < 4 {
+			return 0, nil, errors.New("the header has no fields after the timestamp")
+		}
+
+		p.device = ""
+		p.fields = fields[3:]
+		if isSarDevice(fields[3]) {
+			p.device = fields[3]
+			p.fields = fields[4:]
+		}
+
+		return 0, nil, nil
+	}
+
+	parts := strings.Split(line, ";")
+	if len(parts) < 3 {
+		return 0, nil, errors.New("expected hostname;interval;timestamp;values")
+	}
+
+	// The restarts have an interval of -1 and the number of CPUs instead of
+	// the values.
+	if parts[1] == "-1" {
+		return 0, nil, nil
+	}
+
+	if p.fields == nil {
+		return 0, nil, errors.New("no header line before the values")
+	}
+
+	timestamp, err = parseSarTimestamp(parts[2])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	prefix := ""
+	parts = parts[3:]
+	if p.device != "" {
+		if len(parts) == 0 {
+			return 0, nil, fmt.Errorf("expected the %s before the values", p.device)
+		}
+
+		switch {
+		case p.device == "CPU" && parts[0] == "-1":
+		case p.device == "CPU":
+			prefix = "cpu" + parts[0] + " "
+		default:
+			prefix = parts[0] + " "
+		}
+
+		parts = parts[1:]
+	}
+
+	if len(parts) != len(p.fields) {
+		return 0, nil, fmt.Errorf("expected %d values as in the header, got %d", len(p.fields), len(parts))
+	}
+
+	values = make(map[string]float64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid value of %s: %w", p.fields[i], err)
+		}
+
+		values[prefix+p.fields[i]] = value
+	}
+
+	return timestamp, values, nil
+}
+
+// The names of the device fields are uppercase (e.g. CPU, IFACE, or DEV),
+// unlike the names of the values (e.g. %user, rxkB/s, or tps).
+func isSarDevice(field string) bool {
+	for _, r := range field {
+		if !unicode.IsUpper(r) {
+			return false
+		}
+	}
+
+	return field != ""
+}
+
+// Parses the timestamps of sadf -U, or the times printed without -U, such as
+// 2023-10-17 00:10:01 UTC.
+func parseSarTimestamp(value string) (float64, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		return seconds, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05"} {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err == nil {
+			return float64(t.UnixNano()) / 1e9, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid timestamp %q", value)
+}
@@ -0,0 +1,161 @@
+package wesplot
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// The SI prefixes by their power of ten, from pico to peta.
+var siPrefixes = map[int]string{
+	-12: "p",
+	-9:  "n",
+	-6:  "µ",
+	-3:  "m",
+	0:   "",
+	3:   "k",
+	6:   "M",
+	9:   "G",
+	12:  "T",
+	15:  "P",
+}
+
+// The units that can follow an SI prefix, so the prefix of a unit such as ms
+// is recognized while the m of min is not.
+var siBaseUnits = []string{"s", "B", "b", "bit", "Hz", "W", "Wh", "V", "A", "g", "m", "J", "Pa", "bps", "B/s", "b/s", "bit/s"}
+
+// A DataRowReader that multiplies the Y values by a scale, such as to plot
+// bytes as megabytes with a scale of 1e-6. With automatic scaling (see
+// NewSIDataRowReader), the scale is the SI prefix that shows the first value
+// between 1 and 1000.
+type ScaleDataRowReader struct {
+	input DataRowReader
+	scale float64 // 0 until it is chosen with automatic scaling
+
+	onScaleChosen func(scale float64)
+}
+
+func NewScaleDataRowReader(input DataRowReader, scale float64) *ScaleDataRowReader {
+	return &ScaleDataRowReader{
+		input: input,
+		scale: scale,
+	}
+}
+
+// Scales the Y values with the SI prefix that shows the first non-zero value
+// between 1 and 1000, such as 1e-6 if it is 2500000.
+func NewSIDataRowReader(input DataRowReader) *ScaleDataRowReader {
+	return &ScaleDataRowReader{
+		input: input,
+	}
+}
+
+// Calls the listener once the scale is chosen with automatic scaling, such as
+// to change the unit of the Y axis (see ScaleUnits). Must be called before
+// Read.
+func (r *ScaleDataRowReader) SetScaleListener(listener func(scale float64)) {
+	r.onScaleChosen = listener
+}
+
+func (r *ScaleDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if err != nil {
+		return dataRow, err
+	}
+
+	if r.scale == 0 {
+		r.chooseScale(dataRow.Ys)
+		if r.scale == 0 {
+			return dataRow, nil
+		}
+	}
+
+	if r.scale == 1 || len(dataRow.Ys) == 0 {
+		return dataRow, nil
+	}
+
+	for i := range dataRow.Ys {
+		dataRow.Ys[i] *= r.scale
+	}
+
+	// The TeeWriter writes the scaled values.
+	dataRow.yTexts = nil
+	return dataRow, nil
+}
+
+func (r *ScaleDataRowReader) chooseScale(ys []float64) {
+	for _, y := range ys {
+		if y == 0 || math.IsNaN(y) || math.IsInf(y, 0) {
+			continue
+		}
+
+		exponent := int(math.Floor(math.Log10(math.Abs(y))/3)) * 3
+		exponent = Max(Min(exponent, 15), -12)
+
+		r.scale = math.Pow10(-exponent)
+		if r.onScaleChosen != nil {
+			r.onScaleChosen(r.scale)
+		}
+		return
+	}
+}
+
+func (r *ScaleDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}
+
+// Returns the unit of the values multiplied by scale, such as MB for B with a
+// scale of 1e-6, or ms for ns with a scale of 1e-6. A unit without a known SI
+// prefix is prefixed as a whole, such as kops for ops with a scale of 1e-3.
+// Returns false if the scale is not a power of ten between two SI prefixes.
+func ScaledUnit(unit string, scale float64) (string, bool) {
+	if scale == 1 {
+		return unit, true
+	}
+
+	// Not compared exactly, as 1e-6 is not exactly a power of ten.
+	scaleExponent := math.Round(math.Log10(scale))
+	if scale <= 0 || math.Abs(scale/math.Pow10(int(scaleExponent))-1) > 1e-9 {
+		return unit, false
+	}
+
+	base, exponent := unit, 0
+	for prefixExponent, prefix := range siPrefixes {
+		// u is often typed instead of µ.
+		if prefixExponent == -6 && strings.HasPrefix(unit, "u") {
+			prefix = "u"
+		}
+
+		if prefix == "" || !strings.HasPrefix(unit, prefix) {
+			continue
+		}
+
+		for _, baseUnit := range siBaseUnits {
+			if unit[len(prefix):] == baseUnit {
+				base, exponent = baseUnit, prefixExponent
+			}
+		}
+	}
+
+	// The values are scaled up when the unit is scaled down.
+	prefix, ok := siPrefixes[exponent-int(scaleExponent)]
+	if !ok {
+		return unit, false
+	}
+
+	return prefix + base, true
+}
+
+// Changes the units of the Y axes of the metadata for values multiplied by
+// scale (see ScaledUnit). The units that cannot be scaled are kept.
+func ScaleUnits(metadata *Metadata, scale float64) {
+	metadata.WesplotOptions.YUnit, _ = ScaledUnit(metadata.WesplotOptions.YUnit, scale)
+
+	// The panels may be shared with a copy of the metadata.
+	metadata.Panels = slices.Clone(metadata.Panels)
+	for i := range metadata.Panels {
+		metadata.Panels[i].YUnit, _ = ScaledUnit(metadata.Panels[i].YUnit, scale)
+	}
+}
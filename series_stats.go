@@ -0,0 +1,98 @@
+package wesplot
+
+import (
+	"math"
+	"sync"
+)
+
+// Computes streaming summary statistics for every series since the start of
+// the stream, so clients can show them without recomputing them from the data.
+// Missing values (NaN) are skipped.
+type SeriesStats struct {
+	mutex sync.Mutex
+
+	columns []string
+	series  []seriesAccumulator
+}
+
+type SeriesStatsSnapshot struct {
+	Name   string
+	Count  int64
+	Min    float64
+	Max    float64
+	Mean   float64
+	Stddev float64 // Population standard deviation
+	Last   float64
+}
+
+// Uses Welford's online algorithm for the mean and variance, which is
+// numerically stable.
+type seriesAccumulator struct {
+	count int64
+	min   float64
+	max   float64
+	mean  float64
+	m2    float64
+	last  float64
+}
+
+func NewSeriesStats(columns []string) *SeriesStats {
+	return &SeriesStats{
+		columns: columns,
+		series:  make([]seriesAccumulator, len(columns)),
+	}
+}
+
+func (s *SeriesStats) Update(ys []float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, y := range ys {
+		if i >= len(s.series) {
+			break
+		}
+
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			continue
+		}
+
+		acc := &s.series[i]
+		acc.count++
+
+		if acc.count == 1 {
+			acc.min = y
+			acc.max = y
+		} else {
+			acc.min = math.Min(acc.min, y)
+			acc.max = math.Max(acc.max, y)
+		}
+
+		delta := y - acc.mean
+		acc.mean += delta / float64(acc.count)
+		acc.m2 += delta * (y - acc.mean)
+		acc.last = y
+	}
+}
+
+func (s *SeriesStats) Snapshot() []SeriesStatsSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshots := make([]SeriesStatsSnapshot, len(s.series))
+	for i, acc := range s.series {
+		snapshots[i] = SeriesStatsSnapshot{
+			Name:  s.columns[i],
+			Count: acc.count,
+			Min:   acc.min,
+			Max:   acc.max,
+			Mean:  acc.mean,
+			Last:  acc.last,
+		}
+
+		if acc.count > 0 {
+			snapshots[i].Stddev = math.Sqrt(acc.m2 / float64(acc.count))
+		}
+	}
+
+	return snapshots
+}
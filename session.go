@@ -0,0 +1,137 @@
+package wesplot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// A session file records a plot, so it can be replayed later with the same
+// title, columns, units and axes. It is in the JSON lines format: the first
+// line is {"Metadata": {...}} with the Metadata of the plot, and every other
+// line is a row in the format of TeeFormatJSONL. Session files are written by
+// NewSessionRecorder and read by OpenSession.
+type sessionHeader struct {
+	Metadata Metadata
+}
+
+// A DataRowReader that reads the rows of a session file, until io.EOF.
+type SessionDataRowReader struct {
+	file     *os.File
+	input    *bufio.Reader
+	metadata Metadata
+	line     int
+}
+
+// Opens the session file at path and reads its metadata.
+func OpenSession(path string) (*SessionDataRowReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &SessionDataRowReader{
+		file:  file,
+		input: bufio.NewReader(file),
+	}
+
+	data, err := r.readLine()
+	if err == io.EOF {
+		file.Close()
+		return nil, fmt.Errorf("%s is empty", path)
+	} else if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var header sessionHeader
+	err = json.Unmarshal(data, &header)
+	if err != nil || len(header.Metadata.WesplotOptions.Columns) == 0 {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a session file recorded with --record", path)
+	}
+
+	r.metadata = header.Metadata
+	return r, nil
+}
+
+// Whether the file at path starts like a session file, to tell it apart from
+// other captures such as CSV files.
+func IsSessionFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	prefix := make([]byte, len(`{"Metadata"`))
+	_, err = io.ReadFull(file, prefix)
+	return err == nil && string(prefix) == `{"Metadata"`
+}
+
+// The metadata of the plot when it was recorded.
+func (r *SessionDataRowReader) Metadata() Metadata {
+	return r.metadata
+}
+
+func (r *SessionDataRowReader) readLine() ([]byte, error) {
+	data, err := r.input.ReadBytes('\n')
+	if err == io.EOF && len(data) > 0 {
+		err = nil
+	}
+
+	r.line++
+	return data, err
+}
+
+func (r *SessionDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	data, err := r.readLine()
+	if err != nil {
+		return DataRow{}, err
+	}
+
+	var row ingestRow
+	err = json.Unmarshal(data, &row)
+	if err != nil || row.X == nil {
+		// The last row of a session that was not closed properly may be cut.
+		if !bytes.HasSuffix(data, []byte("\n")) {
+			return DataRow{}, io.EOF
+		}
+
+		return DataRow{}, fmt.Errorf("invalid row on line %d of the session: %s", r.line, data)
+	}
+
+	dataRow := DataRow{X: *row.X, Ys: make([]float64, len(row.Ys))}
+	for i, y := range row.Ys {
+		if y == nil {
+			dataRow.Ys[i] = math.NaN()
+		} else {
+			dataRow.Ys[i] = *y
+		}
+	}
+
+	dataRow.Xs = decodeNullableFloats(row.Xs)
+	if err := dataRow.checkXs(); err != nil {
+		return DataRow{}, fmt.Errorf("invalid row on line %d of the session: %w", r.line, err)
+	}
+
+	if row.C != nil {
+		dataRow.color = *row.C
+		dataRow.hasColor = true
+	}
+
+	return dataRow, nil
+}
+
+func (r *SessionDataRowReader) ColumnNames() []string {
+	return r.metadata.WesplotOptions.Columns
+}
+
+func (r *SessionDataRowReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,201 @@
+package wesplot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A StringReader that listens on a TCP or UDP socket and reads text rows sent
+// by remote producers. Every TCP connection and every UDP datagram can contain
+// one or more newline separated rows, which are split the same way as the
+// RelaxedStringReader. Rows from all connections are fed into the same
+// pipeline, in the order they are received.
+type SocketStringReader struct {
+	listener   net.Listener
+	packetConn net.PacketConn
+	split      func(line string) []string
+
+	lines chan []string
+	errs  chan error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	logger logrus.FieldLogger
+}
+
+// Creates and starts a SocketStringReader. The address is specified as an URL
+// with the form of tcp://host:port or udp://host:port. The host can be omitted
+// to listen on all interfaces.
+func NewSocketStringReader(address string) (*SocketStringReader, error) {
+	return newSocketStringReader(address, splitRelaxed)
+}
+
+// Creates a SocketStringReader that returns every line whole, as the
+// LineStringReader.
+func NewSocketLineReader(address string) (*SocketStringReader, error) {
+	return newSocketStringReader(address, func(line string) []string {
+		return []string{line}
+	})
+}
+
+func newSocketStringReader(address string, split func(line string) []string) (*SocketStringReader, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+
+	r := &SocketStringReader{
+		split:  split,
+		lines:  make(chan []string, bufferSize),
+		errs:   make(chan error, 1),
+		closed: make(chan struct{}),
+		logger: logrus.WithFields(logrus.Fields{"tag": "SocketString", "address": address}),
+	}
+
+	switch u.Scheme {
+	case "tcp", "tcp4", "tcp6":
+		r.listener, err = net.Listen(u.Scheme, u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		go r.acceptLoop()
+	case "udp", "udp4", "udp6":
+		r.packetConn, err = net.ListenPacket(u.Scheme, u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		go r.packetLoop()
+	default:
+		return nil, fmt.Errorf("unsupported listen address scheme %q, expected tcp or udp", u.Scheme)
+	}
+
+	r.logger.Info("listening for data")
+	return r, nil
+}
+
+func (r *SocketStringReader) Read(ctx context.Context) ([]string, error) {
+	select {
+	case line := <-r.lines:
+		return line, nil
+	case err := <-r.errs:
+		return nil, err
+	case <-r.closed:
+		select {
+		case line := <-r.lines:
+			return line, nil
+		default:
+			return nil, io.EOF
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Stops listening and closes all connections. Rows that are already received
+// can still be read, after which Read returns io.EOF.
+func (r *SocketStringReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		if r.listener != nil {
+			err = r.listener.Close()
+		} else {
+			err = r.packetConn.Close()
+		}
+	})
+
+	return err
+}
+
+func (r *SocketStringReader) acceptLoop() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			r.stop(err)
+			return
+		}
+
+		go r.handleConn(conn)
+	}
+}
+
+func (r *SocketStringReader) handleConn(conn net.Conn) {
+	logger := r.logger.WithField("remote", conn.RemoteAddr())
+	logger.Info("producer connected")
+
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+
+	go func() {
+		select {
+		case <-r.closed:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if !r.emit(scanner.Text()) {
+			return
+		}
+	}
+
+	logger.WithError(scanner.Err()).Info("producer disconnected")
+}
+
+func (r *SocketStringReader) packetLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := r.packetConn.ReadFrom(buf)
+		if err != nil {
+			r.stop(err)
+			return
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if !r.emit(line) {
+				return
+			}
+		}
+	}
+}
+
+// Returns false if the reader is closed and no more data should be emitted.
+func (r *SocketStringReader) emit(line string) bool {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 {
+		return true
+	}
+
+	select {
+	case r.lines <- r.split(line):
+		return true
+	case <-r.closed:
+		return false
+	}
+}
+
+func (r *SocketStringReader) stop(err error) {
+	select {
+	case <-r.closed:
+		// Closed normally via Close().
+		return
+	default:
+	}
+
+	r.logger.WithError(err).Error("stopped listening for data")
+	r.errs <- err
+}
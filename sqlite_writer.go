@@ -0,0 +1,306 @@
+//go:build sqlite
+
+package wesplot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Whether wesplot was built with -tags sqlite, and can write SQLite databases.
+const SQLiteSupported = true
+
+// The table the SQLiteWriter appends the values to, with one row per value,
+// so the values of several streams and series can be queried together.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS wesplot (
+	stream TEXT NOT NULL,
+	series TEXT NOT NULL,
+	x      REAL NOT NULL,
+	y      REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS wesplot_stream_series_x ON wesplot (stream, series, x);
+`
+
+// Appends the rows emitted by the DataBroadcaster to a SQLite database, for
+// keeping more data than the window of the plot and analyzing it later with
+// SQL. Every value is a row of the wesplot table, with the stream, the name of
+// its series, and its X (the X of its series if the series have their own X,
+// see DataRow.Xs). The missing values are not written. The database is created
+// if it does not exist, and is appended to otherwise, so several wesplots can
+// share it with different stream names.
+//
+// The stored values of the stream are also served on /history (see History),
+// so the clients can show more data than the window.
+type SQLiteWriter struct {
+	db      *sql.DB
+	reader  *sql.DB // For /history, so the queries do not block the writes
+	insert  *sql.Stmt
+	stream  string
+	columns []string
+
+	// The rows are inserted in a single transaction every flush interval, as
+	// committing every row does not keep up with high rates.
+	mutex   sync.Mutex
+	pending []DataRow
+	// The error of the last flush, returned by the next Write.
+	flushErr error
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// Opens the database at path, into which the rows are written every
+// flushInterval. SQLite requires cgo, so this is only built with -tags sqlite
+// (see SQLiteSupported).
+func NewSQLiteWriter(path string, stream string, columns []string, flushInterval time.Duration) (*SQLiteWriter, error) {
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("the flush interval must be positive, got %s", flushInterval)
+	}
+
+	// With a write-ahead log, committing does not wait for the disk.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection, as SQLite only has a single writer.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot create the database: %w", err)
+	}
+
+	insert, err := db.Prepare("INSERT INTO wesplot (stream, series, x, y) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// With a write-ahead log, the readers do not wait for the writer.
+	reader, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		insert.Close()
+		db.Close()
+		return nil, err
+	}
+
+	w := &SQLiteWriter{
+		db:      db,
+		reader:  reader,
+		insert:  insert,
+		stream:  stream,
+		columns: columns,
+		done:    make(chan struct{}),
+	}
+
+	w.wg.Add(1)
+	go w.flushEvery(flushInterval)
+
+	return w, nil
+}
+
+// Queues the values of the row, which are inserted at the next flush. Returns
+// the error of the previous flush, if any.
+func (w *SQLiteWriter) Write(dataRow DataRow) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.flushErr != nil {
+		return w.flushErr
+	}
+
+	w.pending = append(w.pending, dataRow)
+	return nil
+}
+
+// Flushes the queued rows and closes the database. /history keeps serving the
+// stored values.
+func (w *SQLiteWriter) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.wg.Wait()
+
+		err = w.flush()
+		w.insert.Close()
+		closeErr := w.db.Close()
+		if err == nil {
+			err = closeErr
+		}
+	})
+
+	return err
+}
+
+func (w *SQLiteWriter) flushEvery(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := w.flush()
+			if err != nil {
+				w.mutex.Lock()
+				w.flushErr = err
+				w.mutex.Unlock()
+				return
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Inserts the queued rows in a single transaction.
+func (w *SQLiteWriter) flush() error {
+	w.mutex.Lock()
+	dataRows := w.pending
+	w.pending = nil
+	w.mutex.Unlock()
+
+	if len(dataRows) == 0 {
+		return nil
+	}
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insert := tx.Stmt(w.insert)
+	for _, dataRow := range dataRows {
+		for i, y := range dataRow.Ys {
+			x := dataRow.X
+			if dataRow.Xs != nil {
+				x = dataRow.Xs[i]
+			}
+
+			if math.IsNaN(x) || math.IsNaN(y) {
+				continue
+			}
+
+			series := strconv.Itoa(i)
+			if i < len(w.columns) {
+				series = w.columns[i]
+			}
+
+			_, err = insert.Exec(w.stream, series, x, y)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Returns the stored values of the stream, downsampled to at most
+// query.Points per series.
+func (w *SQLiteWriter) History(ctx context.Context, query HistoryQuery) (History, error) {
+	history := History{Series: []HistorySeries{}}
+
+	var from, to sql.NullFloat64
+	err := w.reader.QueryRowContext(ctx, "SELECT min(x), max(x) FROM wesplot WHERE stream = ?", w.stream).Scan(&from, &to)
+	if err != nil {
+		return history, err
+	}
+
+	if !from.Valid {
+		// Nothing is stored yet.
+		return history, nil
+	}
+
+	history.From = from.Float64
+	history.To = to.Float64
+	if query.From != nil {
+		history.From = *query.From
+	}
+	if query.To != nil {
+		history.To = *query.To
+	}
+
+	if history.To < history.From {
+		return history, errors.New("to is before from")
+	}
+
+	bucketWidth := (history.To - history.From) / float64(query.Points)
+	if bucketWidth == 0 {
+		bucketWidth = 1
+	}
+
+	statement := `SELECT series, avg(x), avg(y), min(y), max(y) FROM wesplot
+		WHERE stream = ? AND x >= ? AND x <= ?`
+	args := []any{w.stream, history.From, history.To}
+	if len(query.Series) > 0 {
+		statement += " AND series IN (?" + strings.Repeat(", ?", len(query.Series)-1) + ")"
+		for _, series := range query.Series {
+			args = append(args, series)
+		}
+	}
+
+	// The last bucket includes To.
+	statement += " GROUP BY series, min(CAST((x - ?) / ? AS INTEGER), ?) ORDER BY series, avg(x)"
+	args = append(args, history.From, bucketWidth, query.Points-1)
+
+	rows, err := w.reader.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var x, y, min, max float64
+		err = rows.Scan(&name, &x, &y, &min, &max)
+		if err != nil {
+			return history, err
+		}
+
+		if len(history.Series) == 0 || history.Series[len(history.Series)-1].Name != name {
+			history.Series = append(history.Series, HistorySeries{Name: name})
+		}
+
+		series := &history.Series[len(history.Series)-1]
+		series.X = append(series.X, x)
+		series.Y = append(series.Y, y)
+		series.Min = append(series.Min, min)
+		series.Max = append(series.Max, max)
+	}
+
+	return history, rows.Err()
+}
+
+// Serves GET /history with the stored values (see HistoryQuery).
+func (w *SQLiteWriter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	query, err := parseHistoryQuery(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := w.History(req.Context(), query)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSONResponse(rw, history)
+}
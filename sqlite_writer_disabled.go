@@ -0,0 +1,37 @@
+//go:build !sqlite
+
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Whether wesplot was built with -tags sqlite, and can write SQLite databases.
+const SQLiteSupported = false
+
+// Writing SQLite databases requires cgo, which is only used with -tags sqlite
+// so wesplot can be cross compiled.
+type SQLiteWriter struct{}
+
+func NewSQLiteWriter(path string, stream string, columns []string, flushInterval time.Duration) (*SQLiteWriter, error) {
+	return nil, errors.New("wesplot was built without SQLite support, rebuild it with -tags sqlite and cgo enabled")
+}
+
+func (w *SQLiteWriter) Write(dataRow DataRow) error {
+	return errors.New("SQLite is not supported")
+}
+
+func (w *SQLiteWriter) Close() error {
+	return nil
+}
+
+func (w *SQLiteWriter) History(ctx context.Context, query HistoryQuery) (History, error) {
+	return History{}, errors.New("SQLite is not supported")
+}
+
+func (w *SQLiteWriter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	http.Error(rw, "SQLite is not supported", http.StatusNotImplemented)
+}
@@ -0,0 +1,270 @@
+package wesplot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A DataRowReader that aggregates the metrics of the StatsD protocol, such as
+// `requests:1|c` or `latency:320|ms|@0.1`, read from a StringReader returning
+// whole lines (see NewSocketLineReader), and emits a row with the aggregates
+// every interval, as a StatsD server would flush them. The X value is the
+// time of the flush.
+//
+// The columns are labeled with the names of the metrics:
+//
+//   - A counter (c) is the sum of the values received during the interval,
+//     corrected by their sample rate, and 0 if none were received.
+//   - A gauge (g) is its last value, which is kept until it is changed. A
+//     value with a sign changes the gauge by that value.
+//   - A set (s) is the number of unique values received during the interval.
+//   - A timer (ms, h or d) is labeled with an aggregate function of the
+//     values received during the interval (e.g. latency p99 or latency mean),
+//     which is one of the functions of the AggregateDataRowReader.
+//
+// The DogStatsD tags of a metric (e.g. |#host:a) are appended to its name,
+// sorted (e.g. requests host:a). Metrics not in the columns are ignored.
+type StatsdDataRowReader struct {
+	input    StringReader
+	columns  []string
+	interval time.Duration
+
+	lines chan string
+	errs  chan error
+
+	ticker   *time.Ticker
+	counters map[string]float64
+	gauges   map[string]float64
+	sets     map[string]map[string]struct{}
+	timers   map[string][]float64
+	sorted   []float64
+
+	// Set after the last interval is flushed at EOF.
+	ended bool
+
+	logger logrus.FieldLogger
+}
+
+// The timer columns must name an aggregate function, such as latency p99.
+func NewStatsdDataRowReader(input StringReader, columns []string, interval time.Duration) (*StatsdDataRowReader, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the statsd interval must be positive, got %v", interval)
+	}
+
+	return &StatsdDataRowReader{
+		input:    input,
+		columns:  columns,
+		interval: interval,
+		counters: make(map[string]float64),
+		gauges:   make(map[string]float64),
+		sets:     make(map[string]map[string]struct{}),
+		timers:   make(map[string][]float64),
+		logger:   logrus.WithField("tag", "Statsd"),
+	}, nil
+}
+
+func (r *StatsdDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	if r.ended {
+		return DataRow{}, io.EOF
+	}
+
+	if r.ticker == nil {
+		r.lines = make(chan string, bufferSize)
+		r.errs = make(chan error, 1)
+		go r.readLines(ctx)
+
+		r.ticker = time.NewTicker(r.interval)
+	}
+
+	// The metrics are not rows, so they are read until the next flush.
+	for {
+		select {
+		case line := <-r.lines:
+			err := r.add(line)
+			if err != nil {
+				r.logger.WithError(err).WithField("line", line).Warn("cannot parse metric, ignoring...")
+				return DataRow{}, ignoreRow(DropReasonPayloadParse)
+			}
+		case <-r.ticker.C:
+			return r.flush(), nil
+		case err := <-r.errs:
+			r.ticker.Stop()
+			if err != io.EOF {
+				return DataRow{}, err
+			}
+
+			// The lines read before EOF are flushed with the last interval.
+			for len(r.lines) > 0 {
+				line := <-r.lines
+				err = r.add(line)
+				if err != nil {
+					r.logger.WithError(err).WithField("line", line).Warn("cannot parse metric, ignoring...")
+				}
+			}
+
+			r.ended = true
+			return r.flush(), nil
+		case <-ctx.Done():
+			return DataRow{}, ctx.Err()
+		}
+	}
+}
+
+func (r *StatsdDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+func (r *StatsdDataRowReader) readLines(ctx context.Context) {
+	for {
+		fields, err := r.input.Read(ctx)
+		if errors.Is(err, errIgnoreThisRow) {
+			continue
+		} else if err != nil {
+			r.errs <- err
+			return
+		}
+
+		if len(fields) == 0 || fields[0] == "" {
+			continue
+		}
+
+		select {
+		case r.lines <- fields[0]:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Adds a metric such as name:value|type|@rate|#tag1,tag2.
+func (r *StatsdDataRowReader) add(line string) error {
+	name, rest, found := strings.Cut(line, ":")
+	if !found || name == "" {
+		return errors.New("expected name:value|type")
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return errors.New("expected name:value|type")
+	}
+
+	text, kind := parts[0], parts[1]
+	sampleRate := 1.0
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return fmt.Errorf("invalid sample rate %q", part)
+			}
+
+			sampleRate = rate
+		case strings.HasPrefix(part, "#"):
+			tags := strings.Split(part[1:], ",")
+			sort.Strings(tags)
+			name += " " + strings.Join(tags, " ")
+		}
+	}
+
+	if kind == "s" {
+		if r.sets[name] == nil {
+			r.sets[name] = make(map[string]struct{})
+		}
+
+		r.sets[name][text] = struct{}{}
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("invalid value %q", text)
+	}
+
+	switch kind {
+	case "c":
+		r.counters[name] += value / sampleRate
+	case "g":
+		if strings.HasPrefix(text, "+") || strings.HasPrefix(text, "-") {
+			value += r.gauges[name]
+		}
+
+		r.gauges[name] = value
+	case "ms", "h", "d":
+		r.timers[name] = append(r.timers[name], value)
+	default:
+		return fmt.Errorf("unsupported metric type %q", kind)
+	}
+
+	return nil
+}
+
+// Returns the row of the aggregates and starts the next interval.
+func (r *StatsdDataRowReader) flush() DataRow {
+	dataRow := DataRow{
+		X:  float64(time.Now().UnixNano()) / 1e9,
+		Ys: make([]float64, len(r.columns)),
+	}
+
+	for i, column := range r.columns {
+		dataRow.Ys[i] = r.value(column)
+	}
+
+	for name := range r.counters {
+		r.counters[name] = 0
+	}
+
+	for name, values := range r.timers {
+		r.timers[name] = values[:0]
+	}
+
+	for name := range r.sets {
+		r.sets[name] = make(map[string]struct{})
+	}
+
+	return dataRow
+}
+
+// Returns NaN (which is plotted as a gap) if the metric of the column was
+// never received.
+func (r *StatsdDataRowReader) value(column string) float64 {
+	if value, ok := r.counters[column]; ok {
+		return value
+	}
+
+	if value, ok := r.gauges[column]; ok {
+		return value
+	}
+
+	if set, ok := r.sets[column]; ok {
+		return float64(len(set))
+	}
+
+	index := strings.LastIndexByte(column, ' ')
+	if index < 0 {
+		return math.NaN()
+	}
+
+	values, ok := r.timers[column[:index]]
+	if !ok {
+		return math.NaN()
+	}
+
+	r.sorted = append(r.sorted[:0], values...)
+	sort.Float64s(r.sorted)
+
+	value, err := aggregate(column[index+1:], values, r.sorted)
+	if err != nil {
+		return math.NaN()
+	}
+
+	return value
+}
@@ -0,0 +1,234 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"nhooyr.io/websocket"
+)
+
+// The ID of the stream of the server itself on /ws-mux and /streams.
+const DefaultStreamID = "default"
+
+// A stream served on /ws-mux in addition to the stream of the server, such as
+// the plot of another wesplot server shown in a dashboard. Its metadata cannot
+// be changed via PUT /options.
+type stream struct {
+	id          string
+	broadcaster *DataBroadcaster
+	metadata    Metadata
+}
+
+// Serves the rows of another DataBroadcaster on /ws-mux, with the given ID.
+// The broadcaster must be started by the caller. Must be called before Run.
+func (s *HttpServer) AddStream(id string, broadcaster *DataBroadcaster, metadata Metadata) error {
+	if id == DefaultStreamID || s.findStream(id) != nil {
+		return fmt.Errorf("stream %q already exists", id)
+	}
+
+	s.streams = append(s.streams, &stream{id: id, broadcaster: broadcaster, metadata: metadata})
+	return nil
+}
+
+func (s *HttpServer) findStream(id string) *stream {
+	for _, stream := range s.streams {
+		if stream.id == id {
+			return stream
+		}
+	}
+
+	return nil
+}
+
+// Serves the page of the web UI showing a plot per stream of /ws-mux.
+func dashboardHandler(webui http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = req.Clone(req.Context())
+		req.URL.Path = "/dashboard.html"
+		webui.ServeHTTP(w, req)
+	})
+}
+
+type StreamInfo struct {
+	ID       string
+	Metadata Metadata
+}
+
+// Lists the streams that can be received on /ws-mux, starting with the stream
+// of the server.
+func (s *HttpServer) handleStreams(w http.ResponseWriter, req *http.Request) {
+	infos := []StreamInfo{{ID: DefaultStreamID, Metadata: s.currentMetadata()}}
+	for _, stream := range s.streams {
+		infos = append(infos, StreamInfo{ID: stream.id, Metadata: stream.metadata})
+	}
+
+	writeJSONResponse(w, infos)
+}
+
+// The messages sent on /ws-mux other than the rows. Exactly one of the other
+// fields is set.
+type MuxMessage struct {
+	Stream      string
+	Metadata    *Metadata   `json:",omitempty"`
+	Annotation  *Annotation `json:",omitempty"`
+	Status      *Status     `json:",omitempty"`
+	StreamEnded bool        `json:",omitempty"`
+	StreamError string      `json:",omitempty"`
+}
+
+// Serves several streams on a single websocket, so a page showing several
+// plots (such as a dashboard) needs a single connection. The streams are
+// selected with the stream query parameter (e.g.
+// /ws-mux?stream=default&stream=cpu), and all of them are sent by default.
+// Every message is a JSON object with the ID of its stream in the Stream field:
+//
+//   - {"Stream": "cpu", "Metadata": {...}} is sent first, and whenever the
+//     metadata of the stream of the server is changed.
+//   - {"Stream": "cpu", "Rows": [...]} contains a batch of rows, as on /ws.
+//   - {"Stream": "cpu", "Annotation": {...}} is sent in order with the rows.
+//   - {"Stream": "cpu", "Status": {...}} is sent whenever the stream becomes
+//     stale or active again (see Status).
+//   - {"Stream": "cpu", "StreamEnded": true, "StreamError": "..."} is sent
+//     once the stream ends, with its error, if any.
+//
+// The websocket is closed once all the selected streams have ended.
+func (s *HttpServer) handleWebSocketMux(w http.ResponseWriter, req *http.Request) {
+	var streams []*stream
+	ids := req.URL.Query()["stream"]
+	if len(ids) == 0 {
+		ids = []string{DefaultStreamID}
+		for _, stream := range s.streams {
+			ids = append(ids, stream.id)
+		}
+	}
+
+	for _, id := range ids {
+		if id == DefaultStreamID {
+			streams = append(streams, &stream{id: id, broadcaster: s.dataBroadcaster})
+			continue
+		}
+
+		found := s.findStream(id)
+		if found == nil {
+			http.Error(w, fmt.Sprintf("unknown stream %q", id), http.StatusNotFound)
+			return
+		}
+
+		streams = append(streams, found)
+	}
+
+	c, err := websocket.Accept(w, req, &websocket.AcceptOptions{
+		OriginPatterns:  []string{"*"},
+		Subprotocols:    websocketProtocols,
+		CompressionMode: s.compression.websocketMode(),
+	})
+	if err != nil {
+		s.logger.WithError(err).Warn("failed to accept new websocket connection")
+		return
+	}
+
+	if !negotiateProtocol(c, req, websocketProtocols) {
+		s.logger.WithField("protocols", req.Header.Get("Sec-WebSocket-Protocol")).Warn("rejected websocket connection with unsupported protocol")
+		return
+	}
+
+	encoding := rowEncoding{float32: c.Subprotocol() == ProtocolV1Float32}
+
+	ctx, cancel := context.WithCancel(c.CloseRead(req.Context()))
+	defer cancel()
+
+	// Conn.Write can be called concurrently by the goroutines of the streams.
+	writeMessage := func(data []byte) error {
+		writeCtx, cancel := s.clientTimeoutContext(ctx)
+		defer cancel()
+		return c.Write(writeCtx, websocket.MessageText, data)
+	}
+
+	heartbeat := func() error {
+		pingCtx, cancel := context.WithTimeout(ctx, heartbeatTimeout)
+		defer cancel()
+		return c.Ping(pingCtx)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, selected := range streams {
+		wg.Add(1)
+		go func(selected *stream) {
+			defer wg.Done()
+
+			// Closes the connection if the stream fails to be written.
+			if !s.streamMux(ctx, selected, req.RemoteAddr, encoding, writeMessage, heartbeat) {
+				cancel()
+			}
+		}(selected)
+	}
+
+	wg.Wait()
+	c.Close(websocket.StatusNormalClosure, "")
+}
+
+// Writes a stream on /ws-mux. Returns true if the stream ended and all the
+// rows were written.
+func (s *HttpServer) streamMux(ctx context.Context, stream *stream, remoteAddr string, encoding rowEncoding, writeMessage func(data []byte) error, heartbeat func() error) bool {
+	quotedID, err := json.Marshal(stream.id)
+	if err != nil {
+		panic(err)
+	}
+
+	// The history is already rounded to float32 if it is stored as float32.
+	encoding.float32 = encoding.float32 || stream.broadcaster.Float32()
+
+	writeMuxMessage := func(message MuxMessage) error {
+		message.Stream = stream.id
+		return writeJSONMessage(writeMessage, message)
+	}
+
+	// The metadata of the stream of the server is sent by streamDataRows
+	// whenever it changes.
+	var writeMetadata func(Metadata) error
+	metadata := stream.metadata
+	if stream.id == DefaultStreamID {
+		metadata = s.currentMetadata()
+		writeMetadata = func(metadata Metadata) error {
+			return writeMuxMessage(MuxMessage{Metadata: &metadata})
+		}
+	}
+
+	err = writeMuxMessage(MuxMessage{Metadata: &metadata})
+	if err != nil {
+		return false
+	}
+
+	streamEnded := s.streamDataRows(ctx, stream.broadcaster, "websocket-mux "+stream.id, remoteAddr, 0, HistoryAll, func(dataRows []DataRow) error {
+		bufp := encodeBufferPool.Get().(*[]byte)
+
+		buf := append((*bufp)[:0], `{"Stream":`...)
+		buf = append(buf, quotedID...)
+		buf = append(buf, `,"Rows":`...)
+		buf = appendDataRowsJSON(buf, dataRows, encoding)
+		buf = append(buf, "}\n"...)
+		err := writeMessage(buf)
+
+		*bufp = buf
+		encodeBufferPool.Put(bufp)
+		return err
+	}, heartbeat, writeMetadata, func(annotation Annotation) error {
+		return writeMuxMessage(MuxMessage{Annotation: &annotation})
+	}, func(status Status) error {
+		return writeMuxMessage(MuxMessage{Status: &status})
+	}, nil, nil)
+
+	if !streamEnded {
+		return false
+	}
+
+	message := MuxMessage{StreamEnded: true}
+	if err := stream.broadcaster.Err(); err != nil {
+		message.StreamError = err.Error()
+	}
+
+	return writeMuxMessage(message) == nil
+}
@@ -0,0 +1,263 @@
+package sys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/sirupsen/logrus"
+)
+
+// The metrics of the ProcDataRowReader, with the labels of their columns.
+var ProcMetrics = map[string][]string{
+	"cpu":       {"cpu %"},
+	"rss":       {"rss bytes"},
+	"fds":       {"fds"},
+	"threads":   {"threads"},
+	"io":        {"io read bytes/s", "io write bytes/s"},
+	"processes": {"processes"},
+}
+
+// A wesplot.DataRowReader that samples the statistics of a process, or of the
+// processes whose name matches a regex, every interval, such as to watch the
+// resource usage of a workload. The X value is the time of the sample.
+//
+// The CPU usage is a percentage of one CPU (as in top), the RSS is in bytes,
+// and the I/O is the bytes per second read from and written to the storage
+// since the previous sample. The values of the matching processes are summed,
+// and the processes are searched again at every sample, so the processes
+// started later are included. The values are NaN (which is plotted as a gap)
+// while no process matches. The stream ends once the process of a pid exits.
+type ProcDataRowReader struct {
+	match    *regexp.Regexp
+	metrics  []string
+	columns  []string
+	interval time.Duration
+
+	ticker    *time.Ticker
+	lastTime  time.Time
+	processes map[int32]*procSample // By pid
+
+	logger logrus.FieldLogger
+}
+
+type procSample struct {
+	process *process.Process
+	io      *process.IOCountersStat
+}
+
+// Samples either the process of the pid, or the processes matching the regex
+// if it is not nil. The metrics are the keys of ProcMetrics (e.g. cpu and
+// rss), whose columns are plotted in that order.
+func NewProcDataRowReader(pid int32, match *regexp.Regexp, metrics []string, interval time.Duration) (*ProcDataRowReader, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the interval must be positive, got %v", interval)
+	}
+
+	r := &ProcDataRowReader{
+		match:     match,
+		metrics:   metrics,
+		interval:  interval,
+		processes: make(map[int32]*procSample),
+		logger:    logrus.WithField("tag", "Proc"),
+	}
+
+	for _, metric := range metrics {
+		columns, ok := ProcMetrics[metric]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q, expected cpu, rss, fds, threads, io, or processes", metric)
+		}
+
+		r.columns = append(r.columns, columns...)
+	}
+
+	if match == nil {
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find process %d: %w", pid, err)
+		}
+
+		r.processes[pid] = &procSample{process: p}
+	}
+
+	return r, nil
+}
+
+func (r *ProcDataRowReader) Read(ctx context.Context) (wesplot.DataRow, error) {
+	if r.ticker == nil {
+		// The rates and the CPU usage are computed from the previous sample.
+		_, err := r.sample(ctx, time.Now())
+		if err != nil {
+			return wesplot.DataRow{}, err
+		}
+
+		r.ticker = time.NewTicker(r.interval)
+	}
+
+	select {
+	case now := <-r.ticker.C:
+		ys, err := r.sample(ctx, now)
+		if err != nil {
+			r.ticker.Stop()
+			return wesplot.DataRow{}, err
+		}
+
+		return wesplot.DataRow{X: float64(now.UnixNano()) / 1e9, Ys: ys}, nil
+	case <-ctx.Done():
+		r.ticker.Stop()
+		return wesplot.DataRow{}, ctx.Err()
+	}
+}
+
+func (r *ProcDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+func (r *ProcDataRowReader) sample(ctx context.Context, now time.Time) ([]float64, error) {
+	if r.match != nil {
+		err := r.findProcesses(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ys := make([]float64, len(r.columns))
+	elapsed := now.Sub(r.lastTime).Seconds()
+	r.lastTime = now
+
+	values := make([]float64, len(r.columns))
+	for pid, sample := range r.processes {
+		err := r.read(ctx, values, sample, elapsed)
+		if err == nil {
+			for i, value := range values {
+				ys[i] += value
+			}
+
+			continue
+		}
+
+		running, runningErr := sample.process.IsRunningWithContext(ctx)
+		if runningErr == nil && running {
+			return nil, fmt.Errorf("cannot read the statistics of process %d: %w", pid, err)
+		}
+
+		if r.match == nil {
+			r.logger.WithField("pid", pid).Info("the process exited")
+			return nil, io.EOF
+		}
+
+		delete(r.processes, pid)
+	}
+
+	if len(r.processes) == 0 {
+		for i := range ys {
+			ys[i] = math.NaN()
+		}
+	}
+
+	return ys, nil
+}
+
+// Reads the metrics of the process into ys.
+func (r *ProcDataRowReader) read(ctx context.Context, ys []float64, sample *procSample, elapsed float64) error {
+	p := sample.process
+	i := 0
+	for _, metric := range r.metrics {
+		switch metric {
+		case "cpu":
+			// Since the previous call, as the interval is 0.
+			percent, err := p.PercentWithContext(ctx, 0)
+			if err != nil {
+				return err
+			}
+
+			ys[i] = percent
+		case "rss":
+			memory, err := p.MemoryInfoWithContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			ys[i] = float64(memory.RSS)
+		case "fds":
+			fds, err := p.NumFDsWithContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			ys[i] = float64(fds)
+		case "threads":
+			threads, err := p.NumThreadsWithContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			ys[i] = float64(threads)
+		case "io":
+			counters, err := p.IOCountersWithContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			// A process found since the previous sample has no rate yet.
+			ys[i], ys[i+1] = 0, 0
+			if sample.io != nil {
+				ys[i] = wesplot.CounterRate(sample.io.ReadBytes, counters.ReadBytes, elapsed)
+				ys[i+1] = wesplot.CounterRate(sample.io.WriteBytes, counters.WriteBytes, elapsed)
+			}
+
+			sample.io = counters
+		case "processes":
+			ys[i] = 1
+		}
+
+		i += len(ProcMetrics[metric])
+	}
+
+	return nil
+}
+
+// Tracks the processes matching the regex, and forgets the ones that exited.
+func (r *ProcDataRowReader) findProcesses(ctx context.Context) error {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list the processes: %w", err)
+	}
+
+	running := make(map[int32]struct{}, len(pids))
+	for _, pid := range pids {
+		running[pid] = struct{}{}
+		if _, ok := r.processes[pid]; ok {
+			continue
+		}
+
+		p, err := process.NewProcessWithContext(ctx, pid)
+		if errors.Is(err, process.ErrorProcessNotRunning) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("cannot read process %d: %w", pid, err)
+		}
+
+		name, err := p.NameWithContext(ctx)
+		if err != nil || !r.match.MatchString(name) {
+			continue
+		}
+
+		r.logger.WithFields(logrus.Fields{"pid": pid, "name": name}).Debug("found a matching process")
+		r.processes[pid] = &procSample{process: p}
+	}
+
+	for pid := range r.processes {
+		if _, ok := running[pid]; !ok {
+			delete(r.processes, pid)
+		}
+	}
+
+	return nil
+}
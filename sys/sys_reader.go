@@ -0,0 +1,161 @@
+// Package sys samples the statistics of the local system and of its
+// processes for wesplot sys and wesplot proc. It is separate from the wesplot
+// package so the programs embedding wesplot do not depend on gopsutil unless
+// they use it.
+package sys
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cactusdynamics/wesplot"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// The metrics of the DataRowReader, with the labels of their columns.
+var Metrics = map[string][]string{
+	"cpu":  {"cpu %"},
+	"mem":  {"mem %"},
+	"swap": {"swap %"},
+	"load": {"load 1m", "load 5m", "load 15m"},
+	"net":  {"net rx bytes/s", "net tx bytes/s"},
+}
+
+// A wesplot.DataRowReader that samples the statistics of the local system every
+// interval, such as the CPU usage, instead of parsing the output of vmstat.
+// The X value is the time of the sample.
+//
+// The CPU, memory and swap usages are percentages, and the network traffic
+// is the bytes per second received and sent on every interface since the
+// previous sample. The first row is read after one interval, so the usages
+// cover a whole interval.
+type DataRowReader struct {
+	metrics  []string
+	columns  []string
+	interval time.Duration
+
+	ticker *time.Ticker
+
+	lastTime time.Time
+	lastNet  net.IOCountersStat
+}
+
+// The metrics are the keys of Metrics (e.g. cpu and net), whose columns
+// are plotted in that order.
+func NewDataRowReader(metrics []string, interval time.Duration) (*DataRowReader, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("the interval must be positive, got %v", interval)
+	}
+
+	r := &DataRowReader{
+		metrics:  metrics,
+		interval: interval,
+	}
+
+	for _, metric := range metrics {
+		columns, ok := Metrics[metric]
+		if !ok {
+			return nil, fmt.Errorf("unknown metric %q, expected cpu, mem, swap, load, or net", metric)
+		}
+
+		r.columns = append(r.columns, columns...)
+	}
+
+	return r, nil
+}
+
+func (r *DataRowReader) Read(ctx context.Context) (wesplot.DataRow, error) {
+	if r.ticker == nil {
+		// The rates and the CPU usage are computed from the previous sample.
+		_, err := r.sample(ctx, time.Now())
+		if err != nil {
+			return wesplot.DataRow{}, err
+		}
+
+		r.ticker = time.NewTicker(r.interval)
+	}
+
+	select {
+	case now := <-r.ticker.C:
+		ys, err := r.sample(ctx, now)
+		if err != nil {
+			r.ticker.Stop()
+			return wesplot.DataRow{}, err
+		}
+
+		return wesplot.DataRow{X: float64(now.UnixNano()) / 1e9, Ys: ys}, nil
+	case <-ctx.Done():
+		r.ticker.Stop()
+		return wesplot.DataRow{}, ctx.Err()
+	}
+}
+
+func (r *DataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+func (r *DataRowReader) sample(ctx context.Context, now time.Time) ([]float64, error) {
+	ys := make([]float64, 0, len(r.columns))
+	elapsed := now.Sub(r.lastTime).Seconds()
+
+	for _, metric := range r.metrics {
+		switch metric {
+		case "cpu":
+			// Since the previous call, as the interval is 0.
+			percents, err := cpu.PercentWithContext(ctx, 0, false)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the CPU usage: %w", err)
+			}
+
+			// The usage of all the CPUs combined, if it could be read.
+			if len(percents) > 0 {
+				ys = append(ys, percents[0])
+			} else {
+				ys = append(ys, math.NaN())
+			}
+		case "mem":
+			memory, err := mem.VirtualMemoryWithContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the memory usage: %w", err)
+			}
+
+			ys = append(ys, memory.UsedPercent)
+		case "swap":
+			swap, err := mem.SwapMemoryWithContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the swap usage: %w", err)
+			}
+
+			ys = append(ys, swap.UsedPercent)
+		case "load":
+			avg, err := load.AvgWithContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the load average: %w", err)
+			}
+
+			ys = append(ys, avg.Load1, avg.Load5, avg.Load15)
+		case "net":
+			// The sum of every interface.
+			counters, err := net.IOCountersWithContext(ctx, false)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the network traffic: %w", err)
+			}
+
+			if len(counters) == 0 {
+				ys = append(ys, math.NaN(), math.NaN())
+			} else {
+				last := r.lastNet
+				r.lastNet = counters[0]
+				ys = append(ys, wesplot.CounterRate(last.BytesRecv, r.lastNet.BytesRecv, elapsed), wesplot.CounterRate(last.BytesSent, r.lastNet.BytesSent, elapsed))
+			}
+		}
+	}
+
+	r.lastTime = now
+	return ys, nil
+}
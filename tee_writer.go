@@ -0,0 +1,442 @@
+package wesplot
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often the gzip stream of a tee file is flushed. Flushing it for every
+// row would make the compression very inefficient.
+const teeGzipFlushInterval = time.Second
+
+// The format of the rows written by the TeeWriter.
+type TeeFormat string
+
+const (
+	// One row per line, with the X value followed by the Y values, and then the
+	// Xs of the series if they have their own X (see DataRow.Xs). Files start
+	// with a header row of the column names.
+	TeeFormatCSV TeeFormat = "csv"
+
+	// One JSON object per line, in the same format as the rows sent to the
+	// browser ({"X": x, "Ys": [y1, y2, ...]}), which can be sent back to a
+	// wesplot started with --ingest.
+	TeeFormatJSONL TeeFormat = "jsonl"
+
+	// The InfluxDB line protocol, with one point per row in the wesplot
+	// measurement and one field per column. The X value is the timestamp of
+	// the point if it is a timestamp (see SetXIsTimestamp), and the x field
+	// otherwise.
+	TeeFormatInfluxLine TeeFormat = "influx-line"
+
+	// The rows in the JSONL format, after a first line with the metadata of
+	// the plot. See NewSessionRecorder.
+	TeeFormatSession TeeFormat = "session"
+)
+
+// The measurement of the points written in the influx-line format.
+const influxMeasurement = "wesplot"
+
+// Writes a copy of the rows emitted by the DataBroadcaster (see AddTeeWriter).
+// Write is only called with data rows, not with the annotations.
+type RowWriter interface {
+	Write(dataRow DataRow) error
+	Close() error
+}
+
+// Writes a copy of the rows emitted by the DataBroadcaster, either to stdout
+// or to a file. CSV files start with a header row built from the column names.
+// Files can be compressed with gzip, and can be rotated once they reach a
+// given size.
+type TeeWriter struct {
+	columns      []string
+	format       TeeFormat
+	precision    int // Significant digits of the Y values, or -1
+	xIsTimestamp bool
+	source       string
+	sourceJSON   []byte
+	metadata     Metadata // Only written in the session format.
+
+	// Empty when writing to stdout.
+	path       string
+	rotateSize int64 // In uncompressed bytes. 0 means never rotate.
+	compress   bool
+
+	file          *os.File
+	gzipWriter    *gzip.Writer
+	output        io.Writer
+	bytesWritten  int64
+	lastFlushTime time.Time
+
+	line []byte
+}
+
+// Writes the rows to stdout without a header row, for piping into other
+// programs.
+func NewTeeStdoutWriter(columns []string, format TeeFormat) *TeeWriter {
+	return &TeeWriter{
+		columns:   columns,
+		format:    format,
+		precision: -1,
+		output:    os.Stdout,
+	}
+}
+
+// Records the plot to a session file at path, which starts with its metadata
+// followed by its rows, so it can be replayed as it was shown (see
+// OpenSession).
+func NewSessionRecorder(path string, metadata Metadata) (*TeeWriter, error) {
+	w := &TeeWriter{
+		columns:   metadata.WesplotOptions.Columns,
+		format:    TeeFormatSession,
+		precision: -1,
+		metadata:  metadata,
+		path:      path,
+	}
+
+	err := w.openFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Writes the rows to the file at path, which is truncated if it exists. Once
+// rotateSize bytes (before compression) are written to the file, it is renamed
+// with the current time appended to its name and a new file is started. If
+// rotateSize is 0, the file is never rotated. If compress is true, the file is
+// compressed with gzip.
+func NewTeeFileWriter(path string, columns []string, format TeeFormat, rotateSize int64, compress bool) (*TeeWriter, error) {
+	w := &TeeWriter{
+		columns:    columns,
+		format:     format,
+		precision:  -1,
+		path:       path,
+		rotateSize: rotateSize,
+		compress:   compress,
+	}
+
+	err := w.openFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Writes the Y values of CSV rows with this number of significant digits. By
+// default, the values are written as they were in the input, or with the
+// fewest digits that represent them exactly if they were not parsed from text.
+// Must be called before Write.
+func (w *TeeWriter) SetPrecision(precision int) {
+	w.precision = precision
+}
+
+// Writes the X values as the timestamps of the points in the influx-line
+// format, as X is a unix timestamp in seconds. Must be called before Write.
+func (w *TeeWriter) SetXIsTimestamp(xIsTimestamp bool) {
+	w.xIsTimestamp = xIsTimestamp
+}
+
+// Adds the source of the rows to every row, to tell the rows of several
+// sources apart in the same output: as the source column before x in the CSV
+// format, as the Source field in the JSONL format, and as the source tag in the
+// influx-line format. Must be called before Write.
+func (w *TeeWriter) SetSource(source string) {
+	w.source = source
+
+	var err error
+	w.sourceJSON, err = json.Marshal(source)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (w *TeeWriter) openFile() error {
+	file, err := os.Create(w.path)
+	if err != nil {
+		return fmt.Errorf("cannot create tee file: %w", err)
+	}
+
+	w.file = file
+	w.output = file
+	if w.compress {
+		w.gzipWriter = gzip.NewWriter(file)
+		w.output = w.gzipWriter
+	}
+
+	w.bytesWritten = 0
+	w.lastFlushTime = time.Now()
+
+	return w.WriteHeader()
+}
+
+// Writes the header row of the column names in the CSV format, or the
+// metadata in the session format, which files start with. Does nothing in the
+// other formats.
+func (w *TeeWriter) WriteHeader() error {
+	if w.format == TeeFormatSession {
+		header, err := json.Marshal(sessionHeader{Metadata: w.metadata})
+		if err != nil {
+			return err
+		}
+
+		return w.writeLine(append(header, '\n'))
+	}
+
+	if w.format != TeeFormatCSV {
+		return nil
+	}
+
+	header := append([]string{"x"}, w.columns...)
+	if w.source != "" {
+		header = append([]string{"source"}, header...)
+	}
+
+	return w.writeLine([]byte(strings.Join(header, ",") + "\n"))
+}
+
+func (w *TeeWriter) Write(dataRow DataRow) error {
+	w.line = w.line[:0]
+
+	switch w.format {
+	case TeeFormatJSONL, TeeFormatSession:
+		if w.source != "" {
+			w.line = append(w.line, `{"Source":`...)
+			w.line = append(w.line, w.sourceJSON...)
+			w.line = append(w.line, ',')
+		}
+
+		prefix := len(w.line)
+		w.line = dataRow.appendJSON(w.line, rowEncoding{})
+		if w.source != "" {
+			// Merges the objects by removing the opening brace of the row.
+			w.line = append(w.line[:prefix], w.line[prefix+1:]...)
+		}
+	case TeeFormatInfluxLine:
+		w.line = w.appendInfluxLine(w.line, dataRow)
+		if len(w.line) == 0 {
+			// Points must have at least one field.
+			return nil
+		}
+	default:
+		if w.source != "" {
+			w.line = append(w.line, w.source...)
+			w.line = append(w.line, ',')
+		}
+
+		w.line = w.appendCSV(w.line, dataRow)
+	}
+
+	w.line = append(w.line, '\n')
+
+	if w.rotateSize > 0 && w.bytesWritten > 0 && w.bytesWritten+int64(len(w.line)) > w.rotateSize {
+		err := w.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.writeLine(w.line)
+}
+
+func (w *TeeWriter) appendCSV(line []byte, dataRow DataRow) []byte {
+	// The X value is not affected by the precision, as it is usually a
+	// timestamp. A time format may contain commas, which would need quoting.
+	if dataRow.xText != "" && !strings.ContainsAny(dataRow.xText, ",\"") {
+		line = append(line, dataRow.xText...)
+	} else {
+		line = strconv.AppendFloat(line, dataRow.X, 'f', -1, 64)
+	}
+
+	useText := w.precision < 0 && len(dataRow.yTexts) == len(dataRow.Ys)
+	for i, y := range dataRow.Ys {
+		line = append(line, ',')
+		if useText {
+			line = append(line, dataRow.yTexts[i]...)
+		} else {
+			line = strconv.AppendFloat(line, y, 'g', w.precision, 64)
+		}
+	}
+
+	// After the Ys, so the columns can be named like the other columns.
+	for _, x := range dataRow.Xs {
+		line = append(line, ',')
+		line = strconv.AppendFloat(line, x, 'f', -1, 64)
+	}
+
+	return line
+}
+
+// Writes the data rows read from the reader until it ends, skipping the
+// annotations, such as to export the plot of another wesplot read with a
+// RemoteDataRowReader. Returns nil once the reader reaches io.EOF.
+func (w *TeeWriter) WriteAll(ctx context.Context, reader DataRowReader) error {
+	for {
+		dataRow, err := reader.Read(ctx)
+		if errors.Is(err, errIgnoreThisRow) {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if dataRow.annotation != nil {
+			continue
+		}
+
+		err = w.Write(dataRow)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Appends nothing if the row has no field, as NaN values cannot be written.
+func (w *TeeWriter) appendInfluxLine(line []byte, dataRow DataRow) []byte {
+	start := len(line)
+	line = append(line, influxMeasurement...)
+	if w.source != "" {
+		line = append(line, ",source="...)
+		line = append(line, influxKeyEscaper.Replace(w.source)...)
+	}
+
+	separator := byte(' ')
+	appendField := func(key string, value float64, precision int) {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+
+		line = append(line, separator)
+		line = append(line, influxKeyEscaper.Replace(key)...)
+		line = append(line, '=')
+		line = strconv.AppendFloat(line, value, 'g', precision, 64)
+		separator = ','
+	}
+
+	if !w.xIsTimestamp {
+		appendField("x", dataRow.X, -1)
+	}
+
+	for i, y := range dataRow.Ys {
+		name := strconv.Itoa(i)
+		if i < len(w.columns) {
+			name = w.columns[i]
+		}
+
+		appendField(name, y, w.precision)
+	}
+
+	for i, x := range dataRow.Xs {
+		name := "x" + strconv.Itoa(i)
+		if len(dataRow.Ys)+i < len(w.columns) {
+			name = w.columns[len(dataRow.Ys)+i]
+		}
+
+		appendField(name, x, -1)
+	}
+
+	if separator == ' ' {
+		return line[:start]
+	}
+
+	if w.xIsTimestamp {
+		line = append(line, ' ')
+		line = strconv.AppendInt(line, int64(dataRow.X*1e9), 10) // In nanoseconds
+	}
+
+	return line
+}
+
+// Escapes the tag values and the field keys of the influx-line format.
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func (w *TeeWriter) writeLine(line []byte) error {
+	n, err := w.output.Write(line)
+	w.bytesWritten += int64(n)
+	if err != nil {
+		return err
+	}
+
+	// Uncompressed rows are written as they come, so the output can be followed
+	// by other programs.
+	if w.gzipWriter != nil && time.Since(w.lastFlushTime) > teeGzipFlushInterval {
+		w.lastFlushTime = time.Now()
+		return w.gzipWriter.Flush()
+	}
+
+	return nil
+}
+
+func (w *TeeWriter) rotate() error {
+	err := w.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(w.path, rotatedTeePath(w.path, time.Now()))
+	if err != nil {
+		return fmt.Errorf("cannot rotate tee file: %w", err)
+	}
+
+	return w.openFile()
+}
+
+// Inserts the time before the extension of the path, so out.csv.gz is rotated
+// to out-20060102-150405.000.csv.gz.
+func rotatedTeePath(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	if ext == ".gz" {
+		ext = filepath.Ext(strings.TrimSuffix(path, ext)) + ext
+	}
+
+	return strings.TrimSuffix(path, ext) + "-" + now.Format("20060102-150405.000") + ext
+}
+
+// Flushes the rows written so far and commits the file to disk, so they are
+// not lost if the system crashes. Does nothing when writing to stdout.
+func (w *TeeWriter) Sync() error {
+	if w.file == nil {
+		return nil
+	}
+
+	if w.gzipWriter != nil {
+		err := w.gzipWriter.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.file.Sync()
+}
+
+// Closes the file, if any. Does not close stdout.
+func (w *TeeWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	var err error
+	if w.gzipWriter != nil {
+		err = w.gzipWriter.Close()
+	}
+
+	closeErr := w.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,80 @@
+package wesplot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Named time formats that can be passed to NewTimeParser in addition to Go
+// time layouts.
+const (
+	TimeFormatEpoch   = "epoch"    // Unix timestamp in seconds, can be fractional. This is the default.
+	TimeFormatEpochMs = "epoch-ms" // Unix timestamp in milliseconds
+	TimeFormatEpochUs = "epoch-us" // Unix timestamp in microseconds
+	TimeFormatEpochNs = "epoch-ns" // Unix timestamp in nanoseconds
+	TimeFormatRFC3339 = "rfc3339"  // e.g. 2006-01-02T15:04:05Z07:00, with optional fractional seconds
+	TimeFormatHMS     = "hh:mm:ss" // e.g. 15:04:05, with optional fractional seconds. The current date is assumed.
+)
+
+// Returns a function that parses a timestamp column into unix seconds, which
+// is what the X value must be when the X value is a timestamp. The format is
+// either one of the named TimeFormat* constants or a Go time layout (e.g.
+// 2006-01-02T15:04:05). Layouts without a timezone are parsed in the local
+// timezone and layouts without a date are assumed to be from today.
+//
+// Note that the input columns are split on spaces and commas, so the format
+// cannot contain either of them.
+func NewTimeParser(format string) (func(string) (float64, error), error) {
+	switch strings.ToLower(format) {
+	case "", TimeFormatEpoch:
+		return func(value string) (float64, error) {
+			return strconv.ParseFloat(value, 64)
+		}, nil
+	case TimeFormatEpochMs:
+		return newEpochParser(1e3), nil
+	case TimeFormatEpochUs:
+		return newEpochParser(1e6), nil
+	case TimeFormatEpochNs:
+		return newEpochParser(1e9), nil
+	case TimeFormatRFC3339:
+		return newLayoutParser(time.RFC3339Nano), nil
+	case TimeFormatHMS:
+		return newLayoutParser("15:04:05"), nil
+	}
+
+	if strings.ContainsAny(format, " ,\t") {
+		return nil, fmt.Errorf("time format %q cannot contain spaces or commas", format)
+	}
+
+	return newLayoutParser(format), nil
+}
+
+func newEpochParser(unitsPerSecond float64) func(string) (float64, error) {
+	return func(value string) (float64, error) {
+		timestamp, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		return timestamp / unitsPerSecond, nil
+	}
+}
+
+func newLayoutParser(layout string) func(string) (float64, error) {
+	return func(value string) (float64, error) {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			return 0, err
+		}
+
+		// The layout has no date component, so we use today's date.
+		if t.Year() == 0 {
+			now := time.Now()
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		}
+
+		return float64(t.UnixMicro()) / 1000000.0, nil
+	}
+}
@@ -0,0 +1,164 @@
+package wesplot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A message received on a topic of a broker, such as MQTT (see the mqtt
+// package).
+type TopicMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// A DataRowReader that converts the messages received on one or more topics
+// into DataRows. The X value is always the receive timestamp.
+//
+// Each message payload can be:
+//
+//   - plain: a single number, which is labeled with the topic name.
+//   - CSV: multiple numbers separated by commas or spaces, which are labeled
+//     with topic/index (e.g. sensors/imu/0, sensors/imu/1).
+//   - JSON: an object, where each numeric field is labeled with topic/field
+//     (e.g. sensors/room1/temperature). Nested objects are flattened.
+//
+// Since sensors usually publish independently of each other, every message
+// emits a row containing the most recent value of every column. Rows are only
+// emitted after every column has received at least one value.
+type TopicDataRowReader struct {
+	receive func(ctx context.Context) (TopicMessage, error)
+	columns []string
+
+	// Maps the column labels to the index in columns.
+	columnIndices map[string]int
+	lastValues    []float64
+
+	// The columns that received a value, which can be NaN.
+	seenColumns    []bool
+	numSeenColumns int
+
+	logger logrus.FieldLogger
+}
+
+// Reads the messages returned by receive. The columns are the labels (as
+// described above) that should be plotted. Messages with labels not in
+// columns are ignored.
+func NewTopicDataRowReader(receive func(ctx context.Context) (TopicMessage, error), columns []string, logger logrus.FieldLogger) *TopicDataRowReader {
+	r := &TopicDataRowReader{
+		receive:       receive,
+		columns:       columns,
+		columnIndices: make(map[string]int, len(columns)),
+		lastValues:    make([]float64, len(columns)),
+		seenColumns:   make([]bool, len(columns)),
+		logger:        logger,
+	}
+
+	for i, column := range columns {
+		r.columnIndices[column] = i
+		r.lastValues[i] = math.NaN()
+	}
+
+	return r
+}
+
+func (r *TopicDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	message, err := r.receive(ctx)
+	if err != nil {
+		return DataRow{}, err
+	}
+
+	logger := r.logger.WithField("topic", message.Topic)
+
+	values, err := parseTopicPayload(message.Topic, message.Payload)
+	if err != nil {
+		logger.WithError(err).Warn("cannot parse payload, ignoring...")
+		return DataRow{}, ignoreRow(DropReasonPayloadParse)
+	}
+
+	updated := false
+	for label, value := range values {
+		i, ok := r.columnIndices[label]
+		if !ok {
+			logger.WithField("label", label).Debug("value not in columns, ignoring...")
+			continue
+		}
+
+		if !r.seenColumns[i] {
+			r.seenColumns[i] = true
+			r.numSeenColumns++
+		}
+
+		r.lastValues[i] = value
+		updated = true
+	}
+
+	if !updated || r.numSeenColumns < len(r.columns) {
+		return DataRow{}, errIgnoreThisRow
+	}
+
+	dataRow := DataRow{
+		Ys: make([]float64, len(r.lastValues)),
+	}
+
+	copy(dataRow.Ys, r.lastValues)
+	dataRow.X = NowXGenerator(dataRow.Ys)
+
+	return dataRow, nil
+}
+
+func (r *TopicDataRowReader) ColumnNames() []string {
+	return r.columns
+}
+
+// Converts a payload into labeled values as documented on
+// TopicDataRowReader.
+func parseTopicPayload(topic string, payload []byte) (map[string]float64, error) {
+	values := make(map[string]float64)
+
+	text := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(text, "{") {
+		var object map[string]any
+		err := json.Unmarshal(payload, &object)
+		if err != nil {
+			return nil, err
+		}
+
+		flattenJSONNumbers(topic, object, values)
+		return values, nil
+	}
+
+	fields := splitRelaxed(text)
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) == 1 {
+			values[topic] = value
+		} else {
+			values[fmt.Sprintf("%s/%d", topic, i)] = value
+		}
+	}
+
+	return values, nil
+}
+
+func flattenJSONNumbers(prefix string, object map[string]any, values map[string]float64) {
+	for key, value := range object {
+		label := prefix + "/" + key
+		switch v := value.(type) {
+		case float64:
+			values[label] = v
+		case map[string]any:
+			flattenJSONNumbers(label, v, values)
+		}
+	}
+}
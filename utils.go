@@ -2,6 +2,7 @@ package wesplot
 
 import (
 	"container/ring"
+	"math"
 
 	"golang.org/x/exp/constraints"
 )
@@ -28,6 +29,24 @@ func Min[T Number](a T, b T) T {
 	return a
 }
 
+func Max[T Number](a T, b T) T {
+	if a < b {
+		return b
+	}
+
+	return a
+}
+
+// Returns the rate per second of a counter, or NaN (which is plotted as a gap)
+// if it was reset, such as when an interface is recreated.
+func CounterRate(last uint64, current uint64, elapsed float64) float64 {
+	if current < last {
+		return math.NaN()
+	}
+
+	return float64(current-last) / elapsed
+}
+
 // Ring taken from https://github.com/Shopify/mybench/blob/main/ring.go
 // This is not a particularly efficient implementation (as it allocates on
 // read), but a good first starting point.
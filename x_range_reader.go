@@ -0,0 +1,43 @@
+package wesplot
+
+import (
+	"context"
+)
+
+// A DataRowReader that ignores the rows with an X value outside of a range,
+// such as to replay a part of a capture, or to plot a part of data with an X
+// that is not a timestamp. The ignored rows are neither buffered nor sent to
+// the clients, and are counted as dropped with DropReasonXRange. The
+// annotations outside of the range are ignored as well.
+type XRangeDataRowReader struct {
+	input DataRowReader
+
+	// Either bound can be nil.
+	min *float64
+	max *float64
+}
+
+func NewXRangeDataRowReader(input DataRowReader, min *float64, max *float64) *XRangeDataRowReader {
+	return &XRangeDataRowReader{
+		input: input,
+		min:   min,
+		max:   max,
+	}
+}
+
+func (r *XRangeDataRowReader) Read(ctx context.Context) (DataRow, error) {
+	dataRow, err := r.input.Read(ctx)
+	if err != nil {
+		return dataRow, err
+	}
+
+	if (r.min != nil && dataRow.X < *r.min) || (r.max != nil && dataRow.X > *r.max) {
+		return DataRow{}, ignoreRow(DropReasonXRange)
+	}
+
+	return dataRow, nil
+}
+
+func (r *XRangeDataRowReader) ColumnNames() []string {
+	return r.input.ColumnNames()
+}